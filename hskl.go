@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"hskl/hskl"
 	"io/ioutil"
@@ -8,31 +10,240 @@ import (
 )
 
 func main() {
-	if len(os.Args) <= 1 || len(os.Args[1]) == 0 {
+	if len(os.Args) > 1 && os.Args[1] == "repl" {
+		hskl.NewRepl(os.Stdout).Run(os.Stdin)
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "lsp" {
+		if err := hskl.ServeLSP(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "lsp error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "debug" {
+		os.Exit(debugFile(os.Args[2:]))
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "fmt" {
+		os.Exit(formatFiles(os.Args[2:]))
+	}
+
+	engine := flag.String("engine", "tree", "execution engine: 'tree' walks the ast, 'vm' runs compiled bytecode")
+	disasm := flag.Bool("disasm", false, "print compiled bytecode instead of running it")
+	diagFormat := flag.String("diagnostics", "text", "format of compile errors: 'text' shows the source excerpt, 'json' is for editors")
+	maxSteps := flag.Int("max-steps", 0, "stop after this many statements and loop rounds, 0 is no limit")
+	maxDepth := flag.Int("max-depth", 0, "stop when func calls nest deeper, 0 is no limit")
+	maxAlloc := flag.Int("max-alloc", 0, "stop when the script made more array elements and string bytes, 0 is no limit")
+	timeout := flag.Duration("timeout", 0, "stop the script after this long, 0 is no limit")
+	seed := flag.Int64("seed", 0, "seed of rand_int, rand_float and shuffle, by default it is the time")
+	flag.Parse()
+
+	//a seed of 0 is a seed too, so the flag is looked for
+	seeded := false
+	flag.Visit(func(f *flag.Flag) {
+		seeded = seeded || f.Name == "seed"
+	})
+
+	if *engine != "tree" && *engine != "vm" {
+		fmt.Printf("unknown engine: %s, want 'tree' or 'vm'\n", *engine)
+		os.Exit(1)
+	}
+
+	if flag.NArg() < 1 || len(flag.Arg(0)) == 0 {
 		fmt.Printf("you should specify the source file\n")
 		return
 	}
 
 	//fmt.Printf("args: %v\n", os.Args)
 
-	body, _ := ioutil.ReadFile(os.Args[1])
+	body, _ := ioutil.ReadFile(flag.Arg(0))
 	program := string(body)
 
 	//fmt.Printf("%s", program)
 	p := hskl.NewParser(program)
+	p.SetFile(flag.Arg(0))
 	analyzer := hskl.NewSemanticAnalyzer()
 
 	pro := p.Program()
+	if diags := p.Diagnostics(); len(diags) > 0 {
+		reportDiagnostics(diags, program, *diagFormat)
+	}
+
 	err := analyzer.DoAnalyze(pro)
-	if err != nil {
+	if diags, ok := err.(hskl.Diagnostics); ok {
+		reportDiagnostics(diags, program, *diagFormat)
+	} else if err != nil {
 		fmt.Printf("analyze error: %v\n", err)
+		os.Exit(1)
+	}
+
+	limits := hskl.Limits{MaxSteps: *maxSteps, MaxCallDepth: *maxDepth, MaxAlloc: *maxAlloc}
+	ctx := context.Background()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	if *engine == "vm" || *disasm {
+		prog, err := hskl.NewCompiler().DoCompile(pro)
+		if err != nil {
+			fmt.Printf("compile error: %v\n", err)
+			os.Exit(1)
+		}
+
+		if *disasm {
+			fmt.Print(prog.Disassemble())
+			return
+		}
+
+		bcVM := hskl.NewBytecodeVM()
+		bcVM.SetLimits(limits)
+		if seeded {
+			bcVM.SetSeed(*seed)
+		}
+		reportRunError(bcVM.DoRunContext(ctx, prog))
 		return
 	}
 
 	interp := hskl.NewInterpreter()
-	err = interp.DoInterpret(pro)
+	interp.SetLimits(limits)
+	if seeded {
+		interp.SetSeed(*seed)
+	}
+	reportRunError(interp.DoInterpretContext(ctx, pro))
+}
 
+//reportDiagnostics prints the errors found in src and exits
+func reportDiagnostics(diags hskl.Diagnostics, src string, format string) {
+	if format == "json" {
+		out, _ := diags.JSON()
+		fmt.Println(string(out))
+	} else {
+		fmt.Print(diags.Render(src))
+	}
+	os.Exit(1)
+}
+
+//debugFile runs 'hskl debug [--dap] [file]', with --dap the debug adapter
+//protocol is served over stdio and the editor tells the file to run
+func debugFile(args []string) int {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	dap := flags.Bool("dap", false, "serve the debug adapter protocol over stdio")
+	flags.Parse(args)
+
+	if *dap {
+		if err := hskl.ServeDAP(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "dap error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	if flags.NArg() < 1 {
+		fmt.Printf("you should specify the source file\n")
+		return 1
+	}
+
+	name := flags.Arg(0)
+	body, err := ioutil.ReadFile(name)
 	if err != nil {
+		fmt.Printf("debug error: %v\n", err)
+		return 1
+	}
+
+	err = hskl.RunDebugger(string(body), name, os.Stdin, os.Stdout)
+	if diags, ok := err.(hskl.Diagnostics); ok {
+		fmt.Print(diags.Render(string(body)))
+		return 1
+	}
+	reportRunError(err)
+	return 0
+}
+
+//formatFiles runs 'hskl fmt [--check] [--diff] [files]', files are
+//rewritten in place and stdin goes to stdout when no file is given
+func formatFiles(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	check := flags.Bool("check", false, "list files not formatted and exit with 1 if any, nothing is written")
+	diff := flags.Bool("diff", false, "print the changes as unified diff, nothing is written")
+	flags.Parse(args)
+
+	files := flags.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	status := 0
+	for _, name := range files {
+		var body []byte
+		var err error
+		if name == "-" {
+			body, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			body, err = ioutil.ReadFile(name)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "fmt error: %v\n", err)
+			status = 1
+			continue
+		}
+
+		src := string(body)
+		out, err := hskl.Format(src)
+		if diags, ok := err.(hskl.Diagnostics); ok {
+			for _, d := range diags {
+				if name != "-" {
+					d.File = name
+				}
+			}
+			fmt.Fprint(os.Stderr, diags.Render(src))
+			status = 1
+			continue
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "fmt error: %v\n", err)
+			status = 1
+			continue
+		}
+
+		if *diff {
+			fmt.Print(hskl.UnifiedDiff(name, src, out))
+		}
+		if *check {
+			if out != src {
+				if !*diff {
+					fmt.Println(name)
+				}
+				status = 1
+			}
+			continue
+		}
+
+		if name == "-" && !*diff {
+			fmt.Print(out)
+		} else if out != src && !*diff {
+			if err := ioutil.WriteFile(name, []byte(out), 0644); err != nil {
+				fmt.Fprintf(os.Stderr, "fmt error: %v\n", err)
+				status = 1
+			}
+		}
+	}
+	return status
+}
+
+//reportRunError prints the traceback of a runtime error and exits
+func reportRunError(err error) {
+	if err == nil {
+		return
+	}
+
+	if rtErr, ok := err.(*hskl.RuntimeError); ok {
+		fmt.Print(rtErr.Traceback())
+	} else {
 		fmt.Printf("interpret error: %v\n", err)
 	}
+	os.Exit(1)
 }
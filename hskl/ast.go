@@ -3,6 +3,8 @@ package hskl
 import (
 	"fmt"
 	"runtime/debug"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 )
@@ -10,6 +12,8 @@ import (
 const (
 	AST_Program = iota + 1
 	AST_INT_CONST
+	AST_FLOAT_CONST
+	AST_BOOL_CONST
 	AST_STRING_CONST
 	AST_VarDecl
 	AST_FuncDecl
@@ -25,12 +29,27 @@ const (
 	AST_INDEXED_REF
 	AST_CONDITION_BLOCK
 	AST_WHILE
+	AST_FOR
+	AST_RANGE
 	AST_BREAK
+	AST_CONTINUE
+	AST_MAP_LIT
+	AST_FUNC_LIT
+	AST_IFACE_BOX
+	AST_TUPLE
+	AST_MULTI_ASSIGN
+	AST_TRY
+	AST_THROW
+	AST_SLICE_REF
 
 	//data type
 	AST_TP_PRIMITIVE
 	AST_TP_ARRAY
+	AST_TP_MAP
+	AST_TP_FUNC
 	AST_TP_STRUCT
+	AST_TP_IFACE
+	AST_TP_TUPLE
 	AST_TP_TYPE_DEF
 	AST_TP_TYPE_REF
 	AST_TP_UNDEF_TYPE
@@ -47,17 +66,25 @@ func doPanic(format string, args ...interface{}) {
 	panic(errors.New(desc))
 }
 
+//interpError is a runtime error of a script, it can be caught by try.
+//carry the line in msg
+//
+//line is known for thrown errors and errors of builtin funcs, others
 type interpError struct {
-	msg string
+	msg  string
+	line int
 }
 
 func (iterr *interpError) Error() string {
+	if iterr.line > 0 {
+		return fmt.Sprintf("%s, line: %d", iterr.msg, iterr.line)
+	}
 	return iterr.msg
 }
 
 func interpPanic(format string, args ...interface{}) {
 	desc := fmt.Sprintf(format, args...)
-	panic(&interpError{desc})
+	panic(&interpError{msg: desc})
 }
 
 type astVisitor interface {
@@ -84,6 +111,8 @@ type AstProgram struct {
 	AstBase
 	tpMap     map[string]AstType
 	decl_list []AstNode
+	//file is the source file name, used in tracebacks
+	file string
 }
 
 func (ast *AstProgram) astType() int {
@@ -100,11 +129,18 @@ func (ast *AstProgram) desc() string {
 
 type AstVarDecl struct {
 	AstBase
-	name    string
-	type_   AstType
-	initVal string
-	initArr []*Token
-	line    int
+	name     string
+	type_    AstType
+	initVal  string
+	initArr  []*Token
+	initExpr AstNode
+	line     int
+	col      int
+	//short is set for a var declared with :=
+	short bool
+
+	//filled by resolver, set when a func literal refers to the var
+	captured bool
 }
 
 func (ast *AstVarDecl) astType() int {
@@ -119,6 +155,10 @@ func (ast *AstVarDecl) desc() string {
 	return fmt.Sprintf("var %s:%s", ast.name, ast.type_.signature())
 }
 
+//nativeFunc is the go implementation of a builtin or host function,
+//it receives the streams of the running engine and the evaluated arguments
+type nativeFunc func(env *nativeEnv, args []interface{}) interface{}
+
 type AstFuncDecl struct {
 	AstBase
 	name       string
@@ -127,8 +167,18 @@ type AstFuncDecl struct {
 	va_param   *string
 	block      *AstCodeBlock
 	builtin    bool
+	native     nativeFunc
 	line       int
+	col        int
 	fixRetType func(fn *AstFuncCall) AstNode
+	//checkArgs checks args of a generic builtin and gives its return type
+	checkArgs func(fn *AstFuncCall, args []AstType) AstType
+	//elemArg is the index of an arg going into the array of arg 0, it is
+	//widened like an assigned value. 0 when there is none
+	elemArg int
+	//recv is the receiver of a method, recvPtr is set for a *T receiver
+	recv    *AstVarDecl
+	recvPtr bool
 }
 
 func (ast *AstFuncDecl) astType() int {
@@ -140,7 +190,33 @@ func (ast *AstFuncDecl) String() string {
 }
 
 func (ast *AstFuncDecl) desc() string {
-	return fmt.Sprintf("func: %s", ast.name)
+	return fmt.Sprintf("func: %s", funcFullName(ast))
+}
+
+//funcParams gives the receiver of a method followed by its params,
+//the receiver is passed as the first arg at runtime
+func funcParams(decl *AstFuncDecl) []*AstVarDecl {
+	if decl.recv == nil {
+		return decl.params
+	}
+	return append([]*AstVarDecl{decl.recv}, decl.params...)
+}
+
+//funcFullName is name of a func, or T.name of a method
+func funcFullName(decl *AstFuncDecl) string {
+	if decl.recv == nil {
+		return decl.name
+	}
+	return recvTypeName(decl) + "." + decl.name
+}
+
+//recvTypeName names a method after the struct it is attached to,
+//whatever alias the receiver is written with
+func recvTypeName(decl *AstFuncDecl) string {
+	if strct, ok := realType(decl.recv.type_).(*AstStructType); ok {
+		return strct.name
+	}
+	return decl.recv.type_.desc()
 }
 
 type AstFuncCall struct {
@@ -148,7 +224,14 @@ type AstFuncCall struct {
 	name string
 	args []AstNode
 	line int
+	col  int
 	ast  *AstFuncDecl
+	//callee is set when the func is called through a value
+	callee AstNode
+	//recv is the receiver of a method call, set by analyzer
+	recv AstNode
+	//dynamic is set when the method is found through an interface at runtime
+	dynamic bool
 }
 
 func (ast *AstFuncCall) astType() int {
@@ -160,13 +243,23 @@ func (ast *AstFuncCall) String() string {
 }
 
 func (ast *AstFuncCall) desc() string {
+	if ast.recv != nil {
+		return fmt.Sprintf("%s.%s()", ast.recv.desc(), ast.name)
+	}
+	if ast.callee != nil {
+		return fmt.Sprintf("%s()", ast.callee.desc())
+	}
 	return fmt.Sprintf("%s()", ast.name)
 }
 
 type AstCodeBlock struct {
 	AstBase
+	line      int
+	col       int
 	vars      []*AstVarDecl
 	stat_list []AstNode
+	//endLine is the line of the closing brace
+	endLine int
 }
 
 func (ast *AstCodeBlock) astType() int {
@@ -186,6 +279,7 @@ type AstAssgin struct {
 	dst  AstNode
 	expr AstNode
 	line int
+	col  int
 }
 
 func (ast *AstAssgin) astType() int {
@@ -206,6 +300,7 @@ type AstBinOP struct {
 	left  AstNode
 	right AstNode
 	line  int
+	col   int
 }
 
 func (ast *AstBinOP) astType() int {
@@ -224,6 +319,7 @@ type AstNewOP struct {
 	AstBase
 	opType AstType
 	line   int
+	col    int
 }
 
 func (ast *AstNewOP) astType() int {
@@ -243,6 +339,7 @@ type AstUnaryOP struct {
 	op   string
 	dst  AstNode
 	line int
+	col  int
 }
 
 func (ast *AstUnaryOP) astType() int {
@@ -261,6 +358,7 @@ type AstReturn struct {
 	AstBase
 	expr AstNode
 	line int
+	col  int
 }
 
 func (ast *AstReturn) astType() int {
@@ -294,6 +392,7 @@ type AstIntConst struct {
 	AstBase
 	value int
 	line  int
+	col   int
 }
 
 func (ast *AstIntConst) astType() int {
@@ -308,10 +407,51 @@ func (ast *AstIntConst) desc() string {
 	return fmt.Sprintf("int const: %d", ast.value)
 }
 
+type AstFloatConst struct {
+	AstBase
+	value float64
+	//text is the literal as written
+	text string
+	line int
+	col  int
+}
+
+func (ast *AstFloatConst) astType() int {
+	return AST_FLOAT_CONST
+}
+
+func (ast *AstFloatConst) String() string {
+	return fmt.Sprintf("AstFloatConst")
+}
+
+func (ast *AstFloatConst) desc() string {
+	return fmt.Sprintf("float const: %v", ast.value)
+}
+
+type AstBoolConst struct {
+	AstBase
+	value bool
+	line  int
+	col   int
+}
+
+func (ast *AstBoolConst) astType() int {
+	return AST_BOOL_CONST
+}
+
+func (ast *AstBoolConst) String() string {
+	return fmt.Sprintf("AstBoolConst")
+}
+
+func (ast *AstBoolConst) desc() string {
+	return fmt.Sprintf("bool const: %v", ast.value)
+}
+
 type AstStringConst struct {
 	AstBase
 	value string
 	line  int
+	col   int
 }
 
 func (ast *AstStringConst) astType() int {
@@ -330,6 +470,14 @@ type AstVarNameRef struct {
 	AstBase
 	name string
 	line int
+	col  int
+
+	//filled by resolver
+	scope int
+	depth int
+
+	//filled by analyzer when the name is a top level func used as value
+	fn *AstFuncDecl
 }
 
 func (ast *AstVarNameRef) astType() int {
@@ -349,6 +497,7 @@ type AstDotRef struct {
 	host AstNode
 	name string
 	line int
+	col  int
 }
 
 func (ast *AstDotRef) astType() int {
@@ -363,11 +512,79 @@ func (ast *AstDotRef) desc() string {
 	return fmt.Sprintf("%s.%s", ast.host.desc(), ast.name)
 }
 
+//AstMapLit is map[K]V{k1: v1, k2: v2}
+type AstMapLit struct {
+	AstBase
+	type_ *AstMapType
+	keys  []AstNode
+	vals  []AstNode
+	line  int
+	col   int
+}
+
+func (ast *AstMapLit) astType() int {
+	return AST_MAP_LIT
+}
+
+func (ast *AstMapLit) String() string {
+	return fmt.Sprintf("AstMapLit")
+}
+
+func (ast *AstMapLit) desc() string {
+	return fmt.Sprintf("%s literal, entries: %d", ast.type_.desc(), len(ast.keys))
+}
+
+//AstFuncLit is an anonymous func, it captures the variables of
+//enclosing scopes by reference
+type AstFuncLit struct {
+	AstBase
+	decl  *AstFuncDecl
+	type_ *AstFuncType
+	line  int
+	col   int
+}
+
+func (ast *AstFuncLit) astType() int {
+	return AST_FUNC_LIT
+}
+
+func (ast *AstFuncLit) String() string {
+	return fmt.Sprintf("AstFuncLit")
+}
+
+func (ast *AstFuncLit) desc() string {
+	return fmt.Sprintf("%s literal, line: %d", ast.type_.desc(), ast.line)
+}
+
+//AstIfaceBox wraps a struct value passed or assigned where an interface
+//is wanted, the box remembers the struct type for method dispatch
+type AstIfaceBox struct {
+	AstBase
+	expr  AstNode
+	strct *AstStructType
+	iface *AstIfaceType
+	line  int
+	col   int
+}
+
+func (ast *AstIfaceBox) astType() int {
+	return AST_IFACE_BOX
+}
+
+func (ast *AstIfaceBox) String() string {
+	return fmt.Sprintf("AstIfaceBox")
+}
+
+func (ast *AstIfaceBox) desc() string {
+	return fmt.Sprintf("%s(%s)", ast.iface.name, ast.expr.desc())
+}
+
 type AstIndexedRef struct {
 	AstBase
 	host  AstNode
 	index AstNode
 	line  int
+	col   int
 }
 
 func (ast *AstIndexedRef) astType() int {
@@ -382,12 +599,43 @@ func (ast *AstIndexedRef) desc() string {
 	return fmt.Sprintf("%s[%s]", ast.host.desc(), ast.index.desc())
 }
 
+//AstSliceRef is host[low:high], an omitted bound is nil
+type AstSliceRef struct {
+	AstBase
+	host AstNode
+	low  AstNode
+	high AstNode
+	line int
+	col  int
+}
+
+func (ast *AstSliceRef) astType() int {
+	return AST_SLICE_REF
+}
+
+func (ast *AstSliceRef) String() string {
+	return fmt.Sprintf("AstSliceRef")
+}
+
+func (ast *AstSliceRef) desc() string {
+	low, high := "", ""
+	if ast.low != nil {
+		low = ast.low.desc()
+	}
+	if ast.high != nil {
+		high = ast.high.desc()
+	}
+	return fmt.Sprintf("%s[%s:%s]", ast.host.desc(), low, high)
+}
+
 type AstConditionBlock struct {
 	AstBase
 
 	first bool
 	cond  AstNode
 	block *AstCodeBlock
+	line  int
+	col   int
 
 	altCondBlock *AstConditionBlock
 	altBlock     *AstCodeBlock
@@ -411,6 +659,8 @@ type AstWhileBlock struct {
 	AstBase
 	cond  AstNode
 	block *AstCodeBlock
+	line  int
+	col   int
 }
 
 func (ast *AstWhileBlock) astType() int {
@@ -425,8 +675,170 @@ func (ast *AstWhileBlock) desc() string {
 	return fmt.Sprintf("while")
 }
 
+//AstForBlock is a counted loop, init is a var decl or an assignment,
+//a missing cond is true. a var declared by init is fresh in every
+//iteration, it starts with the value the last one left
+type AstForBlock struct {
+	AstBase
+	init  AstNode
+	cond  AstNode
+	post  AstNode
+	block *AstCodeBlock
+	line  int
+	col   int
+}
+
+func (ast *AstForBlock) astType() int {
+	return AST_FOR
+}
+
+func (ast *AstForBlock) String() string {
+	return fmt.Sprintf("AstForBlock")
+}
+
+func (ast *AstForBlock) desc() string {
+	return fmt.Sprintf("for")
+}
+
+//AstRangeBlock iterates an array, a map in sorted key order or a string
+//by chars with their rune index. key and val are nil when omitted or written as '_', they are
+//declared again in every iteration
+type AstRangeBlock struct {
+	AstBase
+	key   *AstVarDecl
+	val   *AstVarDecl
+	expr  AstNode
+	block *AstCodeBlock
+	line  int
+	col   int
+}
+
+func (ast *AstRangeBlock) astType() int {
+	return AST_RANGE
+}
+
+func (ast *AstRangeBlock) String() string {
+	return fmt.Sprintf("AstRangeBlock")
+}
+
+func (ast *AstRangeBlock) desc() string {
+	return fmt.Sprintf("for range %s", ast.expr.desc())
+}
+
+//AstTuple is the value list of a return with multiple values
+type AstTuple struct {
+	AstBase
+	exprs []AstNode
+	line  int
+	col   int
+}
+
+func (ast *AstTuple) astType() int {
+	return AST_TUPLE
+}
+
+func (ast *AstTuple) String() string {
+	return fmt.Sprintf("AstTuple")
+}
+
+func (ast *AstTuple) desc() string {
+	descs := []string{}
+	for _, expr := range ast.exprs {
+		descs = append(descs, expr.desc())
+	}
+	return strings.Join(descs, ", ")
+}
+
+//AstMultiAssign destructures the values returned by a call. with ':='
+//decls are the new vars, otherwise dsts are assigned, a nil entry in
+//either list is the blank name '_'
+type AstMultiAssign struct {
+	AstBase
+	decls  []*AstVarDecl
+	dsts   []AstNode
+	define bool
+	expr   AstNode
+	line   int
+	col    int
+}
+
+func (ast *AstMultiAssign) astType() int {
+	return AST_MULTI_ASSIGN
+}
+
+func (ast *AstMultiAssign) String() string {
+	return fmt.Sprintf("AstMultiAssign")
+}
+
+func (ast *AstMultiAssign) desc() string {
+	return fmt.Sprintf("multi assign: %s", ast.expr.desc())
+}
+
+//AstTryBlock runs block, a runtime error or throw in it runs catch with
+//the error bound to name, name is nil when omitted
+type AstTryBlock struct {
+	AstBase
+	block *AstCodeBlock
+	name  *AstVarDecl
+	catch *AstCodeBlock
+	line  int
+	col   int
+}
+
+func (ast *AstTryBlock) astType() int {
+	return AST_TRY
+}
+
+func (ast *AstTryBlock) String() string {
+	return fmt.Sprintf("AstTryBlock")
+}
+
+func (ast *AstTryBlock) desc() string {
+	return fmt.Sprintf("try")
+}
+
+//AstThrow raises a string message or rethrows an error value
+type AstThrow struct {
+	AstBase
+	expr AstNode
+	line int
+	col  int
+}
+
+func (ast *AstThrow) astType() int {
+	return AST_THROW
+}
+
+func (ast *AstThrow) String() string {
+	return fmt.Sprintf("AstThrow")
+}
+
+func (ast *AstThrow) desc() string {
+	return fmt.Sprintf("throw %s", ast.expr.desc())
+}
+
+type AstContinue struct {
+	AstBase
+	line int
+	col  int
+}
+
+func (ast *AstContinue) astType() int {
+	return AST_CONTINUE
+}
+
+func (ast *AstContinue) String() string {
+	return fmt.Sprintf("AstContinue")
+}
+
+func (ast *AstContinue) desc() string {
+	return fmt.Sprintf("continue")
+}
+
 type AstBreak struct {
 	AstBase
+	line int
+	col  int
 }
 
 func (ast *AstBreak) astType() int {
@@ -443,8 +855,12 @@ func (ast *AstBreak) desc() string {
 
 type AstTypeDef struct {
 	AstBase
+	line int
+	col  int
 	name string
 	impl AstType
+	//endLine is the last line of the definition
+	endLine int
 }
 
 func (ast *AstTypeDef) astType() int {
@@ -487,6 +903,12 @@ func (ast *AstPrimType) signature() string {
 	case symTypeInt:
 		return "I"
 
+	case symTypeFloat:
+		return "F"
+
+	case symTypeBool:
+		return "B"
+
 	case symTypeString:
 		return "S"
 
@@ -521,12 +943,121 @@ func (ast *AstArrayType) signature() string {
 }
 
 func (ast *AstArrayType) desc() string {
-	return "[" + ast.elemType.desc()
+	return "[]" + ast.elemType.desc()
+}
+
+type AstMapType struct {
+	keyType AstType
+	valType AstType
+}
+
+func (ast *AstMapType) astType() int {
+	return AST_TP_MAP
+}
+
+func (ast *AstMapType) String() string {
+	return fmt.Sprintf("AstMapType")
+}
+
+func (ast *AstMapType) signature() string {
+	return "{" + ast.keyType.signature() + ast.valType.signature()
+}
+
+func (ast *AstMapType) desc() string {
+	return "map[" + ast.keyType.desc() + "]" + ast.valType.desc()
+}
+
+type AstFuncType struct {
+	params  []AstType
+	retType AstType
+}
+
+func (ast *AstFuncType) astType() int {
+	return AST_TP_FUNC
+}
+
+func (ast *AstFuncType) String() string {
+	return fmt.Sprintf("AstFuncType")
+}
+
+func (ast *AstFuncType) signature() string {
+	sig := "("
+	for _, param := range ast.params {
+		sig += param.signature()
+	}
+	return sig + ")" + ast.retType.signature()
+}
+
+func (ast *AstFuncType) desc() string {
+	params := []string{}
+	for _, param := range ast.params {
+		params = append(params, param.desc())
+	}
+
+	desc := "func(" + strings.Join(params, ", ") + ")"
+	if ast.retType.signature() != "V" {
+		desc += " " + ast.retType.desc()
+	}
+	return desc
+}
+
+//AstTupleType is the result of a func returning multiple values, it is
+//only allowed as return type and is never the type of a variable
+type AstTupleType struct {
+	elems []AstType
+}
+
+func (ast *AstTupleType) astType() int {
+	return AST_TP_TUPLE
+}
+
+func (ast *AstTupleType) String() string {
+	return fmt.Sprintf("AstTupleType")
+}
+
+func (ast *AstTupleType) signature() string {
+	sig := "<"
+	for _, elem := range ast.elems {
+		sig += elem.signature()
+	}
+	return sig + ">"
+}
+
+func (ast *AstTupleType) desc() string {
+	elems := []string{}
+	for _, elem := range ast.elems {
+		elems = append(elems, elem.desc())
+	}
+	return "(" + strings.Join(elems, ", ") + ")"
+}
+
+//newErrorType is the builtin struct of caught errors
+func newErrorType() *AstStructType {
+	return &AstStructType{name: symTypeError, methods: make(map[string]*AstFuncDecl), fields: []*AstVarDecl{
+		{name: "msg", type_: newPrimType(symTypeString)},
+		{name: "line", type_: newPrimType(symTypeInt)},
+	}}
+}
+
+//funcTypeOf gives the type of a func used as value
+func funcTypeOf(decl *AstFuncDecl) *AstFuncType {
+	tp := &AstFuncType{retType: decl.retType}
+	for _, param := range decl.params {
+		tp.params = append(tp.params, param.type_)
+	}
+	return tp
+}
+
+//funcValueDesc is how both engines print a func value
+func funcValueDesc(decl *AstFuncDecl) string {
+	return "<func " + funcFullName(decl) + ">"
 }
 
 type AstStructType struct {
 	name   string
 	fields []*AstVarDecl
+	//methods is shared by every alias of the struct
+	methods map[string]*AstFuncDecl
 }
 
 func (ast *AstStructType) astType() int {
@@ -545,9 +1076,52 @@ func (ast *AstStructType) desc() string {
 	return "struct: " + ast.name
 }
 
+//AstIfaceType is a set of methods, a struct having all of them with
+//the same signatures satisfies it
+type AstIfaceType struct {
+	name    string
+	methods []*AstFuncDecl
+}
+
+func (ast *AstIfaceType) astType() int {
+	return AST_TP_IFACE
+}
+
+func (ast *AstIfaceType) String() string {
+	return fmt.Sprintf("AstIfaceType: " + ast.name)
+}
+
+func (ast *AstIfaceType) signature() string {
+	return "i" + ast.name + ";"
+}
+
+func (ast *AstIfaceType) desc() string {
+	return "interface: " + ast.name
+}
+
+//methodSet gives the methods a value of tp can be called with
+func methodSet(tp AstType) map[string]*AstFuncDecl {
+	switch rtp := realType(tp).(type) {
+	case *AstStructType:
+		return rtp.methods
+
+	case *AstIfaceType:
+		methods := make(map[string]*AstFuncDecl)
+		for _, method := range rtp.methods {
+			methods[method.name] = method
+		}
+		return methods
+	}
+
+	return nil
+}
+
 type AstUndefType struct {
 	name     string
 	resolved AstType
+	//line and col of the first use, unresolved types are reported there
+	line int
+	col  int
 }
 
 func (ast *AstUndefType) astType() int {
@@ -581,3 +1155,113 @@ func realType(tp AstType) AstType {
 
 	return tp
 }
+
+//astSpan gives the count of chars of the token node is positioned at,
+//0 when it is not known
+func astSpan(node AstNode) int {
+	switch tp := node.(type) {
+	case *AstVarNameRef:
+		return len([]rune(tp.name))
+	case *AstVarDecl:
+		return len([]rune(tp.name))
+	case *AstUndefType:
+		return len([]rune(tp.name))
+	case *AstDotRef:
+		return len([]rune(tp.name)) + 1
+	case *AstFuncCall:
+		if tp.callee == nil && tp.recv == nil && tp.col > 0 {
+			return len([]rune(builtinSourceName(tp.name)))
+		}
+		return 1
+	case *AstBinOP:
+		return len(opText[tp.op])
+	case *AstUnaryOP:
+		return len(opText[tp.op])
+	case *AstIntConst:
+		return len(strconv.Itoa(tp.value))
+	case *AstFloatConst:
+		return len(tp.text)
+	case *AstBoolConst:
+		return len(strconv.FormatBool(tp.value))
+	case *AstStringConst:
+		return len([]rune(quoteString(tp.value)))
+	case *AstReturn:
+		return len("return")
+	case *AstBreak:
+		return len("break")
+	case *AstContinue:
+		return len("continue")
+	}
+	return 0
+}
+
+//astPos gives line and column where node starts, 0 when node has none
+func astPos(node AstNode) (int, int) {
+	switch tp := node.(type) {
+	case *AstVarDecl:
+		return tp.line, tp.col
+	case *AstFuncDecl:
+		return tp.line, tp.col
+	case *AstFuncCall:
+		return tp.line, tp.col
+	case *AstCodeBlock:
+		return tp.line, tp.col
+	case *AstAssgin:
+		return tp.line, tp.col
+	case *AstBinOP:
+		return tp.line, tp.col
+	case *AstNewOP:
+		return tp.line, tp.col
+	case *AstUnaryOP:
+		return tp.line, tp.col
+	case *AstReturn:
+		return tp.line, tp.col
+	case *AstIntConst:
+		return tp.line, tp.col
+	case *AstFloatConst:
+		return tp.line, tp.col
+	case *AstBoolConst:
+		return tp.line, tp.col
+	case *AstStringConst:
+		return tp.line, tp.col
+	case *AstVarNameRef:
+		return tp.line, tp.col
+	case *AstDotRef:
+		return tp.line, tp.col
+	case *AstMapLit:
+		return tp.line, tp.col
+	case *AstFuncLit:
+		return tp.line, tp.col
+	case *AstIfaceBox:
+		return tp.line, tp.col
+	case *AstIndexedRef:
+		return tp.line, tp.col
+	case *AstSliceRef:
+		return tp.line, tp.col
+	case *AstConditionBlock:
+		return tp.line, tp.col
+	case *AstWhileBlock:
+		return tp.line, tp.col
+	case *AstForBlock:
+		return tp.line, tp.col
+	case *AstRangeBlock:
+		return tp.line, tp.col
+	case *AstTuple:
+		return tp.line, tp.col
+	case *AstMultiAssign:
+		return tp.line, tp.col
+	case *AstTryBlock:
+		return tp.line, tp.col
+	case *AstThrow:
+		return tp.line, tp.col
+	case *AstContinue:
+		return tp.line, tp.col
+	case *AstBreak:
+		return tp.line, tp.col
+	case *AstTypeDef:
+		return tp.line, tp.col
+	case *AstUndefType:
+		return tp.line, tp.col
+	}
+	return 0, 0
+}
@@ -0,0 +1,273 @@
+package hskl
+
+import (
+	"sort"
+)
+
+//insert, remove and pop give the changed array like append does, sort and
+//reverse change the array in place. a slice of an array is a copy
+
+const (
+	Builtin_insert  = "insert"
+	Builtin_remove  = "remove"
+	Builtin_pop     = "pop"
+	Builtin_copy    = "copy"
+	Builtin_reverse = "reverse"
+	Builtin_sort    = "sort"
+	Builtin_fill    = "fill"
+)
+
+//arrayArg gives the array type of the first arg of an array builtin
+func arrayArg(fn *AstFuncCall, args []AstType) *AstArrayType {
+	arrTp, ok := realType(args[0]).(*AstArrayType)
+	if !ok {
+		doPanic("builtin %s(): need array, actual: %s", fn.name, args[0].desc())
+	}
+	return arrTp
+}
+
+//checkElem checks arg idx of the call is an element of the array
+func checkElem(fn *AstFuncCall, args []AstType, idx int, arrTp *AstArrayType) {
+	if args[idx].signature() != arrTp.elemType.signature() {
+		doPanic("builtin %s(): elem should be %s, actual: %s", fn.name, arrTp.elemType.desc(), args[idx].desc())
+	}
+}
+
+//comparableElem tells elements of the array can be compared by value
+func comparableElem(arrTp *AstArrayType) bool {
+	prim, ok := realType(arrTp.elemType).(*AstPrimType)
+	return ok && prim.name != symTypeAny
+}
+
+//newArrayBuiltin makes a builtin whose first param is an array, params
+//are the ones after it
+func newArrayBuiltin(name string, native nativeFunc, params []*AstVarDecl,
+	retType func(fn *AstFuncCall, args []AstType, arrTp *AstArrayType) AstType) *AstFuncDecl {
+	fc := &AstFuncDecl{}
+	fc.builtin = true
+	fc.native = native
+	fc.name = name
+	fc.retType = newPrimType(symTypeAny)
+
+	fc.params = []*AstVarDecl{{name: "arr", type_: newPrimType(symTypeAny)}}
+	fc.params = append(fc.params, params...)
+
+	fc.checkArgs = func(fn *AstFuncCall, args []AstType) AstType {
+		return retType(fn, args, arrayArg(fn, args))
+	}
+	return fc
+}
+
+//builtinInsert puts elem at idx, idx may be the len to add at the end
+func builtinInsert() *AstFuncDecl {
+	params := []*AstVarDecl{
+		{name: "idx", type_: newPrimType(symTypeInt)},
+		{name: "elem", type_: newPrimType(symTypeAny)},
+	}
+	fc := newArrayBuiltin(Builtin_insert, nativeInsert, params,
+		func(fn *AstFuncCall, args []AstType, arrTp *AstArrayType) AstType {
+			checkElem(fn, args, 2, arrTp)
+			return args[0]
+		})
+	fc.elemArg = 2
+	return fc
+}
+
+func builtinRemove() *AstFuncDecl {
+	params := []*AstVarDecl{{name: "idx", type_: newPrimType(symTypeInt)}}
+	return newArrayBuiltin(Builtin_remove, nativeRemove, params,
+		func(fn *AstFuncCall, args []AstType, arrTp *AstArrayType) AstType {
+			return args[0]
+		})
+}
+
+//builtinPop gives the array without its last elem, and the last elem
+func builtinPop() *AstFuncDecl {
+	return newArrayBuiltin(Builtin_pop, nativePop, nil,
+		func(fn *AstFuncCall, args []AstType, arrTp *AstArrayType) AstType {
+			return &AstTupleType{elems: []AstType{args[0], arrTp.elemType}}
+		})
+}
+
+//builtinCopy gives a shallow copy of the array
+func builtinCopy() *AstFuncDecl {
+	return newArrayBuiltin(Builtin_copy, nativeCopy, nil,
+		func(fn *AstFuncCall, args []AstType, arrTp *AstArrayType) AstType {
+			return args[0]
+		})
+}
+
+func builtinReverse() *AstFuncDecl {
+	return newArrayBuiltin(Builtin_reverse, nativeReverse, nil,
+		func(fn *AstFuncCall, args []AstType, arrTp *AstArrayType) AstType {
+			return newPrimType(symTypeVoid)
+		})
+}
+
+//builtinSort sorts ints, floats and strings ascending, or any elements
+//by a less func taking two of them. equal elements keep their order
+func builtinSort() *AstFuncDecl {
+	fc := newArrayBuiltin(Builtin_sort, nativeSort, nil,
+		func(fn *AstFuncCall, args []AstType, arrTp *AstArrayType) AstType {
+			if len(args) > 2 {
+				doPanic("builtin sort(): need array and an optional less func, recv: %d args", len(args))
+			}
+
+			if len(args) == 2 {
+				less := &AstFuncType{params: []AstType{arrTp.elemType, arrTp.elemType}, retType: newPrimType(symTypeBool)}
+				if realType(args[1]).signature() != less.signature() {
+					doPanic("builtin sort(): less should be %s, actual: %s", less.desc(), args[1].desc())
+				}
+				return newPrimType(symTypeVoid)
+			}
+
+			prim, ok := realType(arrTp.elemType).(*AstPrimType)
+			if !ok || (prim.name != symTypeInt && prim.name != symTypeFloat && prim.name != symTypeString) {
+				doPanic("builtin sort(): %s has no natural order, need a less func", arrTp.elemType.desc())
+			}
+			return newPrimType(symTypeVoid)
+		})
+
+	less := "less"
+	fc.va_param = &less
+	return fc
+}
+
+//builtinFill makes an array of count elems all set to val, val is not
+//copied so an array or struct val is shared by all of them
+func builtinFill() *AstFuncDecl {
+	fc := signatureBuiltin(Builtin_fill, "(count: int, val: any) any", nativeFill)
+	fc.checkArgs = func(fn *AstFuncCall, args []AstType) AstType {
+		if args[1].signature() == "V" {
+			doPanic("builtin fill(): need a value to fill with")
+		}
+		return &AstArrayType{elemType: args[1]}
+	}
+	return fc
+}
+
+func getArrayBuiltins() []*AstFuncDecl {
+	return []*AstFuncDecl{
+		builtinInsert(), builtinRemove(), builtinPop(), builtinCopy(),
+		builtinReverse(), builtinSort(), builtinFill(),
+	}
+}
+
+//arrayValue gives the array arg of a builtin
+func arrayValue(val interface{}, name string) []interface{} {
+	arr, ok := val.([]interface{})
+	if !ok {
+		interpPanic("hskl runtime error, nil reference in %s", name)
+	}
+	return arr
+}
+
+//checkIndex checks idx is in [0, size)
+func checkIndex(idx, size int) {
+	if idx < 0 || idx >= size {
+		interpPanic("hskl runtime error, index out of range: %d, len: %d", idx, size)
+	}
+}
+
+func nativeInsert(env *nativeEnv, args []interface{}) interface{} {
+	arr := arrayValue(args[0], Builtin_insert)
+	idx := args[1].(int)
+	checkIndex(idx, len(arr)+1)
+
+	ret := make([]interface{}, 0, len(arr)+1)
+	ret = append(ret, arr[:idx]...)
+	ret = append(ret, args[2])
+	return append(ret, arr[idx:]...)
+}
+
+func nativeRemove(env *nativeEnv, args []interface{}) interface{} {
+	arr := arrayValue(args[0], Builtin_remove)
+	idx := args[1].(int)
+	checkIndex(idx, len(arr))
+
+	ret := make([]interface{}, 0, len(arr)-1)
+	ret = append(ret, arr[:idx]...)
+	return append(ret, arr[idx+1:]...)
+}
+
+func nativePop(env *nativeEnv, args []interface{}) interface{} {
+	arr := arrayValue(args[0], Builtin_pop)
+	if len(arr) == 0 {
+		interpPanic("builtin pop(): empty array")
+	}
+
+	//cap is cut so appending to the rest does not touch the popped elem
+	last := len(arr) - 1
+	return []interface{}{arr[:last:last], arr[last]}
+}
+
+func nativeCopy(env *nativeEnv, args []interface{}) interface{} {
+	return append([]interface{}{}, arrayValue(args[0], Builtin_copy)...)
+}
+
+func nativeReverse(env *nativeEnv, args []interface{}) interface{} {
+	arr := arrayValue(args[0], Builtin_reverse)
+	for lo, hi := 0, len(arr)-1; lo < hi; lo, hi = lo+1, hi-1 {
+		arr[lo], arr[hi] = arr[hi], arr[lo]
+	}
+	return nil
+}
+
+func nativeSort(env *nativeEnv, args []interface{}) interface{} {
+	arr := arrayValue(args[0], Builtin_sort)
+	if len(args) > 1 {
+		less := args[1]
+		sort.SliceStable(arr, func(i, j int) bool {
+			return env.call(less, []interface{}{arr[i], arr[j]}).(bool)
+		})
+		return nil
+	}
+
+	sort.SliceStable(arr, func(i, j int) bool {
+		return lessValue(arr[i], arr[j])
+	})
+	return nil
+}
+
+//lessValue is the natural order of ints, floats and strings
+func lessValue(lhs, rhs interface{}) bool {
+	switch tVal := lhs.(type) {
+	case int:
+		return tVal < rhs.(int)
+
+	case float64:
+		return tVal < rhs.(float64)
+
+	case string:
+		return tVal < rhs.(string)
+
+	default:
+		doPanic("builtin sort(): no natural order of %T", lhs)
+		return false
+	}
+}
+
+func nativeFill(env *nativeEnv, args []interface{}) interface{} {
+	count := args[0].(int)
+	if count < 0 {
+		interpPanic("builtin fill(): negative count: %d", count)
+	}
+	env.reserve(count)
+
+	arr := make([]interface{}, count)
+	for idx := range arr {
+		arr[idx] = args[1]
+	}
+	return arr
+}
+
+//indexOfValue gives the index of the first elem equal to val, -1 when
+//there is none
+func indexOfValue(arr []interface{}, val interface{}) int {
+	for idx, elem := range arr {
+		if elem == val {
+			return idx
+		}
+	}
+	return -1
+}
@@ -1,59 +1,150 @@
 package hskl
 
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
 const (
-	Builtin_print  = "print"
-	Builtin_printn = "printn"
-	Builtin_str    = "str"
-	Builtin_int    = "_intVal"
-	Builtin_append = "append"
-	Builtin_len    = "len"
+	Builtin_print    = "print"
+	Builtin_printn   = "printn"
+	Builtin_str      = "str"
+	Builtin_int      = "_intVal"
+	Builtin_float    = "_floatVal"
+	Builtin_append   = "append"
+	Builtin_len      = "len"
+	Builtin_delete   = "delete"
+	Builtin_has      = "has"
+	Builtin_keys     = "keys"
+	Builtin_values   = "values"
+	Builtin_eprintn  = "eprintn"
+	Builtin_readLine = "readLine"
+	Builtin_readAll  = "readAll"
 )
 
+//nativeEnv is what builtins use of the engine running them, a nil stream
+//is the one of the process
+type nativeEnv struct {
+	stdout io.Writer
+	stderr io.Writer
+	stdin  io.Reader
+	//in buffers stdin, it is made on the first read
+	in *bufio.Reader
+	//call runs a func value of the engine, for builtins taking funcs
+	call func(fn interface{}, args []interface{}) interface{}
+	//canAlloc checks a size against the alloc limit of the engine before
+	//a builtin makes it, the size is counted after the builtin returns
+	canAlloc func(size int)
+	rng      *rand.Rand
+}
+
+func (env *nativeEnv) out() io.Writer {
+	if env.stdout == nil {
+		return os.Stdout
+	}
+	return env.stdout
+}
+
+func (env *nativeEnv) errOut() io.Writer {
+	if env.stderr == nil {
+		return os.Stderr
+	}
+	return env.stderr
+}
+
+//reserve stops the builtin when size more elements or bytes are over
+//the alloc limit, so a huge array or string is never made
+func (env *nativeEnv) reserve(size int) {
+	if env.canAlloc != nil {
+		env.canAlloc(size)
+	}
+}
+
+func (env *nativeEnv) reader() *bufio.Reader {
+	if env.in == nil {
+		stdin := env.stdin
+		if stdin == nil {
+			stdin = os.Stdin
+		}
+		env.in = bufio.NewReader(stdin)
+	}
+	return env.in
+}
+
+func (env *nativeEnv) setStdin(in io.Reader) {
+	env.stdin, env.in = in, nil
+}
+
 func builtFuncMap(name string) string {
 	if name == "int" {
 		return Builtin_int
 	}
 
+	if name == "float" {
+		return Builtin_float
+	}
+
 	return name
 }
 
-func builtPrint() *AstFuncDecl {
-	fc := &AstFuncDecl{}
-	fc.builtin = true
-	fc.name = Builtin_print
-	fc.retType = newPrimType(symTypeVoid)
+//builtinSourceName is the name a builtin is called with in scripts
+func builtinSourceName(name string) string {
+	if name == Builtin_int {
+		return "int"
+	}
 
-	args := "args"
-	fc.va_param = &args
+	if name == Builtin_float {
+		return "float"
+	}
 
-	fmtParam := &AstVarDecl{}
-	fmtParam.name = "format"
-	fmtParam.type_ = newPrimType(symTypeString)
-	fc.params = []*AstVarDecl{fmtParam}
+	return name
+}
 
-	return fc
+func builtPrint() *AstFuncDecl {
+	return newFormatBuiltin(Builtin_print, nativePrint, newPrimType(symTypeVoid), true)
 }
 
 func builtPrintn() *AstFuncDecl {
-	fc := &AstFuncDecl{}
-	fc.builtin = true
-	fc.name = Builtin_printn
-	fc.retType = newPrimType(symTypeVoid)
+	return newFormatBuiltin(Builtin_printn, nativePrintn, newPrimType(symTypeVoid), true)
+}
 
-	args := "args"
-	fc.va_param = &args
+func builtinEprintn() *AstFuncDecl {
+	return newFormatBuiltin(Builtin_eprintn, nativeEprintn, newPrimType(symTypeVoid), true)
+}
 
-	fmtParam := &AstVarDecl{}
-	fmtParam.name = "format"
-	fmtParam.type_ = newPrimType(symTypeString)
-	fc.params = []*AstVarDecl{fmtParam}
+//builtinReadLine gives the next line of stdin without its line break,
+//ok is false at the end of input
+func builtinReadLine() *AstFuncDecl {
+	fc := &AstFuncDecl{}
+	fc.builtin = true
+	fc.native = nativeReadLine
+	fc.name = Builtin_readLine
+	fc.retType = &AstTupleType{elems: []AstType{newPrimType(symTypeString), newPrimType(symTypeBool)}}
+	return fc
+}
 
+//builtinReadAll gives what is left of stdin
+func builtinReadAll() *AstFuncDecl {
+	fc := &AstFuncDecl{}
+	fc.builtin = true
+	fc.native = nativeReadAll
+	fc.name = Builtin_readAll
+	fc.retType = newPrimType(symTypeString)
 	return fc
 }
 
 func builtinStr() *AstFuncDecl {
 	fc := &AstFuncDecl{}
 	fc.builtin = true
+	fc.native = nativeStr
 	fc.name = Builtin_str
 	fc.retType = newPrimType(symTypeString)
 
@@ -64,9 +155,21 @@ func builtinStr() *AstFuncDecl {
 	return fc
 }
 
+//checkConvert checks the arg of int() and float() is a number or a string
+func checkConvert(fn *AstFuncCall, args []AstType) {
+	switch args[0].signature() {
+	case "I", "F", "S":
+		break
+
+	default:
+		doPanic("builtin %s(): cant convert %s, need int, float or string", builtinSourceName(fn.name), args[0].desc())
+	}
+}
+
 func builtinInt() *AstFuncDecl {
 	fc := &AstFuncDecl{}
 	fc.builtin = true
+	fc.native = nativeInt
 	fc.name = Builtin_int
 	fc.retType = newPrimType(symTypeInt)
 
@@ -74,12 +177,39 @@ func builtinInt() *AstFuncDecl {
 	fmtParam.name = "val"
 	fmtParam.type_ = newPrimType(symTypeAny)
 	fc.params = []*AstVarDecl{fmtParam}
+
+	fc.checkArgs = func(fn *AstFuncCall, args []AstType) AstType {
+		checkConvert(fn, args)
+		return fc.retType
+	}
 	return fc
 }
 
+func builtinFloat() *AstFuncDecl {
+	fc := &AstFuncDecl{}
+	fc.builtin = true
+	fc.native = nativeFloat
+	fc.name = Builtin_float
+	fc.retType = newPrimType(symTypeFloat)
+
+	fmtParam := &AstVarDecl{}
+	fmtParam.name = "val"
+	fmtParam.type_ = newPrimType(symTypeAny)
+	fc.params = []*AstVarDecl{fmtParam}
+
+	fc.checkArgs = func(fn *AstFuncCall, args []AstType) AstType {
+		checkConvert(fn, args)
+		return fc.retType
+	}
+	return fc
+}
+
+//builtinAppend gives the array with elem added at the end, an int elem
+//of a float array is widened
 func builtinAppend() *AstFuncDecl {
 	fc := &AstFuncDecl{}
 	fc.builtin = true
+	fc.native = nativeAppend
 	fc.name = Builtin_append
 	fc.retType = &AstArrayType{elemType: newPrimType(symTypeAny)}
 
@@ -91,8 +221,10 @@ func builtinAppend() *AstFuncDecl {
 	elemParm := &AstVarDecl{name: "elem", type_: newPrimType(symTypeAny)}
 	fc.params = append(fc.params, elemParm)
 
-	fc.fixRetType = func(fn *AstFuncCall) AstNode {
-		return fn.args[0]
+	fc.elemArg = 1
+	fc.checkArgs = func(fn *AstFuncCall, args []AstType) AstType {
+		checkElem(fn, args, 1, arrayArg(fn, args))
+		return args[0]
 	}
 
 	return fc
@@ -101,19 +233,294 @@ func builtinAppend() *AstFuncDecl {
 func builtinLen() *AstFuncDecl {
 	fc := &AstFuncDecl{}
 	fc.builtin = true
+	fc.native = nativeLen
 	fc.name = Builtin_len
 	fc.retType = newPrimType(symTypeInt)
 
 	fmtParam := &AstVarDecl{}
 	fmtParam.name = "arr"
-	fmtParam.type_ = &AstArrayType{elemType: newPrimType(symTypeAny)}
+	fmtParam.type_ = newPrimType(symTypeAny)
 	fc.params = []*AstVarDecl{fmtParam}
+
+	fc.checkArgs = func(fn *AstFuncCall, args []AstType) AstType {
+		switch tp := realType(args[0]).(type) {
+		case *AstArrayType, *AstMapType:
+			return fc.retType
+
+		case *AstPrimType:
+			if tp.name == symTypeString {
+				return fc.retType
+			}
+		}
+
+		doPanic("builtin len(): need array, map or string, actual: %s", args[0].desc())
+		return nil
+	}
 	return fc
 }
 
+//mapArg gives the map type of the first arg of a map builtin
+func mapArg(fn *AstFuncCall, args []AstType) *AstMapType {
+	mapTp, ok := realType(args[0]).(*AstMapType)
+	if !ok {
+		doPanic("builtin %s(): need map, actual: %s", fn.name, args[0].desc())
+	}
+	return mapTp
+}
+
+//newMapBuiltin makes a builtin whose first param is a map, with an optional key param
+func newMapBuiltin(name string, withKey bool, native nativeFunc,
+	retType func(mapTp *AstMapType) AstType) *AstFuncDecl {
+	fc := &AstFuncDecl{}
+	fc.builtin = true
+	fc.native = native
+	fc.name = name
+	fc.retType = newPrimType(symTypeAny)
+
+	fc.params = []*AstVarDecl{{name: "m", type_: newPrimType(symTypeAny)}}
+	if withKey {
+		fc.params = append(fc.params, &AstVarDecl{name: "key", type_: newPrimType(symTypeAny)})
+	}
+
+	fc.checkArgs = func(fn *AstFuncCall, args []AstType) AstType {
+		mapTp := mapArg(fn, args)
+		if withKey && args[1].signature() != mapTp.keyType.signature() {
+			doPanic("builtin %s(): key should be %s, actual: %s", name, mapTp.keyType.desc(), args[1].desc())
+		}
+		return retType(mapTp)
+	}
+
+	return fc
+}
+
+func builtinDelete() *AstFuncDecl {
+	return newMapBuiltin(Builtin_delete, true, nativeDelete, func(mapTp *AstMapType) AstType {
+		return newPrimType(symTypeVoid)
+	})
+}
+
+func builtinHas(name string) *AstFuncDecl {
+	return newMapBuiltin(name, true, nativeHas, func(mapTp *AstMapType) AstType {
+		return newPrimType(symTypeBool)
+	})
+}
+
+func builtinKeys() *AstFuncDecl {
+	return newMapBuiltin(Builtin_keys, false, nativeKeys, func(mapTp *AstMapType) AstType {
+		return &AstArrayType{elemType: mapTp.keyType}
+	})
+}
+
+func builtinValues() *AstFuncDecl {
+	return newMapBuiltin(Builtin_values, false, nativeValues, func(mapTp *AstMapType) AstType {
+		return &AstArrayType{elemType: mapTp.valType}
+	})
+}
+
+func nativePrint(env *nativeEnv, args []interface{}) interface{} {
+	fmt.Fprint(env.out(), printText(Builtin_print, args))
+	return nil
+}
+
+func nativePrintn(env *nativeEnv, args []interface{}) interface{} {
+	fmt.Fprintln(env.out(), printText(Builtin_printn, args))
+	return nil
+}
+
+func nativeEprintn(env *nativeEnv, args []interface{}) interface{} {
+	fmt.Fprintln(env.errOut(), printText(Builtin_eprintn, args))
+	return nil
+}
+
+func nativeReadLine(env *nativeEnv, args []interface{}) interface{} {
+	line, err := env.reader().ReadString('\n')
+	if err != nil && err != io.EOF {
+		interpPanic("builtin readLine(): %v", err)
+	}
+	if err == io.EOF && line == "" {
+		return []interface{}{"", false}
+	}
+
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return []interface{}{line, true}
+}
+
+func nativeReadAll(env *nativeEnv, args []interface{}) interface{} {
+	body, err := ioutil.ReadAll(env.reader())
+	if err != nil {
+		interpPanic("builtin readAll(): %v", err)
+	}
+	return string(body)
+}
+
+func nativeStr(env *nativeEnv, args []interface{}) interface{} {
+	if fVal, ok := args[0].(float64); ok {
+		return formatFloat(fVal)
+	}
+	return fmt.Sprintf("%v", args[0])
+}
+
+//formatFloat prints the shortest repr that reads back the same value,
+//a float always shows a dot or exponent so it is not taken as int
+func formatFloat(val float64) string {
+	str := strconv.FormatFloat(val, 'g', -1, 64)
+	if strings.ContainsAny(str, ".eIN") {
+		return str
+	}
+	return str + ".0"
+}
+
+func nativeInt(env *nativeEnv, args []interface{}) interface{} {
+	switch tVal := args[0].(type) {
+	case int:
+		return tVal
+
+	case float64:
+		//truncate toward zero
+		return floatToInt("int", tVal)
+
+	case string:
+		iVal, err := strconv.Atoi(tVal)
+		if err != nil {
+			interpPanic("builtin int(): cant convert: %q", tVal)
+		}
+		return iVal
+
+	default:
+		doPanic("builtin int(): cant convert: %s", tVal)
+		return nil
+	}
+}
+
+func nativeFloat(env *nativeEnv, args []interface{}) interface{} {
+	switch tVal := args[0].(type) {
+	case int:
+		return float64(tVal)
+
+	case float64:
+		return tVal
+
+	case string:
+		fVal, err := strconv.ParseFloat(tVal, 64)
+		if err != nil {
+			interpPanic("builtin float(): cant convert: %q", tVal)
+		}
+		return fVal
+
+	default:
+		doPanic("builtin float(): cant convert: %v", tVal)
+		return nil
+	}
+}
+
+func nativeAppend(env *nativeEnv, args []interface{}) interface{} {
+	if args[0] == nil {
+		interpPanic("hskl runtime error, nil reference in append")
+		return nil
+	}
+
+	switch rType := args[0].(type) {
+	case []interface{}:
+		return append(rType, args[1])
+
+	default:
+		doPanic("builtin append error, unknown array type: %T", rType)
+		return nil
+	}
+}
+
+func nativeLen(env *nativeEnv, args []interface{}) interface{} {
+	switch tVal := args[0].(type) {
+	case []interface{}:
+		return len(tVal)
+
+	case map[interface{}]interface{}:
+		return len(tVal)
+
+	case string:
+		return utf8.RuneCountInString(tVal)
+
+	default:
+		interpPanic("hskl runtime error, nil reference in len")
+		return nil
+	}
+}
+
+func mapValue(val interface{}, name string) map[interface{}]interface{} {
+	mVal, ok := val.(map[interface{}]interface{})
+	if !ok {
+		interpPanic("hskl runtime error, nil map in %s", name)
+	}
+	return mVal
+}
+
+func nativeDelete(env *nativeEnv, args []interface{}) interface{} {
+	delete(mapValue(args[0], Builtin_delete), args[1])
+	return nil
+}
+
+func nativeHas(env *nativeEnv, args []interface{}) interface{} {
+	_, ok := mapValue(args[0], Builtin_has)[args[1]]
+	return ok
+}
+
+//keyLess orders map keys so keys() and values() do not depend on go map order
+func keyLess(a, b interface{}) bool {
+	switch aVal := a.(type) {
+	case int:
+		return aVal < b.(int)
+
+	case float64:
+		return aVal < b.(float64)
+
+	case string:
+		return aVal < b.(string)
+
+	case bool:
+		return !aVal && b.(bool)
+
+	default:
+		doPanic("unknown map key type: %T", a)
+		return false
+	}
+}
+
+func sortedKeys(mVal map[interface{}]interface{}) []interface{} {
+	keys := make([]interface{}, 0, len(mVal))
+	for key := range mVal {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keyLess(keys[i], keys[j])
+	})
+	return keys
+}
+
+func nativeKeys(env *nativeEnv, args []interface{}) interface{} {
+	return sortedKeys(mapValue(args[0], Builtin_keys))
+}
+
+func nativeValues(env *nativeEnv, args []interface{}) interface{} {
+	mVal := mapValue(args[0], Builtin_values)
+	vals := []interface{}{}
+	for _, key := range sortedKeys(mVal) {
+		vals = append(vals, mVal[key])
+	}
+	return vals
+}
+
 func getBuiltinFunc() []*AstFuncDecl {
 	fl := []*AstFuncDecl{}
-	fl = append(fl, builtPrint(), builtPrintn(), builtinStr(), builtinInt())
+	fl = append(fl, builtPrint(), builtPrintn(), builtinStr(), builtinInt(), builtinFloat())
 	fl = append(fl, builtinAppend(), builtinLen())
+	fl = append(fl, builtinDelete(), builtinHas(Builtin_has), builtinKeys(), builtinValues())
+	fl = append(fl, builtinEprintn(), builtinReadLine(), builtinReadAll())
+	fl = append(fl, builtinSprintf(), builtinPrintf())
+	fl = append(fl, getStringBuiltins()...)
+	fl = append(fl, getArrayBuiltins()...)
+	fl = append(fl, getMathBuiltins()...)
+	fl = append(fl, getRandBuiltins()...)
 	return fl
 }
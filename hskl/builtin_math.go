@@ -0,0 +1,211 @@
+package hskl
+
+import (
+	"math"
+)
+
+//int is 64 bit, + - * and ** wrap around on overflow. / truncates toward
+//zero and % takes the sign of the dividend, both fail on a zero divisor
+
+const (
+	Builtin_abs   = "abs"
+	Builtin_min   = "min"
+	Builtin_max   = "max"
+	Builtin_clamp = "clamp"
+)
+
+//intPow gives base ** exp by squaring, exp is not negative
+func intPow(base, exp int) int {
+	ret := 1
+	for exp > 0 {
+		if exp&1 == 1 {
+			ret *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return ret
+}
+
+//numberType gives the result type of a math builtin taking ints and
+//floats, it is float when any arg is a float
+func numberType(fn *AstFuncCall, args []AstType) AstType {
+	ret := newPrimType(symTypeInt)
+	for idx, arg := range args {
+		switch arg.signature() {
+		case "I":
+			break
+
+		case "F":
+			ret = newPrimType(symTypeFloat)
+			break
+
+		default:
+			doPanic("builtin %s(): need int or float, arg %d is %s", fn.name, idx+1, arg.desc())
+		}
+	}
+	return ret
+}
+
+//newNumberBuiltin makes a builtin taking ints and floats, more args
+//than params are taken when more is set
+func newNumberBuiltin(name string, native nativeFunc, params []string, more bool) *AstFuncDecl {
+	fc := &AstFuncDecl{}
+	fc.builtin = true
+	fc.native = native
+	fc.name = name
+	fc.retType = newPrimType(symTypeAny)
+
+	for _, param := range params {
+		fc.params = append(fc.params, &AstVarDecl{name: param, type_: newPrimType(symTypeAny)})
+	}
+	if more {
+		rest := "rest"
+		fc.va_param = &rest
+	}
+
+	fc.checkArgs = func(fn *AstFuncCall, args []AstType) AstType {
+		if len(args) < len(params) {
+			doPanic("builtin %s(): need %d args at least, recv: %d", name, len(params), len(args))
+		}
+		return numberType(fn, args)
+	}
+	return fc
+}
+
+func getMathBuiltins() []*AstFuncDecl {
+	return []*AstFuncDecl{
+		newNumberBuiltin(Builtin_abs, nativeAbs, []string{"x"}, false),
+		newNumberBuiltin(Builtin_min, nativeMin, []string{"a", "b"}, true),
+		newNumberBuiltin(Builtin_max, nativeMax, []string{"a", "b"}, true),
+		newNumberBuiltin(Builtin_clamp, nativeClamp, []string{"x", "lo", "hi"}, false),
+		signatureBuiltin("pow", "(x: float, y: float) float", nativePow),
+		signatureBuiltin("sqrt", "(x: float) float", nativeSqrt),
+		signatureBuiltin("floor", "(x: float) int", nativeFloor),
+		signatureBuiltin("ceil", "(x: float) int", nativeCeil),
+		signatureBuiltin("gcd", "(a: int, b: int) int", nativeGcd),
+	}
+}
+
+//lessNumber compares two ints or floats, an int is widened when the
+//other is a float
+func lessNumber(lhs, rhs interface{}) bool {
+	l, lok := lhs.(int)
+	r, rok := rhs.(int)
+	if lok && rok {
+		return l < r
+	}
+	return toFloat(lhs) < toFloat(rhs)
+}
+
+func toFloat(val interface{}) float64 {
+	if iVal, ok := val.(int); ok {
+		return float64(iVal)
+	}
+	return val.(float64)
+}
+
+//numberResult gives val as a float when any arg is a float, the same as
+//the type of the call
+func numberResult(val interface{}, args []interface{}) interface{} {
+	for _, arg := range args {
+		if _, ok := arg.(float64); ok {
+			return toFloat(val)
+		}
+	}
+	return val
+}
+
+//floatToInt converts a rounded float, it fails when the int can not
+//hold it
+func floatToInt(name string, val float64) int {
+	if math.IsNaN(val) || val < math.MinInt64 || val >= math.MaxInt64 {
+		interpPanic("builtin %s(): %v out of int range", name, val)
+	}
+	return int(val)
+}
+
+//nativeAbs of the most negative int is a runtime error, its positive
+//value is out of int range
+func nativeAbs(env *nativeEnv, args []interface{}) interface{} {
+	if iVal, ok := args[0].(int); ok {
+		if iVal == math.MinInt64 {
+			interpPanic("builtin abs(): %d out of int range when positive", iVal)
+		}
+		if iVal < 0 {
+			return -iVal
+		}
+		return iVal
+	}
+	return math.Abs(args[0].(float64))
+}
+
+func nativeMin(env *nativeEnv, args []interface{}) interface{} {
+	ret := args[0]
+	for _, arg := range args[1:] {
+		if lessNumber(arg, ret) {
+			ret = arg
+		}
+	}
+	return numberResult(ret, args)
+}
+
+func nativeMax(env *nativeEnv, args []interface{}) interface{} {
+	ret := args[0]
+	for _, arg := range args[1:] {
+		if lessNumber(ret, arg) {
+			ret = arg
+		}
+	}
+	return numberResult(ret, args)
+}
+
+func nativeClamp(env *nativeEnv, args []interface{}) interface{} {
+	val, lo, hi := args[0], args[1], args[2]
+	if lessNumber(hi, lo) {
+		interpPanic("builtin clamp(): lo %v is greater than hi %v", lo, hi)
+	}
+
+	if lessNumber(val, lo) {
+		val = lo
+	} else if lessNumber(hi, val) {
+		val = hi
+	}
+	return numberResult(val, args)
+}
+
+func nativePow(env *nativeEnv, args []interface{}) interface{} {
+	return math.Pow(args[0].(float64), args[1].(float64))
+}
+
+func nativeSqrt(env *nativeEnv, args []interface{}) interface{} {
+	val := args[0].(float64)
+	if val < 0 {
+		interpPanic("builtin sqrt(): negative arg: %v", val)
+	}
+	return math.Sqrt(val)
+}
+
+func nativeFloor(env *nativeEnv, args []interface{}) interface{} {
+	return floatToInt("floor", math.Floor(args[0].(float64)))
+}
+
+func nativeCeil(env *nativeEnv, args []interface{}) interface{} {
+	return floatToInt("ceil", math.Ceil(args[0].(float64)))
+}
+
+//nativeGcd is never negative, gcd(0, 0) is 0. a gcd of the most negative
+//int is out of int range
+func nativeGcd(env *nativeEnv, args []interface{}) interface{} {
+	a, b := args[0].(int), args[1].(int)
+	for b != 0 {
+		a, b = b, a%b
+	}
+	if a == math.MinInt64 {
+		interpPanic("builtin gcd(): %d out of int range when positive", a)
+	}
+	if a < 0 {
+		return -a
+	}
+	return a
+}
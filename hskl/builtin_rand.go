@@ -0,0 +1,76 @@
+package hskl
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+//every interpreter has its own rng, it is seeded by the time unless a seed
+//is set by the host or by seed() of the script
+
+const (
+	Builtin_randInt   = "rand_int"
+	Builtin_randFloat = "rand_float"
+	Builtin_shuffle   = "shuffle"
+	Builtin_seed      = "seed"
+)
+
+//random gives the rng of the engine, made on the first use
+func (env *nativeEnv) random() *rand.Rand {
+	if env.rng == nil {
+		env.setSeed(time.Now().UnixNano())
+	}
+	return env.rng
+}
+
+func (env *nativeEnv) setSeed(seed int64) {
+	env.rng = rand.New(rand.NewSource(seed))
+}
+
+//builtinShuffle puts the elems of the array in a random order in place
+func builtinShuffle() *AstFuncDecl {
+	return newArrayBuiltin(Builtin_shuffle, nativeShuffle, nil,
+		func(fn *AstFuncCall, args []AstType, arrTp *AstArrayType) AstType {
+			return newPrimType(symTypeVoid)
+		})
+}
+
+func getRandBuiltins() []*AstFuncDecl {
+	return []*AstFuncDecl{
+		signatureBuiltin(Builtin_randInt, "(lo: int, hi: int) int", nativeRandInt),
+		signatureBuiltin(Builtin_randFloat, "() float", nativeRandFloat),
+		signatureBuiltin(Builtin_seed, "(n: int)", nativeSeed),
+		builtinShuffle(),
+	}
+}
+
+//nativeRandInt gives an int in [lo, hi], hi included
+func nativeRandInt(env *nativeEnv, args []interface{}) interface{} {
+	lo, hi := args[0].(int), args[1].(int)
+	if lo > hi {
+		interpPanic("builtin rand_int(): lo %d is greater than hi %d", lo, hi)
+	}
+	if span := hi - lo; span < 0 || span == math.MaxInt64 {
+		interpPanic("builtin rand_int(): range [%d, %d] is too large", lo, hi)
+	}
+	return lo + int(env.random().Int63n(int64(hi-lo)+1))
+}
+
+//nativeRandFloat gives a float in [0, 1)
+func nativeRandFloat(env *nativeEnv, args []interface{}) interface{} {
+	return env.random().Float64()
+}
+
+func nativeSeed(env *nativeEnv, args []interface{}) interface{} {
+	env.setSeed(int64(args[0].(int)))
+	return nil
+}
+
+func nativeShuffle(env *nativeEnv, args []interface{}) interface{} {
+	arr := arrayValue(args[0], Builtin_shuffle)
+	env.random().Shuffle(len(arr), func(i, j int) {
+		arr[i], arr[j] = arr[j], arr[i]
+	})
+	return nil
+}
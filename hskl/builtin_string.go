@@ -0,0 +1,265 @@
+package hskl
+
+import (
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+//strings are indexed, sliced and measured by rune, a char is a string of
+//one rune. range over a string gives rune indices too
+
+const (
+	Builtin_contains = "contains"
+	Builtin_indexOf  = "indexOf"
+)
+
+//runeIndex gives the char at rune idx of s
+func runeIndex(s string, idx int) string {
+	if idx >= 0 {
+		pos := 0
+		for _, char := range s {
+			if pos == idx {
+				return string(char)
+			}
+			pos++
+		}
+	}
+	interpPanic("hskl runtime error, index out of range: %d, len: %d", idx, utf8.RuneCountInString(s))
+	return ""
+}
+
+//sliceValue gives host[low:high] of a string, or a copy of it of an
+//array. a nil bound is the start or the end
+func sliceValue(host, low, high interface{}) interface{} {
+	switch tHost := host.(type) {
+	case string:
+		runes := []rune(tHost)
+		lo, hi := sliceBounds(low, high, len(runes))
+		return string(runes[lo:hi])
+
+	case []interface{}:
+		lo, hi := sliceBounds(low, high, len(tHost))
+		return append([]interface{}{}, tHost[lo:hi]...)
+
+	case nil:
+		interpPanic("hskl runtime error, nil reference in slice")
+	}
+
+	doPanic("slice error, host should be string or array, actual: %T", host)
+	return nil
+}
+
+//sliceBounds checks the bounds of a slice of size elements
+func sliceBounds(low, high interface{}, size int) (int, int) {
+	lo, hi := 0, size
+	if low != nil {
+		lo = low.(int)
+	}
+	if high != nil {
+		hi = high.(int)
+	}
+
+	if lo < 0 || hi > size || lo > hi {
+		interpPanic("hskl runtime error, slice bounds out of range: [%d:%d], len: %d", lo, hi, size)
+	}
+	return lo, hi
+}
+
+//signatureBuiltin declares a builtin by its hskl signature
+func signatureBuiltin(name, signature string, native nativeFunc) *AstFuncDecl {
+	decl, err := parseSignature(name, signature, newTypeMap())
+	if err != nil {
+		doPanic("%v", err)
+	}
+	decl.native = native
+	return decl
+}
+
+func getStringBuiltins() []*AstFuncDecl {
+	return []*AstFuncDecl{
+		signatureBuiltin("substr", "(s: string, start: int, count: int) string", nativeSubstr),
+		signatureBuiltin("split", "(s: string, sep: string) []string", nativeSplit),
+		signatureBuiltin("join", "(parts: []string, sep: string) string", nativeJoin),
+		signatureBuiltin("trim", "(s: string) string", nativeTrim),
+		signatureBuiltin("replace", "(s: string, old: string, with: string) string", nativeReplace),
+		signatureBuiltin("startsWith", "(s: string, prefix: string) bool", nativeStartsWith),
+		signatureBuiltin("endsWith", "(s: string, suffix: string) bool", nativeEndsWith),
+		signatureBuiltin("upper", "(s: string) string", nativeUpper),
+		signatureBuiltin("lower", "(s: string) string", nativeLower),
+		signatureBuiltin("repeat", "(s: string, count: int) string", nativeRepeat),
+		signatureBuiltin("ord", "(char: string) int", nativeOrd),
+		signatureBuiltin("chr", "(code: int) string", nativeChr),
+		builtinContains(), builtinIndexOf(),
+	}
+}
+
+//newSearchBuiltin declares a builtin looking for elem in a string or
+//another collection, check tells the other collection is one it takes
+func newSearchBuiltin(name string, native nativeFunc, retType AstType,
+	check func(fn *AstFuncCall, args []AstType) bool) *AstFuncDecl {
+	fc := signatureBuiltin(name, "(coll: any, elem: any) any", native)
+	fc.retType = retType
+	fc.elemArg = 1
+	fc.checkArgs = func(fn *AstFuncCall, args []AstType) AstType {
+		if prim, ok := realType(args[0]).(*AstPrimType); ok && prim.name == symTypeString {
+			if args[1].signature() != "S" {
+				doPanic("builtin %s(): need string to look for, actual: %s", name, args[1].desc())
+			}
+			return retType
+		}
+
+		if check == nil || !check(fn, args) {
+			doPanic("builtin %s(): can not look in %s", name, args[0].desc())
+		}
+		return retType
+	}
+	return fc
+}
+
+//searchArray tells the call looks for an elem of an array, elements
+//should be of a primitive type to be compared
+func searchArray(fn *AstFuncCall, args []AstType) bool {
+	arrTp, ok := realType(args[0]).(*AstArrayType)
+	if !ok {
+		return false
+	}
+
+	if !comparableElem(arrTp) {
+		doPanic("builtin %s(): can not compare elems of %s", fn.name, args[0].desc())
+	}
+	checkElem(fn, args, 1, arrTp)
+	return true
+}
+
+//builtinContains looks for a substring, an elem of an array or a key of
+//a map
+func builtinContains() *AstFuncDecl {
+	return newSearchBuiltin(Builtin_contains, nativeContains, newPrimType(symTypeBool),
+		func(fn *AstFuncCall, args []AstType) bool {
+			if searchArray(fn, args) {
+				return true
+			}
+
+			mapTp, ok := realType(args[0]).(*AstMapType)
+			if ok && args[1].signature() != mapTp.keyType.signature() {
+				doPanic("builtin contains(): key should be %s, actual: %s", mapTp.keyType.desc(), args[1].desc())
+			}
+			return ok
+		})
+}
+
+//builtinIndexOf gives the rune index of a substring or the index of an
+//elem of an array, -1 when not found
+func builtinIndexOf() *AstFuncDecl {
+	return newSearchBuiltin(Builtin_indexOf, nativeIndexOf, newPrimType(symTypeInt), searchArray)
+}
+
+func nativeContains(env *nativeEnv, args []interface{}) interface{} {
+	switch tVal := args[0].(type) {
+	case string:
+		return strings.Contains(tVal, args[1].(string))
+
+	case []interface{}:
+		return indexOfValue(tVal, args[1]) >= 0
+	}
+	return nativeHas(env, args)
+}
+
+func nativeIndexOf(env *nativeEnv, args []interface{}) interface{} {
+	str, ok := args[0].(string)
+	if !ok {
+		return indexOfValue(arrayValue(args[0], Builtin_indexOf), args[1])
+	}
+
+	idx := strings.Index(str, args[1].(string))
+	if idx < 0 {
+		return -1
+	}
+	return utf8.RuneCountInString(str[:idx])
+}
+
+func nativeSubstr(env *nativeEnv, args []interface{}) interface{} {
+	start, count := args[1].(int), args[2].(int)
+	if count < 0 {
+		interpPanic("builtin substr(): negative count: %d", count)
+	}
+	return sliceValue(args[0], start, start+count)
+}
+
+func nativeSplit(env *nativeEnv, args []interface{}) interface{} {
+	parts := []interface{}{}
+	for _, part := range strings.Split(args[0].(string), args[1].(string)) {
+		parts = append(parts, part)
+	}
+	return parts
+}
+
+func nativeJoin(env *nativeEnv, args []interface{}) interface{} {
+	arr, ok := args[0].([]interface{})
+	if !ok {
+		interpPanic("hskl runtime error, nil reference in join")
+	}
+
+	parts := make([]string, len(arr))
+	for idx, part := range arr {
+		parts[idx] = part.(string)
+	}
+	return strings.Join(parts, args[1].(string))
+}
+
+func nativeTrim(env *nativeEnv, args []interface{}) interface{} {
+	return strings.TrimSpace(args[0].(string))
+}
+
+func nativeReplace(env *nativeEnv, args []interface{}) interface{} {
+	return strings.Replace(args[0].(string), args[1].(string), args[2].(string), -1)
+}
+
+func nativeStartsWith(env *nativeEnv, args []interface{}) interface{} {
+	return strings.HasPrefix(args[0].(string), args[1].(string))
+}
+
+func nativeEndsWith(env *nativeEnv, args []interface{}) interface{} {
+	return strings.HasSuffix(args[0].(string), args[1].(string))
+}
+
+func nativeUpper(env *nativeEnv, args []interface{}) interface{} {
+	return strings.ToUpper(args[0].(string))
+}
+
+func nativeLower(env *nativeEnv, args []interface{}) interface{} {
+	return strings.ToLower(args[0].(string))
+}
+
+func nativeRepeat(env *nativeEnv, args []interface{}) interface{} {
+	count := args[1].(int)
+	if count < 0 {
+		interpPanic("builtin repeat(): negative count: %d", count)
+	}
+
+	str := args[0].(string)
+	if len(str) > 0 && count > math.MaxInt64/len(str) {
+		interpPanic("builtin repeat(): result too long, %d bytes repeated %d times", len(str), count)
+	}
+	env.reserve(len(str) * count)
+	return strings.Repeat(str, count)
+}
+
+//nativeOrd gives the code point of a char
+func nativeOrd(env *nativeEnv, args []interface{}) interface{} {
+	char := args[0].(string)
+	if utf8.RuneCountInString(char) != 1 {
+		interpPanic("builtin ord(): need one char, recv: %q", char)
+	}
+	code, _ := utf8.DecodeRuneInString(char)
+	return int(code)
+}
+
+func nativeChr(env *nativeEnv, args []interface{}) interface{} {
+	code := args[0].(int)
+	if code < 0 || code > utf8.MaxRune || !utf8.ValidRune(rune(code)) {
+		interpPanic("builtin chr(): invalid code point: %d", code)
+	}
+	return string(rune(code))
+}
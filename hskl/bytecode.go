@@ -0,0 +1,203 @@
+package hskl
+
+import (
+	"fmt"
+	"strings"
+)
+
+//bytecode op codes, operands are big endian u16 unless noted
+const (
+	OP_CONST        = iota + 1 //u16 const idx
+	OP_NIL                     //push nil
+	OP_POP                     //drop top
+	OP_LOAD_LOCAL              //u16 slot
+	OP_STORE_LOCAL             //u16 slot
+	OP_LOAD_GLOBAL             //u16 slot
+	OP_STORE_GLOBAL            //u16 slot
+	OP_VAR_INIT                //u16 const idx of *AstVarDecl, push initial value
+	OP_MAKE_CELL               //u16 slot, box the local so closures can share it
+	OP_LOAD_CELL               //u16 slot of a boxed local
+	OP_STORE_CELL              //u16 slot of a boxed local
+	OP_LOAD_UPVAL              //u16 idx of captured cell
+	OP_STORE_UPVAL             //u16 idx of captured cell
+	OP_CLOSURE                 //u16 func idx, push closure over its upvals
+	OP_COPY_RECV               //u16 const idx of method *AstFuncDecl, copy value receiver on top
+	OP_BOX                     //u16 const idx of *AstStructType, [val] -> interface value
+	OP_METHOD                  //u16 const idx of method name, [iface] -> [fn recv]
+	OP_NEW                     //u16 const idx of AstType
+	OP_MAKE_MAP                //u16 pair count, [k1 v1 .. kn vn] -> map
+	OP_TUPLE                   //u16 value count, [v1 .. vn] -> multiple return values
+	OP_UNPACK                  //u16 value count, [values] -> [vn .. v1]
+	OP_INDEX                   //[idx host] -> val
+	OP_SET_INDEX               //[val host idx] ->
+	OP_SLICE                   //[host low high] -> val, nil bound is omitted
+	OP_FIELD                   //u16 const idx of field name, [host] -> val
+	OP_SET_FIELD               //u16 const idx of field name, [val host] ->
+	OP_ADD
+	OP_SUB
+	OP_MUL
+	OP_DIV
+	OP_MOD
+	OP_POW
+	OP_BIT_AND
+	OP_BIT_OR
+	OP_BIT_XOR
+	OP_SHL
+	OP_SHR
+	OP_EQ
+	OP_NEQ
+	OP_LT
+	OP_LTE
+	OP_GT
+	OP_GTE
+	OP_NEG
+	OP_NOT
+	OP_JUMP       //u16 target
+	OP_JUMP_FALSE //u16 target, pop condition
+	OP_ITER       //[coll] -> range iterator
+	OP_ITER_NEXT  //u16 target, [iter] -> [key val], jump to target when done
+	OP_TRY        //u16 target of the catch block, push error handler
+	OP_END_TRY    //pop error handler
+	OP_THROW      //[msg or error] ->, raise runtime error
+	OP_CALL       //u16 func idx, u8 argc
+	OP_NATIVE     //u16 native func idx, u8 argc
+	OP_CALL_VALUE //u8 argc, [fn args..] -> ret
+	OP_RETURN     //pop return value
+)
+
+var opNames = map[byte]string{
+	OP_CONST: "CONST", OP_NIL: "NIL", OP_POP: "POP",
+	OP_LOAD_LOCAL: "LOAD_LOCAL", OP_STORE_LOCAL: "STORE_LOCAL",
+	OP_LOAD_GLOBAL: "LOAD_GLOBAL", OP_STORE_GLOBAL: "STORE_GLOBAL",
+	OP_VAR_INIT: "VAR_INIT", OP_NEW: "NEW", OP_MAKE_MAP: "MAKE_MAP",
+	OP_TUPLE: "TUPLE", OP_UNPACK: "UNPACK",
+	OP_MAKE_CELL: "MAKE_CELL", OP_LOAD_CELL: "LOAD_CELL", OP_STORE_CELL: "STORE_CELL",
+	OP_LOAD_UPVAL: "LOAD_UPVAL", OP_STORE_UPVAL: "STORE_UPVAL", OP_CLOSURE: "CLOSURE",
+	OP_COPY_RECV: "COPY_RECV", OP_BOX: "BOX", OP_METHOD: "METHOD",
+	OP_INDEX: "INDEX", OP_SET_INDEX: "SET_INDEX", OP_SLICE: "SLICE",
+	OP_FIELD: "FIELD", OP_SET_FIELD: "SET_FIELD",
+	OP_ADD: "ADD", OP_SUB: "SUB", OP_MUL: "MUL", OP_DIV: "DIV", OP_MOD: "MOD", OP_POW: "POW",
+	OP_BIT_AND: "BIT_AND", OP_BIT_OR: "BIT_OR", OP_BIT_XOR: "BIT_XOR", OP_SHL: "SHL", OP_SHR: "SHR",
+	OP_EQ: "EQ", OP_NEQ: "NEQ", OP_LT: "LT", OP_LTE: "LTE", OP_GT: "GT", OP_GTE: "GTE",
+	OP_NEG: "NEG", OP_NOT: "NOT",
+	OP_JUMP: "JUMP", OP_JUMP_FALSE: "JUMP_FALSE", OP_ITER: "ITER", OP_ITER_NEXT: "ITER_NEXT",
+	OP_TRY: "TRY", OP_END_TRY: "END_TRY", OP_THROW: "THROW",
+	OP_CALL: "CALL", OP_NATIVE: "NATIVE", OP_CALL_VALUE: "CALL_VALUE", OP_RETURN: "RETURN",
+}
+
+//operand bytes following each op code
+func opWidth(op byte) int {
+	switch op {
+	case OP_CALL, OP_NATIVE:
+		return 3
+
+	case OP_CONST, OP_LOAD_LOCAL, OP_STORE_LOCAL, OP_LOAD_GLOBAL, OP_STORE_GLOBAL,
+		OP_VAR_INIT, OP_NEW, OP_MAKE_MAP, OP_TUPLE, OP_UNPACK, OP_FIELD, OP_SET_FIELD,
+		OP_JUMP, OP_JUMP_FALSE, OP_ITER_NEXT, OP_TRY,
+		OP_MAKE_CELL, OP_LOAD_CELL, OP_STORE_CELL, OP_LOAD_UPVAL, OP_STORE_UPVAL, OP_CLOSURE,
+		OP_COPY_RECV, OP_BOX, OP_METHOD:
+		return 2
+
+	case OP_CALL_VALUE:
+		return 1
+
+	default:
+		return 0
+	}
+}
+
+//bcFunc is one compiled function: code, line table and constant pool
+type bcFunc struct {
+	name    string
+	decl    *AstFuncDecl
+	nparams int
+	nslots  int
+	code    []byte
+	lines   []int
+	steps   []bool //ops a statement starts at, the vm counts a step there
+	consts  []interface{}
+	upvals  []bcUpval
+}
+
+//bcUpval tells where a closure finds a captured cell when it is created:
+//a boxed local slot of the enclosing func, or an upval of the enclosing func
+type bcUpval struct {
+	local bool
+	index int
+}
+
+func (fn *bcFunc) addConst(val interface{}) int {
+	for idx, old := range fn.consts {
+		if old == val {
+			return idx
+		}
+	}
+
+	fn.consts = append(fn.consts, val)
+	return len(fn.consts) - 1
+}
+
+func (fn *bcFunc) disassemble() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("func %s: params: %d, slots: %d", fn.name, fn.nparams, fn.nslots))
+	if len(fn.upvals) > 0 {
+		sb.WriteString(fmt.Sprintf(", upvals: %d", len(fn.upvals)))
+	}
+	sb.WriteString("\n")
+
+	for ip := 0; ip < len(fn.code); {
+		op := fn.code[ip]
+		sb.WriteString(fmt.Sprintf("%04d %4d %-12s", ip, fn.lines[ip], opNames[op]))
+
+		switch opWidth(op) {
+		case 2:
+			arg := int(fn.code[ip+1])<<8 | int(fn.code[ip+2])
+			sb.WriteString(fmt.Sprintf(" %d", arg))
+			switch op {
+			case OP_CONST, OP_FIELD, OP_SET_FIELD:
+				sb.WriteString(fmt.Sprintf(" (%v)", fn.consts[arg]))
+
+			case OP_VAR_INIT:
+				sb.WriteString(fmt.Sprintf(" (%s)", fn.consts[arg].(*AstVarDecl).desc()))
+			}
+			break
+
+		case 1:
+			sb.WriteString(fmt.Sprintf(" argc: %d", fn.code[ip+1]))
+			break
+
+		case 3:
+			arg := int(fn.code[ip+1])<<8 | int(fn.code[ip+2])
+			sb.WriteString(fmt.Sprintf(" %d argc: %d", arg, fn.code[ip+3]))
+			break
+		}
+
+		sb.WriteString("\n")
+		ip += 1 + opWidth(op)
+	}
+
+	return sb.String()
+}
+
+//bcProgram is the output of compiler, init runs global declarations
+type bcProgram struct {
+	funcs    []*bcFunc
+	natives  []*AstFuncDecl
+	globals  []string
+	init     *bcFunc
+	mainFunc int
+	file     string
+	//methods finds the func of a method called through an interface
+	methods map[*AstFuncDecl]*bcClosure
+}
+
+func (prog *bcProgram) Disassemble() string {
+	var sb strings.Builder
+	sb.WriteString(prog.init.disassemble())
+	for _, fn := range prog.funcs {
+		sb.WriteString("\n")
+		sb.WriteString(fn.disassemble())
+	}
+
+	return sb.String()
+}
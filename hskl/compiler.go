@@ -0,0 +1,929 @@
+package hskl
+
+import (
+	"github.com/pkg/errors"
+)
+
+//bcLoop collects jumps of break and continue, patched when the loop ends.
+//tries is the count of try blocks around the loop
+type bcLoop struct {
+	breaks    []int
+	continues []int
+	tries     int
+}
+
+//bcLocal is a local slot, cell is set when a closure captures it
+type bcLocal struct {
+	slot int
+	cell bool
+}
+
+//bcCompiler turns an analyzed program into bytecode. locals get a slot in
+//their function, slots of a finished block are reused by its siblings.
+//a func literal is compiled by a child compiler, enclosing is its parent
+type bcCompiler struct {
+	prog      *bcProgram
+	fn        *bcFunc
+	funcIdx   map[*AstFuncDecl]int
+	nativeIdx map[*AstFuncDecl]int
+	funcVals  map[*AstFuncDecl]*bcClosure
+	globals   map[string]int
+	scopes    []map[string]bcLocal
+	nextSlot  int
+	loops     []*bcLoop
+	tries     int
+	line      int
+	//stmt marks the next op as the start of a statement
+	stmt      bool
+	enclosing *bcCompiler
+	upvals    map[string]int
+}
+
+func (c *bcCompiler) emit(op byte) {
+	c.fn.code = append(c.fn.code, op)
+	c.fn.lines = append(c.fn.lines, c.line)
+	c.fn.steps = append(c.fn.steps, c.stmt)
+	c.stmt = false
+}
+
+func (c *bcCompiler) emitU16(op byte, arg int) int {
+	if arg > 0xffff {
+		doPanic("compile error, operand overflow: %d, line: %d", arg, c.line)
+	}
+
+	c.emit(op)
+	pos := len(c.fn.code)
+	c.emit(byte(arg >> 8))
+	c.emit(byte(arg))
+	return pos
+}
+
+func (c *bcCompiler) emitCall(op byte, arg int, argc int) {
+	if argc > 0xff {
+		doPanic("compile error, too many args: %d, line: %d", argc, c.line)
+	}
+
+	c.emitU16(op, arg)
+	c.emit(byte(argc))
+}
+
+func (c *bcCompiler) emitJump(op byte) int {
+	return c.emitU16(op, 0xffff)
+}
+
+func (c *bcCompiler) patchJump(pos int) {
+	c.patchJumpTo(pos, len(c.fn.code))
+}
+
+func (c *bcCompiler) patchJumpTo(pos int, target int) {
+	if target > 0xffff {
+		doPanic("compile error, function %s too large", c.fn.name)
+	}
+
+	c.fn.code[pos] = byte(target >> 8)
+	c.fn.code[pos+1] = byte(target)
+}
+
+func (c *bcCompiler) setLine(line int) {
+	if line > 0 {
+		c.line = line
+	}
+}
+
+func (c *bcCompiler) pushScope() {
+	c.scopes = append(c.scopes, make(map[string]bcLocal))
+}
+
+func (c *bcCompiler) popScope() {
+	top := c.scopes[len(c.scopes)-1]
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.nextSlot -= len(top)
+}
+
+func (c *bcCompiler) declareLocal(decl *AstVarDecl) int {
+	slot := c.nextSlot
+	c.scopes[len(c.scopes)-1][decl.name] = bcLocal{slot: slot, cell: decl.captured}
+	c.nextSlot++
+	if c.nextSlot > c.fn.nslots {
+		c.fn.nslots = c.nextSlot
+	}
+
+	return slot
+}
+
+func (c *bcCompiler) lookupLocal(name string) (bcLocal, bool) {
+	for idx := len(c.scopes) - 1; idx >= 0; idx-- {
+		if local, ok := c.scopes[idx][name]; ok {
+			return local, true
+		}
+	}
+
+	return bcLocal{}, false
+}
+
+//lookupUpval finds name in enclosing funcs and records it as an upval
+func (c *bcCompiler) lookupUpval(name string) (int, bool) {
+	if c.enclosing == nil {
+		return 0, false
+	}
+
+	if idx, ok := c.upvals[name]; ok {
+		return idx, true
+	}
+
+	var uv bcUpval
+	if local, ok := c.enclosing.lookupLocal(name); ok {
+		if !local.cell {
+			doPanic("compile error, captured var is not boxed: %s", name)
+		}
+		uv = bcUpval{local: true, index: local.slot}
+	} else if idx, ok := c.enclosing.lookupUpval(name); ok {
+		uv = bcUpval{index: idx}
+	} else {
+		return 0, false
+	}
+
+	c.upvals[name] = len(c.fn.upvals)
+	c.fn.upvals = append(c.fn.upvals, uv)
+	return c.upvals[name], true
+}
+
+func (c *bcCompiler) compileProgram(program *AstProgram) {
+	c.prog.mainFunc = -1
+	for _, decl := range program.decl_list {
+		if node, ok := decl.(*AstFuncDecl); ok {
+			c.funcIdx[node] = len(c.prog.funcs)
+			if node.name == entryFunc && node.recv == nil {
+				c.prog.mainFunc = len(c.prog.funcs)
+			}
+			c.prog.funcs = append(c.prog.funcs, &bcFunc{name: funcFullName(node), decl: node, nparams: len(funcParams(node))})
+		}
+	}
+
+	c.prog.methods = make(map[*AstFuncDecl]*bcClosure)
+	for node := range c.funcIdx {
+		if node.recv != nil {
+			c.prog.methods[node] = c.funcValue(node)
+		}
+	}
+
+	//global initializers
+	c.fn = &bcFunc{name: "<init>"}
+	c.prog.init = c.fn
+	for _, decl := range program.decl_list {
+		if node, ok := decl.(*AstVarDecl); ok {
+			slot := len(c.prog.globals)
+			c.globals[node.name] = slot
+			c.prog.globals = append(c.prog.globals, node.name)
+			c.compileVarInit(node, func() { c.emitU16(OP_STORE_GLOBAL, slot) })
+		}
+	}
+	c.emit(OP_NIL)
+	c.emit(OP_RETURN)
+
+	for _, decl := range program.decl_list {
+		if node, ok := decl.(*AstFuncDecl); ok {
+			c.compileFuncDecl(node)
+		}
+	}
+}
+
+func (c *bcCompiler) compileFuncDecl(node *AstFuncDecl) {
+	c.compileBody(c.prog.funcs[c.funcIdx[node]], node)
+}
+
+func (c *bcCompiler) compileBody(fn *bcFunc, node *AstFuncDecl) {
+	c.fn = fn
+	c.scopes = nil
+	c.nextSlot = 0
+	c.loops = nil
+	c.tries = 0
+	c.upvals = make(map[string]int)
+	c.setLine(node.line)
+
+	c.pushScope()
+	for _, param := range funcParams(node) {
+		slot := c.declareLocal(param)
+		if param.captured {
+			c.emitU16(OP_MAKE_CELL, slot)
+		}
+	}
+
+	c.compileCodeBlock(node.block)
+	c.popScope()
+
+	c.emit(OP_NIL)
+	c.emit(OP_RETURN)
+}
+
+//compileVarInit pushes the initial value of var, store emits the store op
+func (c *bcCompiler) compileVarInit(node *AstVarDecl, store func()) {
+	c.setLine(node.line)
+	if prim, ok := realType(node.type_).(*AstPrimType); ok && prim.name == symTypeVoid {
+		return
+	}
+
+	if node.initExpr != nil {
+		c.compileExpr(node.initExpr)
+		store()
+		return
+	}
+
+	c.emitU16(OP_VAR_INIT, c.fn.addConst(node))
+	store()
+}
+
+//compileFuncLit compiles the literal as a func of its own, then emits
+//the closure creation in the current func
+func (c *bcCompiler) compileFuncLit(node *AstFuncLit) {
+	fn := &bcFunc{name: node.decl.name, decl: node.decl, nparams: len(node.decl.params)}
+	idx := len(c.prog.funcs)
+	c.prog.funcs = append(c.prog.funcs, fn)
+
+	child := &bcCompiler{prog: c.prog, funcIdx: c.funcIdx, nativeIdx: c.nativeIdx,
+		funcVals: c.funcVals, globals: c.globals, enclosing: c}
+	child.compileBody(fn, node.decl)
+
+	c.setLine(node.line)
+	c.emitU16(OP_CLOSURE, idx)
+}
+
+//funcValue is the constant pushed when a top level func is used as value
+func (c *bcCompiler) funcValue(decl *AstFuncDecl) *bcClosure {
+	if val, ok := c.funcVals[decl]; ok {
+		return val
+	}
+
+	val := &bcClosure{native: decl}
+	if decl.native == nil {
+		val = &bcClosure{fn: c.prog.funcs[c.funcIdx[decl]]}
+	}
+	c.funcVals[decl] = val
+	return val
+}
+
+func (c *bcCompiler) compileCodeBlock(node *AstCodeBlock) {
+	for _, varDecl := range node.vars {
+		//the init expr is compiled before the name is visible
+		decl := varDecl
+		c.compileVarInit(decl, func() {
+			slot := c.declareLocal(decl)
+			c.emitU16(OP_STORE_LOCAL, slot)
+			if decl.captured {
+				c.emitU16(OP_MAKE_CELL, slot)
+			}
+		})
+	}
+
+	for _, ast := range node.stat_list {
+		c.stmt = true
+		c.compileStatement(ast)
+	}
+}
+
+func (c *bcCompiler) compileNestedBlock(node *AstCodeBlock) {
+	c.pushScope()
+	c.compileCodeBlock(node)
+	c.popScope()
+}
+
+func (c *bcCompiler) compileStatement(ast AstNode) {
+	switch stat := ast.(type) {
+	case *AstAssgin:
+		c.compileAssign(stat)
+		break
+
+	case *AstBinOP, *AstUnaryOP, *AstIntConst, *AstFloatConst, *AstBoolConst, *AstStringConst,
+		*AstVarNameRef, *AstFuncCall:
+		c.compileExpr(ast)
+		c.emit(OP_POP)
+		break
+
+	case *AstMultiAssign:
+		c.compileMultiAssign(stat)
+		break
+
+	case *AstReturn:
+		if tuple, ok := stat.expr.(*AstTuple); ok {
+			for _, expr := range tuple.exprs {
+				c.compileExpr(expr)
+			}
+			c.setLine(tuple.line)
+			c.emitU16(OP_TUPLE, len(tuple.exprs))
+		} else if stat.expr != nil {
+			c.compileExpr(stat.expr)
+		} else {
+			c.emit(OP_NIL)
+		}
+		c.emit(OP_RETURN)
+		break
+
+	case *AstCodeBlock:
+		c.compileNestedBlock(stat)
+		break
+
+	case *AstConditionBlock:
+		c.compileConditionBlock(stat)
+		break
+
+	case *AstWhileBlock:
+		c.compileWhileBlock(stat)
+		break
+
+	case *AstForBlock:
+		c.compileForBlock(stat)
+		break
+
+	case *AstRangeBlock:
+		c.compileRangeBlock(stat)
+		break
+
+	case *AstBreak:
+		if len(c.loops) == 0 {
+			doPanic("compile error, break outside loop in func: %s", c.fn.name)
+		}
+		loop := c.loops[len(c.loops)-1]
+		c.leaveTries(loop)
+		loop.breaks = append(loop.breaks, c.emitJump(OP_JUMP))
+		break
+
+	case *AstContinue:
+		if len(c.loops) == 0 {
+			doPanic("compile error, continue outside loop in func: %s", c.fn.name)
+		}
+		loop := c.loops[len(c.loops)-1]
+		c.leaveTries(loop)
+		loop.continues = append(loop.continues, c.emitJump(OP_JUMP))
+		break
+
+	case *AstTryBlock:
+		c.compileTryBlock(stat)
+		break
+
+	case *AstThrow:
+		c.compileExpr(stat.expr)
+		c.setLine(stat.line)
+		c.emit(OP_THROW)
+		break
+
+	case *AstNoopStat:
+		break
+
+	default:
+		doPanic("compile error, unknown statement: %T", ast)
+	}
+}
+
+func (c *bcCompiler) compileConditionBlock(node *AstConditionBlock) {
+	ends := []int{}
+	for cur := node; cur != nil; cur = cur.altCondBlock {
+		c.compileExpr(cur.cond)
+		next := c.emitJump(OP_JUMP_FALSE)
+		c.compileNestedBlock(cur.block)
+		ends = append(ends, c.emitJump(OP_JUMP))
+		c.patchJump(next)
+
+		if cur.altCondBlock == nil && cur.altBlock != nil {
+			c.compileNestedBlock(cur.altBlock)
+		}
+	}
+
+	for _, pos := range ends {
+		c.patchJump(pos)
+	}
+}
+
+func (c *bcCompiler) compileWhileBlock(node *AstWhileBlock) {
+	c.setLine(node.line)
+	start := len(c.fn.code)
+	c.compileExpr(node.cond)
+	exit := c.emitJump(OP_JUMP_FALSE)
+
+	loop := &bcLoop{tries: c.tries}
+	c.loops = append(c.loops, loop)
+	c.compileNestedBlock(node.block)
+	c.loops = c.loops[:len(c.loops)-1]
+
+	//the jump back is a round of the loop, it runs at the loop line
+	c.setLine(node.line)
+	back := c.emitJump(OP_JUMP)
+	c.patchJumpTo(back, start)
+	c.patchJump(exit)
+	c.patchLoop(loop, start)
+}
+
+//leaveTries pops the handlers of try blocks a break or continue jumps out of
+func (c *bcCompiler) leaveTries(loop *bcLoop) {
+	for idx := loop.tries; idx < c.tries; idx++ {
+		c.emit(OP_END_TRY)
+	}
+}
+
+//compileTryBlock registers the catch block as handler while the try block
+//runs, the vm pushes the caught error before jumping to the handler
+func (c *bcCompiler) compileTryBlock(node *AstTryBlock) {
+	c.setLine(node.line)
+	handler := c.emitJump(OP_TRY)
+	c.tries++
+	c.compileNestedBlock(node.block)
+	c.tries--
+	c.emit(OP_END_TRY)
+	end := c.emitJump(OP_JUMP)
+
+	c.patchJump(handler)
+	c.pushScope()
+	if node.name != nil {
+		slot := c.declareLocal(node.name)
+		c.emitU16(OP_STORE_LOCAL, slot)
+		if node.name.captured {
+			c.emitU16(OP_MAKE_CELL, slot)
+		}
+	} else {
+		c.emit(OP_POP)
+	}
+	c.compileCodeBlock(node.catch)
+	c.popScope()
+	c.patchJump(end)
+}
+
+//patchLoop points continues to next, breaks to the current position
+func (c *bcCompiler) patchLoop(loop *bcLoop, next int) {
+	for _, pos := range loop.continues {
+		c.patchJumpTo(pos, next)
+	}
+
+	for _, pos := range loop.breaks {
+		c.patchJump(pos)
+	}
+}
+
+//compileForBlock keeps the init var in a scope around the loop, continue
+//jumps to post
+func (c *bcCompiler) compileForBlock(node *AstForBlock) {
+	c.pushScope()
+	if decl, ok := node.init.(*AstVarDecl); ok {
+		c.compileCodeBlock(&AstCodeBlock{vars: []*AstVarDecl{decl}})
+	} else if node.init != nil {
+		c.compileStatement(node.init)
+	}
+
+	start := len(c.fn.code)
+	exit := -1
+	if node.cond != nil {
+		c.compileExpr(node.cond)
+		exit = c.emitJump(OP_JUMP_FALSE)
+	}
+
+	loop := &bcLoop{tries: c.tries}
+	c.loops = append(c.loops, loop)
+	c.compileNestedBlock(node.block)
+	c.loops = c.loops[:len(c.loops)-1]
+
+	//a captured init var gets a fresh cell for the next iteration
+	next := len(c.fn.code)
+	if decl, ok := node.init.(*AstVarDecl); ok && decl.captured {
+		local, _ := c.lookupLocal(decl.name)
+		c.emitU16(OP_LOAD_CELL, local.slot)
+		c.emitU16(OP_STORE_LOCAL, local.slot)
+		c.emitU16(OP_MAKE_CELL, local.slot)
+	}
+	if node.post != nil {
+		c.compileStatement(node.post)
+	}
+
+	//the jump back is a round of the loop, it runs at the loop line
+	c.setLine(node.line)
+	back := c.emitJump(OP_JUMP)
+	c.patchJumpTo(back, start)
+	if exit >= 0 {
+		c.patchJump(exit)
+	}
+	c.patchLoop(loop, next)
+	c.popScope()
+}
+
+//compileRangeBlock keeps the iterator in a hidden local, key and value
+//are stored into the body scope in every iteration
+func (c *bcCompiler) compileRangeBlock(node *AstRangeBlock) {
+	c.setLine(node.line)
+	c.pushScope()
+	c.compileExpr(node.expr)
+	c.setLine(node.line)
+	c.emit(OP_ITER)
+	iter := c.declareLocal(&AstVarDecl{name: "<iter>"})
+	c.emitU16(OP_STORE_LOCAL, iter)
+
+	start := len(c.fn.code)
+	c.emitU16(OP_LOAD_LOCAL, iter)
+	exit := c.emitJump(OP_ITER_NEXT)
+
+	c.pushScope()
+	for _, decl := range []*AstVarDecl{node.val, node.key} {
+		if decl == nil {
+			c.emit(OP_POP)
+			continue
+		}
+
+		slot := c.declareLocal(decl)
+		c.emitU16(OP_STORE_LOCAL, slot)
+		if decl.captured {
+			c.emitU16(OP_MAKE_CELL, slot)
+		}
+	}
+
+	loop := &bcLoop{tries: c.tries}
+	c.loops = append(c.loops, loop)
+	c.compileCodeBlock(node.block)
+	c.loops = c.loops[:len(c.loops)-1]
+	c.popScope()
+
+	//the jump back is a round of the loop, it runs at the loop line
+	c.setLine(node.line)
+	back := c.emitJump(OP_JUMP)
+	c.patchJumpTo(back, start)
+	c.patchJump(exit)
+	c.patchLoop(loop, start)
+	c.popScope()
+}
+
+func (c *bcCompiler) compileAssign(node *AstAssgin) {
+	c.setLine(node.line)
+	c.compileExpr(node.expr)
+	c.compileAssignTo(node.dst)
+}
+
+//compileMultiAssign unpacks the values with the first one on top, so
+//targets are stored from left to right like the tree walker does
+func (c *bcCompiler) compileMultiAssign(node *AstMultiAssign) {
+	c.setLine(node.line)
+	c.compileExpr(node.expr)
+
+	count := len(node.dsts)
+	if node.define {
+		count = len(node.decls)
+	}
+	c.setLine(node.line)
+	c.emitU16(OP_UNPACK, count)
+
+	for idx := 0; idx < count; idx++ {
+		if node.define {
+			decl := node.decls[idx]
+			if decl == nil {
+				c.emit(OP_POP)
+				continue
+			}
+
+			slot := c.declareLocal(decl)
+			c.emitU16(OP_STORE_LOCAL, slot)
+			if decl.captured {
+				c.emitU16(OP_MAKE_CELL, slot)
+			}
+		} else if node.dsts[idx] == nil {
+			c.emit(OP_POP)
+		} else {
+			c.compileAssignTo(node.dsts[idx])
+		}
+	}
+}
+
+//compileAssignTo stores the value on top of stack into dst
+func (c *bcCompiler) compileAssignTo(dst AstNode) {
+	switch dst := dst.(type) {
+	case *AstVarNameRef:
+		c.compileStore(dst)
+		break
+
+	case *AstIndexedRef:
+		c.compileExpr(dst.host)
+		c.compileExpr(dst.index)
+		c.setLine(dst.line)
+		c.emit(OP_SET_INDEX)
+		break
+
+	case *AstDotRef:
+		c.compileExpr(dst.host)
+		c.setLine(dst.line)
+		c.emitU16(OP_SET_FIELD, c.fn.addConst(dst.name))
+		break
+
+	default:
+		doPanic("compile error, can not assign to: %T", dst)
+	}
+}
+
+func (c *bcCompiler) compileStore(node *AstVarNameRef) {
+	if node.scope != refGlobal {
+		if local, ok := c.lookupLocal(node.name); ok {
+			if local.cell {
+				c.emitU16(OP_STORE_CELL, local.slot)
+			} else {
+				c.emitU16(OP_STORE_LOCAL, local.slot)
+			}
+			return
+		}
+
+		if idx, ok := c.lookupUpval(node.name); ok {
+			c.emitU16(OP_STORE_UPVAL, idx)
+			return
+		}
+	}
+
+	if slot, ok := c.globals[node.name]; ok {
+		c.emitU16(OP_STORE_GLOBAL, slot)
+		return
+	}
+
+	doPanic("compile error, symbol not found: %s, line: %d", node.name, node.line)
+}
+
+func (c *bcCompiler) compileLoad(node *AstVarNameRef) {
+	if node.fn != nil {
+		c.emitU16(OP_CONST, c.fn.addConst(c.funcValue(node.fn)))
+		return
+	}
+
+	if node.scope != refGlobal {
+		if local, ok := c.lookupLocal(node.name); ok {
+			if local.cell {
+				c.emitU16(OP_LOAD_CELL, local.slot)
+			} else {
+				c.emitU16(OP_LOAD_LOCAL, local.slot)
+			}
+			return
+		}
+
+		if idx, ok := c.lookupUpval(node.name); ok {
+			c.emitU16(OP_LOAD_UPVAL, idx)
+			return
+		}
+	}
+
+	if slot, ok := c.globals[node.name]; ok {
+		c.emitU16(OP_LOAD_GLOBAL, slot)
+		return
+	}
+
+	doPanic("compile error, symbol not found: %s, line: %d", node.name, node.line)
+}
+
+var bcBinOps = map[string]byte{
+	PLUS: OP_ADD, MINUS: OP_SUB, MUL: OP_MUL, DIV: OP_DIV, MOD: OP_MOD, POW: OP_POW,
+	BIT_AND: OP_BIT_AND, BIT_OR: OP_BIT_OR, BIT_XOR: OP_BIT_XOR, SHL: OP_SHL, SHR: OP_SHR,
+	EQU: OP_EQ, NEQ: OP_NEQ, LT: OP_LT, LTE: OP_LTE, GT: OP_GT, GTE: OP_GTE,
+}
+
+func (c *bcCompiler) compileExpr(ast AstNode) {
+	switch node := ast.(type) {
+	case *AstIntConst:
+		c.emitU16(OP_CONST, c.fn.addConst(node.value))
+		break
+
+	case *AstFloatConst:
+		c.emitU16(OP_CONST, c.fn.addConst(node.value))
+		break
+
+	case *AstBoolConst:
+		c.emitU16(OP_CONST, c.fn.addConst(node.value))
+		break
+
+	case *AstStringConst:
+		c.emitU16(OP_CONST, c.fn.addConst(node.value))
+		break
+
+	case *AstVarNameRef:
+		c.setLine(node.line)
+		c.compileLoad(node)
+		break
+
+	case *AstBinOP:
+		if node.op == AND || node.op == OR {
+			c.compileLogicOP(node)
+			break
+		}
+
+		c.compileExpr(node.left)
+		c.compileExpr(node.right)
+		op, ok := bcBinOps[node.op]
+		if !ok {
+			doPanic("compile error, unknown binary operator: %s, line: %d", node.op, node.line)
+		}
+		c.setLine(node.line)
+		c.emit(op)
+		break
+
+	case *AstUnaryOP:
+		c.compileExpr(node.dst)
+		c.setLine(node.line)
+		switch node.op {
+		case PLUS:
+			break
+
+		case MINUS:
+			c.emit(OP_NEG)
+			break
+
+		case NOT:
+			c.emit(OP_NOT)
+			break
+
+		default:
+			doPanic("compile error, unknown unary operator: %s, line: %d", node.op, node.line)
+		}
+		break
+
+	case *AstIndexedRef:
+		//index first, same order as the tree walker
+		c.compileExpr(node.index)
+		c.compileExpr(node.host)
+		c.setLine(node.line)
+		c.emit(OP_INDEX)
+		break
+
+	case *AstSliceRef:
+		c.compileExpr(node.host)
+		for _, bound := range []AstNode{node.low, node.high} {
+			if bound != nil {
+				c.compileExpr(bound)
+			} else {
+				c.emit(OP_NIL)
+			}
+		}
+		c.setLine(node.line)
+		c.emit(OP_SLICE)
+		break
+
+	case *AstDotRef:
+		c.compileExpr(node.host)
+		c.setLine(node.line)
+		c.emitU16(OP_FIELD, c.fn.addConst(node.name))
+		break
+
+	case *AstNewOP:
+		c.setLine(node.line)
+		c.emitU16(OP_NEW, c.fn.addConst(node.opType))
+		break
+
+	case *AstMapLit:
+		for idx := range node.keys {
+			c.compileExpr(node.keys[idx])
+			c.compileExpr(node.vals[idx])
+		}
+		c.setLine(node.line)
+		c.emitU16(OP_MAKE_MAP, len(node.keys))
+		break
+
+	case *AstFuncCall:
+		c.compileFuncCall(node)
+		break
+
+	case *AstFuncLit:
+		c.compileFuncLit(node)
+		break
+
+	case *AstIfaceBox:
+		c.compileExpr(node.expr)
+		c.setLine(node.line)
+		c.emitU16(OP_BOX, c.fn.addConst(node.strct))
+		break
+
+	default:
+		doPanic("compile error, unknown expression: %T", ast)
+	}
+}
+
+//compileLogicOP jumps over the right side when the left side decides
+//the result, same short circuit as the tree walker
+func (c *bcCompiler) compileLogicOP(node *AstBinOP) {
+	c.compileExpr(node.left)
+	c.setLine(node.line)
+	other := c.emitJump(OP_JUMP_FALSE)
+
+	if node.op == AND {
+		c.compileExpr(node.right)
+		end := c.emitJump(OP_JUMP)
+		c.patchJump(other)
+		c.emitU16(OP_CONST, c.fn.addConst(false))
+		c.patchJump(end)
+	} else {
+		c.emitU16(OP_CONST, c.fn.addConst(true))
+		end := c.emitJump(OP_JUMP)
+		c.patchJump(other)
+		c.compileExpr(node.right)
+		c.patchJump(end)
+	}
+}
+
+func (c *bcCompiler) compileFuncCall(node *AstFuncCall) {
+	if node.callee != nil {
+		c.compileValueCall(node)
+		return
+	}
+
+	if node.dynamic {
+		c.compileDynamicCall(node)
+		return
+	}
+
+	//receiver is passed as the first arg of a method
+	argc := len(node.args)
+	if node.recv != nil {
+		c.compileExpr(node.recv)
+		if !node.ast.recvPtr {
+			c.setLine(node.line)
+			c.emitU16(OP_COPY_RECV, c.fn.addConst(node.ast))
+		}
+		argc++
+	}
+
+	for _, arg := range node.args {
+		c.compileExpr(arg)
+	}
+	c.setLine(node.line)
+
+	if node.ast.native != nil {
+		idx, ok := c.nativeIdx[node.ast]
+		if !ok {
+			idx = len(c.prog.natives)
+			c.nativeIdx[node.ast] = idx
+			c.prog.natives = append(c.prog.natives, node.ast)
+		}
+		c.emitCall(OP_NATIVE, idx, len(node.args))
+		return
+	}
+
+	idx, ok := c.funcIdx[node.ast]
+	if !ok {
+		doPanic("compile error, func not found: %s, line: %d", node.name, node.line)
+	}
+	c.emitCall(OP_CALL, idx, argc)
+}
+
+//compileDynamicCall looks up the method of the struct boxed in the
+//interface, then calls it like a func value with the receiver first
+func (c *bcCompiler) compileDynamicCall(node *AstFuncCall) {
+	c.compileExpr(node.recv)
+	c.setLine(node.line)
+	c.emitU16(OP_METHOD, c.fn.addConst(node.name))
+	for _, arg := range node.args {
+		c.compileExpr(arg)
+	}
+	c.setLine(node.line)
+
+	if len(node.args)+1 > 0xff {
+		doPanic("compile error, too many args: %d, line: %d", len(node.args), c.line)
+	}
+	c.emit(OP_CALL_VALUE)
+	c.emit(byte(len(node.args) + 1))
+}
+
+//compileValueCall pushes the func value below its args
+func (c *bcCompiler) compileValueCall(node *AstFuncCall) {
+	c.compileExpr(node.callee)
+	for _, arg := range node.args {
+		c.compileExpr(arg)
+	}
+	c.setLine(node.line)
+
+	if len(node.args) > 0xff {
+		doPanic("compile error, too many args: %d, line: %d", len(node.args), c.line)
+	}
+	c.emit(OP_CALL_VALUE)
+	c.emit(byte(len(node.args)))
+}
+
+func (c *bcCompiler) DoCompile(root AstNode) (prog *bcProgram, result error) {
+	return c.compile(root, true)
+}
+
+//compile translates the program, a library loaded by embedder needs no main
+func (c *bcCompiler) compile(root AstNode, needMain bool) (prog *bcProgram, result error) {
+	defer func() {
+		if r := recover(); r != nil {
+			prog = nil
+			result = errors.New(panicMessage(r))
+		}
+	}()
+
+	node, ok := root.(*AstProgram)
+	if !ok {
+		return nil, errors.Errorf("root ast type should be program, actual recv: %T", root)
+	}
+
+	c.prog.file = node.file
+	c.compileProgram(node)
+	if needMain && c.prog.mainFunc < 0 {
+		return nil, errors.Errorf("main func is not defined")
+	}
+
+	return c.prog, nil
+}
+
+func NewCompiler() *bcCompiler {
+	c := &bcCompiler{}
+	c.prog = &bcProgram{}
+	c.funcIdx = make(map[*AstFuncDecl]int)
+	c.nativeIdx = make(map[*AstFuncDecl]int)
+	c.funcVals = make(map[*AstFuncDecl]*bcClosure)
+	c.globals = make(map[string]int)
+	return c
+}
@@ -0,0 +1,410 @@
+package hskl
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//the program runs in one thread as far as the editor knows
+const dapThreadID = 1
+
+type dapMessage struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+type dapArgs struct {
+	Program     string `json:"program"`
+	StopOnEntry bool   `json:"stopOnEntry"`
+	Source      struct {
+		Path string `json:"path"`
+	} `json:"source"`
+	Breakpoints []struct {
+		Line      int    `json:"line"`
+		Condition string `json:"condition"`
+	} `json:"breakpoints"`
+	FrameID            int    `json:"frameId"`
+	VariablesReference int    `json:"variablesReference"`
+	Expression         string `json:"expression"`
+}
+
+type dapVariable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+//dapOutput sends what the program writes as output events
+type dapOutput struct {
+	srv      *dapServer
+	category string
+}
+
+func (out *dapOutput) Write(p []byte) (int, error) {
+	out.srv.event("output", map[string]string{"category": out.category, "output": string(p)})
+	return len(p), nil
+}
+
+//dapScope is what a variables reference of the scopes reply points at
+type dapScope struct {
+	frame  *stackFrame
+	global bool
+}
+
+//dapServer is the debug adapter, requests are read by a goroutine and
+//served by the one running the program: between statements while it
+//runs, and until it goes on when it stopped
+type dapServer struct {
+	out     io.Writer
+	mu      sync.Mutex
+	seq     int
+	reqs    chan *dapMessage
+	readErr error
+
+	dbg         *debugger
+	file        string
+	stopOnEntry bool
+	running     bool
+	resume      bool
+	done        bool
+	//frames and refs are valid while the program stops, a variables
+	//reference is the index in refs plus 1
+	frames []*stackFrame
+	refs   []interface{}
+}
+
+//ServeDAP serves the debug adapter protocol until the editor disconnects,
+//the program to debug is given by the launch request
+func ServeDAP(in io.Reader, out io.Writer) error {
+	srv := &dapServer{out: out, reqs: make(chan *dapMessage)}
+	go srv.readLoop(bufio.NewReader(in))
+
+	for msg := range srv.reqs {
+		srv.handle(msg)
+		if srv.done {
+			return nil
+		}
+	}
+	return srv.readErr
+}
+
+func (srv *dapServer) readLoop(in *bufio.Reader) {
+	defer close(srv.reqs)
+	for {
+		body, err := readFrame(in)
+		if err != nil {
+			if err != io.EOF {
+				srv.readErr = err
+			}
+			return
+		}
+
+		msg := &dapMessage{}
+		if err := json.Unmarshal(body, msg); err != nil {
+			srv.readErr = err
+			return
+		}
+		srv.reqs <- msg
+	}
+}
+
+func (srv *dapServer) write(msg map[string]interface{}) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	srv.seq++
+	msg["seq"] = srv.seq
+	body, _ := json.Marshal(msg)
+	fmt.Fprintf(srv.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (srv *dapServer) reply(req *dapMessage, body interface{}) {
+	srv.write(map[string]interface{}{"type": "response", "request_seq": req.Seq, "command": req.Command,
+		"success": true, "body": body})
+}
+
+func (srv *dapServer) fail(req *dapMessage, msg string) {
+	srv.write(map[string]interface{}{"type": "response", "request_seq": req.Seq, "command": req.Command,
+		"success": false, "message": msg})
+}
+
+func (srv *dapServer) event(name string, body interface{}) {
+	srv.write(map[string]interface{}{"type": "event", "event": name, "body": body})
+}
+
+//handle serves one request, a request always gets a response even when
+//serving it fails
+func (srv *dapServer) handle(req *dapMessage) {
+	defer func() {
+		if r := recover(); r != nil {
+			srv.fail(req, panicMessage(r))
+		}
+	}()
+
+	args := &dapArgs{}
+	if len(req.Arguments) > 0 {
+		if err := json.Unmarshal(req.Arguments, args); err != nil {
+			doPanic("bad arguments of %s: %s", req.Command, err)
+		}
+	}
+
+	d := srv.dbg
+	if d == nil && req.Command != "initialize" && req.Command != "launch" && req.Command != "disconnect" {
+		doPanic("no program launched")
+	}
+
+	switch req.Command {
+	case "initialize":
+		srv.reply(req, map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsConditionalBreakpoints":   true,
+			"supportsEvaluateForHovers":        true,
+		})
+		srv.event("initialized", nil)
+
+	case "launch":
+		body, err := ioutil.ReadFile(args.Program)
+		if err != nil {
+			doPanic("%s", err)
+		}
+		dbg, err := newDebugger(string(body), args.Program)
+		if err != nil {
+			doPanic("%s", err)
+		}
+
+		dbg.stopped, dbg.poll = srv.stopped, srv.poll
+		//stdin carries the protocol, the program reads no input
+		dbg.interp.SetStdout(&dapOutput{srv: srv, category: "stdout"})
+		dbg.interp.SetStderr(&dapOutput{srv: srv, category: "stderr"})
+		dbg.interp.SetStdin(strings.NewReader(""))
+		srv.dbg, srv.file, srv.stopOnEntry = dbg, args.Program, args.StopOnEntry
+		srv.reply(req, nil)
+
+	case "setBreakpoints":
+		d.breaks = map[int]*breakpoint{}
+		bps := []map[string]interface{}{}
+		for _, bp := range args.Breakpoints {
+			err := d.setBreak(bp.Line, bp.Condition)
+			verified := err == nil && d.lines[bp.Line]
+			res := map[string]interface{}{"verified": verified, "line": bp.Line}
+			if err != nil {
+				res["message"] = err.Error()
+			} else if !verified {
+				res["message"] = "no statement starts at this line"
+			}
+			bps = append(bps, res)
+		}
+		srv.reply(req, map[string]interface{}{"breakpoints": bps})
+
+	case "configurationDone":
+		srv.reply(req, nil)
+		if !srv.running {
+			srv.run()
+		}
+
+	case "threads":
+		srv.reply(req, map[string]interface{}{"threads": []map[string]interface{}{{"id": dapThreadID, "name": "main"}}})
+
+	case "stackTrace":
+		stack, active := d.frames()
+		srv.frames = active
+		frames := []map[string]interface{}{}
+		for idx, frame := range stack {
+			frames = append(frames, map[string]interface{}{"id": idx, "name": frame.Func, "line": frame.Line,
+				"column": 1, "source": map[string]string{"name": filepath.Base(srv.file), "path": srv.file}})
+		}
+		srv.reply(req, map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)})
+
+	case "scopes":
+		frame := srv.frame(args.FrameID)
+		srv.reply(req, map[string]interface{}{"scopes": []map[string]interface{}{
+			{"name": "Locals", "variablesReference": srv.ref(&dapScope{frame: frame}), "expensive": false},
+			{"name": "Globals", "variablesReference": srv.ref(&dapScope{global: true}), "expensive": false},
+		}})
+
+	case "variables":
+		srv.reply(req, map[string]interface{}{"variables": srv.variables(args.VariablesReference)})
+
+	case "evaluate":
+		val, tp, err := d.eval(args.Expression, srv.frame(args.FrameID))
+		if err != nil {
+			doPanic("%s", err)
+		}
+		srv.reply(req, map[string]interface{}{"result": formatValue(val), "type": tp.desc(),
+			"variablesReference": srv.ref(val)})
+
+	case "continue":
+		d.mode = debugRun
+		srv.resume = true
+		srv.reply(req, map[string]interface{}{"allThreadsContinued": true})
+
+	case "next":
+		d.mode = debugStepOver
+		srv.resume = true
+		srv.reply(req, nil)
+
+	case "stepIn":
+		d.mode = debugStepIn
+		srv.resume = true
+		srv.reply(req, nil)
+
+	case "stepOut":
+		d.mode = debugStepOut
+		srv.resume = true
+		srv.reply(req, nil)
+
+	case "pause":
+		d.paused = true
+		srv.reply(req, nil)
+
+	case "disconnect", "terminate":
+		if d != nil {
+			d.quit = true
+		}
+		srv.resume, srv.done = true, true
+		srv.reply(req, nil)
+
+	default:
+		doPanic("unsupported request: %s", req.Command)
+	}
+}
+
+//run runs the launched program, what it prints is sent as output events
+func (srv *dapServer) run() {
+	srv.running = true
+	err := srv.dbg.run(srv.stopOnEntry)
+
+	code := 0
+	if rtErr, ok := err.(*RuntimeError); ok {
+		srv.event("output", map[string]string{"category": "stderr", "output": rtErr.Traceback()})
+		code = 1
+	} else if err != nil && err != errDebugQuit {
+		srv.event("output", map[string]string{"category": "stderr", "output": err.Error() + "\n"})
+		code = 1
+	}
+	srv.event("exited", map[string]int{"exitCode": code})
+	srv.event("terminated", nil)
+}
+
+//stopped serves requests until one lets the program go on
+func (srv *dapServer) stopped(reason string) {
+	d := srv.dbg
+	srv.frames, srv.refs, srv.resume = nil, nil, false
+	srv.event("stopped", map[string]interface{}{"reason": reason, "threadId": dapThreadID, "allThreadsStopped": true})
+	if d.condErr != nil {
+		srv.event("output", map[string]string{"category": "console", "output": d.condErr.Error() + "\n"})
+	}
+
+	for !srv.resume {
+		req, ok := <-srv.reqs
+		if !ok {
+			d.quit = true
+			return
+		}
+		srv.handle(req)
+	}
+}
+
+//poll serves the requests come in while the program runs
+func (srv *dapServer) poll() {
+	for {
+		select {
+		case req, ok := <-srv.reqs:
+			if !ok {
+				srv.dbg.quit = true
+				return
+			}
+			srv.handle(req)
+
+		default:
+			return
+		}
+	}
+}
+
+func (srv *dapServer) frame(id int) *stackFrame {
+	if srv.frames == nil {
+		_, srv.frames = srv.dbg.frames()
+	}
+	if id < 0 || id >= len(srv.frames) {
+		doPanic("unknown frame: %d", id)
+	}
+	return srv.frames[id]
+}
+
+//ref gives a variables reference of a scope or a value with elements, 0
+//when there is nothing to expand
+func (srv *dapServer) ref(val interface{}) int {
+	switch tVal := val.(type) {
+	case []interface{}:
+		if len(tVal) == 0 {
+			return 0
+		}
+
+	case map[string]interface{}, map[interface{}]interface{}, *dapScope:
+		break
+
+	default:
+		return 0
+	}
+
+	srv.refs = append(srv.refs, val)
+	return len(srv.refs)
+}
+
+func (srv *dapServer) variables(ref int) []dapVariable {
+	if ref < 1 || ref > len(srv.refs) {
+		doPanic("unknown variables reference: %d", ref)
+	}
+
+	vars := []dapVariable{}
+	add := func(name string, val interface{}, tp AstNode) {
+		v := dapVariable{Name: name, Value: formatValue(val), VariablesReference: srv.ref(val)}
+		if tp != nil {
+			v.Type = tp.desc()
+		}
+		vars = append(vars, v)
+	}
+
+	switch tVal := srv.refs[ref-1].(type) {
+	case *dapScope:
+		list := srv.dbg.globals()
+		if !tVal.global {
+			list = srv.dbg.locals(tVal.frame)
+		}
+		for _, v := range list {
+			add(v.name, v.val, v.type_)
+		}
+
+	case []interface{}:
+		for idx, elem := range tVal {
+			add(fmt.Sprintf("[%d]", idx), elem, nil)
+		}
+
+	case map[string]interface{}:
+		names := []string{}
+		for name := range tVal {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			add(name, tVal[name], nil)
+		}
+
+	case map[interface{}]interface{}:
+		for _, key := range sortedKeys(tVal) {
+			add(formatValue(key), tVal[key], nil)
+		}
+	}
+	return vars
+}
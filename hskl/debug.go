@@ -0,0 +1,570 @@
+package hskl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+//errDebugQuit is returned by a program the debugger was told to stop
+var errDebugQuit = errors.New("debug session quit")
+
+//step modes of the debugger, a step stops at the next statement run at
+//the call depth it asks for
+const (
+	debugRun = iota
+	debugStepIn
+	debugStepOver
+	debugStepOut
+)
+
+//reasons a program stops for
+const (
+	stopEntry      = "entry"
+	stopStep       = "step"
+	stopBreakpoint = "breakpoint"
+	stopPause      = "pause"
+)
+
+type breakpoint struct {
+	line int
+	cond string
+}
+
+//debugger runs a program under control of a front end. the interpreter
+//calls hook before each statement, the program stops there when a step
+//or a breakpoint says so and goes on when the front end returns
+type debugger struct {
+	interp   *interpreter
+	analyzer *semanticAnalyzer
+	pro      *AstProgram
+	src      []string
+	//lines are the lines a statement starts at
+	lines  map[int]bool
+	breaks map[int]*breakpoint
+	mode   int
+	//depth is the call depth the last step started at
+	depth  int
+	entry  bool
+	paused bool
+	quit   bool
+	//line and col are where the program stopped
+	line int
+	col  int
+	//a line with several statements breaks once, at the first of them
+	lastStat  AstNode
+	lastLine  int
+	lastDepth int
+	//condErr is set when the condition of the breakpoint hit fails
+	condErr    error
+	evaluating bool
+
+	//stopped is called when the program stops, poll before each statement
+	//while it runs
+	stopped func(reason string)
+	poll    func()
+}
+
+//newDebugger checks the program in src, it does not run yet
+func newDebugger(src, file string) (*debugger, error) {
+	p := NewParser(src)
+	p.SetFile(file)
+	pro := p.Program().(*AstProgram)
+	if diags := p.Diagnostics(); len(diags) > 0 {
+		return nil, diags
+	}
+
+	analyzer := NewSemanticAnalyzer()
+	if err := analyzer.DoAnalyze(pro); err != nil {
+		return nil, err
+	}
+
+	d := &debugger{interp: NewInterpreter(), analyzer: analyzer, pro: pro, src: strings.Split(src, "\n"),
+		lines: map[int]bool{}, breaks: map[int]*breakpoint{}}
+	d.interp.hook = d.hook
+	stmtLines(pro, d.lines)
+	return d, nil
+}
+
+//run runs the program to its end, stopOnEntry stops it before the first
+//statement of main. errDebugQuit means the front end stopped it
+func (d *debugger) run(stopOnEntry bool) error {
+	if stopOnEntry {
+		d.mode = debugStepIn
+		d.entry = true
+	}
+	return d.interp.DoInterpret(d.pro)
+}
+
+func (d *debugger) hook(stat AstNode) {
+	if d.evaluating {
+		return
+	}
+	if d.poll != nil {
+		d.poll()
+	}
+	if d.quit {
+		panic(errDebugQuit)
+	}
+
+	line, col := astPos(stat)
+	depth := d.interp.callDepth()
+	sameLine := line == d.lastLine && depth == d.lastDepth && stat != d.lastStat
+	d.lastStat, d.lastLine, d.lastDepth = stat, line, depth
+
+	reason := ""
+	if d.paused {
+		reason = stopPause
+	} else if d.mode == debugStepIn || (d.mode == debugStepOver && depth <= d.depth) ||
+		(d.mode == debugStepOut && depth < d.depth) {
+		reason = stopStep
+		if d.entry {
+			reason = stopEntry
+		}
+	} else if bp, ok := d.breaks[line]; ok && !sameLine && d.hit(bp) {
+		reason = stopBreakpoint
+	}
+
+	if reason == "" {
+		return
+	}
+
+	d.line, d.col = line, col
+	d.mode, d.entry, d.paused = debugRun, false, false
+	d.stopped(reason)
+	d.condErr = nil
+	if d.quit {
+		panic(errDebugQuit)
+	}
+	d.depth = depth
+}
+
+//hit tells if the program stops at bp, a condition which fails to run
+//stops it too, with condErr set
+func (d *debugger) hit(bp *breakpoint) bool {
+	if bp.cond == "" {
+		return true
+	}
+
+	val, tp, err := d.eval(bp.cond, d.interp.curFrame)
+	if err == nil && tp.signature() != "B" {
+		err = errors.Errorf("condition should be bool, actual: %s", tp.desc())
+	}
+	if err != nil {
+		d.condErr = errors.Wrapf(err, "condition of breakpoint at line %d", bp.line)
+		return true
+	}
+	return val.(bool)
+}
+
+//setBreak sets the breakpoint at line, cond is a bool expression checked
+//where the program is when the line is reached
+func (d *debugger) setBreak(line int, cond string) error {
+	if cond != "" {
+		if err := parseExpr(cond, d.pro.tpMap); err != nil {
+			return err
+		}
+	}
+
+	d.breaks[line] = &breakpoint{line: line, cond: cond}
+	return nil
+}
+
+func parseExpr(text string, tpMap map[string]AstType) (result error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = errors.New(panicMessage(r))
+		}
+	}()
+
+	newIncrementalParser(text, tpMap).replExpr()
+	return nil
+}
+
+//eval evaluates expr in frame, names are looked up from frame outwards
+//like the running code does. the state of the program is kept as it was
+func (d *debugger) eval(expr string, frame *stackFrame) (val interface{}, tp AstType, result error) {
+	interp, se := d.interp, d.analyzer
+	stack, size, cur, state := interp.callStack, interp.stackSize, interp.curFrame, frame.state
+	d.evaluating = true
+	defer func() {
+		if r := recover(); r != nil {
+			result = errors.New(panicMessage(r))
+		}
+		d.evaluating = false
+		interp.callStack, interp.stackSize, interp.curFrame = stack, size, cur
+		frame.state = state
+		se.resetInput()
+	}()
+
+	node := newIncrementalParser(expr, d.pro.tpMap).replExpr()
+	se.pushSymbolTable()
+	for _, v := range d.locals(frame) {
+		if vtp, ok := v.type_.(AstType); ok {
+			decl := &AstVarDecl{name: v.name, type_: vtp}
+			se.curSymbolTable.insertSymbol(newVarSymbol(v.name, vtp, se.curSymbolTable.level, decl), se.debug)
+		}
+	}
+
+	tp, _ = se.visitAst(node).(AstType)
+	if tp == nil {
+		doPanic("expression has no value: %s", expr)
+	}
+
+	interp.curFrame = frame
+	return interp.visitAst(node), tp, nil
+}
+
+//locals gives the vars seen from frame, globals excluded, sorted by name
+func (d *debugger) locals(frame *stackFrame) []*vari {
+	seen := map[string]bool{}
+	vars := []*vari{}
+	for ; frame != nil && frame != d.interp.globalFrame; frame = frame.upLevel {
+		for name, v := range frame.table {
+			if !seen[name] {
+				seen[name] = true
+				vars = append(vars, v)
+			}
+		}
+	}
+
+	sort.Slice(vars, func(i, j int) bool { return vars[i].name < vars[j].name })
+	return vars
+}
+
+func (d *debugger) globals() []*vari {
+	vars := []*vari{}
+	for _, v := range d.interp.globalFrame.table {
+		vars = append(vars, v)
+	}
+
+	sort.Slice(vars, func(i, j int) bool { return vars[i].name < vars[j].name })
+	return vars
+}
+
+//frames gives the funcs being called and the block frames they run in,
+//the innermost first
+func (d *debugger) frames() ([]StackFrame, []*stackFrame) {
+	stack, active := d.interp.backtrace(), d.interp.activeFrames()
+	if len(stack) > 0 {
+		stack[len(stack)-1].Line = d.line
+	}
+
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+		active[i], active[j] = active[j], active[i]
+	}
+	return stack, active
+}
+
+//stmtLines records the lines statements start at, the bodies of func
+//literals included
+func stmtLines(node AstNode, lines map[int]bool) {
+	switch n := node.(type) {
+	case *AstProgram:
+		for _, decl := range n.decl_list {
+			stmtLines(decl, lines)
+		}
+
+	case *AstFuncDecl:
+		stmtLines(n.block, lines)
+
+	case *AstFuncLit:
+		stmtLines(n.decl.block, lines)
+
+	case *AstVarDecl:
+		if n.initExpr != nil {
+			stmtLines(n.initExpr, lines)
+		}
+
+	case *AstCodeBlock:
+		if n == nil {
+			return
+		}
+		for _, decl := range n.vars {
+			stmtLines(decl, lines)
+		}
+		for _, stat := range n.stat_list {
+			line, _ := astPos(stat)
+			lines[line] = true
+			stmtLines(stat, lines)
+		}
+
+	case *AstConditionBlock:
+		for ; n != nil; n = n.altCondBlock {
+			stmtLines(n.cond, lines)
+			stmtLines(n.block, lines)
+			stmtLines(n.altBlock, lines)
+		}
+
+	case *AstWhileBlock:
+		stmtLines(n.cond, lines)
+		stmtLines(n.block, lines)
+
+	case *AstForBlock:
+		for _, part := range []AstNode{n.init, n.cond, n.post, n.block} {
+			if part != nil {
+				stmtLines(part, lines)
+			}
+		}
+
+	case *AstRangeBlock:
+		stmtLines(n.expr, lines)
+		stmtLines(n.block, lines)
+
+	case *AstTryBlock:
+		stmtLines(n.block, lines)
+		stmtLines(n.catch, lines)
+
+	case *AstAssgin:
+		stmtLines(n.expr, lines)
+
+	case *AstMultiAssign:
+		stmtLines(n.expr, lines)
+
+	case *AstReturn:
+		if n.expr != nil {
+			stmtLines(n.expr, lines)
+		}
+
+	case *AstThrow:
+		stmtLines(n.expr, lines)
+
+	case *AstBinOP:
+		stmtLines(n.left, lines)
+		stmtLines(n.right, lines)
+
+	case *AstUnaryOP:
+		stmtLines(n.dst, lines)
+
+	case *AstTuple:
+		for _, expr := range n.exprs {
+			stmtLines(expr, lines)
+		}
+
+	case *AstFuncCall:
+		for _, arg := range n.args {
+			stmtLines(arg, lines)
+		}
+	}
+}
+
+const debugHelp = `commands:
+  break <line> [if <cond>]  b  stop at line, only when cond is true if given
+  delete <line>             d  remove the breakpoint at line
+  breaks                       list the breakpoints
+  continue                  c  run until a breakpoint
+  step                      s  run to the next statement, into calls
+  next                      n  run to the next statement, over calls
+  out                       o  run until the current func returns
+  bt                           show the funcs being called
+  print <expr>              p  evaluate expr where the program stopped
+  locals                       show the vars of the current func
+  list                      l  show the source around the current line
+  help                      h  show this message
+  quit                      q  stop the program
+an empty line repeats the last continue or step command
+`
+
+//debugCLI is the command line front end of the debugger
+type debugCLI struct {
+	dbg  *debugger
+	in   *bufio.Scanner
+	out  io.Writer
+	file string
+	last string
+}
+
+//RunDebugger debugs the program in src with commands read from in. it
+//stops before the first statement of main so breakpoints can be set, what
+//the program prints goes to out too
+func RunDebugger(src, file string, in io.Reader, out io.Writer) error {
+	d, err := newDebugger(src, file)
+	if err != nil {
+		return err
+	}
+	d.interp.SetStdout(out)
+
+	cli := &debugCLI{dbg: d, in: bufio.NewScanner(in), out: out, file: file}
+	if cli.file == "" {
+		cli.file = "<script>"
+	}
+	d.stopped = cli.stopped
+
+	err = d.run(true)
+	if err == errDebugQuit {
+		return nil
+	}
+	if err == nil {
+		cli.printf("program exited\n")
+	}
+	return err
+}
+
+func (cli *debugCLI) printf(format string, args ...interface{}) {
+	fmt.Fprintf(cli.out, format, args...)
+}
+
+//stopped reads commands until one lets the program go on
+func (cli *debugCLI) stopped(reason string) {
+	d := cli.dbg
+	cli.printf("stopped at %s:%d (%s)\n", cli.file, d.line, reason)
+	if d.condErr != nil {
+		cli.printf("error: %v\n", d.condErr)
+	}
+	cli.list(d.line, 0)
+
+	for {
+		cli.printf("(hdb) ")
+		if !cli.in.Scan() {
+			cli.printf("\n")
+			d.quit = true
+			return
+		}
+
+		line := strings.TrimSpace(cli.in.Text())
+		if line == "" {
+			line = cli.last
+		}
+		if cli.command(line) {
+			return
+		}
+	}
+}
+
+//command runs one command, true means the program should go on
+func (cli *debugCLI) command(line string) bool {
+	d := cli.dbg
+	name, arg := line, ""
+	if idx := strings.IndexAny(line, " \t"); idx > 0 {
+		name, arg = line[:idx], strings.TrimSpace(line[idx:])
+	}
+
+	switch name {
+	case "":
+		break
+
+	case "break", "b":
+		cli.setBreak(arg)
+
+	case "delete", "d":
+		n, err := strconv.Atoi(arg)
+		if _, ok := d.breaks[n]; err != nil || !ok {
+			cli.printf("no breakpoint at line: %s\n", arg)
+		}
+		delete(d.breaks, n)
+
+	case "breaks":
+		lines := []int{}
+		for n := range d.breaks {
+			lines = append(lines, n)
+		}
+		sort.Ints(lines)
+		for _, n := range lines {
+			if cond := d.breaks[n].cond; cond != "" {
+				cli.printf("line %d if %s\n", n, cond)
+			} else {
+				cli.printf("line %d\n", n)
+			}
+		}
+
+	case "continue", "c":
+		d.mode = debugRun
+		cli.last = name
+		return true
+
+	case "step", "s":
+		d.mode = debugStepIn
+		cli.last = name
+		return true
+
+	case "next", "n":
+		d.mode = debugStepOver
+		cli.last = name
+		return true
+
+	case "out", "o":
+		d.mode = debugStepOut
+		cli.last = name
+		return true
+
+	case "bt":
+		stack, _ := d.frames()
+		for idx, frame := range stack {
+			cli.printf("#%d  %s at %s:%d\n", idx, frame.Func, cli.file, frame.Line)
+		}
+
+	case "print", "p":
+		val, _, err := d.eval(arg, d.interp.curFrame)
+		if err != nil {
+			cli.printf("error: %v\n", err)
+		} else {
+			cli.printf("%s\n", formatValue(val))
+		}
+
+	case "locals":
+		for _, v := range d.locals(d.interp.curFrame) {
+			cli.printf("%s: %s = %s\n", v.name, v.type_.desc(), formatValue(v.val))
+		}
+
+	case "list", "l":
+		cli.list(d.line, 3)
+
+	case "help", "h":
+		cli.printf("%s", debugHelp)
+
+	case "quit", "q":
+		d.quit = true
+		return true
+
+	default:
+		cli.printf("unknown command: %s, try help\n", name)
+	}
+	return false
+}
+
+//setBreak handles 'break <line> [if <cond>]'
+func (cli *debugCLI) setBreak(arg string) {
+	text, cond := arg, ""
+	if idx := strings.Index(arg, " if "); idx >= 0 {
+		text, cond = arg[:idx], strings.TrimSpace(arg[idx+len(" if "):])
+	}
+
+	line, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil || line <= 0 {
+		cli.printf("bad line: %s\n", text)
+		return
+	}
+
+	if err := cli.dbg.setBreak(line, cond); err != nil {
+		cli.printf("bad condition: %v\n", err)
+		return
+	}
+
+	if !cli.dbg.lines[line] {
+		cli.printf("breakpoint at line %d, no statement starts there\n", line)
+	} else {
+		cli.printf("breakpoint at line %d\n", line)
+	}
+}
+
+//list shows the source lines around line, the current one marked
+func (cli *debugCLI) list(line, around int) {
+	src := cli.dbg.src
+	for n := line - around; n <= line+around; n++ {
+		if n < 1 || n > len(src) {
+			continue
+		}
+
+		mark := "  "
+		if n == line {
+			mark = "=>"
+		}
+		cli.printf("%s %4d | %s\n", mark, n, strings.TrimRight(src[n-1], "\r"))
+	}
+}
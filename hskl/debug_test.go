@@ -0,0 +1,176 @@
+package hskl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const debugProgram = `func fib(n: int) int {
+    if n < 2 {
+        return n
+    }
+    return fib(n - 1) + fib(n - 2)
+}
+
+func main() {
+    var arr: []int
+    var a: int
+    while a < 5 {
+        arr = append(arr, fib(a))
+        a = a + 1
+    }
+    printn("len: " + str(len(arr)))
+}`
+
+func TestDebugger(t *testing.T) {
+	cmds := []string{
+		"b 12 if a == 3", "b 7", "c", "p a", "p fib(a) * 10", "p nope",
+		"s", "s", "s", "bt", "o", "locals", "n", "n", "d 12", "c",
+	}
+	out := &bytes.Buffer{}
+	err := RunDebugger(debugProgram, "fib.hskl", strings.NewReader(strings.Join(cmds, "\n")+"\n"), out)
+	if err != nil {
+		t.Fatalf("debug error: %v", err)
+	}
+
+	want := []string{
+		"stopped at fib.hskl:11 (entry)",
+		"breakpoint at line 7, no statement starts there",
+		"stopped at fib.hskl:12 (breakpoint)",
+		"(hdb) 3\n",
+		"(hdb) 20\n",
+		"(hdb) error: error in varRef, symbol not found: nope",
+		//into fib, then its return
+		"stopped at fib.hskl:2 (step)",
+		"stopped at fib.hskl:5 (step)",
+		"stopped at fib.hskl:2 (step)",
+		"#0  fib at fib.hskl:2\n#1  fib at fib.hskl:5\n#2  main at fib.hskl:12\n",
+		//the outer fib has no statement left, out goes on in main
+		"stopped at fib.hskl:13 (step)",
+		"(hdb) a: int = 3\narr: []int = [0 1 1 2]\n",
+		"stopped at fib.hskl:12 (step)",
+		"stopped at fib.hskl:13 (step)",
+		"len: 5\nprogram exited",
+	}
+	got := out.String()
+	for _, w := range want {
+		idx := strings.Index(got, w)
+		if idx < 0 {
+			t.Fatalf("missing %q in:\n%s", w, out.String())
+		}
+		got = got[idx+len(w):]
+	}
+}
+
+//dapScript frames the requests of a scripted editor
+func dapScript(msgs ...map[string]interface{}) *bytes.Buffer {
+	in := &bytes.Buffer{}
+	for idx, msg := range msgs {
+		msg["seq"] = idx + 1
+		msg["type"] = "request"
+		body, _ := json.Marshal(msg)
+		fmt.Fprintf(in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	return in
+}
+
+func TestDAP(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "hskl")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "fib.hskl")
+	ioutil.WriteFile(path, []byte(debugProgram), 0644)
+
+	req := func(cmd string, args map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{"command": cmd, "arguments": args}
+	}
+	in := dapScript(
+		req("initialize", map[string]interface{}{"adapterID": "hskl"}),
+		req("launch", map[string]interface{}{"program": path}),
+		req("setBreakpoints", map[string]interface{}{"source": map[string]string{"path": path},
+			"breakpoints": []map[string]interface{}{{"line": 3, "condition": "n == 1"}, {"line": 7}}}),
+		req("configurationDone", nil),
+		req("threads", nil),
+		req("stackTrace", map[string]interface{}{"threadId": 1}),
+		req("scopes", map[string]interface{}{"frameId": 1}),
+		req("variables", map[string]interface{}{"variablesReference": 1}),
+		req("evaluate", map[string]interface{}{"expression": "n + 100", "frameId": 0}),
+		req("evaluate", map[string]interface{}{"expression": "n +", "frameId": 0}),
+		req("setBreakpoints", map[string]interface{}{"source": map[string]string{"path": path},
+			"breakpoints": []map[string]interface{}{}}),
+		req("continue", map[string]interface{}{"threadId": 1}),
+		req("disconnect", nil),
+	)
+
+	out := &bytes.Buffer{}
+	if err := ServeDAP(in, out); err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+
+	resps := map[string][]string{}
+	events := []string{}
+	output := ""
+	for out.Len() > 0 {
+		var length int
+		if _, err := fmt.Fscanf(out, "Content-Length: %d\r\n\r\n", &length); err != nil {
+			t.Fatalf("read header: %v", err)
+		}
+		body := out.Next(length)
+		msg := map[string]interface{}{}
+		json.Unmarshal(body, &msg)
+
+		if msg["type"] == "event" {
+			events = append(events, msg["event"].(string))
+			if msg["event"] == "output" {
+				output += msg["body"].(map[string]interface{})["output"].(string)
+			}
+			continue
+		}
+		cmd := msg["command"].(string)
+		resps[cmd] = append(resps[cmd], string(body))
+	}
+
+	cases := []struct {
+		cmd  string
+		idx  int
+		want []string
+	}{
+		{"initialize", 0, []string{`"supportsConditionalBreakpoints":true`}},
+		{"setBreakpoints", 0, []string{`{"line":3,"verified":true}`, `"line":7,"message":"no statement starts at this line","verified":false`}},
+		{"stackTrace", 0, []string{`{"column":1,"id":0,"line":3,"name":"fib"`, `"id":1,"line":12,"name":"main"`}},
+		{"variables", 0, []string{`{"name":"a","value":"1","type":"int","variablesReference":0}`,
+			`{"name":"arr","value":"[0]","type":"[]int","variablesReference":3}`}},
+		{"evaluate", 0, []string{`"result":"101"`, `"type":"int"`}},
+		{"evaluate", 1, []string{`"success":false`}},
+		{"disconnect", 0, []string{`"success":true`}},
+	}
+	for _, c := range cases {
+		if len(resps[c.cmd]) <= c.idx {
+			t.Errorf("no response %d of %s", c.idx, c.cmd)
+			continue
+		}
+		for _, w := range c.want {
+			if got := resps[c.cmd][c.idx]; !strings.Contains(got, w) {
+				t.Errorf("%s: %s\nwant: %s", c.cmd, got, w)
+			}
+		}
+	}
+
+	stops := 0
+	for _, ev := range events {
+		if ev == "stopped" {
+			stops++
+		}
+	}
+	if stops != 1 || events[len(events)-1] != "terminated" {
+		t.Errorf("events: %v", events)
+	}
+	if output != "len: 5\n" {
+		t.Errorf("output: %q", output)
+	}
+}
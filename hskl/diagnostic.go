@@ -0,0 +1,163 @@
+package hskl
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//Severity tells how bad a diagnostic is
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+//diagnostic codes, Lxxx come from lexer, Pxxx from parser, Sxxx from analyzer.
+//S001 is a semantic error of no other kind
+const (
+	diagUnknownChar     = "L001"
+	diagUnclosedString  = "L002"
+	diagUnclosedComment = "L003"
+	diagIntRange        = "L004"
+	diagSyntax          = "P001"
+	diagSemantic        = "S001"
+	diagNoMain          = "S002"
+	diagUndefined       = "S003"
+	diagTypeMismatch    = "S004"
+	diagArgCount        = "S005"
+	diagRedefined       = "S006"
+	diagMisplaced       = "S007"
+)
+
+//Diagnostic is a problem found in the source. Line and Column are 1 based,
+//0 when unknown, Span is the count of chars marked from Column
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	Message  string   `json:"message"`
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Span     int      `json:"span"`
+}
+
+func (d *Diagnostic) String() string {
+	file := d.File
+	if file == "" {
+		file = "<script>"
+	}
+
+	pos := file
+	if d.Line > 0 {
+		pos += fmt.Sprintf(":%d", d.Line)
+		if d.Column > 0 {
+			pos += fmt.Sprintf(":%d", d.Column)
+		}
+	}
+	return fmt.Sprintf("%s: %s[%s]: %s", pos, d.Severity, d.Code, d.Message)
+}
+
+//Render formats the diagnostic with the source line it points at and a
+//caret under the marked chars, src is the whole source text
+func (d *Diagnostic) Render(src string) string {
+	var sb strings.Builder
+	sb.WriteString(d.String())
+	sb.WriteString("\n")
+
+	lines := strings.Split(src, "\n")
+	if d.Line <= 0 || d.Line > len(lines) {
+		return sb.String()
+	}
+
+	text := []rune(strings.TrimRight(lines[d.Line-1], "\r"))
+	gutter := fmt.Sprintf("%5d | ", d.Line)
+	fmt.Fprintf(&sb, "%s%s\n", gutter, string(text))
+
+	//tabs are kept so the caret lines up with the excerpt
+	col := d.Column
+	if col <= 0 {
+		col = 1
+		for col <= len(text) && (text[col-1] == ' ' || text[col-1] == '\t') {
+			col++
+		}
+	}
+
+	pad := []rune{}
+	for idx := 0; idx < col-1 && idx < len(text); idx++ {
+		if text[idx] == '\t' {
+			pad = append(pad, '\t')
+		} else {
+			pad = append(pad, ' ')
+		}
+	}
+
+	span := d.Span
+	if span < 1 {
+		span = 1
+	}
+	fmt.Fprintf(&sb, "%s| %s%s\n", strings.Repeat(" ", len(gutter)-2), string(pad), strings.Repeat("^", span))
+	return sb.String()
+}
+
+//Diagnostics is the list found in one run, it is the error returned
+//when the source has problems
+type Diagnostics []*Diagnostic
+
+func (ds Diagnostics) Error() string {
+	msgs := []string{}
+	for _, d := range ds {
+		msgs = append(msgs, d.String())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+//HasErrors tells if any diagnostic is an error, warnings do not stop a run
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+//Render formats all diagnostics against src
+func (ds Diagnostics) Render(src string) string {
+	var sb strings.Builder
+	for _, d := range ds {
+		sb.WriteString(d.Render(src))
+	}
+	return sb.String()
+}
+
+//JSON encodes the list for editors, an empty list is []
+func (ds Diagnostics) JSON() ([]byte, error) {
+	if ds == nil {
+		ds = Diagnostics{}
+	}
+	return json.MarshalIndent(ds, "", "  ")
+}
+
+//sortDiagnostics orders by position, diagnostics without line go first
+func sortDiagnostics(ds Diagnostics) {
+	sort.SliceStable(ds, func(i, j int) bool {
+		if ds[i].Line != ds[j].Line {
+			return ds[i].Line < ds[j].Line
+		}
+		return ds[i].Column < ds[j].Column
+	})
+}
+
+//newDiagnostic makes an error diagnostic, a trailing ", line: N" of msg is
+//taken as position when line is not known
+func newDiagnostic(code, file, msg string, line, col, span int) *Diagnostic {
+	text, n := splitLine(msg)
+	if n > 0 && n != line {
+		line, col, span = n, 0, 0
+	}
+	return &Diagnostic{Severity: SeverityError, Code: code, Message: text, File: file,
+		Line: line, Column: col, Span: span}
+}
@@ -0,0 +1,37 @@
+package hskl
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDiagnosticRender(t *testing.T) {
+	src := "func main() {\n\tvar s: string\n\ts = 5\n}"
+	d := newDiagnostic(diagSemantic, "a.hskl", "assign with diffirent type", 3, 4, 1)
+
+	want := "a.hskl:3:4: error[S001]: assign with diffirent type\n" +
+		"    3 | \ts = 5\n" +
+		"      | \t  ^\n"
+	if got := d.Render(src); got != want {
+		t.Errorf("render:\n%s\nwant:\n%s", got, want)
+	}
+
+	//position carried in the message is used when line is unknown
+	d = newDiagnostic(diagSemantic, "", "bad call, line: 2", 0, 0, 0)
+	if d.Line != 2 || d.Message != "bad call" || d.String() != "<script>:2: error[S001]: bad call" {
+		t.Errorf("diagnostic from message: %s", d)
+	}
+
+	var ds Diagnostics
+	out, err := ds.JSON()
+	if err != nil || string(out) != "[]" {
+		t.Errorf("empty json: %s, %v", out, err)
+	}
+
+	ds = Diagnostics{d}
+	out, _ = ds.JSON()
+	decoded := []map[string]interface{}{}
+	if err := json.Unmarshal(out, &decoded); err != nil || decoded[0]["code"] != "S001" || decoded[0]["line"] != 2.0 {
+		t.Errorf("json: %s, %v", out, err)
+	}
+}
@@ -84,7 +84,7 @@ func (se *astDotifier) visitCodeBlock(node *AstCodeBlock) interface{} {
 
 	for _, ast := range node.stat_list {
 		switch ast.(type) {
-		case *AstAssgin, *AstBinOP, *AstUnaryOP, *AstIntConst, *AstVarNameRef, *AstFuncCall, *AstReturn:
+		case *AstAssgin, *AstBinOP, *AstUnaryOP, *AstIntConst, *AstFloatConst, *AstBoolConst, *AstVarNameRef, *AstFuncCall, *AstReturn:
 			se.visitAst(ast)
 			se.ps.addLine("n%d -> n%d", node.seq, se.lastSeq)
 			break
@@ -179,7 +179,7 @@ func (se *astDotifier) visitAssign(node *AstAssgin) interface{} {
 	se.ps.addLine("n%d -> n%d", node.seq, se.lastSeq)
 
 	switch node.expr.(type) {
-	case *AstBinOP, *AstUnaryOP, *AstIntConst, *AstVarNameRef, *AstFuncCall:
+	case *AstBinOP, *AstUnaryOP, *AstIntConst, *AstFloatConst, *AstBoolConst, *AstVarNameRef, *AstFuncCall:
 		se.visitAst(node.expr)
 		se.ps.addLine("n%d -> n%d", node.seq, se.lastSeq)
 		break
@@ -197,7 +197,7 @@ func (se *astDotifier) visitBinOP(node *AstBinOP) interface{} {
 	se.ps.addLine("n%d [label=\"BinOp: %s\"]", node.seq, node.op)
 
 	switch node.left.(type) {
-	case *AstBinOP, *AstUnaryOP, *AstIntConst, *AstVarNameRef, *AstFuncCall:
+	case *AstBinOP, *AstUnaryOP, *AstIntConst, *AstFloatConst, *AstBoolConst, *AstVarNameRef, *AstFuncCall:
 		se.visitAst(node.left)
 		se.ps.addLine("n%d -> n%d", node.seq, se.lastSeq)
 		break
@@ -207,7 +207,7 @@ func (se *astDotifier) visitBinOP(node *AstBinOP) interface{} {
 	}
 
 	switch node.right.(type) {
-	case *AstBinOP, *AstUnaryOP, *AstIntConst, *AstVarNameRef, *AstFuncCall:
+	case *AstBinOP, *AstUnaryOP, *AstIntConst, *AstFloatConst, *AstBoolConst, *AstVarNameRef, *AstFuncCall:
 		se.visitAst(node.right)
 		se.ps.addLine("n%d -> n%d", node.seq, se.lastSeq)
 		break
@@ -225,7 +225,7 @@ func (se *astDotifier) visitUnaryOP(node *AstUnaryOP) interface{} {
 	se.ps.addLine("n%d [label=\"UnaryOp: %s\"]", node.seq, node.op)
 
 	switch node.dst.(type) {
-	case *AstBinOP, *AstUnaryOP, *AstIntConst, *AstVarNameRef:
+	case *AstBinOP, *AstUnaryOP, *AstIntConst, *AstFloatConst, *AstBoolConst, *AstVarNameRef:
 		seq := se.visitAst(node.dst).(string)
 		se.ps.addLine("n%d -> n%d", node.seq, seq)
 		break
@@ -245,6 +245,20 @@ func (se *astDotifier) visitIntConst(node *AstIntConst) interface{} {
 	return node.seq
 }
 
+func (se *astDotifier) visitFloatConst(node *AstFloatConst) interface{} {
+	node.seq = se.newNodeSeq()
+	se.ps.addLine("n%d [label=\"FloatConst: %v\"]", node.seq, node.value)
+	se.lastSeq = node.seq
+	return node.seq
+}
+
+func (se *astDotifier) visitBoolConst(node *AstBoolConst) interface{} {
+	node.seq = se.newNodeSeq()
+	se.ps.addLine("n%d [label=\"BoolConst: %v\"]", node.seq, node.value)
+	se.lastSeq = node.seq
+	return node.seq
+}
+
 func (se *astDotifier) visitVarRef(node *AstVarNameRef) interface{} {
 	node.seq = se.newNodeSeq()
 	se.ps.addLine("n%d [label=\"VarRef: %s\"]", node.seq, node.name)
@@ -302,6 +316,12 @@ func (se *astDotifier) visitAst(ast AstNode) interface{} {
 	case *AstIntConst:
 		return se.visitIntConst(statement)
 
+	case *AstFloatConst:
+		return se.visitFloatConst(statement)
+
+	case *AstBoolConst:
+		return se.visitBoolConst(statement)
+
 	case *AstVarNameRef:
 		return se.visitVarRef(statement)
 
@@ -0,0 +1,732 @@
+package hskl
+
+import (
+	"context"
+	"io"
+	"math"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+//hostFunc is a go function registered by the embedder
+type hostFunc struct {
+	name      string
+	signature string
+	retType   AstType
+	fn        reflect.Value
+}
+
+//VM embeds hskl into go programs: go functions are registered with a
+//hskl signature, then a script is loaded and its functions are called
+//with go values
+type VM struct {
+	engine string
+	hosts  []*hostFunc
+	funcs  map[string]*AstFuncDecl
+	interp *interpreter
+	bcVM   *bytecodeVM
+	bcProg *bcProgram
+	loaded bool
+}
+
+//SetEngine selects 'tree' (default) or 'vm' to run the loaded script
+func (vm *VM) SetEngine(engine string) error {
+	if vm.loaded {
+		return errors.Errorf("engine must be set before load")
+	}
+
+	if engine != "tree" && engine != "vm" {
+		return errors.Errorf("unknown engine: %s", engine)
+	}
+
+	vm.engine = engine
+	return nil
+}
+
+//parseSignature parses signature like "(a: int, b: string) int"
+func parseSignature(name, signature string, tpMap map[string]AstType) (decl *AstFuncDecl, result error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = errors.Errorf("bad signature of host func %s: %s", name, panicMessage(r))
+		}
+	}()
+
+	p := newIncrementalParser(signature, tpMap)
+	decl = p.host_signature(name)
+	decl.builtin = true
+	return decl, nil
+}
+
+//hostTypeOk checks a go type can carry values of hskl type tp
+func hostTypeOk(tp AstType, gt reflect.Type) bool {
+	if gt.Kind() == reflect.Interface {
+		return gt.NumMethod() == 0
+	}
+
+	switch rtp := tp.(type) {
+	case *AstPrimType:
+		switch rtp.name {
+		case symTypeInt:
+			switch gt.Kind() {
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				return true
+			}
+			return false
+
+		case symTypeFloat:
+			return gt.Kind() == reflect.Float32 || gt.Kind() == reflect.Float64
+
+		case symTypeBool:
+			return gt.Kind() == reflect.Bool
+
+		case symTypeString:
+			return gt.Kind() == reflect.String
+
+		default:
+			return false
+		}
+
+	case *AstArrayType:
+		return gt.Kind() == reflect.Slice && hostTypeOk(rtp.elemType, gt.Elem())
+
+	case *AstMapType:
+		return gt.Kind() == reflect.Map && hostTypeOk(rtp.keyType, gt.Key()) && hostTypeOk(rtp.valType, gt.Elem())
+
+	case *AstStructType, *AstUndefType:
+		return gt.Kind() == reflect.Map && gt.Key().Kind() == reflect.String
+
+	default:
+		return false
+	}
+}
+
+//Register exposes fn to scripts under name. signature is written in
+//hskl syntax, eg: "(a: int, b: []string) string", fn must take matching
+//go params and return at most one value and an optional trailing error.
+//a host func shadows a builtin of the same name
+func (vm *VM) Register(name, signature string, fn interface{}) error {
+	if vm.loaded {
+		return errors.Errorf("host func %s must be registered before load", name)
+	}
+
+	for _, host := range vm.hosts {
+		if host.name == name {
+			return errors.Errorf("host func %s already registered", name)
+		}
+	}
+
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func {
+		return errors.Errorf("host func %s is not a go func: %T", name, fn)
+	}
+
+	decl, err := parseSignature(name, signature, newTypeMap())
+	if err != nil {
+		return err
+	}
+
+	ft := fv.Type()
+	if ft.IsVariadic() || ft.NumIn() != len(decl.params) {
+		return errors.Errorf("host func %s expects %d params, go func has %d", name, len(decl.params), ft.NumIn())
+	}
+
+	for idx, param := range decl.params {
+		if !hostTypeOk(param.type_, ft.In(idx)) {
+			return errors.Errorf("host func %s param %s: %s can not be passed as %s",
+				name, param.name, param.type_.desc(), ft.In(idx))
+		}
+	}
+
+	outs := ft.NumOut()
+	if outs > 0 && ft.Out(outs-1) == errorType {
+		outs--
+	}
+
+	if outs > 1 || ft.NumOut() > outs+1 {
+		return errors.Errorf("host func %s should return at most one value and an error", name)
+	}
+
+	prim, ok := decl.retType.(*AstPrimType)
+	isVoid := ok && prim.name == symTypeVoid
+	if isVoid != (outs == 0) {
+		return errors.Errorf("host func %s return type %s not match go func", name, decl.retType.desc())
+	}
+
+	if !isVoid && !hostTypeOk(decl.retType, ft.Out(0)) {
+		return errors.Errorf("host func %s returns %s, can not be passed as %s", name, ft.Out(0), decl.retType.desc())
+	}
+
+	vm.hosts = append(vm.hosts, &hostFunc{name: name, signature: signature, retType: decl.retType, fn: fv})
+	return nil
+}
+
+//native wraps the go func so both engines can call it like a builtin
+func (host *hostFunc) native() nativeFunc {
+	ft := host.fn.Type()
+	return func(env *nativeEnv, args []interface{}) interface{} {
+		in := make([]reflect.Value, len(args))
+		for idx, arg := range args {
+			val, err := toGoValue(arg, ft.In(idx))
+			if err != nil {
+				interpPanic("host func %s arg %d: %v", host.name, idx, err)
+			}
+			in[idx] = val
+		}
+
+		out := host.fn.Call(in)
+		if len(out) > 0 && ft.Out(len(out)-1) == errorType {
+			if err := out[len(out)-1]; !err.IsNil() {
+				interpPanic("host func %s failed: %v", host.name, err.Interface())
+			}
+			out = out[:len(out)-1]
+		}
+
+		if len(out) == 0 {
+			return nil
+		}
+
+		ret, err := fromGoValue(out[0])
+		if err != nil {
+			interpPanic("host func %s result: %v", host.name, err)
+		}
+		return retypeValue(ret, host.retType)
+	}
+}
+
+//toGoValue converts an hskl runtime value into go type tp
+func toGoValue(val interface{}, tp reflect.Type) (reflect.Value, error) {
+	if box, ok := val.(*ifaceVal); ok {
+		val = box.val
+	}
+
+	if val == nil {
+		return reflect.Zero(tp), nil
+	}
+
+	if tp.Kind() == reflect.Interface {
+		return reflect.ValueOf(exportValue(val)), nil
+	}
+
+	switch tp.Kind() {
+	//a value the go type can not hold is not converted, instead of
+	//being truncated
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if ival, ok := val.(int); ok && !reflect.Zero(tp).OverflowInt(int64(ival)) {
+			return reflect.ValueOf(ival).Convert(tp), nil
+		}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if ival, ok := val.(int); ok && ival >= 0 && !reflect.Zero(tp).OverflowUint(uint64(ival)) {
+			return reflect.ValueOf(ival).Convert(tp), nil
+		}
+
+	case reflect.Float32, reflect.Float64:
+		if fval, ok := val.(float64); ok && !reflect.Zero(tp).OverflowFloat(fval) {
+			return reflect.ValueOf(fval).Convert(tp), nil
+		}
+
+	case reflect.Bool:
+		if bval, ok := val.(bool); ok {
+			return reflect.ValueOf(bval).Convert(tp), nil
+		}
+
+	case reflect.String:
+		if sval, ok := val.(string); ok {
+			return reflect.ValueOf(sval).Convert(tp), nil
+		}
+
+	case reflect.Slice:
+		if arr, ok := val.([]interface{}); ok {
+			slice := reflect.MakeSlice(tp, len(arr), len(arr))
+			for idx, elem := range arr {
+				ev, err := toGoValue(elem, tp.Elem())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				slice.Index(idx).Set(ev)
+			}
+			return slice, nil
+		}
+
+	case reflect.Map:
+		if obj, ok := val.(map[interface{}]interface{}); ok {
+			mp := reflect.MakeMapWithSize(tp, len(obj))
+			for key, elem := range obj {
+				kv, err := toGoValue(key, tp.Key())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				ev, err := toGoValue(elem, tp.Elem())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				mp.SetMapIndex(kv, ev)
+			}
+			return mp, nil
+		}
+
+		if obj, ok := val.(map[string]interface{}); ok && tp.Key().Kind() == reflect.String {
+			mp := reflect.MakeMapWithSize(tp, len(obj))
+			for key, elem := range obj {
+				ev, err := toGoValue(elem, tp.Elem())
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				mp.SetMapIndex(reflect.ValueOf(key).Convert(tp.Key()), ev)
+			}
+			return mp, nil
+		}
+	}
+
+	return reflect.Value{}, errors.Errorf("can not convert %T to %s", val, tp)
+}
+
+//fromGoValue converts a go value into hskl runtime representation
+func fromGoValue(gv reflect.Value) (interface{}, error) {
+	switch gv.Kind() {
+	case reflect.Invalid:
+		return nil, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(gv.Int()), nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		//hskl int is 64 bit signed, a larger uint is not wrapped
+		if uval := gv.Uint(); uval <= math.MaxInt64 {
+			return int(uval), nil
+		}
+		return nil, errors.Errorf("%s %d overflows int", gv.Type(), gv.Uint())
+
+	case reflect.Float32, reflect.Float64:
+		return gv.Float(), nil
+
+	case reflect.Bool:
+		return gv.Bool(), nil
+
+	case reflect.String:
+		return gv.String(), nil
+
+	case reflect.Interface, reflect.Ptr:
+		if gv.IsNil() {
+			return nil, nil
+		}
+		return fromGoValue(gv.Elem())
+
+	case reflect.Slice, reflect.Array:
+		if gv.Kind() == reflect.Slice && gv.IsNil() {
+			return nil, nil
+		}
+
+		arr := make([]interface{}, gv.Len())
+		for idx := range arr {
+			elem, err := fromGoValue(gv.Index(idx))
+			if err != nil {
+				return nil, err
+			}
+			arr[idx] = elem
+		}
+		return arr, nil
+
+	case reflect.Map:
+		if gv.IsNil() {
+			return nil, nil
+		}
+
+		if gv.Type().Key().Kind() != reflect.String {
+			mv := make(map[interface{}]interface{}, gv.Len())
+			iter := gv.MapRange()
+			for iter.Next() {
+				key, err := fromGoValue(iter.Key())
+				if err != nil {
+					return nil, err
+				}
+				elem, err := fromGoValue(iter.Value())
+				if err != nil {
+					return nil, err
+				}
+				mv[key] = elem
+			}
+			return mv, nil
+		}
+
+		obj := make(map[string]interface{}, gv.Len())
+		iter := gv.MapRange()
+		for iter.Next() {
+			elem, err := fromGoValue(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			obj[iter.Key().String()] = elem
+		}
+		return obj, nil
+	}
+
+	return nil, errors.Errorf("unsupported go type: %s", gv.Type())
+}
+
+//exportValue deep copies an hskl value, so the host can keep it
+//without aliasing the script's arrays and structs
+func exportValue(val interface{}) interface{} {
+	switch tp := val.(type) {
+	case []interface{}:
+		arr := make([]interface{}, len(tp))
+		for idx, elem := range tp {
+			arr[idx] = exportValue(elem)
+		}
+		return arr
+
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(tp))
+		for key, elem := range tp {
+			obj[key] = exportValue(elem)
+		}
+		return obj
+
+	case map[interface{}]interface{}:
+		mv := make(map[interface{}]interface{}, len(tp))
+		for key, elem := range tp {
+			mv[key] = exportValue(elem)
+		}
+		return mv
+
+	case *ifaceVal:
+		return exportValue(tp.val)
+
+	default:
+		return val
+	}
+}
+
+//retypeValue turns go maps with string keys, converted as structs by
+//fromGoValue, into hskl maps where tp says so
+func retypeValue(val interface{}, tp AstType) interface{} {
+	switch rtp := realType(tp).(type) {
+	case *AstMapType:
+		if obj, ok := val.(map[string]interface{}); ok {
+			mv := make(map[interface{}]interface{}, len(obj))
+			for key, elem := range obj {
+				mv[key] = retypeValue(elem, rtp.valType)
+			}
+			return mv
+		}
+
+		if mv, ok := val.(map[interface{}]interface{}); ok {
+			for key, elem := range mv {
+				mv[key] = retypeValue(elem, rtp.valType)
+			}
+		}
+		break
+
+	case *AstArrayType:
+		if arr, ok := val.([]interface{}); ok {
+			for idx, elem := range arr {
+				arr[idx] = retypeValue(elem, rtp.elemType)
+			}
+		}
+		break
+
+	case *AstStructType:
+		if obj, ok := val.(map[string]interface{}); ok {
+			for _, field := range rtp.fields {
+				if elem, ok := obj[field.name]; ok {
+					obj[field.name] = retypeValue(elem, field.type_)
+				}
+			}
+		}
+		break
+	}
+
+	return val
+}
+
+//Load parses, checks and initializes src, a main func is not required.
+//only one script can be loaded into a VM
+func (vm *VM) Load(src string) (result error) {
+	if vm.loaded {
+		return errors.Errorf("script already loaded")
+	}
+
+	tpMap := newTypeMap()
+	analyzer := NewSemanticAnalyzer()
+	for _, host := range vm.hosts {
+		decl, err := parseSignature(host.name, host.signature, tpMap)
+		if err != nil {
+			return err
+		}
+
+		decl.native = host.native()
+		analyzer.addHostFunc(decl)
+	}
+
+	var pro AstNode
+	err := func() (result error) {
+		defer func() {
+			if r := recover(); r != nil {
+				result = errors.Errorf("parse error: %s", panicMessage(r))
+			}
+		}()
+
+		p := newIncrementalParser(src, tpMap)
+		pro = p.Program()
+		if diags := p.Diagnostics(); len(diags) > 0 {
+			return errors.Errorf("parse error: %s", diags)
+		}
+		return nil
+	}()
+
+	if err != nil {
+		return err
+	}
+
+	err = analyzer.analyze(pro, false)
+	if err != nil {
+		return errors.Wrap(err, "analyze error")
+	}
+
+	vm.funcs = map[string]*AstFuncDecl{}
+	for _, decl := range pro.(*AstProgram).decl_list {
+		if fn, ok := decl.(*AstFuncDecl); ok && fn.recv == nil {
+			vm.funcs[fn.name] = fn
+		}
+	}
+
+	vm.loaded = true
+	if vm.engine == "vm" {
+		prog, err := NewCompiler().compile(pro, false)
+		if err != nil {
+			return errors.Wrap(err, "compile error")
+		}
+
+		vm.bcProg = prog
+		return vm.guard(func() interface{} {
+			vm.bcVM.load(prog)
+			return nil
+		}, nil)
+	}
+
+	return vm.guard(func() interface{} {
+		vm.interp.visitProgram(pro.(*AstProgram))
+		return nil
+	}, nil)
+}
+
+//guard runs fn and turns script runtime panics into error
+func (vm *VM) guard(fn func() interface{}, ret *interface{}) (result error) {
+	defer func() {
+		if r := recover(); r != nil {
+			limitErr, isLimit := limitError(r)
+			if vm.engine == "vm" {
+				result = vm.bcVM.runError(r)
+				vm.bcVM.resetInput()
+			} else {
+				result = vm.interp.runtimeError(r)
+				vm.interp.resetInput()
+			}
+			if isLimit {
+				result = limitErr
+			}
+		}
+	}()
+
+	val := fn()
+	if ret != nil {
+		*ret = val
+	}
+	return nil
+}
+
+//Run loads src and calls its main func
+func (vm *VM) Run(src string) (interface{}, error) {
+	if err := vm.Load(src); err != nil {
+		return nil, err
+	}
+
+	return vm.Call(entryFunc)
+}
+
+//RunContext is Run that stops when ctx is done
+func (vm *VM) RunContext(ctx context.Context, src string) (interface{}, error) {
+	defer vm.setContext(ctx)()
+	return vm.Run(src)
+}
+
+//CallContext is Call that stops when ctx is done
+func (vm *VM) CallContext(ctx context.Context, name string, args ...interface{}) (interface{}, error) {
+	defer vm.setContext(ctx)()
+	return vm.Call(name, args...)
+}
+
+//setContext sets ctx on both engines and gives the func that clears it
+func (vm *VM) setContext(ctx context.Context) func() {
+	vm.interp.ctx, vm.bcVM.ctx = ctx, ctx
+	return func() {
+		vm.interp.ctx, vm.bcVM.ctx = nil, nil
+	}
+}
+
+//Call calls a script func with go args and returns its result as go value:
+//int, float64, bool, string, []interface{} for arrays, map[string]interface{} for structs
+//and map[interface{}]interface{} for maps, multiple return values come in a []interface{}
+func (vm *VM) Call(name string, args ...interface{}) (interface{}, error) {
+	if !vm.loaded {
+		return nil, errors.Errorf("no script loaded")
+	}
+
+	decl := vm.funcs[name]
+	if decl == nil {
+		return nil, errors.Errorf("func %s is not defined", name)
+	}
+
+	if len(args) != len(decl.params) {
+		return nil, errors.Errorf("func %s expects %d args, recv: %d", name, len(decl.params), len(args))
+	}
+
+	vals := make([]interface{}, len(args))
+	for idx, arg := range args {
+		val, err := fromGoValue(reflect.ValueOf(arg))
+		if err != nil {
+			return nil, errors.Wrapf(err, "func %s arg %d", name, idx)
+		}
+		val = retypeValue(val, decl.params[idx].type_)
+
+		if !valueFits(val, decl.params[idx].type_) {
+			return nil, errors.Errorf("func %s arg %d: %T can not be passed as %s",
+				name, idx, arg, decl.params[idx].type_.desc())
+		}
+		vals[idx] = val
+	}
+
+	var ret interface{}
+	var err error
+	if vm.engine == "vm" {
+		idx := -1
+		for i, fn := range vm.bcProg.funcs {
+			if fn.decl == decl {
+				idx = i
+			}
+		}
+		err = vm.guard(func() interface{} {
+			return vm.bcVM.callFunc(idx, vals)
+		}, &ret)
+	} else {
+		err = vm.guard(func() interface{} {
+			return vm.interp.callFunc(decl, vals)
+		}, &ret)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return exportValue(ret), nil
+}
+
+//valueFits checks a converted go arg against the param type of script
+func valueFits(val interface{}, tp AstType) bool {
+	rtp := realType(tp)
+	if prim, ok := rtp.(*AstPrimType); ok && prim.name == symTypeAny {
+		return true
+	}
+
+	switch tv := val.(type) {
+	case nil:
+		return true
+
+	case int:
+		prim, ok := rtp.(*AstPrimType)
+		return ok && prim.name == symTypeInt
+
+	case float64:
+		prim, ok := rtp.(*AstPrimType)
+		return ok && prim.name == symTypeFloat
+
+	case bool:
+		prim, ok := rtp.(*AstPrimType)
+		return ok && prim.name == symTypeBool
+
+	case string:
+		prim, ok := rtp.(*AstPrimType)
+		return ok && prim.name == symTypeString
+
+	case []interface{}:
+		arr, ok := rtp.(*AstArrayType)
+		if !ok {
+			return false
+		}
+		for _, elem := range tv {
+			if !valueFits(elem, arr.elemType) {
+				return false
+			}
+		}
+		return true
+
+	case map[interface{}]interface{}:
+		mtp, ok := rtp.(*AstMapType)
+		if !ok {
+			return false
+		}
+		for key, elem := range tv {
+			if !valueFits(key, mtp.keyType) || !valueFits(elem, mtp.valType) {
+				return false
+			}
+		}
+		return true
+
+	case map[string]interface{}:
+		stp, ok := rtp.(*AstStructType)
+		if !ok {
+			return false
+		}
+		for _, field := range stp.fields {
+			if elem, ok := tv[field.name]; ok && !valueFits(elem, field.type_) {
+				return false
+			}
+		}
+		return len(tv) <= len(stp.fields)
+
+	default:
+		return false
+	}
+}
+
+func NewVM() *VM {
+	vm := &VM{engine: "tree"}
+	vm.interp = NewInterpreter()
+	vm.bcVM = NewBytecodeVM()
+	return vm
+}
+
+//SetStdout sets where the script prints, nil is the process stdout
+func (vm *VM) SetStdout(out io.Writer) {
+	vm.interp.SetStdout(out)
+	vm.bcVM.SetStdout(out)
+}
+
+//SetStderr sets where eprintn of the script writes, nil is the process stderr
+func (vm *VM) SetStderr(out io.Writer) {
+	vm.interp.SetStderr(out)
+	vm.bcVM.SetStderr(out)
+}
+
+//SetStdin sets what the script reads, nil is the process stdin
+func (vm *VM) SetStdin(in io.Reader) {
+	vm.interp.SetStdin(in)
+	vm.bcVM.SetStdin(in)
+}
+
+//SetSeed fixes the seed of the rng of the script, so its random numbers
+//are the same on every run
+func (vm *VM) SetSeed(seed int64) {
+	vm.interp.SetSeed(seed)
+	vm.bcVM.SetSeed(seed)
+}
+
+//SetLimits bounds the steps, call depth and allocated size of the script,
+//the counts go on over Load and every Call until SetLimits is called again
+func (vm *VM) SetLimits(limits Limits) {
+	vm.interp.SetLimits(limits)
+	vm.bcVM.SetLimits(limits)
+}
@@ -0,0 +1,322 @@
+package hskl
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const embedScript = `
+type point struct {
+    x: int
+    y: int
+}
+
+var total: int
+
+func describe(n: int) string {
+    total = total + n
+    return label("n", double(n))
+}
+
+func origin() point {
+    var p: point
+    p.x = scale(3)
+    p.y = 4
+    return p
+}
+
+func names(parts: []string) string {
+    return join(parts, ",")
+}
+
+func counts(m: map[string]int) map[int]bool {
+    seen := map[int]bool{0: false}
+    seen[sum(m)] = true
+    return seen
+}
+
+func broken() int {
+    return fail(1)
+}
+
+func main() {
+    print(describe(2))
+}
+`
+
+func newEmbedVM(t *testing.T, engine string) *VM {
+	vm := NewVM()
+	if err := vm.SetEngine(engine); err != nil {
+		t.Fatalf("set engine: %v", err)
+	}
+
+	hosts := []struct {
+		name string
+		sig  string
+		fn   interface{}
+	}{
+		{"double", "(a: int) int", func(a int) int { return a * 2 }},
+		{"scale", "(a: int) int", func(a int64) int64 { return a * 10 }},
+		{"label", "(k: string, v: int) string", func(k string, v int) string { return fmt.Sprintf("%s=%d", k, v) }},
+		{"join", "(parts: []string, sep: string) string", strings.Join},
+		{"sum", "(m: map[string]int) int", func(m map[string]int) int { return m["a"] + m["b"] }},
+		{"fail", "(code: int) int", func(code int) (int, error) { return 0, fmt.Errorf("code %d", code) }},
+	}
+
+	for _, host := range hosts {
+		if err := vm.Register(host.name, host.sig, host.fn); err != nil {
+			t.Fatalf("register %s: %v", host.name, err)
+		}
+	}
+
+	return vm
+}
+
+func TestEmbedCall(t *testing.T) {
+	for _, engine := range []string{"tree", "vm"} {
+		vm := newEmbedVM(t, engine)
+		if err := vm.Load(embedScript); err != nil {
+			t.Fatalf("%s load: %v", engine, err)
+		}
+
+		ret, err := vm.Call("describe", 21)
+		if err != nil || ret != "n=42" {
+			t.Errorf("%s describe = %v, %v", engine, ret, err)
+		}
+
+		ret, err = vm.Call("origin")
+		want := map[string]interface{}{"x": 30, "y": 4}
+		if err != nil || !reflect.DeepEqual(ret, want) {
+			t.Errorf("%s origin = %v, %v", engine, ret, err)
+		}
+
+		ret, err = vm.Call("names", []string{"a", "b"})
+		if err != nil || ret != "a,b" {
+			t.Errorf("%s names = %v, %v", engine, ret, err)
+		}
+
+		ret, err = vm.Call("counts", map[string]int{"a": 1, "b": 2})
+		wantMap := map[interface{}]interface{}{0: false, 3: true}
+		if err != nil || !reflect.DeepEqual(ret, wantMap) {
+			t.Errorf("%s counts = %v, %v", engine, ret, err)
+		}
+
+		_, err = vm.Call("broken")
+		if err == nil || !strings.Contains(err.Error(), "host func fail failed: code 1") {
+			t.Errorf("%s broken error: %v", engine, err)
+		}
+
+		//vm is still usable after a runtime error
+		ret, err = vm.Call("describe", 1)
+		if err != nil || ret != "n=2" {
+			t.Errorf("%s describe after error = %v, %v", engine, ret, err)
+		}
+
+		if _, err = vm.Call("describe", "x"); err == nil {
+			t.Errorf("%s describe accepted string arg", engine)
+		}
+
+		if _, err = vm.Call("missing"); err == nil {
+			t.Errorf("%s call of undefined func succeeded", engine)
+		}
+	}
+}
+
+func TestEmbedRegister(t *testing.T) {
+	vm := NewVM()
+	cases := []struct {
+		name string
+		sig  string
+		fn   interface{}
+	}{
+		{"f1", "(a: int) int", 3},
+		{"f2", "(a: int) int", func(a string) int { return 0 }},
+		{"f3", "(a: int)", func(a int) int { return a }},
+		{"f4", "(a: int", func(a int) {}},
+		{"f5", "() int", func() (int, string) { return 0, "" }},
+	}
+
+	for _, c := range cases {
+		if err := vm.Register(c.name, c.sig, c.fn); err == nil {
+			t.Errorf("register %s %s accepted", c.name, c.sig)
+		}
+	}
+}
+
+func TestToGoValueOverflow(t *testing.T) {
+	cases := []struct {
+		val interface{}
+		tp  reflect.Type
+		ok  bool
+	}{
+		{255, reflect.TypeOf(uint8(0)), true},
+		{300, reflect.TypeOf(uint8(0)), false},
+		{-1, reflect.TypeOf(uint(0)), false},
+		{-128, reflect.TypeOf(int8(0)), true},
+		{200, reflect.TypeOf(int8(0)), false},
+		{40000, reflect.TypeOf(int16(0)), false},
+		{1e300, reflect.TypeOf(float32(0)), false},
+		{1.5, reflect.TypeOf(float32(0)), true},
+	}
+
+	for _, c := range cases {
+		gv, err := toGoValue(c.val, c.tp)
+		if (err == nil) != c.ok {
+			t.Errorf("convert %v to %s, want ok: %v, error: %v", c.val, c.tp, c.ok, err)
+		}
+		if err == nil && fmt.Sprint(gv.Interface()) != fmt.Sprint(c.val) {
+			t.Errorf("convert %v to %s = %v", c.val, c.tp, gv.Interface())
+		}
+	}
+}
+
+func TestFromGoValueOverflow(t *testing.T) {
+	src := `func echo(n: int) int {
+    return n
+}
+
+func viaHost() int {
+    return big()
+}`
+
+	for _, engine := range []string{"tree", "vm"} {
+		vm := NewVM()
+		vm.SetEngine(engine)
+		vm.Register("big", "() int", func() uint64 { return math.MaxUint64 })
+		if err := vm.Load(src); err != nil {
+			t.Fatalf("%s load: %v", engine, err)
+		}
+
+		if ret, err := vm.Call("echo", uint64(math.MaxUint64)); err == nil {
+			t.Errorf("%s echo of max uint64 = %v", engine, ret)
+		}
+		if ret, err := vm.Call("viaHost"); err == nil || !strings.Contains(err.Error(), "overflows int") {
+			t.Errorf("%s max uint64 returned by host = %v, %v", engine, ret, err)
+		}
+		if ret, err := vm.Call("echo", uint64(math.MaxInt64)); err != nil || ret != math.MaxInt64 {
+			t.Errorf("%s echo of max int64 = %v, %v", engine, ret, err)
+		}
+	}
+}
+
+func TestEmbedSemantic(t *testing.T) {
+	vm := newEmbedVM(t, "tree")
+	err := vm.Load(`func main() {
+    print(double("x"))
+}`)
+	if err == nil || !strings.Contains(err.Error(), "analyze error") {
+		t.Errorf("host func arg type not checked: %v", err)
+	}
+
+	vm = newEmbedVM(t, "tree")
+	ret, err := vm.Run(`func main() int {
+    return double(4)
+}`)
+	if err != nil || ret != 8 {
+		t.Errorf("run = %v, %v", ret, err)
+	}
+}
+
+func TestEmbedSeed(t *testing.T) {
+	src := `func main() string {
+    arr := []int{1, 2, 3, 4, 5, 6}
+    shuffle(arr)
+    return sprintf("%d %v %v", rand_int(1, 1000), rand_float() < 1.0, arr)
+}`
+
+	//the same seed gives the same numbers on every run and engine
+	var want interface{}
+	for idx, engine := range []string{"tree", "vm", "tree"} {
+		vm := NewVM()
+		vm.SetEngine(engine)
+		vm.SetSeed(7)
+		ret, err := vm.Run(src)
+		if err != nil {
+			t.Fatalf("%s run error: %v", engine, err)
+		}
+		if idx == 0 {
+			want = ret
+		} else if ret != want {
+			t.Errorf("%s with the same seed = %v, want: %v", engine, ret, want)
+		}
+	}
+}
+
+func TestEmbedGoPanicLine(t *testing.T) {
+	src := `func crashAt(n: int) int {
+    var m: int
+    m = crash(n)
+    return m
+}`
+
+	for _, engine := range []string{"tree", "vm"} {
+		vm := NewVM()
+		if err := vm.SetEngine(engine); err != nil {
+			t.Fatalf("set engine: %v", err)
+		}
+		crash := func(n int) int {
+			var arr []int
+			return arr[n]
+		}
+		if err := vm.Register("crash", "(n: int) int", crash); err != nil {
+			t.Fatalf("register crash: %v", err)
+		}
+		if err := vm.Load(src); err != nil {
+			t.Fatalf("%s load: %v", engine, err)
+		}
+
+		_, err := vm.Call("crashAt", 1)
+		rtErr, ok := err.(*RuntimeError)
+		if !ok || rtErr.Line != 3 {
+			t.Errorf("%s go panic error: %#v", engine, err)
+		}
+	}
+}
+
+func TestEmbedLimits(t *testing.T) {
+	src := `func spin(n: int) int {
+    i := 0
+    while i < n {
+        i = i + 1
+    }
+    return i
+}
+
+func forever() {
+    while true {
+    }
+}`
+
+	for _, engine := range []string{"tree", "vm"} {
+		vm := NewVM()
+		vm.SetEngine(engine)
+		if err := vm.Load(src); err != nil {
+			t.Fatalf("%s load: %v", engine, err)
+		}
+
+		vm.SetLimits(Limits{MaxSteps: 100})
+		if _, err := vm.Call("spin", 1000); err == nil {
+			t.Errorf("%s step limit not applied", engine)
+		} else if _, ok := err.(*StepLimitError); !ok {
+			t.Errorf("%s step limit error: %#v", engine, err)
+		}
+
+		//the vm is usable again with new limits
+		vm.SetLimits(Limits{})
+		if ret, err := vm.Call("spin", 10); err != nil || ret != 10 {
+			t.Errorf("%s spin after limit = %v, %v", engine, ret, err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err := vm.CallContext(ctx, "forever")
+		cancel()
+		if _, ok := err.(*DeadlineError); !ok {
+			t.Errorf("%s deadline error: %#v", engine, err)
+		}
+	}
+}
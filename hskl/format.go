@@ -0,0 +1,800 @@
+package hskl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const formatIndent = "    "
+
+//formatter prints a program in the canonical layout: 4 spaces indent,
+//spaces around binary operators and after commas, one blank line at most
+//between items and always one around funcs and struct or interface types.
+//comments stay above or after the line they were written at
+type formatter struct {
+	src      []string
+	comments []*Token
+	next     int
+	out      *strings.Builder
+	indent   int
+	//maxLine is the last source line of the item being printed, comments
+	//up to it go after the printed line
+	maxLine int
+	//fresh is set after an opening line, no blank line follows it
+	fresh     bool
+	lastBlank bool
+	//prefix holds the comments written before the item on its line
+	prefix string
+}
+
+//Format gives src in the canonical layout, src with syntax errors is
+//refused with their diagnostics
+func Format(src string) (string, error) {
+	p := newFormatParser(src)
+	pro := p.Program().(*AstProgram)
+	if diags := p.Diagnostics(); len(diags) > 0 {
+		return "", diags
+	}
+
+	f := &formatter{src: strings.Split(src, "\n"), comments: p.lex.comments, out: &strings.Builder{}}
+	f.program(pro)
+	return f.out.String(), nil
+}
+
+func (f *formatter) program(pro *AstProgram) {
+	f.fresh = true
+	spacious := false
+	decls := pro.decl_list
+	for idx := 0; idx < len(decls); {
+		line, col := astPos(decls[idx])
+		blank := spacious || isSpacious(decls[idx])
+		spacious = isSpacious(decls[idx])
+		f.item(line, col, blank)
+
+		switch decl := decls[idx].(type) {
+		case *AstVarDecl:
+			group := f.varGroup(decls, idx)
+			f.varDecl(group)
+			idx += len(group)
+			continue
+
+		case *AstFuncDecl:
+			f.funcDecl(decl)
+
+		case *AstTypeDef:
+			f.typeDef(decl)
+		}
+		idx++
+	}
+
+	f.flushComments(len(f.src) + 1)
+}
+
+//isSpacious tells if a top level decl is set apart by blank lines
+func isSpacious(decl AstNode) bool {
+	switch node := decl.(type) {
+	case *AstFuncDecl:
+		return true
+
+	case *AstTypeDef:
+		switch node.impl.(type) {
+		case *AstStructType, *AstIfaceType:
+			return true
+		}
+
+	case *AstVarDecl:
+		_, ok := node.initExpr.(*AstFuncLit)
+		return ok
+	}
+	return false
+}
+
+//varGroup gives the decls from idx written in one 'var a, b: T'
+func (f *formatter) varGroup(decls []AstNode, idx int) []*AstVarDecl {
+	first := decls[idx].(*AstVarDecl)
+	group := []*AstVarDecl{first}
+	for _, node := range decls[idx+1:] {
+		decl, ok := node.(*AstVarDecl)
+		if !ok || !sameDecl(first, decl) {
+			break
+		}
+		group = append(group, decl)
+	}
+	return group
+}
+
+//sameDecl tells if b was declared with a, every type written is its own
+//node when parsing for the formatter
+func sameDecl(a, b *AstVarDecl) bool {
+	return !a.short && !b.short && a.type_ == b.type_ && a.line == b.line
+}
+
+//item starts a decl or statement at line and col, the comments above it
+//are written first. blank forces a blank line before them
+func (f *formatter) item(line, col int, blank bool) {
+	f.maxLine = line
+	if blank {
+		f.blankLine()
+	}
+	f.flushComments(line)
+	if f.blankBefore(line) {
+		f.blankLine()
+	}
+
+	for f.next < len(f.comments) && f.comments[f.next].line == line && f.comments[f.next].column < col {
+		f.prefix += f.comments[f.next].value + " "
+		f.next++
+	}
+}
+
+//see records that the item being printed reaches line
+func (f *formatter) see(line int) {
+	if line > f.maxLine {
+		f.maxLine = line
+	}
+}
+
+func (f *formatter) blankBefore(line int) bool {
+	return line >= 2 && line-2 < len(f.src) && strings.TrimSpace(f.src[line-2]) == ""
+}
+
+func (f *formatter) blankLine() {
+	if f.fresh || f.lastBlank {
+		return
+	}
+	f.out.WriteString("\n")
+	f.lastBlank = true
+}
+
+//flushComments writes the comments before line on their own lines, a
+//blank line above a comment is kept
+func (f *formatter) flushComments(line int) {
+	for f.next < len(f.comments) && f.comments[f.next].line < line {
+		comment := f.comments[f.next]
+		f.next++
+		if f.blankBefore(comment.line) {
+			f.blankLine()
+		}
+		f.out.WriteString(strings.Repeat(formatIndent, f.indent) + comment.value + "\n")
+		f.fresh = false
+		f.lastBlank = false
+	}
+}
+
+//writeLine writes text at the current indent, the comments left on the
+//source lines up to srcLine go after it
+func (f *formatter) writeLine(text string, srcLine int) {
+	line := strings.Repeat(formatIndent, f.indent) + f.prefix + text
+	f.prefix = ""
+	for f.next < len(f.comments) && f.comments[f.next].line <= srcLine {
+		line += " " + f.comments[f.next].value
+		f.next++
+	}
+	f.out.WriteString(line + "\n")
+	f.fresh = strings.HasSuffix(text, "{")
+	f.lastBlank = false
+}
+
+//body writes the vars and statements of block one level deeper, the
+//lines with braces are written by the caller
+func (f *formatter) body(block *AstCodeBlock) {
+	f.indent++
+	for idx := 0; idx < len(block.vars); {
+		f.item(block.vars[idx].line, block.vars[idx].col, false)
+		group := []*AstVarDecl{block.vars[idx]}
+		for _, decl := range block.vars[idx+1:] {
+			if !sameDecl(group[0], decl) {
+				break
+			}
+			group = append(group, decl)
+		}
+		f.varDecl(group)
+		idx += len(group)
+	}
+
+	for _, stat := range block.stat_list {
+		f.statement(stat)
+	}
+	f.flushComments(block.endLine)
+	f.indent--
+}
+
+func (f *formatter) varDecl(group []*AstVarDecl) {
+	text := f.varDeclText(group)
+	f.writeLine(text, f.maxLine)
+}
+
+func (f *formatter) varDeclText(group []*AstVarDecl) string {
+	decl := group[0]
+	if !decl.short {
+		names := []string{}
+		for _, decl := range group {
+			names = append(names, decl.name)
+		}
+		return "var " + strings.Join(names, ", ") + ": " + f.typ(decl.type_)
+	}
+
+	if decl.initExpr != nil {
+		return decl.name + " := " + f.expr(decl.initExpr)
+	}
+
+	if arr, ok := decl.type_.(*AstArrayType); ok {
+		elem := f.typ(arr.elemType)
+		vals := []string{}
+		for _, tok := range decl.initArr {
+			if tok.type_ == STRING_CONST {
+				vals = append(vals, quoteString(tok.value))
+			} else {
+				vals = append(vals, tok.value)
+			}
+		}
+		return decl.name + " := []" + elem + "{" + strings.Join(vals, ", ") + "}"
+	}
+
+	if prim, ok := decl.type_.(*AstPrimType); ok && prim.name == symTypeString {
+		return decl.name + " := " + quoteString(decl.initVal)
+	}
+	return decl.name + " := " + decl.initVal
+}
+
+func (f *formatter) funcDecl(decl *AstFuncDecl) {
+	head := "func "
+	if decl.recv != nil {
+		ptr := ""
+		if decl.recvPtr {
+			ptr = "*"
+		}
+		head += "(" + decl.recv.name + " " + ptr + f.typ(decl.recv.type_) + ") "
+	}
+	head += decl.name + f.signature(decl) + " {"
+
+	f.writeLine(head, decl.line)
+	f.body(decl.block)
+	f.writeLine("}", decl.block.endLine)
+}
+
+//signature gives the params in parens and the return type
+func (f *formatter) signature(decl *AstFuncDecl) string {
+	groups := []string{}
+	for idx := 0; idx < len(decl.params); {
+		names := []string{decl.params[idx].name}
+		end := idx + 1
+		for end < len(decl.params) && decl.params[end].type_ == decl.params[idx].type_ {
+			names = append(names, decl.params[end].name)
+			end++
+		}
+		groups = append(groups, strings.Join(names, ", ")+": "+f.typ(decl.params[idx].type_))
+		idx = end
+	}
+	return "(" + strings.Join(groups, ", ") + ")" + f.retType(decl.retType)
+}
+
+func (f *formatter) retType(tp AstType) string {
+	if prim, ok := tp.(*AstPrimType); tp == nil || (ok && prim.name == symTypeVoid) {
+		return ""
+	}
+	return " " + f.typ(tp)
+}
+
+func (f *formatter) typeDef(def *AstTypeDef) {
+	switch impl := def.impl.(type) {
+	case *AstStructType:
+		f.writeLine("type "+def.name+" struct {", def.line)
+		f.indent++
+		for idx := 0; idx < len(impl.fields); {
+			f.item(impl.fields[idx].line, impl.fields[idx].col, false)
+			group := []*AstVarDecl{impl.fields[idx]}
+			for _, field := range impl.fields[idx+1:] {
+				if !sameDecl(group[0], field) {
+					break
+				}
+				group = append(group, field)
+			}
+
+			names := []string{}
+			for _, field := range group {
+				names = append(names, field.name)
+			}
+			f.writeLine(strings.Join(names, ", ")+": "+f.typ(group[0].type_), f.maxLine)
+			idx += len(group)
+		}
+		f.flushComments(def.endLine)
+		f.indent--
+		f.writeLine("}", def.endLine)
+
+	case *AstIfaceType:
+		f.writeLine("type "+def.name+" interface {", def.line)
+		f.indent++
+		for _, method := range impl.methods {
+			f.item(method.line, method.col, false)
+			f.writeLine(method.name+f.signature(method), f.maxLine)
+		}
+		f.flushComments(def.endLine)
+		f.indent--
+		f.writeLine("}", def.endLine)
+
+	default:
+		f.writeLine("type "+def.name+" "+f.typ(def.impl), def.line)
+	}
+}
+
+//typ gives a type as written in source
+func (f *formatter) typ(tp AstType) string {
+	switch node := tp.(type) {
+	case *AstPrimType:
+		return node.name
+
+	case *AstUndefType:
+		return node.name
+
+	case *AstStructType:
+		return node.name
+
+	case *AstIfaceType:
+		return node.name
+
+	case *AstArrayType:
+		return "[]" + f.typ(node.elemType)
+
+	case *AstMapType:
+		return "map[" + f.typ(node.keyType) + "]" + f.typ(node.valType)
+
+	case *AstFuncType:
+		params := []string{}
+		for _, param := range node.params {
+			params = append(params, f.typ(param))
+		}
+		return "func(" + strings.Join(params, ", ") + ")" + f.retType(node.retType)
+
+	case *AstTupleType:
+		elems := []string{}
+		for _, elem := range node.elems {
+			elems = append(elems, f.typ(elem))
+		}
+		return "(" + strings.Join(elems, ", ") + ")"
+	}
+
+	doPanic("format: unknown type: %s", tp.desc())
+	return ""
+}
+
+func (f *formatter) statement(stat AstNode) {
+	if _, ok := stat.(*AstNoopStat); ok {
+		return
+	}
+
+	line, col := astPos(stat)
+	f.item(line, col, false)
+
+	switch node := stat.(type) {
+	case *AstConditionBlock:
+		f.writeLine("if "+f.expr(node.cond)+" {", f.maxLine)
+		for {
+			f.body(node.block)
+			if node.altCondBlock != nil {
+				node = node.altCondBlock
+				f.maxLine = node.line
+				text := "} elif " + f.expr(node.cond) + " {"
+				f.writeLine(text, f.maxLine)
+				continue
+			}
+
+			if node.altBlock != nil {
+				f.writeLine("} else {", node.altBlock.line)
+				f.body(node.altBlock)
+				f.writeLine("}", node.altBlock.endLine)
+			} else {
+				f.writeLine("}", node.block.endLine)
+			}
+			break
+		}
+
+	case *AstWhileBlock:
+		f.writeLine("while "+f.expr(node.cond)+" {", f.maxLine)
+		f.body(node.block)
+		f.writeLine("}", node.block.endLine)
+
+	case *AstForBlock:
+		clause := ""
+		if decl, ok := node.init.(*AstVarDecl); ok {
+			clause = f.varDeclText([]*AstVarDecl{decl})
+		} else if node.init != nil {
+			clause = f.simple(node.init)
+		}
+		clause += "; "
+		if node.cond != nil {
+			clause += f.expr(node.cond)
+		}
+		clause += ";"
+		if node.post != nil {
+			clause += " " + f.simple(node.post)
+		}
+
+		f.writeLine("for "+clause+" {", f.maxLine)
+		f.body(node.block)
+		f.writeLine("}", node.block.endLine)
+
+	case *AstRangeBlock:
+		vars := "_"
+		if node.key != nil {
+			vars = node.key.name
+		}
+		if node.val != nil {
+			vars += ", " + node.val.name
+		}
+
+		f.writeLine("for "+vars+" := range "+f.expr(node.expr)+" {", f.maxLine)
+		f.body(node.block)
+		f.writeLine("}", node.block.endLine)
+
+	case *AstTryBlock:
+		f.writeLine("try {", f.maxLine)
+		f.body(node.block)
+		catch := "} catch {"
+		if node.name != nil {
+			catch = "} catch " + node.name.name + " {"
+		}
+		f.writeLine(catch, node.catch.line)
+		f.body(node.catch)
+		f.writeLine("}", node.catch.endLine)
+
+	default:
+		text := f.simple(stat)
+		f.writeLine(text, f.maxLine)
+	}
+}
+
+//simple gives a statement without block
+func (f *formatter) simple(stat AstNode) string {
+	switch node := stat.(type) {
+	case *AstAssgin:
+		return f.expr(node.dst) + " = " + f.expr(node.expr)
+
+	case *AstMultiAssign:
+		names := []string{}
+		if node.define {
+			for _, decl := range node.decls {
+				if decl == nil {
+					names = append(names, "_")
+				} else {
+					names = append(names, decl.name)
+				}
+			}
+			return strings.Join(names, ", ") + " := " + f.expr(node.expr)
+		}
+
+		for _, dst := range node.dsts {
+			if dst == nil {
+				names = append(names, "_")
+			} else {
+				names = append(names, f.expr(dst))
+			}
+		}
+		return strings.Join(names, ", ") + " = " + f.expr(node.expr)
+
+	case *AstReturn:
+		if node.expr == nil {
+			return "return"
+		}
+		return "return " + f.expr(node.expr)
+
+	case *AstThrow:
+		return "throw " + f.expr(node.expr)
+
+	case *AstBreak:
+		return "break"
+
+	case *AstContinue:
+		return "continue"
+	}
+	return f.expr(stat)
+}
+
+//binPrec gives the binding of a binary operator, higher binds tighter
+func binPrec(op string) int {
+	switch op {
+	case OR:
+		return 1
+	case AND:
+		return 2
+	case EQU, NEQ:
+		return 3
+	case LT, LTE, GT, GTE:
+		return 4
+	case PLUS, MINUS, BIT_OR, BIT_XOR:
+		return 5
+	case POW:
+		return 7
+	}
+	return 6
+}
+
+var opText = map[string]string{
+	PLUS: "+", MINUS: "-", MUL: "*", DIV: "/", MOD: "%", POW: "**",
+	BIT_AND: "&", BIT_OR: "|", BIT_XOR: "^", SHL: "<<", SHR: ">>",
+	EQU: "==", NEQ: "!=", LT: "<", LTE: "<=", GT: ">", GTE: ">=",
+	AND: "&&", OR: "||", NOT: "!",
+}
+
+func (f *formatter) expr(node AstNode) string {
+	line, _ := astPos(node)
+	f.see(line)
+
+	switch node := node.(type) {
+	case *AstIntConst:
+		return strconv.Itoa(node.value)
+
+	case *AstFloatConst:
+		if node.text != "" {
+			return node.text
+		}
+		return strconv.FormatFloat(node.value, 'g', -1, 64)
+
+	case *AstBoolConst:
+		return strconv.FormatBool(node.value)
+
+	case *AstStringConst:
+		return quoteString(node.value)
+
+	case *AstVarNameRef:
+		return node.name
+
+	case *AstBinOP:
+		//operators are left associative, a right operand of the same
+		//binding keeps its parens. ** is right associative and binds
+		//tighter than a sign
+		prec := binPrec(node.op)
+		left := f.expr(node.left)
+		if bin, ok := node.left.(*AstBinOP); ok && (binPrec(bin.op) < prec || bin.op == POW && prec == 7) {
+			left = "(" + left + ")"
+		}
+		if _, ok := node.left.(*AstUnaryOP); ok && node.op == POW {
+			left = "(" + left + ")"
+		}
+		right := f.expr(node.right)
+		if bin, ok := node.right.(*AstBinOP); ok && binPrec(bin.op) <= prec && bin.op != POW {
+			right = "(" + right + ")"
+		}
+		return left + " " + opText[node.op] + " " + right
+
+	case *AstUnaryOP:
+		operand := f.expr(node.dst)
+		if bin, ok := node.dst.(*AstBinOP); ok && bin.op != POW {
+			operand = "(" + operand + ")"
+		}
+		return opText[node.op] + operand
+
+	case *AstFuncCall:
+		callee := builtinSourceName(node.name)
+		if node.callee != nil {
+			callee = f.host(node.callee)
+		}
+		return callee + "(" + f.exprList(node.args) + ")"
+
+	case *AstDotRef:
+		return f.host(node.host) + "." + node.name
+
+	case *AstIndexedRef:
+		return f.host(node.host) + "[" + f.expr(node.index) + "]"
+
+	case *AstSliceRef:
+		low, high := "", ""
+		if node.low != nil {
+			low = f.expr(node.low)
+		}
+		if node.high != nil {
+			high = f.expr(node.high)
+		}
+		return f.host(node.host) + "[" + low + ":" + high + "]"
+
+	case *AstNewOP:
+		return "new(" + f.typ(node.opType) + ")"
+
+	case *AstTuple:
+		return f.exprList(node.exprs)
+
+	case *AstMapLit:
+		return f.mapLit(node)
+
+	case *AstFuncLit:
+		return f.funcLit(node)
+	}
+
+	doPanic("format: unknown expression: %s", node.desc())
+	return ""
+}
+
+//host gives the expr a call, field or index applies to
+func (f *formatter) host(node AstNode) string {
+	text := f.expr(node)
+	switch node.(type) {
+	case *AstVarNameRef, *AstFuncCall, *AstDotRef, *AstIndexedRef, *AstSliceRef, *AstFuncLit:
+		return text
+	}
+	return "(" + text + ")"
+}
+
+func (f *formatter) exprList(nodes []AstNode) string {
+	texts := []string{}
+	for _, node := range nodes {
+		texts = append(texts, f.expr(node))
+	}
+	return strings.Join(texts, ", ")
+}
+
+//mapLit keeps a literal on one line unless its first entry was on a line
+//of its own, then every entry gets a line
+func (f *formatter) mapLit(lit *AstMapLit) string {
+	head := f.typ(lit.type_) + "{"
+	if len(lit.keys) == 0 {
+		return head + "}"
+	}
+
+	if first, _ := astPos(lit.keys[0]); first == lit.line {
+		entries := []string{}
+		for idx, key := range lit.keys {
+			entries = append(entries, f.expr(key)+": "+f.expr(lit.vals[idx]))
+		}
+		return head + strings.Join(entries, ", ") + "}"
+	}
+
+	pad := strings.Repeat(formatIndent, f.indent+1)
+	text := head + "\n"
+	for idx, key := range lit.keys {
+		text += pad + f.expr(key) + ": " + f.expr(lit.vals[idx]) + ",\n"
+	}
+	return text + strings.Repeat(formatIndent, f.indent) + "}"
+}
+
+//funcLit prints the body aside and gives it back as text, the caller
+//writes what follows the closing brace
+func (f *formatter) funcLit(lit *AstFuncLit) string {
+	head := "func" + f.signature(lit.decl) + " {"
+	out, fresh, lastBlank, maxLine := f.out, f.fresh, f.lastBlank, f.maxLine
+	f.out = &strings.Builder{}
+	f.fresh = true
+	f.body(lit.decl.block)
+	text := head + "\n" + f.out.String() + strings.Repeat(formatIndent, f.indent) + "}"
+
+	f.out, f.fresh, f.lastBlank, f.maxLine = out, fresh, lastBlank, maxLine
+	f.see(lit.decl.block.endLine)
+	return text
+}
+
+//quoteString writes s back as a literal, the escapes are those the lexer
+//reads
+func quoteString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\f':
+			sb.WriteString(`\f`)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+//diffOp is a line of a diff, kind is ' ', '-' or '+'. aLine and bLine are
+//the 0 based lines of both sides where it goes
+type diffOp struct {
+	kind  byte
+	text  string
+	aLine int
+	bLine int
+}
+
+//UnifiedDiff gives the changes from a to b in unified format with 3 lines
+//of context, name labels both sides. it is empty when a equals b
+func UnifiedDiff(name, a, b string) string {
+	x, y := diffLines(a), diffLines(b)
+
+	//lcs[i][j] is the longest common part of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	ops := []diffOp{}
+	changes := []int{}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			ops = append(ops, diffOp{' ', x[i], i, j})
+			i++
+			j++
+		case j < len(y) && (i == len(x) || lcs[i][j+1] > lcs[i+1][j]):
+			changes = append(changes, len(ops))
+			ops = append(ops, diffOp{'+', y[j], i, j})
+			j++
+		default:
+			changes = append(changes, len(ops))
+			ops = append(ops, diffOp{'-', x[i], i, j})
+			i++
+		}
+	}
+
+	if len(changes) == 0 {
+		return ""
+	}
+
+	const context = 3
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", name, name)
+	for idx := 0; idx < len(changes); {
+		start := changes[idx] - context
+		if start < 0 {
+			start = 0
+		}
+		last := changes[idx]
+		for idx++; idx < len(changes) && changes[idx]-last-1 <= 2*context; idx++ {
+			last = changes[idx]
+		}
+		end := last + context + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		aCnt, bCnt := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				aCnt++
+			}
+			if op.kind != '-' {
+				bCnt++
+			}
+		}
+
+		aStart, bStart := ops[start].aLine, ops[start].bLine
+		if aCnt > 0 {
+			aStart++
+		}
+		if bCnt > 0 {
+			bStart++
+		}
+		fmt.Fprintf(&sb, "@@ -%d,%d +%d,%d @@\n", aStart, aCnt, bStart, bCnt)
+		for _, op := range ops[start:end] {
+			fmt.Fprintf(&sb, "%c%s\n", op.kind, op.text)
+		}
+	}
+	return sb.String()
+}
+
+//diffLines splits text in lines, a last line without newline is marked
+//like diff does
+func diffLines(text string) []string {
+	lines := strings.Split(text, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n\\ No newline at end of file"
+	return lines
+}
@@ -0,0 +1,164 @@
+package hskl
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestFormatData(t *testing.T) {
+	files, _ := filepath.Glob("../data/*.hskl")
+	for _, path := range files {
+		body, _ := ioutil.ReadFile(path)
+		out, err := Format(string(body))
+		if err != nil {
+			t.Errorf("%s: format error: %v", path, err)
+			continue
+		}
+
+		again, err := Format(out)
+		if err != nil || again != out {
+			t.Errorf("%s: format is not stable, err: %v\n%s", path, err, UnifiedDiff(path, out, again))
+			continue
+		}
+
+		//the formatted program does the same
+		want, wantErr := interpretOut(analyzeFile(t, path))
+		pro := NewParser(out).Program()
+		if err := NewSemanticAnalyzer().DoAnalyze(pro); err != nil {
+			t.Errorf("%s: analyze formatted error: %v", path, err)
+			continue
+		}
+		got, gotErr := interpretOut(pro)
+		if got != want || (gotErr == nil) != (wantErr == nil) {
+			t.Errorf("%s: formatted output differs\nwant:\n%s\ngot:\n%s", path, want, got)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	src := `//points
+type point struct { x,y:int
+    tag : string }
+var origin:point
+func  dist2(a,b :point,scale:int) int {
+    /* squared */ return (a.x-b.x)*(a.x-b.x)+(a.y - b.y)*(a.y-b.y) * scale //no sqrt
+}
+func main() {
+
+
+    var n:int
+    sq := func(v:int) int { return v*v }
+    names := map[string]int{
+        "a": 1, "b": 2}
+    n = 10 - (4 - 3)
+    if !(n > 3) && n != 0 { printn("small") } elif n == 9 {
+        printn("nine")
+    } else {
+
+        printn("sq:\t" + str(sq(n)) + "\"done\"")
+    }
+    for i := 0; i < 3; i = i + 1 { n = n - -i }
+    for _, v := range names { printn(v) }
+    //trailing
+}`
+	want := `//points
+type point struct {
+    x, y: int
+    tag: string
+}
+
+var origin: point
+
+func dist2(a, b: point, scale: int) int {
+    /* squared */ return (a.x - b.x) * (a.x - b.x) + (a.y - b.y) * (a.y - b.y) * scale //no sqrt
+}
+
+func main() {
+    var n: int
+    sq := func(v: int) int {
+        return v * v
+    }
+    names := map[string]int{
+        "a": 1,
+        "b": 2,
+    }
+    n = 10 - (4 - 3)
+    if !(n > 3) && n != 0 {
+        printn("small")
+    } elif n == 9 {
+        printn("nine")
+    } else {
+        printn("sq:\t" + str(sq(n)) + "\"done\"")
+    }
+    for i := 0; i < 3; i = i + 1 {
+        n = n - -i
+    }
+    for _, v := range names {
+        printn(v)
+    }
+    //trailing
+}
+`
+	got, err := Format(src)
+	if err != nil {
+		t.Fatalf("format error: %v", err)
+	}
+	if got != want {
+		t.Errorf("format differs\n%s", UnifiedDiff("format", want, got))
+	}
+
+	if _, err := Format("func main() {\n    n = \n}"); err == nil {
+		t.Errorf("syntax error should be reported")
+	}
+}
+
+func TestFormatOperators(t *testing.T) {
+	cases := map[string]string{
+		"-2**2":         "-2 ** 2",
+		"(-2) ** 2":     "(-2) ** 2",
+		"2 ** 3 ** 2":   "2 ** 3 ** 2",
+		"(2 ** 3) ** 2": "(2 ** 3) ** 2",
+		"2 ** (n * 3)":  "2 ** (n * 3)",
+		"n|n&3":         "n | n & 3",
+		"(n | n) & 3":   "(n | n) & 3",
+		"n % 2 * n":     "n % 2 * n",
+		"1<<n >> 2 ^ n": "1 << n >> 2 ^ n",
+	}
+
+	for expr, want := range cases {
+		got, err := Format("func main() {\n    n = " + expr + "\n}")
+		if err != nil {
+			t.Errorf("format %q error: %v", expr, err)
+			continue
+		}
+		if want = "func main() {\n    n = " + want + "\n}\n"; got != want {
+			t.Errorf("format %q = %q, want: %q", expr, got, want)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	a := "a\nb\nc\nd\ne\nf\ng\nh\n"
+	b := "a\nB\nc\nd\ne\nf\ng\nh\ni\n"
+	want := `--- x
++++ x
+@@ -1,8 +1,9 @@
+ a
+-b
++B
+ c
+ d
+ e
+ f
+ g
+ h
++i
+`
+	if got := UnifiedDiff("x", a, b); got != want {
+		t.Errorf("diff:\n%s", got)
+	}
+	if got := UnifiedDiff("x", a, a); got != "" {
+		t.Errorf("diff of same text: %s", got)
+	}
+}
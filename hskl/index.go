@@ -0,0 +1,186 @@
+package hskl
+
+import (
+	"sort"
+	"strings"
+)
+
+//symbolDef is a declared var, func, method or struct field
+type symbolDef struct {
+	name string
+	decl AstNode
+	line int
+	col  int
+	//global is set for the top level decls of the program
+	global bool
+}
+
+//hover describes the symbol with its type
+func (def *symbolDef) hover() string {
+	switch decl := def.decl.(type) {
+	case *AstFuncDecl:
+		sig := strings.TrimPrefix(funcTypeOf(decl).desc(), "func")
+		return "func " + funcFullName(decl) + sig
+
+	case *AstVarDecl:
+		if decl.type_ == nil {
+			return "var " + decl.name
+		}
+		return "var " + decl.name + ": " + decl.type_.desc()
+	}
+	return def.name
+}
+
+//symbolRef is a name in the source bound to its def, the def itself is
+//one of its refs
+type symbolRef struct {
+	line int
+	col  int
+	span int
+	def  *symbolDef
+}
+
+//symbolIndex records where symbols are declared and used, it is filled by
+//the analyzer for editor support. a nil index records nothing
+type symbolIndex struct {
+	defs map[AstNode]*symbolDef
+	refs []*symbolRef
+	seen map[[2]int]bool
+}
+
+func newSymbolIndex() *symbolIndex {
+	return &symbolIndex{defs: map[AstNode]*symbolDef{}, seen: map[[2]int]bool{}}
+}
+
+//declPos gives name and position of a decl, 0 line for builtins
+func declPos(decl AstNode) (string, int, int) {
+	switch node := decl.(type) {
+	case *AstFuncDecl:
+		return node.name, node.line, node.col
+
+	case *AstVarDecl:
+		return node.name, node.line, node.col
+	}
+	return "", 0, 0
+}
+
+//define records decl, its name is a ref to itself
+func (idx *symbolIndex) define(decl AstNode) *symbolDef {
+	if idx == nil {
+		return nil
+	}
+	if def, ok := idx.defs[decl]; ok {
+		return def
+	}
+
+	name, line, col := declPos(decl)
+	if line == 0 {
+		return nil
+	}
+
+	def := &symbolDef{name: name, decl: decl, line: line, col: col}
+	idx.defs[decl] = def
+	idx.refer(line, col, decl)
+	return def
+}
+
+//refer records a use of decl at line and col
+func (idx *symbolIndex) refer(line, col int, decl AstNode) {
+	if idx == nil || line == 0 || idx.seen[[2]int{line, col}] {
+		return
+	}
+
+	def := idx.define(decl)
+	if def == nil {
+		return
+	}
+
+	idx.seen[[2]int{line, col}] = true
+	idx.refs = append(idx.refs, &symbolRef{line: line, col: col, span: len([]rune(def.name)), def: def})
+}
+
+//markGlobals flags the top level decls of pro
+func (idx *symbolIndex) markGlobals(pro *AstProgram) {
+	for _, decl := range pro.decl_list {
+		if fn, ok := decl.(*AstFuncDecl); ok && fn.recv != nil {
+			continue
+		}
+		if def := idx.defs[decl]; def != nil {
+			def.global = true
+		}
+	}
+}
+
+//at finds the ref covering line and col
+func (idx *symbolIndex) at(line, col int) *symbolRef {
+	for _, ref := range idx.refs {
+		if ref.line == line && col >= ref.col && col < ref.col+ref.span {
+			return ref
+		}
+	}
+	return nil
+}
+
+//refsOf gives all refs of def in source order
+func (idx *symbolIndex) refsOf(def *symbolDef) []*symbolRef {
+	refs := []*symbolRef{}
+	for _, ref := range idx.refs {
+		if ref.def == def {
+			refs = append(refs, ref)
+		}
+	}
+
+	sort.Slice(refs, func(i, j int) bool {
+		if refs[i].line != refs[j].line {
+			return refs[i].line < refs[j].line
+		}
+		return refs[i].col < refs[j].col
+	})
+	return refs
+}
+
+//visible gives the vars and funcs usable at line: globals and the locals
+//of the top level decl containing line declared before it. a later local
+//shadows an earlier one or a global of the same name
+func (idx *symbolIndex) visible(pro *AstProgram, line int) map[string]*symbolDef {
+	start, end := 0, 0
+	for _, decl := range pro.decl_list {
+		declLine, _ := astPos(decl)
+		if declLine <= line {
+			start, end = declLine, 0
+		} else if end == 0 {
+			end = declLine
+		}
+	}
+
+	names := map[string]*symbolDef{}
+	for _, def := range idx.defs {
+		fn, isFunc := def.decl.(*AstFuncDecl)
+		if isFunc && fn.recv != nil {
+			continue
+		}
+		if def.global {
+			if old, ok := names[def.name]; !ok || old.global {
+				names[def.name] = def
+			}
+		}
+	}
+
+	locals := []*symbolDef{}
+	for _, def := range idx.defs {
+		if _, ok := def.decl.(*AstVarDecl); !ok || def.global {
+			continue
+		}
+		if def.line >= start && def.line <= line && (end == 0 || def.line < end) {
+			locals = append(locals, def)
+		}
+	}
+
+	sort.Slice(locals, func(i, j int) bool {
+		return locals[i].line < locals[j].line
+	})
+	for _, def := range locals {
+		names[def.name] = def
+	}
+	return names
+}
@@ -1,8 +1,12 @@
 package hskl
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"math"
 	"strconv"
+	"unicode/utf8"
 
 	"github.com/pkg/errors"
 )
@@ -11,6 +15,7 @@ const (
 	Frame_Normal = iota + 1
 	FrameRun_Return
 	FrameRun_Break
+	FrameRun_Continue
 )
 
 type stackFrame struct {
@@ -20,6 +25,11 @@ type stackFrame struct {
 	retVal  interface{}
 	state   byte
 	interp  *interpreter
+	//fn is set for the frame of a func call, callLine is where it is called
+	fn       *AstFuncDecl
+	callLine int
+	//calls is the count of funcs being called when the frame runs
+	calls int
 }
 
 func makeFrame(interp *interpreter, level int, upLevel *stackFrame) *stackFrame {
@@ -59,6 +69,28 @@ type vari struct {
 	val   interface{}
 }
 
+//closure is a func value, env is the frame the func literal was
+//evaluated in, top level funcs are bound to the global frame
+type closure struct {
+	decl *AstFuncDecl
+	env  *stackFrame
+}
+
+func (c *closure) String() string {
+	return funcValueDesc(c.decl)
+}
+
+//ifaceVal is a struct value boxed into an interface, methods are found
+//through the struct type at runtime
+type ifaceVal struct {
+	tp  *AstStructType
+	val interface{}
+}
+
+func (v *ifaceVal) String() string {
+	return fmt.Sprint(v.val)
+}
+
 func newIntVari(level int, ast *AstVarDecl) *vari {
 	va := &vari{}
 	va.name = ast.name
@@ -72,6 +104,27 @@ func newIntVari(level int, ast *AstVarDecl) *vari {
 	return va
 }
 
+func newFloatVari(level int, ast *AstVarDecl) *vari {
+	va := &vari{}
+	va.name = ast.name
+	va.type_ = ast.type_
+
+	va.val = 0.0
+	if len(ast.initVal) > 0 {
+		va.val, _ = strconv.ParseFloat(ast.initVal, 64)
+	}
+
+	return va
+}
+
+func newBoolVari(level int, ast *AstVarDecl) *vari {
+	va := &vari{}
+	va.name = ast.name
+	va.type_ = ast.type_
+	va.val = ast.initVal == "true"
+	return va
+}
+
 func newStringVari(level int, ast *AstVarDecl) *vari {
 	va := &vari{}
 	va.name = ast.name
@@ -101,6 +154,14 @@ func newStructVari(level int, ast *AstVarDecl) *vari {
 				mv[field.name] = 0
 				break
 
+			case symTypeFloat:
+				mv[field.name] = 0.0
+				break
+
+			case symTypeBool:
+				mv[field.name] = false
+				break
+
 			case symTypeString:
 				mv[field.name] = ""
 				break
@@ -140,6 +201,22 @@ func newArrayVari(level int, ast *AstVarDecl) *vari {
 			va.val = valArr
 			break
 
+		case symTypeFloat:
+			valArr := []interface{}{}
+			if ast.initArr != nil {
+				for _, token := range ast.initArr {
+					val, _ := strconv.ParseFloat(token.value, 64)
+					valArr = append(valArr, val)
+				}
+			}
+
+			va.val = valArr
+			break
+
+		case symTypeBool:
+			va.val = []interface{}{}
+			break
+
 		case symTypeString:
 			valArr := []interface{}{}
 			if ast.initArr != nil {
@@ -155,7 +232,7 @@ func newArrayVari(level int, ast *AstVarDecl) *vari {
 			doPanic("newArrayVari error, unknown primitive elem type: %T", elem)
 		}
 
-	case *AstArrayType, *AstStructType:
+	case *AstArrayType, *AstMapType, *AstFuncType, *AstStructType, *AstIfaceType:
 		va.val = []interface{}{}
 		break
 
@@ -167,15 +244,36 @@ func newArrayVari(level int, ast *AstVarDecl) *vari {
 }
 
 type interpreter struct {
-	callStack []*stackFrame
-	stackSize int
-	curFrame  *stackFrame
-	mainFunc  *AstFuncDecl
-	debug     bool
+	callStack   []*stackFrame
+	stackSize   int
+	curFrame    *stackFrame
+	globalFrame *stackFrame
+	mainFunc    *AstFuncDecl
+	file        string
+	debug       bool
+	//hook is called before each statement when a debugger is attached
+	hook func(stat AstNode)
+	//limits are counted by steps and allocated, ctx is set while a
+	//program runs with a context
+	limits    Limits
+	ctx       context.Context
+	steps     int
+	allocated int
+	//curLine is the line of the statement running, it is the line of go
+	//level errors which carry none
+	curLine int
+	//env holds the streams builtins read and write
+	env *nativeEnv
 }
 
+//pushStackFrame opens a block frame, chained to the enclosing frame
 func (interp *interpreter) pushStackFrame() *stackFrame {
-	symTb := makeFrame(interp, interp.stackSize, interp.curFrame)
+	return interp.pushFrame(interp.curFrame)
+}
+
+func (interp *interpreter) pushFrame(upLevel *stackFrame) *stackFrame {
+	symTb := makeFrame(interp, interp.stackSize, upLevel)
+	symTb.calls = interp.curFrame.calls
 	interp.callStack = append(interp.callStack, symTb)
 	interp.stackSize++
 	interp.curFrame = symTb
@@ -199,6 +297,33 @@ func (interp *interpreter) frameBreaked() bool {
 	return interp.curFrame.state == FrameRun_Break
 }
 
+func (interp *interpreter) frameContinued() bool {
+	return interp.curFrame.state == FrameRun_Continue
+}
+
+//frameJumped tells a return, break or continue is leaving the block
+func (interp *interpreter) frameJumped() bool {
+	return interp.frameReturned() || interp.frameBreaked() || interp.frameContinued()
+}
+
+//loopDone eats break and continue of the loop body, true means the
+//loop should stop
+func (interp *interpreter) loopDone() bool {
+	if interp.frameReturned() {
+		return true
+	}
+
+	if interp.frameBreaked() {
+		interp.curFrame.state = Frame_Normal
+		return true
+	}
+
+	if interp.frameContinued() {
+		interp.curFrame.state = Frame_Normal
+	}
+	return false
+}
+
 func (interp *interpreter) visitProgram(program *AstProgram) {
 	for _, decl := range program.decl_list {
 		switch node := decl.(type) {
@@ -227,6 +352,13 @@ func (interp *interpreter) visitVarDecl(node *AstVarDecl) {
 	}
 
 	//ok
+	if node.initExpr != nil {
+		va := &vari{name: node.name, type_: node.type_}
+		va.val = interp.visitAst(node.initExpr)
+		interp.curFrame.insertVari(va)
+		return
+	}
+
 	switch tp := node.type_.(type) {
 	case *AstPrimType:
 		switch tp.name {
@@ -235,6 +367,16 @@ func (interp *interpreter) visitVarDecl(node *AstVarDecl) {
 			interp.curFrame.insertVari(va)
 			break
 
+		case symTypeFloat:
+			va := newFloatVari(interp.curFrame.level, node)
+			interp.curFrame.insertVari(va)
+			break
+
+		case symTypeBool:
+			va := newBoolVari(interp.curFrame.level, node)
+			interp.curFrame.insertVari(va)
+			break
+
 		case symTypeString:
 			va := newStringVari(interp.curFrame.level, node)
 			interp.curFrame.insertVari(va)
@@ -255,13 +397,25 @@ func (interp *interpreter) visitVarDecl(node *AstVarDecl) {
 
 	case *AstArrayType:
 		va := newArrayVari(interp.curFrame.level, node)
+		interp.alloc(len(node.initArr), node.line)
 		interp.curFrame.insertVari(va)
 		break
 
+	case *AstMapType:
+		va := &vari{name: node.name, type_: node.type_}
+		va.val = make(map[interface{}]interface{})
+		interp.curFrame.insertVari(va)
+		break
+
+	case *AstFuncType, *AstIfaceType:
+		interp.curFrame.insertVari(&vari{name: node.name, type_: node.type_})
+		break
+
 	case *AstUndefType:
 		ast := &AstVarDecl{}
 		ast.initArr = node.initArr
 		ast.initVal = node.initVal
+		ast.initExpr = node.initExpr
 		ast.line = node.line
 		ast.name = node.name
 		ast.type_ = tp.resolved
@@ -275,115 +429,130 @@ func (interp *interpreter) visitVarDecl(node *AstVarDecl) {
 }
 
 func (interp *interpreter) visitFuncDecl(node *AstFuncDecl) {
-	if node.name == entryFunc {
+	if node.name == entryFunc && node.recv == nil {
 		interp.mainFunc = node
 	}
 }
 
-func (interp *interpreter) visitBuiltinPrint(node *AstFuncCall) interface{} {
-	//lookup args
-	val := interp.curFrame.lookup("format", false).val
-	fmt.Printf("%v", val)
-	return nil
-}
-
-func (interp *interpreter) visitBuiltinPrintn(node *AstFuncCall) interface{} {
-	//lookup args
-	val := interp.curFrame.lookup("format", false).val
-	fmt.Printf("%v\n", val)
-	return nil
+//callFunc calls a top level func, its frame is chained to the global frame
+//instead of the caller, so callee can not see caller's locals
+func (interp *interpreter) callFunc(decl *AstFuncDecl, args []interface{}) interface{} {
+	return interp.callIn(decl, interp.globalFrame, args, 0)
 }
 
-func (interp *interpreter) visitBuiltinStr(node *AstFuncCall) interface{} {
-	//lookup args
-	val := interp.curFrame.lookup("val", false).val
-	//fmt.Printf("builtin print: %v\n", val)
-	return fmt.Sprintf("%v", val)
-}
-
-func (interp *interpreter) visitBuiltinInt(node *AstFuncCall) interface{} {
-	//lookup args
-	val := interp.curFrame.lookup("val", false).val
-	switch tVal := val.(type) {
-	case int:
-		return tVal
-
-	case string:
-		iVal, _ := strconv.Atoi(tVal)
-		return iVal
+//callIn binds args in a new function frame chained to env and runs the
+//body, builtin and host functions run their go implementation directly
+func (interp *interpreter) callIn(decl *AstFuncDecl, env *stackFrame, args []interface{}, line int) interface{} {
+	if decl.native != nil {
+		return interp.callNative(decl, args, line)
+	}
 
-	default:
-		doPanic("builtin int(): cant convert: %s", tVal)
-		return nil
+	interp.pushFrame(env)
+	interp.curFrame.fn = decl
+	interp.curFrame.callLine = line
+	interp.curFrame.calls++
+	if max := interp.limits.MaxCallDepth; max > 0 && interp.curFrame.calls > max {
+		panic(&CallDepthError{Max: max, Line: line})
+	}
+	for idx, param := range funcParams(decl) {
+		interp.curFrame.insertVari(&vari{name: param.name, type_: param.type_, val: args[idx]})
 	}
-}
 
-func (interp *interpreter) visitBuiltinAppend(node *AstFuncCall) interface{} {
-	vari := interp.curFrame.lookup("arr", false)
+	callerLine := interp.curLine
+	ret := interp.visitCodeBlock(decl.block)
+	//return and break not cross func boundary
+	interp.popStackFrame().state = Frame_Normal
+	interp.curLine = callerLine
+	return ret
+}
 
-	if vari.val == nil {
-		doPanic("hsk runtime error, nil reference: %s, line: %d", node.desc(), node.line)
-		return nil
+func (interp *interpreter) visitFuncCall(node *AstFuncCall) interface{} {
+	//func value is evaluated before args
+	var fn *closure
+	if node.callee != nil {
+		val, ok := interp.visitAst(node.callee).(*closure)
+		if !ok {
+			interpPanic("hskl runtime error, call of nil func, line: %d", node.line)
+		}
+		fn = val
 	}
 
-	switch rType := vari.val.(type) {
-	case []interface{}:
-		elem := interp.curFrame.lookup("elem", false).val
-		return append(rType, elem)
-
-	case []int:
-		elem := interp.curFrame.lookup("elem", false).val
-		return append(rType, elem.(int))
+	//prepare for args, receiver goes first
+	args := []interface{}{}
+	decl := node.ast
+	if node.recv != nil {
+		val := interp.visitAst(node.recv)
+		if node.dynamic {
+			box, ok := val.(*ifaceVal)
+			if !ok {
+				interpPanic("hskl runtime error, call of method %s on nil interface, line: %d", node.name, node.line)
+			}
+			decl, val = box.tp.methods[node.name], box.val
+		}
 
-	case []string:
-		elem := interp.curFrame.lookup("elem", false).val
-		return append(rType, elem.(string))
+		recv, ok := recvValue(decl, val)
+		if !ok {
+			interpPanic("hskl runtime error, nil receiver of method %s, line: %d", funcFullName(decl), node.line)
+		}
+		args = append(args, recv)
+	}
+	for _, argExp := range node.args {
+		arg := interp.visitAst(argExp)
+		args = append(args, arg)
+	}
 
-	default:
-		doPanic("visit builtin append error, unknown array type: %T", rType)
-		return nil
+	if fn != nil {
+		return interp.callIn(fn.decl, fn.env, args, node.line)
 	}
+	if decl.native != nil {
+		return interp.callNative(decl, args, node.line)
+	}
+	return interp.callIn(decl, interp.globalFrame, args, node.line)
 }
 
-func (interp *interpreter) visitBuiltinLen(node *AstFuncCall) int {
-	vari := interp.curFrame.lookup("arr", false)
-	arr := vari.val.([]interface{})
-	return len(arr)
+//callValue calls a func value for a builtin
+func (interp *interpreter) callValue(fn interface{}, args []interface{}) interface{} {
+	val, ok := fn.(*closure)
+	if !ok {
+		interpPanic("hskl runtime error, call of nil func")
+	}
+	return interp.callIn(val.decl, val.env, args, 0)
 }
 
-func (interp *interpreter) visitBuiltinFunc(node *AstFuncCall) interface{} {
-	switch node.name {
-	case Builtin_print:
-		return interp.visitBuiltinPrint(node)
-
-	case Builtin_printn:
-		return interp.visitBuiltinPrintn(node)
-
-	case Builtin_str:
-		return interp.visitBuiltinStr(node)
-
-	case Builtin_int:
-		return interp.visitBuiltinInt(node)
-
-	case Builtin_append:
-		return interp.visitBuiltinAppend(node)
+//callNative runs a builtin or host func, its runtime errors get the line
+//of the call. what it makes counts to the allocated size
+func (interp *interpreter) callNative(decl *AstFuncDecl, args []interface{}, line int) interface{} {
+	ret := interp.runtimeLine(line, func() interface{} {
+		return decl.native(interp.env, args)
+	})
+	if size := allocOf(ret, args); size > 0 {
+		interp.alloc(size, line)
+	}
+	return ret
+}
 
-	case Builtin_len:
-		return interp.visitBuiltinLen(node)
+//recvValue is what a method gets as receiver: a *T receiver shares the
+//caller's struct, a value receiver works on a shallow copy, so changes
+//to its fields are not seen by the caller. false means nil receiver
+func recvValue(decl *AstFuncDecl, val interface{}) (interface{}, bool) {
+	if decl.recvPtr {
+		return val, true
+	}
 
-	default:
-		doPanic("interpret built func failed, name: %s, call at line: %d", node.name, node.line)
-		return nil
+	obj, ok := val.(map[string]interface{})
+	if !ok {
+		return nil, false
 	}
-}
 
-func (interp *interpreter) visitFuncCall(node *AstFuncCall) interface{} {
-	if node.ast.builtin {
-		return interp.visitBuiltinFunc(node)
+	cp := make(map[string]interface{}, len(obj))
+	for key, elem := range obj {
+		cp[key] = elem
 	}
+	return cp, true
+}
 
-	ret := interp.visitCodeBlock(node.ast.block)
-	return ret
+func (interp *interpreter) visitFuncLit(node *AstFuncLit) interface{} {
+	return &closure{decl: node.decl, env: interp.curFrame}
 }
 
 func (interp *interpreter) visitCodeBlockVars(node *AstCodeBlock) {
@@ -400,11 +569,31 @@ func (interp *interpreter) visitCodeBlockStatement(node *AstCodeBlock) interface
 
 eval_loop:
 	for _, ast := range node.stat_list {
+		interp.step(ast)
+		if interp.hook != nil {
+			interp.hook(ast)
+		}
+
 		switch stat := ast.(type) {
-		case *AstAssgin, *AstBinOP, *AstUnaryOP, *AstIntConst, *AstVarNameRef, *AstFuncCall:
+		case *AstAssgin, *AstBinOP, *AstUnaryOP, *AstIntConst, *AstFloatConst, *AstVarNameRef, *AstFuncCall:
 			interp.visitAst(ast)
 			break
 
+		case *AstMultiAssign:
+			interp.visitMultiAssign(stat)
+			break
+
+		case *AstThrow:
+			interp.visitThrow(stat)
+			break
+
+		case *AstTryBlock:
+			ret = interp.visitTryBlock(stat)
+			if interp.frameJumped() {
+				return ret
+			}
+			break
+
 		case *AstReturn:
 			ret = interp.visitReturn(stat)
 			interp.curFrame.state = FrameRun_Return
@@ -414,14 +603,14 @@ eval_loop:
 			interp.pushStackFrame()
 			ret = interp.visitCodeBlock(stat)
 			interp.popStackFrame()
-			if interp.frameReturned() {
+			if interp.frameJumped() {
 				return ret
 			}
 			break
 
 		case *AstConditionBlock:
 			ret = interp.visitConditionBlock(stat)
-			if interp.frameReturned() || interp.frameBreaked() {
+			if interp.frameJumped() {
 				return ret
 			}
 			break
@@ -433,10 +622,28 @@ eval_loop:
 			}
 			break
 
+		case *AstForBlock:
+			ret = interp.visitForBlock(stat)
+			if interp.frameReturned() {
+				return ret
+			}
+			break
+
+		case *AstRangeBlock:
+			ret = interp.visitRangeBlock(stat)
+			if interp.frameReturned() {
+				return ret
+			}
+			break
+
 		case *AstBreak:
 			interp.curFrame.state = FrameRun_Break
 			return nil
 
+		case *AstContinue:
+			interp.curFrame.state = FrameRun_Continue
+			return nil
+
 		case *AstNoopStat:
 			break
 
@@ -460,29 +667,13 @@ func (interp *interpreter) conditionOk(val interface{}) bool {
 	}
 
 	switch tp := val.(type) {
-	case int:
-		if tp == 0 {
-			return false
-		} else {
-			return true
-		}
-		break
-
-	case string:
-		if len(tp) > 0 {
-			return true
-		} else {
-			return false
-		}
-
-		break
+	case bool:
+		return tp
 
 	default:
 		doPanic("conditionOk recv unknown type: %T:%v", val, val)
 		return false
 	}
-
-	return false
 }
 
 func (interp *interpreter) visitConditionBlock(node *AstConditionBlock) interface{} {
@@ -512,30 +703,165 @@ func (interp *interpreter) visitWhileBlock(node *AstWhileBlock) interface{} {
 	var ret interface{}
 	for interp.conditionOk(interp.visitAst(node.cond)) {
 
+		interp.step(node)
 		interp.pushStackFrame()
 		interp.visitCodeBlockVars(node.block)
 		ret = interp.visitCodeBlockStatement(node.block)
 		interp.popStackFrame()
 
-		if interp.frameReturned() {
+		if interp.loopDone() {
 			break
 		}
+	}
+	return ret
+}
 
-		if interp.frameBreaked() {
-			//eat break state
-			interp.curFrame.state = Frame_Normal
+func (interp *interpreter) visitForBlock(node *AstForBlock) interface{} {
+	var ret interface{}
+	interp.pushStackFrame()
+	if decl, ok := node.init.(*AstVarDecl); ok {
+		interp.visitVarDecl(decl)
+	} else if node.init != nil {
+		interp.visitAst(node.init)
+	}
+
+	for node.cond == nil || interp.conditionOk(interp.visitAst(node.cond)) {
+		interp.step(node)
+		interp.pushStackFrame()
+		interp.visitCodeBlockVars(node.block)
+		ret = interp.visitCodeBlockStatement(node.block)
+		interp.popStackFrame()
+
+		if interp.loopDone() {
 			break
 		}
+
+		if decl, ok := node.init.(*AstVarDecl); ok {
+			interp.renewLoopVar(decl)
+		}
+		if node.post != nil {
+			interp.visitAst(node.post)
+		}
 	}
+
+	interp.popStackFrame()
 	return ret
 }
 
+//renewLoopVar gives the next iteration a fresh copy of the init var in a
+//new frame, closures made in the last iteration keep the old one
+func (interp *interpreter) renewLoopVar(decl *AstVarDecl) {
+	old := interp.curFrame.lookup(decl.name, false)
+	interp.popStackFrame()
+	interp.pushStackFrame()
+	interp.curFrame.insertVari(&vari{name: old.name, type_: old.type_, val: old.val})
+}
+
+func (interp *interpreter) visitRangeBlock(node *AstRangeBlock) interface{} {
+	var ret interface{}
+	iter := newRangeIter(interp.visitAst(node.expr))
+	for {
+		key, val, ok := iter.next()
+		if !ok {
+			break
+		}
+
+		interp.step(node)
+		interp.pushStackFrame()
+		if node.key != nil {
+			interp.curFrame.insertVari(&vari{name: node.key.name, type_: node.key.type_, val: key})
+		}
+		if node.val != nil {
+			interp.curFrame.insertVari(&vari{name: node.val.name, type_: node.val.type_, val: val})
+		}
+		interp.visitCodeBlockVars(node.block)
+		ret = interp.visitCodeBlockStatement(node.block)
+		interp.popStackFrame()
+
+		if interp.loopDone() {
+			break
+		}
+	}
+	return ret
+}
+
+//rangeIter walks what a range loop iterates, both engines share it. the
+//length of an array and the keys of a map are taken when the loop starts,
+//elements are read when they are reached, a deleted key is skipped
+type rangeIter struct {
+	coll interface{}
+	keys []interface{}
+	size int
+	pos  int
+}
+
+func newRangeIter(coll interface{}) *rangeIter {
+	it := &rangeIter{coll: coll}
+	switch tp := coll.(type) {
+	case []interface{}:
+		it.size = len(tp)
+		break
+
+	case map[interface{}]interface{}:
+		it.keys = sortedKeys(tp)
+		it.size = len(it.keys)
+		break
+
+	case string:
+		for idx := range tp {
+			it.keys = append(it.keys, idx)
+		}
+		it.size = len(it.keys)
+		break
+	}
+
+	return it
+}
+
+func (it *rangeIter) next() (key interface{}, val interface{}, ok bool) {
+	for it.pos < it.size {
+		idx := it.pos
+		it.pos++
+
+		switch tp := it.coll.(type) {
+		case []interface{}:
+			return idx, tp[idx], true
+
+		case map[interface{}]interface{}:
+			key = it.keys[idx]
+			if val, ok = tp[key]; ok {
+				return key, val, true
+			}
+			break
+
+		case string:
+			//the key is the rune index, the same as s[i] takes
+			offset := it.keys[idx].(int)
+			char, _ := utf8.DecodeRuneInString(tp[offset:])
+			return idx, string(char), true
+		}
+	}
+
+	return nil, nil, false
+}
+
 func (interp *interpreter) setAstVal(dst AstNode, val interface{}) {
 	switch rTp := dst.(type) {
 	case *AstIndexedRef:
 		if ret := interp.visitAst(rTp.host); ret != nil {
-			arr := ret.([]interface{})
+			if mv, ok := ret.(map[interface{}]interface{}); ok {
+				mv[interp.visitAst(rTp.index)] = val
+				break
+			}
+
+			arr, ok := ret.([]interface{})
+			if !ok {
+				interpPanic("hskl runtime error, can not assign to index of %s, line: %d", rTp.host.desc(), rTp.line)
+			}
 			idx := interp.visitAst(rTp.index).(int)
+			if idx < 0 || idx >= len(arr) {
+				interpPanic("hskl runtime error, index out of range: %d, len: %d, line: %d", idx, len(arr), rTp.line)
+			}
 			arr[idx] = val
 		} else {
 			interpPanic("hskl runtime error, nil reference: %s, line: %d", rTp.host.desc(), rTp.line)
@@ -543,7 +869,7 @@ func (interp *interpreter) setAstVal(dst AstNode, val interface{}) {
 		break
 
 	case *AstVarNameRef:
-		sym := interp.curFrame.lookup(rTp.name, true)
+		sym := interp.lookupRef(rTp)
 		if sym == nil {
 			doPanic("error in varRef, symbol not found: %s", rTp.name)
 		}
@@ -573,12 +899,101 @@ func (interp *interpreter) visitAssign(node *AstAssgin) interface{} {
 	return ret
 }
 
+//visitTryBlock runs the catch block when a runtime error escapes the try
+//block, frames pushed after the try are dropped. errors of the host go
+//code and analyzer are not caught
+func (interp *interpreter) visitTryBlock(node *AstTryBlock) interface{} {
+	depth := interp.stackSize
+	ret, caught := interp.runTry(node.block)
+	if caught == nil {
+		return ret
+	}
+
+	interp.callStack = interp.callStack[:depth]
+	interp.stackSize = depth
+	interp.curFrame = interp.callStack[depth-1]
+	interp.curFrame.state = Frame_Normal
+
+	interp.pushStackFrame()
+	if node.name != nil {
+		interp.curFrame.insertVari(&vari{name: node.name.name, type_: node.name.type_, val: errorValue(caught, 0)})
+	}
+	ret = interp.visitCodeBlock(node.catch)
+	interp.popStackFrame()
+	return ret
+}
+
+func (interp *interpreter) runTry(block *AstCodeBlock) (ret interface{}, caught *interpError) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(*interpError)
+			if !ok {
+				panic(r)
+			}
+			caught = err
+		}
+	}()
+
+	interp.pushStackFrame()
+	ret = interp.visitCodeBlock(block)
+	interp.popStackFrame()
+	return ret, nil
+}
+
+func (interp *interpreter) visitThrow(node *AstThrow) {
+	panic(thrownError(interp.visitAst(node.expr), node.line))
+}
+
+//thrownError makes the runtime error of a throw, a rethrown error keeps
+//its message and line
+func thrownError(val interface{}, line int) *interpError {
+	if obj, ok := val.(map[string]interface{}); ok {
+		return &interpError{msg: obj["msg"].(string), line: obj["line"].(int)}
+	}
+	return &interpError{msg: val.(string), line: line}
+}
+
+//errorValue is the error struct a catch block gets. the line is the one
+//of err, the one ending the message of the tree walker or the given line
+func errorValue(err *interpError, line int) map[string]interface{} {
+	if err.line > 0 {
+		return map[string]interface{}{"msg": err.msg, "line": err.line}
+	}
+
+	msg, n := splitLine(err.msg)
+	if n > 0 {
+		line = n
+	}
+	return map[string]interface{}{"msg": msg, "line": line}
+}
+
+//visitMultiAssign spreads the values returned by the call over the
+//targets from left to right, blank targets drop their value
+func (interp *interpreter) visitMultiAssign(node *AstMultiAssign) {
+	vals := interp.visitAst(node.expr).([]interface{})
+	if node.define {
+		for idx, decl := range node.decls {
+			if decl != nil {
+				interp.curFrame.insertVari(&vari{name: decl.name, type_: decl.type_, val: vals[idx]})
+			}
+		}
+		return
+	}
+
+	for idx, dst := range node.dsts {
+		if dst != nil {
+			interp.setAstVal(dst, vals[idx])
+		}
+	}
+}
+
 func (interp *interpreter) visitBinOP(node *AstBinOP) interface{} {
 	var lhs interface{}
 	var rhs interface{}
 
 	switch node.left.(type) {
-	case *AstBinOP, *AstUnaryOP, *AstStringConst, *AstIntConst, *AstVarNameRef, *AstFuncCall:
+	case *AstBinOP, *AstUnaryOP, *AstStringConst, *AstIntConst, *AstFloatConst, *AstBoolConst,
+		*AstVarNameRef, *AstIndexedRef, *AstSliceRef, *AstDotRef, *AstFuncCall:
 		lhs = interp.visitAst(node.left)
 		break
 
@@ -586,8 +1001,16 @@ func (interp *interpreter) visitBinOP(node *AstBinOP) interface{} {
 		doPanic("error in binop left, unknown ast type: %s, line: %d", node.left, node.line)
 	}
 
+	//short circuit, right side is evaluated only when it decides the result
+	if node.op == AND || node.op == OR {
+		if lhs.(bool) == (node.op == OR) {
+			return lhs
+		}
+	}
+
 	switch node.right.(type) {
-	case *AstBinOP, *AstUnaryOP, *AstStringConst, *AstIntConst, *AstVarNameRef, *AstFuncCall:
+	case *AstBinOP, *AstUnaryOP, *AstStringConst, *AstIntConst, *AstFloatConst, *AstBoolConst,
+		*AstVarNameRef, *AstIndexedRef, *AstSliceRef, *AstDotRef, *AstFuncCall:
 		rhs = interp.visitAst(node.right)
 		break
 
@@ -595,10 +1018,53 @@ func (interp *interpreter) visitBinOP(node *AstBinOP) interface{} {
 		doPanic("error in binop right, unknown ast type: %s, line: %d", node.right, node.line)
 	}
 
+	if node.op == AND || node.op == OR {
+		return rhs.(bool)
+	}
+
 	if s1, ok := lhs.(string); ok {
-		//must be string add
 		s2 := rhs.(string)
-		return s1 + s2
+		switch node.op {
+		case PLUS:
+			interp.alloc(len(s1)+len(s2), node.line)
+			return s1 + s2
+
+		case EQU:
+			return s1 == s2
+
+		case NEQ:
+			return s1 != s2
+
+		case LT:
+			return s1 < s2
+
+		case LTE:
+			return s1 <= s2
+
+		case GT:
+			return s1 > s2
+
+		case GTE:
+			return s1 >= s2
+		}
+
+		doPanic("unsupported string operator: %s, line: %d", node.op, node.line)
+	}
+
+	if f1, ok := lhs.(float64); ok {
+		return interp.floatBinOP(node, f1, rhs.(float64))
+	}
+
+	if b1, ok := lhs.(bool); ok {
+		switch node.op {
+		case EQU:
+			return b1 == rhs.(bool)
+
+		case NEQ:
+			return b1 != rhs.(bool)
+		}
+
+		doPanic("unsupported bool operator: %s, line: %d", node.op, node.line)
 	}
 
 	lhv := lhs.(int)
@@ -619,77 +1085,110 @@ func (interp *interpreter) visitBinOP(node *AstBinOP) interface{} {
 		if rhv != 0 {
 			return lhv / rhv
 		} else {
-			interpPanic("div by zero: %s, line: %d", node.desc(), node.line)
+			interpPanic("div by zero, line: %d", node.line)
 		}
 
-	case AND:
-		if lhv == 0 {
-			return lhv
-		} else {
-			return rhv
+	case MOD:
+		if rhv == 0 {
+			interpPanic("mod by zero, line: %d", node.line)
 		}
+		return lhv % rhv
 
-	case OR:
-		if lhv != 0 {
-			return lhv
-		} else {
-			return rhv
+	case POW:
+		if rhv < 0 {
+			interpPanic("negative exponent: %d, line: %d", rhv, node.line)
 		}
+		return intPow(lhv, rhv)
 
-	case NOT:
-		if lhv == 0 {
-			return 1
-		} else {
-			return 0
+	case BIT_AND:
+		return lhv & rhv
+
+	case BIT_OR:
+		return lhv | rhv
+
+	case BIT_XOR:
+		return lhv ^ rhv
+
+	case SHL, SHR:
+		if rhv < 0 {
+			interpPanic("negative shift count: %d, line: %d", rhv, node.line)
 		}
+		if node.op == SHL {
+			return lhv << uint(rhv)
+		}
+		return lhv >> uint(rhv)
 
 	case EQU:
-		if lhv == rhv {
-			return 1
-		} else {
-			return 0
-		}
+		return lhv == rhv
 
 	case NEQ:
-		if lhv != rhv {
-			return 1
-		} else {
-			return 0
-		}
+		return lhv != rhv
 
 	case LT:
-		if lhv < rhv {
-			return 1
-		} else {
-			return 0
-		}
+		return lhv < rhv
+
 	case LTE:
-		if lhv <= rhv {
-			return 1
-		} else {
-			return 0
-		}
+		return lhv <= rhv
+
 	case GT:
-		if lhv > rhv {
-			return 1
-		} else {
-			return 0
-		}
+		return lhv > rhv
+
 	case GTE:
-		if lhv >= rhv {
-			return 1
-		} else {
-			return 0
-		}
+		return lhv >= rhv
 	}
 
 	return 0
 }
 
+//floatBinOP evaluates float arithmetic and comparisons
+func (interp *interpreter) floatBinOP(node *AstBinOP, lhv, rhv float64) interface{} {
+	switch node.op {
+	case PLUS:
+		return lhv + rhv
+
+	case MINUS:
+		return lhv - rhv
+
+	case MUL:
+		return lhv * rhv
+
+	case DIV:
+		if rhv == 0 {
+			interpPanic("div by zero, line: %d", node.line)
+		}
+		return lhv / rhv
+
+	case POW:
+		return math.Pow(lhv, rhv)
+
+	case EQU:
+		return lhv == rhv
+
+	case NEQ:
+		return lhv != rhv
+
+	case LT:
+		return lhv < rhv
+
+	case LTE:
+		return lhv <= rhv
+
+	case GT:
+		return lhv > rhv
+
+	case GTE:
+		return lhv >= rhv
+	}
+
+	doPanic("unsupported float operator: %s, line: %d", node.op, node.line)
+	return nil
+}
+
 func (interp *interpreter) visitUnaryOP(node *AstUnaryOP) interface{} {
 	var rhs interface{}
 	switch node.dst.(type) {
-	case *AstBinOP, *AstUnaryOP, *AstIntConst, *AstVarNameRef:
+	case *AstBinOP, *AstUnaryOP, *AstIntConst, *AstFloatConst, *AstBoolConst,
+		*AstVarNameRef, *AstIndexedRef, *AstSliceRef, *AstDotRef, *AstFuncCall:
 		rhs = interp.visitAst(node.dst)
 		break
 
@@ -697,6 +1196,23 @@ func (interp *interpreter) visitUnaryOP(node *AstUnaryOP) interface{} {
 		doPanic("error in unaryop dst, unknown ast type: %s, line: %d", node.dst, node.line)
 	}
 
+	if node.op == NOT {
+		return !rhs.(bool)
+	}
+
+	if fVal, ok := rhs.(float64); ok {
+		switch node.op {
+		case PLUS:
+			return fVal
+
+		case MINUS:
+			return -fVal
+
+		default:
+			doPanic("unknown float unary operator: %s, line: %d", node.op, node.line)
+		}
+	}
+
 	intVal := rhs.(int)
 	switch node.op {
 	case PLUS:
@@ -705,13 +1221,6 @@ func (interp *interpreter) visitUnaryOP(node *AstUnaryOP) interface{} {
 	case MINUS:
 		return -intVal
 
-	case NOT:
-		if intVal == 0 {
-			return 1
-		} else {
-			return 0
-		}
-
 	default:
 		doPanic("unknown unary operator: %s, line: %d", node.op, node.line)
 	}
@@ -727,6 +1236,9 @@ func (se *interpreter) visitNewOP(node *AstNewOP) interface{} {
 	case *AstArrayType:
 		return []interface{}{}
 
+	case *AstMapType:
+		return make(map[interface{}]interface{})
+
 	default:
 		doPanic("error type when interpret new op: %s", tp.desc())
 	}
@@ -735,6 +1247,15 @@ func (se *interpreter) visitNewOP(node *AstNewOP) interface{} {
 }
 
 func (interp *interpreter) visitReturn(node *AstReturn) interface{} {
+	//multiple values are returned in a slice
+	if tuple, ok := node.expr.(*AstTuple); ok {
+		vals := []interface{}{}
+		for _, expr := range tuple.exprs {
+			vals = append(vals, interp.visitAst(expr))
+		}
+		return vals
+	}
+
 	if node.expr != nil {
 		return interp.visitAst(node.expr)
 	}
@@ -746,17 +1267,28 @@ func (interp *interpreter) visitIntConst(node *AstIntConst) interface{} {
 	return node.value
 }
 
+func (interp *interpreter) visitFloatConst(node *AstFloatConst) interface{} {
+	return node.value
+}
+
+func (interp *interpreter) visitBoolConst(node *AstBoolConst) interface{} {
+	return node.value
+}
+
 func (interp *interpreter) visitStringConst(node *AstStringConst) interface{} {
 	return node.value
 }
 
+func (interp *interpreter) visitMapLit(node *AstMapLit) interface{} {
+	mv := make(map[interface{}]interface{})
+	for idx := range node.keys {
+		mv[interp.visitAst(node.keys[idx])] = interp.visitAst(node.vals[idx])
+	}
+	return mv
+}
+
 func (interp *interpreter) visitIndexedRef(node *AstIndexedRef) interface{} {
 	idxTp := interp.visitAst(node.index)
-	primTp, ok := idxTp.(int)
-	if !ok {
-		interpPanic("hskl runtime error, nil reference: %s, line: %d", node.host.desc(), node.line)
-		return nil
-	}
 
 	//check host
 	hostTp := interp.visitAst(node.host)
@@ -765,19 +1297,67 @@ func (interp *interpreter) visitIndexedRef(node *AstIndexedRef) interface{} {
 		return nil
 	}
 
+	if mv, ok := hostTp.(map[interface{}]interface{}); ok {
+		val, found := mv[idxTp]
+		if !found {
+			interpPanic("hskl runtime error, map key not found: %v, line: %d", idxTp, node.line)
+		}
+		return val
+	}
+
+	primTp, ok := idxTp.(int)
+	if !ok {
+		interpPanic("hskl runtime error, nil reference: %s, line: %d", node.host.desc(), node.line)
+		return nil
+	}
+
+	if str, ok := hostTp.(string); ok {
+		return interp.runtimeLine(node.line, func() interface{} {
+			return runeIndex(str, primTp)
+		})
+	}
+
 	arrTp, ok := hostTp.([]interface{})
 	if !ok {
-		doPanic("error in indexedRef: %s, host should be array, actual: %T",
-			node.host, arrTp)
+		interpPanic("hskl runtime error, can not index %s of %T, line: %d", node.host.desc(), hostTp, node.line)
 		return nil
 	}
 
-	if primTp < len(arrTp) {
-		return arrTp[primTp]
+	if primTp < 0 || primTp >= len(arrTp) {
+		interpPanic("hskl runtime error, index out of range: %d, len: %d, line: %d", primTp, len(arrTp), node.line)
 	}
-	doPanic("error in indexedRef: %v, index out of bound, index: %d, arr len: %d",
-		primTp, primTp, len(arrTp))
-	return nil
+	return arrTp[primTp]
+}
+
+func (interp *interpreter) visitSliceRef(node *AstSliceRef) interface{} {
+	host := interp.visitAst(node.host)
+	var low, high interface{}
+	if node.low != nil {
+		low = interp.visitAst(node.low)
+	}
+	if node.high != nil {
+		high = interp.visitAst(node.high)
+	}
+
+	return interp.runtimeLine(node.line, func() interface{} {
+		val := sliceValue(host, low, high)
+		interp.alloc(allocOf(val, nil), node.line)
+		return val
+	})
+}
+
+//runtimeLine runs fn, its runtime errors get the line when they have none
+func (interp *interpreter) runtimeLine(line int, fn func() interface{}) interface{} {
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(*interpError); ok && err.line == 0 {
+				err.line = line
+			}
+			panic(r)
+		}
+	}()
+
+	return fn()
 }
 
 func (interp *interpreter) visitDotRef(node *AstDotRef) interface{} {
@@ -798,8 +1378,34 @@ func (interp *interpreter) visitDotRef(node *AstDotRef) interface{} {
 	return mv[node.name]
 }
 
+//lookupRef finds the variable in the frame bound by resolver
+func (interp *interpreter) lookupRef(node *AstVarNameRef) *vari {
+	switch node.scope {
+	case refGlobal:
+		return interp.globalFrame.lookup(node.name, false)
+
+	case refLocal, refEnclosing:
+		frame := interp.curFrame
+		for i := 0; i < node.depth && frame != nil; i++ {
+			frame = frame.upLevel
+		}
+
+		if frame == nil {
+			return nil
+		}
+		return frame.lookup(node.name, false)
+
+	default:
+		return interp.curFrame.lookup(node.name, true)
+	}
+}
+
 func (interp *interpreter) visitVarRef(node *AstVarNameRef) interface{} {
-	sym := interp.curFrame.lookup(node.name, true)
+	if node.fn != nil {
+		return &closure{decl: node.fn, env: interp.globalFrame}
+	}
+
+	sym := interp.lookupRef(node)
 	if sym == nil {
 		doPanic("error in varRef, symbol not found: %s", node.name)
 		return nil
@@ -813,16 +1419,21 @@ func (interp *interpreter) DoInterpret(root AstNode) (result error) {
 		if r := recover(); r != nil {
 			//stack := string(debug.Stack())
 			//desc := r.(error).Error() + "\n" + stack
-			if rte, ok := r.(*interpError); ok {
-				result = errors.New(rte.msg)
-			} else {
-				result = errors.New(r.(error).Error())
+			if r == errDebugQuit {
+				result = errDebugQuit
+				return
+			}
+			if err, ok := limitError(r); ok {
+				result = err
+				return
 			}
+			result = interp.runtimeError(r)
 		}
 	}()
 
 	switch node := root.(type) {
 	case *AstProgram:
+		interp.file = node.file
 		interp.visitProgram(node)
 		break
 
@@ -830,16 +1441,114 @@ func (interp *interpreter) DoInterpret(root AstNode) (result error) {
 		return errors.Errorf("root ast type should be program, actual recv: %T", root)
 	}
 
-	call := &AstFuncCall{}
-	call.ast = interp.mainFunc
-	call.name = entryFunc
-	interp.pushStackFrame()
-	interp.visitFuncCall(call)
-	interp.popStackFrame()
+	if interp.mainFunc == nil {
+		return errors.Errorf("main func is not defined")
+	}
+
+	if interp.ctx != nil {
+		interp.checkDeadline(nil)
+	}
+	interp.callFunc(interp.mainFunc, nil)
 	interp.curFrame.state = Frame_Normal
 	return nil
 }
 
+//runtimeError describes a panic of a running script with the funcs
+//being called, frames are not popped when a panic unwinds the go stack.
+//a func frame runs at the line its callee is called at
+func (interp *interpreter) runtimeError(r interface{}) *RuntimeError {
+	msg, line := panicMessage(r), 0
+	if err, ok := r.(*interpError); ok {
+		msg, line = err.msg, err.line
+	}
+	if line == 0 {
+		msg, line = splitLine(msg)
+	}
+	if line == 0 {
+		line = interp.curLine
+	}
+	return newRuntimeError(msg, line, interp.backtrace())
+}
+
+//backtrace lists the hskl funcs being called, the outermost first. a func
+//runs at the line its callee is called at, the line of the innermost is
+//left to the caller
+func (interp *interpreter) backtrace() []StackFrame {
+	stack := []StackFrame{}
+	for _, frame := range interp.callStack {
+		if frame.fn == nil {
+			continue
+		}
+
+		if len(stack) > 0 {
+			stack[len(stack)-1].Line = frame.callLine
+		}
+		stack = append(stack, StackFrame{Func: funcFullName(frame.fn), File: interp.file})
+	}
+	return stack
+}
+
+//activeFrames gives for each func being called the innermost block frame
+//it runs in, the outermost func first
+func (interp *interpreter) activeFrames() []*stackFrame {
+	frames := []*stackFrame{}
+	for idx, frame := range interp.callStack {
+		if frame.fn == nil {
+			continue
+		}
+
+		if len(frames) > 0 {
+			frames[len(frames)-1] = interp.callStack[idx-1]
+		}
+		frames = append(frames, frame)
+	}
+
+	if len(frames) > 0 {
+		frames[len(frames)-1] = interp.curFrame
+	}
+	return frames
+}
+
+//callDepth is the count of funcs being called
+func (interp *interpreter) callDepth() int {
+	return interp.curFrame.calls
+}
+
+//interpretInput runs one item of an incremental session in the global
+//frame, the value of a bare expression is returned
+func (interp *interpreter) interpretInput(item AstNode) interface{} {
+	switch node := item.(type) {
+	case *AstVarDecl:
+		interp.visitVarDecl(node)
+		break
+
+	case *AstFuncDecl:
+		interp.visitFuncDecl(node)
+		break
+
+	case *AstTypeDef:
+		break
+
+	case *AstAssgin, *AstMultiAssign, *AstConditionBlock, *AstWhileBlock, *AstForBlock, *AstRangeBlock, *AstCodeBlock,
+		*AstTryBlock, *AstThrow:
+		interp.visitCodeBlockStatement(&AstCodeBlock{stat_list: []AstNode{node}})
+		break
+
+	default:
+		return interp.visitAst(node)
+	}
+
+	return nil
+}
+
+//resetInput unwinds the frames left by a failed incremental input
+func (interp *interpreter) resetInput() {
+	interp.callStack = interp.callStack[:1]
+	interp.stackSize = 1
+	interp.curFrame = interp.globalFrame
+	interp.curFrame.state = Frame_Normal
+}
+
 func (interp *interpreter) visitAst(ast AstNode) interface{} {
 	//fmt.Printf("visit ast: %T\n", ast)
 	switch statement := ast.(type) {
@@ -859,6 +1568,15 @@ func (interp *interpreter) visitAst(ast AstNode) interface{} {
 	case *AstUnaryOP:
 		return interp.visitUnaryOP(statement)
 
+	case *AstMapLit:
+		return interp.visitMapLit(statement)
+
+	case *AstFuncLit:
+		return interp.visitFuncLit(statement)
+
+	case *AstIfaceBox:
+		return &ifaceVal{tp: statement.strct, val: interp.visitAst(statement.expr)}
+
 	case *AstNewOP:
 		return interp.visitNewOP(statement)
 
@@ -868,6 +1586,12 @@ func (interp *interpreter) visitAst(ast AstNode) interface{} {
 	case *AstIntConst:
 		return interp.visitIntConst(statement)
 
+	case *AstFloatConst:
+		return interp.visitFloatConst(statement)
+
+	case *AstBoolConst:
+		return interp.visitBoolConst(statement)
+
 	case *AstStringConst:
 		return interp.visitStringConst(statement)
 
@@ -877,26 +1601,14 @@ func (interp *interpreter) visitAst(ast AstNode) interface{} {
 	case *AstIndexedRef:
 		return interp.visitIndexedRef(statement)
 
+	case *AstSliceRef:
+		return interp.visitSliceRef(statement)
+
 	case *AstDotRef:
 		return interp.visitDotRef(statement)
 
 	case *AstFuncCall:
-		//prepare for args
-		args := []interface{}{}
-		for _, argExp := range statement.args {
-			arg := interp.visitAst(argExp)
-			args = append(args, arg)
-		}
-
-		interp.pushStackFrame()
-		for idx, param := range statement.ast.params {
-			interp.curFrame.insertVari(&vari{name: param.name, type_: param.type_, val: args[idx]})
-		}
-
-		ret := interp.visitFuncCall(statement)
-		//return and break not cross func boundary
-		interp.popStackFrame().state = Frame_Normal
-		return ret
+		return interp.visitFuncCall(statement)
 
 	default:
 		doPanic("unknown ast when interpret: %T", ast)
@@ -913,5 +1625,27 @@ func NewInterpreter() *interpreter {
 	inter.callStack = []*stackFrame{symTb}
 	inter.stackSize = 1
 	inter.curFrame = inter.callStack[0]
+	inter.globalFrame = symTb
+	inter.env = &nativeEnv{call: inter.callValue, canAlloc: inter.canAlloc}
 	return inter
 }
+
+//SetStdout sets where print and printn write, nil is the process stdout
+func (interp *interpreter) SetStdout(out io.Writer) {
+	interp.env.stdout = out
+}
+
+//SetStderr sets where eprintn writes, nil is the process stderr
+func (interp *interpreter) SetStderr(out io.Writer) {
+	interp.env.stderr = out
+}
+
+//SetStdin sets what readLine and readAll read, nil is the process stdin
+func (interp *interpreter) SetStdin(in io.Reader) {
+	interp.env.setStdin(in)
+}
+
+//SetSeed fixes the seed of the rng behind rand_int, rand_float and shuffle
+func (interp *interpreter) SetSeed(seed int64) {
+	interp.env.setSeed(seed)
+}
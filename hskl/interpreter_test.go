@@ -1,9 +1,14 @@
 package hskl
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestInterp(t *testing.T) {
@@ -33,3 +38,365 @@ func TestInterp(t *testing.T) {
 	// 	fmt.Printf("%s %s: %v\n", va.name, va.type_, va.val)
 	// }
 }
+
+func TestRuntimeErrorStack(t *testing.T) {
+	src := `type box struct {
+    items: []int
+}
+
+func (b box) at(i: int) int {
+    return b.items[i]
+}
+
+func pick(b: box, i: int) int {
+    get := func(n: int) int {
+        return b.at(n)
+    }
+    return get(i)
+}
+
+func main() {
+    var b: box
+    b.items = append(new([]int), 1)
+    printn("got " + pick(b, 3))
+}`
+
+	want := []StackFrame{
+		{Func: "main", File: "pick.hskl", Line: 19},
+		{Func: "pick", File: "pick.hskl", Line: 13},
+		{Func: "lambda@10", File: "pick.hskl", Line: 11},
+		{Func: "box.at", File: "pick.hskl", Line: 6},
+	}
+
+	for _, engine := range []string{"tree", "vm"} {
+		p := NewParser(src)
+		p.SetFile("pick.hskl")
+		pro := p.Program()
+		if err := NewSemanticAnalyzer().DoAnalyze(pro); err != nil {
+			t.Fatalf("analyze error: %v", err)
+		}
+
+		var err error
+		if engine == "vm" {
+			prog, compErr := NewCompiler().DoCompile(pro)
+			if compErr != nil {
+				t.Fatalf("compile error: %v", compErr)
+			}
+			err = NewBytecodeVM().DoRun(prog)
+		} else {
+			err = NewInterpreter().DoInterpret(pro)
+		}
+
+		rtErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Errorf("%s error is not a RuntimeError: %v", engine, err)
+			continue
+		}
+
+		if rtErr.Line != 6 || !reflect.DeepEqual(rtErr.Stack, want) {
+			t.Errorf("%s error line: %d, stack: %v", engine, rtErr.Line, rtErr.Stack)
+		}
+
+		trace := rtErr.Traceback()
+		if !strings.HasPrefix(trace, "Traceback (most recent call last):\n") ||
+			!strings.Contains(trace, "  File \"pick.hskl\", line 6, in box.at\n") {
+			t.Errorf("%s traceback:\n%s", engine, trace)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	src := `func down(n: int) int {
+    return down(n + 1)
+}
+
+func grow() {
+    var s: string
+    while true {
+        s = s + "abcd"
+    }
+}
+
+func spin() {
+    while true {
+    }
+}
+
+func main() {
+    try {
+        MODE
+    } catch {
+        printn("caught")
+    }
+}`
+
+	cases := []struct {
+		call   string
+		limits Limits
+		check  func(err error) bool
+	}{
+		{"down(0)", Limits{MaxCallDepth: 50}, func(err error) bool {
+			e, ok := err.(*CallDepthError)
+			return ok && e.Max == 50 && e.Line == 2
+		}},
+		{"spin()", Limits{MaxSteps: 1000}, func(err error) bool {
+			e, ok := err.(*StepLimitError)
+			return ok && e.Max == 1000 && e.Line == 13
+		}},
+		{"grow()", Limits{MaxAlloc: 4096}, func(err error) bool {
+			e, ok := err.(*AllocLimitError)
+			return ok && e.Line == 8
+		}},
+		{"fill(1000000000, 0)", Limits{MaxAlloc: 100000}, func(err error) bool {
+			e, ok := err.(*AllocLimitError)
+			return ok && e.Max == 100000 && e.Line == 19
+		}},
+		{`repeat("ab", 1000000000)`, Limits{MaxAlloc: 100000}, func(err error) bool {
+			e, ok := err.(*AllocLimitError)
+			return ok && e.Max == 100000 && e.Line == 19
+		}},
+		{"spin()", Limits{}, func(err error) bool {
+			e, ok := err.(*DeadlineError)
+			return ok && e.Err == context.DeadlineExceeded
+		}},
+	}
+
+	for _, engine := range []string{"tree", "vm"} {
+		for _, c := range cases {
+			p := NewParser(strings.Replace(src, "MODE", c.call, 1))
+			pro := p.Program()
+			if err := NewSemanticAnalyzer().DoAnalyze(pro); err != nil {
+				t.Fatalf("analyze error: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			var err error
+			stdout := &bytes.Buffer{}
+			if engine == "vm" {
+				prog, compErr := NewCompiler().DoCompile(pro)
+				if compErr != nil {
+					t.Fatalf("compile error: %v", compErr)
+				}
+				vm := NewBytecodeVM()
+				vm.SetLimits(c.limits)
+				vm.SetStdout(stdout)
+				err = vm.DoRunContext(ctx, prog)
+			} else {
+				interp := NewInterpreter()
+				interp.SetLimits(c.limits)
+				interp.SetStdout(stdout)
+				err = interp.DoInterpretContext(ctx, pro)
+			}
+			cancel()
+
+			if !c.check(err) {
+				t.Errorf("%s %s with %+v: %T %v", engine, c.call, c.limits, err, err)
+			}
+			if out := stdout.String(); out != "" {
+				t.Errorf("%s %s: limit should not be caught, output: %q", engine, c.call, out)
+			}
+		}
+	}
+}
+
+func TestStdio(t *testing.T) {
+	src := `func main() {
+    var n: int
+    line, ok := readLine()
+    while ok {
+        print("> " + line + "\n")
+        n = n + 1
+        line, ok = readLine()
+    }
+    eprintn("lines: " + str(n))
+    printn("rest: " + readAll())
+}`
+
+	for _, engine := range []string{"tree", "vm"} {
+		pro := NewParser(src).Program()
+		if err := NewSemanticAnalyzer().DoAnalyze(pro); err != nil {
+			t.Fatalf("analyze error: %v", err)
+		}
+
+		stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+		stdin := strings.NewReader("a\r\n\nb")
+		var err error
+		if engine == "vm" {
+			prog, compErr := NewCompiler().DoCompile(pro)
+			if compErr != nil {
+				t.Fatalf("compile error: %v", compErr)
+			}
+			vm := NewBytecodeVM()
+			vm.SetStdout(stdout)
+			vm.SetStderr(stderr)
+			vm.SetStdin(stdin)
+			err = vm.DoRun(prog)
+		} else {
+			interp := NewInterpreter()
+			interp.SetStdout(stdout)
+			interp.SetStderr(stderr)
+			interp.SetStdin(stdin)
+			err = interp.DoInterpret(pro)
+		}
+
+		if err != nil {
+			t.Errorf("%s run error: %v", engine, err)
+		}
+		if got := stdout.String(); got != "> a\n> \n> b\nrest: \n" {
+			t.Errorf("%s stdout: %q", engine, got)
+		}
+		if got := stderr.String(); got != "lines: 3\n" {
+			t.Errorf("%s stderr: %q", engine, got)
+		}
+	}
+}
+
+//runSource runs src on the engine, giving what it prints
+func runSource(t *testing.T, engine string, src string) (string, error) {
+	p := NewParser(src)
+	pro := p.Program()
+	if diags := p.Diagnostics(); len(diags) > 0 {
+		t.Fatalf("parse error: %v", diags[0])
+	}
+	if err := NewSemanticAnalyzer().DoAnalyze(pro); err != nil {
+		t.Fatalf("analyze error: %v", err)
+	}
+
+	stdout := &bytes.Buffer{}
+	if engine == "vm" {
+		prog, err := NewCompiler().DoCompile(pro)
+		if err != nil {
+			t.Fatalf("compile error: %v", err)
+		}
+		vm := NewBytecodeVM()
+		vm.SetStdout(stdout)
+		err = vm.DoRun(prog)
+		return stdout.String(), err
+	}
+
+	interp := NewInterpreter()
+	interp.SetStdout(stdout)
+	err := interp.DoInterpret(pro)
+	return stdout.String(), err
+}
+
+func TestAppendIface(t *testing.T) {
+	src := `type Shape interface {
+    area() int
+}
+
+type rect struct {
+    w, h: int
+}
+
+func (r rect) area() int {
+    return r.w * r.h
+}
+
+func main() {
+    var r: rect
+    var shapes: []Shape
+    r.w = 2
+    r.h = 3
+    shapes = append(shapes, r)
+    shapes = insert(shapes, 0, r)
+    printn("%d %d", shapes[0].area(), shapes[1].area())
+}`
+
+	for _, engine := range []string{"tree", "vm"} {
+		out, err := runSource(t, engine, src)
+		if err != nil {
+			t.Errorf("%s run error: %v", engine, err)
+		}
+		if out != "6 6\n" {
+			t.Errorf("%s output: %q", engine, out)
+		}
+	}
+}
+
+func TestLoopVarPerIteration(t *testing.T) {
+	src := `func main() {
+    var fs: []func() int
+    var nums: []int
+    nums = append(nums, 3)
+    nums = append(nums, 4)
+    for i := 0; i < 3; i = i + 1 {
+        fs = append(fs, func() int {
+            return i
+        })
+    }
+    for _, v := range nums {
+        fs = append(fs, func() int {
+            return v
+        })
+    }
+    for _, f := range fs {
+        print("%d ", f())
+    }
+}`
+
+	for _, engine := range []string{"tree", "vm"} {
+		out, err := runSource(t, engine, src)
+		if err != nil {
+			t.Errorf("%s run error: %v", engine, err)
+		}
+		if out != "0 1 2 3 4 " {
+			t.Errorf("%s output: %q", engine, out)
+		}
+	}
+}
+
+func TestRangeString(t *testing.T) {
+	src := `func main() {
+    s := "héllo"
+    for i, c := range s {
+        print("%d%s%v ", i, c, s[i] == c)
+    }
+}`
+
+	for _, engine := range []string{"tree", "vm"} {
+		out, err := runSource(t, engine, src)
+		if err != nil {
+			t.Errorf("%s run error: %v", engine, err)
+		}
+		if out != "0htrue 1étrue 2ltrue 3ltrue 4otrue " {
+			t.Errorf("%s output: %q", engine, out)
+		}
+	}
+}
+
+func TestShadowBuiltin(t *testing.T) {
+	src := `var abs: int
+
+func max(a: []int) int {
+    var m: int
+    m = a[0]
+    for _, v := range a {
+        if v > m {
+            m = v
+        }
+    }
+    return m
+}
+
+func str(n: int) string {
+    return "bad"
+}
+
+func main() {
+    nums := []int{3, 9, 4}
+    abs = 7
+    print("%d %d %d ", max(nums), abs, min(2, 1))
+    print("n=" + 5)
+}`
+
+	for _, engine := range []string{"tree", "vm"} {
+		out, err := runSource(t, engine, src)
+		if err != nil {
+			t.Errorf("%s run error: %v", engine, err)
+		}
+		if out != "9 7 1 n=5" {
+			t.Errorf("%s output: %q", engine, out)
+		}
+	}
+}
@@ -2,6 +2,8 @@ package hskl
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
 	"unicode"
 
 	"github.com/pkg/errors"
@@ -11,10 +13,14 @@ import (
 const (
 	//const
 	INT_CONST    = "INT_CONST"
+	FLOAT_CONST  = "FLOAT_CONST"
+	BOOL_CONST   = "BOOL_CONST"
 	STRING_CONST = "STRING_CONST"
 
 	//primitive type
 	TYPE_INT    = "INT"
+	TYPE_FLOAT  = "FLOAT"
+	TYPE_BOOL   = "BOOL"
 	TYPE_STRING = "STRING"
 	TYPE_ANY    = "ANY"
 
@@ -32,10 +38,12 @@ const (
 	LF       = "LF"       //"\n"
 	TYPE     = "TYPE"
 	STRUCT   = "STRUCT"
+	IFACE    = "INTERFACE"
 	QUOTE2   = "\""
 
 	//operator
 	NEW = "NEW"
+	MAP = "MAP"
 
 	OR = "OR" //"||"
 
@@ -54,6 +62,14 @@ const (
 
 	MUL = "MUL" //"*"
 	DIV = "DIV" //"/"
+	MOD = "MOD" //"%"
+	POW = "POW" //"**"
+
+	BIT_AND = "BIT_AND" //"&"
+	BIT_OR  = "BIT_OR"  //"|"
+	BIT_XOR = "BIT_XOR" //"^"
+	SHL     = "SHL"     //"<<"
+	SHR     = "SHR"     //">>"
 
 	NOT = "NOT" //"!"
 
@@ -67,31 +83,52 @@ const (
 	RETURN     = "RETURN"
 
 	//flow control
-	IF    = "IF"
-	ELIF  = "ELIF"
-	ELSE  = "ELSE"
-	WHILE = "WHILE"
-	BREAK = "BREAK"
+	IF       = "IF"
+	ELIF     = "ELIF"
+	ELSE     = "ELSE"
+	WHILE    = "WHILE"
+	BREAK    = "BREAK"
+	FOR      = "FOR"
+	RANGE    = "RANGE"
+	CONTINUE = "CONTINUE"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	THROW    = "THROW"
+
+	//comment, only kept in trivia mode
+	COMMENT = "COMMENT"
 
 	//EOF
 	EOF = "EOF"
 )
 
 var keywords = map[string]string{"func": FUNC,
-	"var":    VAR,
-	"int":    TYPE_INT,
-	"None":   NONE,
-	"string": TYPE_STRING,
-	"return": RETURN,
-	"any":    TYPE_ANY,
-	"type":   TYPE,
-	"struct": STRUCT,
-	"new":    NEW,
-	"if":     IF,
-	"elif":   ELIF,
-	"else":   ELSE,
-	"while":  WHILE,
-	"break":  BREAK}
+	"var":       VAR,
+	"int":       TYPE_INT,
+	"float":     TYPE_FLOAT,
+	"bool":      TYPE_BOOL,
+	"true":      BOOL_CONST,
+	"false":     BOOL_CONST,
+	"None":      NONE,
+	"string":    TYPE_STRING,
+	"return":    RETURN,
+	"any":       TYPE_ANY,
+	"type":      TYPE,
+	"struct":    STRUCT,
+	"interface": IFACE,
+	"new":       NEW,
+	"map":       MAP,
+	"if":        IF,
+	"elif":      ELIF,
+	"else":      ELSE,
+	"while":     WHILE,
+	"for":       FOR,
+	"range":     RANGE,
+	"continue":  CONTINUE,
+	"try":       TRY,
+	"catch":     CATCH,
+	"throw":     THROW,
+	"break":     BREAK}
 
 type Token struct {
 	type_  string
@@ -112,6 +149,12 @@ type hskLexer struct {
 	lineNo    int
 	colNo     int
 	lastError error
+	diags     Diagnostics
+
+	//trivia keeps comments in comments instead of dropping them, the
+	//formatter needs them
+	trivia   bool
+	comments []*Token
 }
 
 func (lex *hskLexer) advanceBy(cnt int) {
@@ -140,11 +183,11 @@ func (lex *hskLexer) advance() {
 }
 
 func (lex *hskLexer) skipComment(mult bool) {
-	line := lex.lineNo
+	line, col := lex.lineNo, lex.colNo
 	for lex.pos < lex.posMax {
 		if lex.curChar == '*' && lex.peekChar(1) == '/' {
 			lex.advanceBy(2)
-			break
+			return
 		}
 		lex.advance()
 
@@ -152,6 +195,10 @@ func (lex *hskLexer) skipComment(mult bool) {
 			return
 		}
 	}
+
+	if mult {
+		lex.lexerError(diagUnclosedComment, line, col, 2, "comment is not closed")
+	}
 }
 
 func (lex *hskLexer) peekChar(idx int) rune {
@@ -163,8 +210,12 @@ func (lex *hskLexer) peekChar(idx int) rune {
 	}
 }
 
-func (lex *hskLexer) lexerError(desc string) {
-
+//lexerError records a bad input at line:col, the lexer skips it and goes on
+//so the parser sees as many real errors as possible
+func (lex *hskLexer) lexerError(code string, line, col, span int, format string, args ...interface{}) {
+	desc := fmt.Sprintf(format, args...)
+	lex.lastError = errors.Errorf("%s, line: %d", desc, line)
+	lex.diags = append(lex.diags, newDiagnostic(code, "", desc, line, col, span))
 }
 
 func (lex *hskLexer) getInteger() string {
@@ -177,6 +228,38 @@ func (lex *hskLexer) getInteger() string {
 	return string(numDigits)
 }
 
+//getNumber reads an int or float literal: 12, 3.14, 1e-9, 2.5E+3
+func (lex *hskLexer) getNumber() *Token {
+	tok := &Token{type_: INT_CONST, line: lex.lineNo, column: lex.colNo}
+	val := lex.getInteger()
+
+	if lex.curChar == '.' && unicode.IsDigit(lex.peekChar(1)) {
+		lex.advance()
+		val += "." + lex.getInteger()
+		tok.type_ = FLOAT_CONST
+	}
+
+	if lex.curChar == 'e' || lex.curChar == 'E' {
+		sign := lex.peekChar(1)
+		if unicode.IsDigit(sign) {
+			lex.advance()
+			val += "e" + lex.getInteger()
+			tok.type_ = FLOAT_CONST
+		} else if (sign == '+' || sign == '-') && unicode.IsDigit(lex.peekChar(2)) {
+			lex.advanceBy(2)
+			val += "e" + string(sign) + lex.getInteger()
+			tok.type_ = FLOAT_CONST
+		}
+	}
+
+	if _, err := strconv.Atoi(val); tok.type_ == INT_CONST && err != nil {
+		lex.lexerError(diagIntRange, tok.line, tok.column, len(val), "int literal out of range: %s", val)
+	}
+
+	tok.value = val
+	return tok
+}
+
 func (lex *hskLexer) escapedChar(escaped rune) rune {
 	switch escaped {
 	//case 'u':
@@ -201,10 +284,16 @@ func (lex *hskLexer) escapedChar(escaped rune) rune {
 }
 
 func (lex *hskLexer) getString() string {
+	line, col := lex.lineNo, lex.colNo
 	lex.advance()
 
 	var val []rune
 	for lex.curChar != '"' {
+		if lex.pos >= lex.posMax {
+			lex.lexerError(diagUnclosedString, line, col, 1, "string is not closed")
+			return string(val)
+		}
+
 		switch lex.curChar {
 		case '\\':
 			lex.advance()
@@ -277,11 +366,10 @@ func (lex *hskLexer) getNextToken() (tok *Token) {
 		col := lex.colNo
 		line := lex.lineNo
 		if unicode.IsDigit(lex.curChar) {
-			val := lex.getInteger()
-			return &Token{type_: INT_CONST, value: val, line: line, column: col}
+			return lex.getNumber()
 		}
 
-		if unicode.IsLetter(lex.curChar) {
+		if unicode.IsLetter(lex.curChar) || lex.curChar == '_' {
 			return lex.getId()
 		}
 
@@ -302,7 +390,23 @@ func (lex *hskLexer) getNextToken() (tok *Token) {
 			return token
 
 		case '*':
-			token := &Token{MUL, string(lex.curChar), lex.lineNo, lex.colNo}
+			var token *Token
+			if lex.peekChar(1) == '*' {
+				token = &Token{POW, "**", lex.lineNo, lex.colNo}
+				lex.advanceBy(2)
+			} else {
+				token = &Token{MUL, "*", lex.lineNo, lex.colNo}
+				lex.advanceBy(1)
+			}
+			return token
+
+		case '%':
+			token := &Token{MOD, string(lex.curChar), lex.lineNo, lex.colNo}
+			lex.advance()
+			return token
+
+		case '^':
+			token := &Token{BIT_XOR, string(lex.curChar), lex.lineNo, lex.colNo}
 			lex.advance()
 			return token
 
@@ -310,7 +414,12 @@ func (lex *hskLexer) getNextToken() (tok *Token) {
 			var token *Token
 			nextChar := lex.peekChar(1)
 			if nextChar == '*' || nextChar == '/' {
+				start := lex.pos
 				lex.skipComment(nextChar == '*')
+				if lex.trivia {
+					text := strings.TrimRight(string(lex.text[start:lex.pos]), " \t\r\n")
+					lex.comments = append(lex.comments, &Token{COMMENT, text, line, col})
+				}
 				continue
 			}
 			token = &Token{DIV, string(lex.curChar), lex.lineNo, lex.colNo}
@@ -360,8 +469,8 @@ func (lex *hskLexer) getNextToken() (tok *Token) {
 				token = &Token{AND, "&&", lex.lineNo, lex.colNo}
 				lex.advanceBy(2)
 			} else {
-				token = nil
-				lex.lastError = errors.Errorf("unkonwn sequence: %s", string([]rune{lex.curChar, nextChar}))
+				token = &Token{BIT_AND, "&", lex.lineNo, lex.colNo}
+				lex.advanceBy(1)
 			}
 			return token
 
@@ -372,8 +481,8 @@ func (lex *hskLexer) getNextToken() (tok *Token) {
 				token = &Token{OR, "||", lex.lineNo, lex.colNo}
 				lex.advanceBy(2)
 			} else {
-				token = nil
-				lex.lastError = errors.Errorf("unkonwn sequence: %s", string([]rune{lex.curChar, nextChar}))
+				token = &Token{BIT_OR, "|", lex.lineNo, lex.colNo}
+				lex.advanceBy(1)
 			}
 			return token
 
@@ -383,6 +492,9 @@ func (lex *hskLexer) getNextToken() (tok *Token) {
 			if nextChar == '=' {
 				token = &Token{LTE, "<=", lex.lineNo, lex.colNo}
 				lex.advanceBy(2)
+			} else if nextChar == '<' {
+				token = &Token{SHL, "<<", lex.lineNo, lex.colNo}
+				lex.advanceBy(2)
 			} else {
 				token = &Token{LT, "<", lex.lineNo, lex.colNo}
 				lex.advanceBy(1)
@@ -395,8 +507,11 @@ func (lex *hskLexer) getNextToken() (tok *Token) {
 			if nextChar == '=' {
 				token = &Token{GTE, ">=", lex.lineNo, lex.colNo}
 				lex.advanceBy(2)
+			} else if nextChar == '>' {
+				token = &Token{SHR, ">>", lex.lineNo, lex.colNo}
+				lex.advanceBy(2)
 			} else {
-				token = &Token{GT, "<", lex.lineNo, lex.colNo}
+				token = &Token{GT, ">", lex.lineNo, lex.colNo}
 				lex.advanceBy(1)
 			}
 			return token
@@ -452,8 +567,9 @@ func (lex *hskLexer) getNextToken() (tok *Token) {
 			return token
 
 		default:
-			lex.lastError = errors.Errorf("unknown char \"%s\": %x at line: %d: %d", string(lex.curChar), lex.curChar, lex.lineNo, lex.colNo)
-			return nil
+			lex.lexerError(diagUnknownChar, lex.lineNo, lex.colNo, 1, "unknown char \"%s\": %x", string(lex.curChar), lex.curChar)
+			lex.advance()
+			continue
 		}
 	}
 }
@@ -464,6 +580,7 @@ func newLexer(text string) *hskLexer {
 	lex.posMax = len(lex.text)
 	if lex.posMax > 0 {
 		lex.lineNo = 1
+		lex.colNo = 1
 		lex.curChar = lex.text[0]
 	}
 	return lex
@@ -25,3 +25,75 @@ func TestLexer(t *testing.T) {
 		}
 	}
 }
+
+func TestLexNumber(t *testing.T) {
+	cases := map[string][]string{
+		"12":        {INT_CONST, "12"},
+		"3.14":      {FLOAT_CONST, "3.14"},
+		"1e-9":      {FLOAT_CONST, "1e-9"},
+		"2.5E+3":    {FLOAT_CONST, "2.5e+3"},
+		"7e":        {INT_CONST, "7"},
+		"arr.field": {ID, "arr"},
+	}
+
+	for text, want := range cases {
+		token := newLexer(text).getNextToken()
+		if token.type_ != want[0] || token.value != want[1] {
+			t.Errorf("lex %q = %v, want: %s %s", text, token, want[0], want[1])
+		}
+	}
+
+	lex := newLexer("1.x")
+	for _, want := range []string{INT_CONST, DOT, ID} {
+		if token := lex.getNextToken(); token.type_ != want {
+			t.Errorf("lex \"1.x\" got %v, want: %s", token, want)
+		}
+	}
+}
+
+func TestLexOperators(t *testing.T) {
+	lex := newLexer("a ** b * c % d & e | f ^ g << h >> i && j || k > l")
+	want := []string{ID, POW, ID, MUL, ID, MOD, ID, BIT_AND, ID, BIT_OR, ID, BIT_XOR, ID,
+		SHL, ID, SHR, ID, AND, ID, OR, ID, GT, ID, EOF}
+	for _, tp := range want {
+		if token := lex.getNextToken(); token.type_ != tp {
+			t.Errorf("lex operators got %v, want: %s", token, tp)
+		}
+	}
+}
+
+func TestLexPosition(t *testing.T) {
+	lex := newLexer("var a: int\n  b = \"x\" + 12")
+	want := [][2]int{{1, 1}, {1, 5}, {1, 6}, {1, 8}, {2, 3}, {2, 5}, {2, 7}, {2, 11}, {2, 13}}
+	for _, pos := range want {
+		token := lex.getNextToken()
+		if token.line != pos[0] || token.column != pos[1] {
+			t.Errorf("token %v, want pos: %d:%d", token, pos[0], pos[1])
+		}
+	}
+}
+
+func TestLexDiagnostics(t *testing.T) {
+	cases := map[string][3]interface{}{
+		"a = 1 $ 2":                      {diagUnknownChar, 1, 7},
+		"a = 1\n  b = 2 @ 3":             {diagUnknownChar, 2, 9},
+		"s = \"abc":                      {diagUnclosedString, 1, 5},
+		"a = 1 /* b\n":                   {diagUnclosedComment, 1, 7},
+		"a = 99999999999999999999 + 1.5": {diagIntRange, 1, 5},
+	}
+
+	for src, want := range cases {
+		lex := newLexer(src)
+		for token := lex.getNextToken(); token.type_ != EOF; token = lex.getNextToken() {
+		}
+
+		if len(lex.diags) != 1 {
+			t.Errorf("lex %q, diagnostics: %v", src, lex.diags)
+			continue
+		}
+		d := lex.diags[0]
+		if d.Code != want[0] || d.Line != want[1] || d.Column != want[2] {
+			t.Errorf("lex %q, diagnostic: %v, want: %v", src, d, want)
+		}
+	}
+}
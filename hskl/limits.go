@@ -0,0 +1,208 @@
+package hskl
+
+import (
+	"context"
+	"fmt"
+)
+
+//the deadline is looked at once in this many steps
+const deadlineCheckSteps = 256
+
+//Limits bounds what a script may use when it runs, a zero field means no
+//limit. a step is a statement or a round of a loop, the allocated size
+//is the total count of array elements and string bytes the script made
+type Limits struct {
+	MaxSteps     int
+	MaxCallDepth int
+	MaxAlloc     int
+}
+
+//StepLimitError stops a script that ran more steps than allowed
+type StepLimitError struct {
+	Max  int
+	Line int
+}
+
+func (err *StepLimitError) Error() string {
+	return fmt.Sprintf("hskl limit error, more than %d steps run%s%d", err.Max, lineSuffix, err.Line)
+}
+
+//CallDepthError stops a script that nests more func calls than allowed
+type CallDepthError struct {
+	Max  int
+	Line int
+}
+
+func (err *CallDepthError) Error() string {
+	return fmt.Sprintf("hskl limit error, call depth exceeds %d%s%d", err.Max, lineSuffix, err.Line)
+}
+
+//AllocLimitError stops a script that allocated more than allowed
+type AllocLimitError struct {
+	Max  int
+	Line int
+}
+
+func (err *AllocLimitError) Error() string {
+	return fmt.Sprintf("hskl limit error, allocated size exceeds %d%s%d", err.Max, lineSuffix, err.Line)
+}
+
+//DeadlineError stops a script whose context is done, Err is the error of
+//the context
+type DeadlineError struct {
+	Err  error
+	Line int
+}
+
+func (err *DeadlineError) Error() string {
+	return fmt.Sprintf("hskl limit error, %s%s%d", err.Err, lineSuffix, err.Line)
+}
+
+//limitError tells the panic of an exceeded limit, it is not a runtime
+//error so try/catch of the script does not stop it
+func limitError(r interface{}) (error, bool) {
+	switch err := r.(type) {
+	case *StepLimitError:
+		return err, true
+	case *CallDepthError:
+		return err, true
+	case *AllocLimitError:
+		return err, true
+	case *DeadlineError:
+		return err, true
+	}
+	return nil, false
+}
+
+//SetLimits sets the limits of the scripts run later, the counts start
+//again from zero
+func (interp *interpreter) SetLimits(limits Limits) {
+	interp.limits = limits
+	interp.steps, interp.allocated = 0, 0
+}
+
+//step counts a statement or a loop round of node
+func (interp *interpreter) step(node AstNode) {
+	line, _ := astPos(node)
+	if line > 0 {
+		interp.curLine = line
+	}
+
+	interp.steps++
+	if max := interp.limits.MaxSteps; max > 0 && interp.steps > max {
+		panic(&StepLimitError{Max: max, Line: line})
+	}
+
+	if interp.ctx != nil && interp.steps%deadlineCheckSteps == 0 {
+		interp.checkDeadline(node)
+	}
+}
+
+func (interp *interpreter) checkDeadline(node AstNode) {
+	select {
+	case <-interp.ctx.Done():
+		line := 0
+		if node != nil {
+			line, _ = astPos(node)
+		}
+		panic(&DeadlineError{Err: interp.ctx.Err(), Line: line})
+
+	default:
+	}
+}
+
+//alloc counts size more array elements or string bytes made at line
+func (interp *interpreter) alloc(size, line int) {
+	interp.allocated += size
+	if max := interp.limits.MaxAlloc; max > 0 && interp.allocated > max {
+		panic(&AllocLimitError{Max: max, Line: line})
+	}
+}
+
+//canAlloc panics when size more would exceed the alloc limit, nothing
+//is counted
+func (interp *interpreter) canAlloc(size int) {
+	if max := interp.limits.MaxAlloc; max > 0 && size > max-interp.allocated {
+		panic(&AllocLimitError{Max: max, Line: interp.curLine})
+	}
+}
+
+//allocOf is the size a builtin made, an array grown from its first arg
+//counts only the new elements
+func allocOf(ret interface{}, args []interface{}) int {
+	switch tRet := ret.(type) {
+	case string:
+		return len(tRet)
+
+	case []interface{}:
+		if len(args) > 0 {
+			if arr, ok := args[0].([]interface{}); ok && len(tRet) >= len(arr) {
+				return len(tRet) - len(arr)
+			}
+		}
+		return len(tRet)
+	}
+	return 0
+}
+
+//DoInterpretContext runs the program until main returns or ctx is done
+func (interp *interpreter) DoInterpretContext(ctx context.Context, root AstNode) error {
+	interp.ctx = ctx
+	defer func() {
+		interp.ctx = nil
+	}()
+	return interp.DoInterpret(root)
+}
+
+//SetLimits sets the limits of the programs run later, the counts start
+//again from zero. a step of the vm is a statement or a jump back to the
+//start of a loop, close to what the tree walker counts
+func (vm *bytecodeVM) SetLimits(limits Limits) {
+	vm.limits = limits
+	vm.steps, vm.allocated = 0, 0
+}
+
+func (vm *bytecodeVM) step() {
+	vm.steps++
+	if max := vm.limits.MaxSteps; max > 0 && vm.steps > max {
+		panic(&StepLimitError{Max: max, Line: vm.curLine()})
+	}
+
+	if vm.ctx != nil && vm.steps%deadlineCheckSteps == 0 {
+		vm.checkDeadline()
+	}
+}
+
+func (vm *bytecodeVM) checkDeadline() {
+	select {
+	case <-vm.ctx.Done():
+		panic(&DeadlineError{Err: vm.ctx.Err(), Line: vm.curLine()})
+
+	default:
+	}
+}
+
+//alloc counts size more array elements or string bytes made
+func (vm *bytecodeVM) alloc(size int) {
+	vm.allocated += size
+	if max := vm.limits.MaxAlloc; max > 0 && vm.allocated > max {
+		panic(&AllocLimitError{Max: max, Line: vm.curLine()})
+	}
+}
+
+//canAlloc panics when size more would exceed the alloc limit, nothing
+//is counted
+func (vm *bytecodeVM) canAlloc(size int) {
+	if max := vm.limits.MaxAlloc; max > 0 && size > max-vm.allocated {
+		panic(&AllocLimitError{Max: max, Line: vm.curLine()})
+	}
+}
+
+//DoRunContext runs the program until main returns or ctx is done
+func (vm *bytecodeVM) DoRunContext(ctx context.Context, prog *bcProgram) error {
+	vm.ctx = ctx
+	defer func() {
+		vm.ctx = nil
+	}()
+	return vm.DoRun(prog)
+}
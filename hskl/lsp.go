@@ -0,0 +1,501 @@
+package hskl
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+//json-rpc error codes used by the language server
+const (
+	rpcMethodNotFound = -32601
+	rpcInternalError  = -32603
+)
+
+//lsp completion item kinds
+const (
+	lspKindMethod   = 2
+	lspKindFunction = 3
+	lspKindField    = 5
+	lspKindVariable = 6
+)
+
+type rpcMessage struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	Params json.RawMessage  `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+//lspPosition is 0 based, character counts utf-16 units of the line
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspLocation struct {
+	URI   string   `json:"uri"`
+	Range lspRange `json:"range"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+}
+
+type lspCompletion struct {
+	Label  string `json:"label"`
+	Kind   int    `json:"kind"`
+	Detail string `json:"detail"`
+}
+
+type lspDocParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+	Position lspPosition `json:"position"`
+	Context  struct {
+		IncludeDeclaration bool `json:"includeDeclaration"`
+	} `json:"context"`
+}
+
+//lspDoc is an open document and what the analyzer found in it
+type lspDoc struct {
+	uri   string
+	lines []string
+	pro   *AstProgram
+	diags Diagnostics
+	index *symbolIndex
+}
+
+//analyzeDoc parses and checks text, a broken program is still indexed as
+//far as the parser got
+func analyzeDoc(uri, text string) *lspDoc {
+	doc := &lspDoc{uri: uri, lines: strings.Split(text, "\n"), index: newSymbolIndex()}
+	file := strings.TrimPrefix(uri, "file://")
+
+	err := func() (result error) {
+		defer func() {
+			if r := recover(); r != nil {
+				result = errors.Errorf("%s", panicMessage(r))
+			}
+		}()
+
+		p := NewParser(text)
+		p.SetFile(file)
+		doc.pro = p.Program().(*AstProgram)
+		doc.diags = p.Diagnostics()
+
+		se := NewSemanticAnalyzer()
+		se.index = doc.index
+		result = se.analyze(doc.pro, true)
+		doc.index.markGlobals(doc.pro)
+		return result
+	}()
+
+	//errors of a broken program are mostly echoes of the syntax errors
+	if len(doc.diags) > 0 {
+		return doc
+	}
+	if diags, ok := err.(Diagnostics); ok {
+		doc.diags = diags
+	} else if err != nil {
+		doc.diags = Diagnostics{newDiagnostic(diagSemantic, file, err.Error(), 0, 0, 0)}
+	}
+	return doc
+}
+
+func (doc *lspDoc) line(n int) []rune {
+	if n < 1 || n > len(doc.lines) {
+		return nil
+	}
+	return []rune(strings.TrimRight(doc.lines[n-1], "\r"))
+}
+
+//toPos converts a 1 based line and rune column to an lsp position
+func (doc *lspDoc) toPos(line, col int) lspPosition {
+	if line < 1 {
+		return lspPosition{}
+	}
+
+	char := 0
+	for idx, r := range doc.line(line) {
+		if idx >= col-1 {
+			break
+		}
+		char++
+		if r >= 0x10000 {
+			char++
+		}
+	}
+	return lspPosition{Line: line - 1, Character: char}
+}
+
+//fromPos converts an lsp position to a 1 based line and rune column
+func (doc *lspDoc) fromPos(pos lspPosition) (int, int) {
+	col, units := 1, 0
+	for _, r := range doc.line(pos.Line + 1) {
+		if units >= pos.Character {
+			break
+		}
+		units++
+		if r >= 0x10000 {
+			units++
+		}
+		col++
+	}
+	return pos.Line + 1, col
+}
+
+func (doc *lspDoc) rangeOf(line, col, span int) lspRange {
+	return lspRange{Start: doc.toPos(line, col), End: doc.toPos(line, col+span)}
+}
+
+func (doc *lspDoc) diagnostics() []lspDiagnostic {
+	out := []lspDiagnostic{}
+	for _, d := range doc.diags {
+		rg := doc.rangeOf(d.Line, d.Column, d.Span)
+		if d.Column == 0 {
+			//no column, mark the whole line
+			rg = doc.rangeOf(d.Line, 1, len(doc.line(d.Line)))
+		}
+
+		severity := 1
+		if d.Severity == SeverityWarning {
+			severity = 2
+		}
+		out = append(out, lspDiagnostic{Range: rg, Severity: severity, Code: d.Code, Source: "hskl", Message: d.Message})
+	}
+	return out
+}
+
+func (doc *lspDoc) location(ref *symbolRef) lspLocation {
+	return lspLocation{URI: doc.uri, Range: doc.rangeOf(ref.line, ref.col, ref.span)}
+}
+
+//hostType gives the type of the dotted names before col, like a.b in
+//"a.b.", nil when it can not be told
+func (doc *lspDoc) hostType(line, col int) AstType {
+	text := doc.line(line)
+	if col-1 > len(text) {
+		return nil
+	}
+	text = text[:col-1]
+
+	//skip the name being typed
+	end := len(text)
+	for end > 0 && isIdentRune(text[end-1]) {
+		end--
+	}
+	if end == 0 || text[end-1] != '.' {
+		return nil
+	}
+
+	start := end
+	for start > 0 && (isIdentRune(text[start-1]) || text[start-1] == '.') {
+		start--
+	}
+	names := strings.Split(string(text[start:end-1]), ".")
+
+	def := doc.index.visible(doc.pro, line)[names[0]]
+	if def == nil {
+		return nil
+	}
+	decl, ok := def.decl.(*AstVarDecl)
+	if !ok || decl.type_ == nil {
+		return nil
+	}
+
+	tp := realType(decl.type_)
+	for _, name := range names[1:] {
+		strct, ok := tp.(*AstStructType)
+		if !ok {
+			return nil
+		}
+
+		var next AstType
+		for _, field := range strct.fields {
+			if field.name == name {
+				next = realType(field.type_)
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		tp = next
+	}
+	return tp
+}
+
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+//complete gives fields and methods after a dot, else the names visible
+//at the position and the builtins
+func (doc *lspDoc) complete(line, col int) []lspCompletion {
+	items := []lspCompletion{}
+	if doc.pro == nil {
+		return items
+	}
+
+	if tp := doc.hostType(line, col); tp != nil {
+		if strct, ok := tp.(*AstStructType); ok {
+			for _, field := range strct.fields {
+				items = append(items, lspCompletion{Label: field.name, Kind: lspKindField, Detail: field.type_.desc()})
+			}
+		}
+		for name, method := range methodSet(tp) {
+			items = append(items, lspCompletion{Label: name, Kind: lspKindMethod, Detail: (&symbolDef{decl: method}).hover()})
+		}
+	} else {
+		visible := doc.index.visible(doc.pro, line)
+		for name, def := range visible {
+			kind := lspKindVariable
+			if _, ok := def.decl.(*AstFuncDecl); ok {
+				kind = lspKindFunction
+			}
+			items = append(items, lspCompletion{Label: name, Kind: kind, Detail: def.hover()})
+		}
+		for _, fn := range getBuiltinFunc() {
+			//a builtin shadowed by a decl of the script is not offered
+			if _, ok := visible[builtinSourceName(fn.name)]; !ok {
+				items = append(items, lspCompletion{Label: builtinSourceName(fn.name), Kind: lspKindFunction, Detail: "builtin"})
+			}
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Label < items[j].Label
+	})
+	return items
+}
+
+//lspServer speaks the language server protocol, documents are synced
+//whole on every change
+type lspServer struct {
+	in   *bufio.Reader
+	out  io.Writer
+	docs map[string]*lspDoc
+}
+
+//ServeLSP runs a language server reading requests from in and writing
+//replies to out, it returns when the client sends exit or closes in
+func ServeLSP(in io.Reader, out io.Writer) error {
+	srv := &lspServer{in: bufio.NewReader(in), out: out, docs: map[string]*lspDoc{}}
+	for {
+		msg, err := srv.read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if msg.Method == "exit" {
+			return nil
+		}
+		srv.handle(msg)
+	}
+}
+
+func (srv *lspServer) read() (*rpcMessage, error) {
+	body, err := readFrame(srv.in)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &rpcMessage{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, errors.Wrap(err, "bad message")
+	}
+	return msg, nil
+}
+
+//readFrame reads the body of a message framed by a Content-Length header,
+//the debug adapter is framed the same way
+func readFrame(in *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		header, err := in.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+
+		header = strings.TrimSpace(header)
+		if header == "" {
+			break
+		}
+		if strings.HasPrefix(strings.ToLower(header), "content-length:") {
+			length, err = strconv.Atoi(strings.TrimSpace(header[len("content-length:"):]))
+			if err != nil {
+				return nil, errors.Errorf("bad header: %s", header)
+			}
+		}
+	}
+
+	if length < 0 {
+		return nil, errors.New("missing Content-Length header")
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(in, body); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func (srv *lspServer) write(msg map[string]interface{}) {
+	msg["jsonrpc"] = "2.0"
+	body, _ := json.Marshal(msg)
+	fmt.Fprintf(srv.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (srv *lspServer) reply(id *json.RawMessage, result interface{}) {
+	srv.write(map[string]interface{}{"id": id, "result": result})
+}
+
+func (srv *lspServer) notify(method string, params interface{}) {
+	srv.write(map[string]interface{}{"method": method, "params": params})
+}
+
+//handle serves one message, a request always gets a reply even when
+//serving it fails
+func (srv *lspServer) handle(msg *rpcMessage) {
+	defer func() {
+		if r := recover(); r != nil && msg.ID != nil {
+			srv.write(map[string]interface{}{"id": msg.ID,
+				"error": rpcError{Code: rpcInternalError, Message: panicMessage(r)}})
+		}
+	}()
+
+	params := &lspDocParams{}
+	if len(msg.Params) > 0 {
+		if err := json.Unmarshal(msg.Params, params); err != nil {
+			doPanic("bad params of %s: %s", msg.Method, err)
+		}
+	}
+	uri := params.TextDocument.URI
+
+	switch msg.Method {
+	case "initialize":
+		srv.reply(msg.ID, map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1,
+				"definitionProvider": true,
+				"referencesProvider": true,
+				"hoverProvider":      true,
+				"completionProvider": map[string]interface{}{"triggerCharacters": []string{"."}},
+			},
+			"serverInfo": map[string]string{"name": "hskl"},
+		})
+		break
+
+	case "shutdown":
+		srv.reply(msg.ID, nil)
+		break
+
+	case "textDocument/didOpen":
+		srv.update(uri, params.TextDocument.Text)
+		break
+
+	case "textDocument/didChange":
+		if n := len(params.ContentChanges); n > 0 {
+			srv.update(uri, params.ContentChanges[n-1].Text)
+		}
+		break
+
+	case "textDocument/didClose":
+		delete(srv.docs, uri)
+		srv.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": []lspDiagnostic{}})
+		break
+
+	case "textDocument/definition":
+		doc, ref := srv.refAt(uri, params.Position)
+		if ref == nil {
+			srv.reply(msg.ID, nil)
+			break
+		}
+		def := ref.def
+		srv.reply(msg.ID, lspLocation{URI: uri, Range: doc.rangeOf(def.line, def.col, len([]rune(def.name)))})
+		break
+
+	case "textDocument/references":
+		doc, ref := srv.refAt(uri, params.Position)
+		locs := []lspLocation{}
+		if ref != nil {
+			for _, use := range doc.index.refsOf(ref.def) {
+				if use.line == ref.def.line && use.col == ref.def.col && !params.Context.IncludeDeclaration {
+					continue
+				}
+				locs = append(locs, doc.location(use))
+			}
+		}
+		srv.reply(msg.ID, locs)
+		break
+
+	case "textDocument/hover":
+		doc, ref := srv.refAt(uri, params.Position)
+		if ref == nil {
+			srv.reply(msg.ID, nil)
+			break
+		}
+		srv.reply(msg.ID, map[string]interface{}{
+			"contents": map[string]string{"kind": "plaintext", "value": ref.def.hover()},
+			"range":    doc.location(ref).Range,
+		})
+		break
+
+	case "textDocument/completion":
+		items := []lspCompletion{}
+		if doc := srv.docs[uri]; doc != nil {
+			items = doc.complete(doc.fromPos(params.Position))
+		}
+		srv.reply(msg.ID, items)
+		break
+
+	default:
+		//notifications not known are dropped
+		if msg.ID != nil {
+			srv.write(map[string]interface{}{"id": msg.ID,
+				"error": rpcError{Code: rpcMethodNotFound, Message: "method not found: " + msg.Method}})
+		}
+	}
+}
+
+//update analyzes the new text of a document and publishes its diagnostics
+func (srv *lspServer) update(uri, text string) {
+	doc := analyzeDoc(uri, text)
+	srv.docs[uri] = doc
+	srv.notify("textDocument/publishDiagnostics", map[string]interface{}{"uri": uri, "diagnostics": doc.diagnostics()})
+}
+
+func (srv *lspServer) refAt(uri string, pos lspPosition) (*lspDoc, *symbolRef) {
+	doc := srv.docs[uri]
+	if doc == nil {
+		return nil, nil
+	}
+	return doc, doc.index.at(doc.fromPos(pos))
+}
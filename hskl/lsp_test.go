@@ -0,0 +1,165 @@
+package hskl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+//lspScript frames the messages of a scripted client
+func lspScript(msgs ...map[string]interface{}) *bytes.Buffer {
+	in := &bytes.Buffer{}
+	for _, msg := range msgs {
+		msg["jsonrpc"] = "2.0"
+		body, _ := json.Marshal(msg)
+		fmt.Fprintf(in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	return in
+}
+
+//lspReplies reads back what the server wrote, replies by id and params of
+//notifications in order
+func lspReplies(t *testing.T, out *bytes.Buffer) (map[float64]map[string]interface{}, []map[string]interface{}) {
+	replies := map[float64]map[string]interface{}{}
+	notes := []map[string]interface{}{}
+	reader := bufio.NewReader(out)
+	for out.Len() > 0 || reader.Buffered() > 0 {
+		var length int
+		if _, err := fmt.Fscanf(reader, "Content-Length: %d\r\n\r\n", &length); err != nil {
+			t.Fatalf("read header: %v", err)
+		}
+
+		body := make([]byte, length)
+		io.ReadFull(reader, body)
+		msg := map[string]interface{}{}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			t.Fatalf("read reply %s: %v", body, err)
+		}
+
+		if id, ok := msg["id"].(float64); ok {
+			replies[id] = msg
+		} else {
+			notes = append(notes, msg["params"].(map[string]interface{}))
+		}
+	}
+	return replies, notes
+}
+
+func lspRequest(id int, method string, uri string, line, char int) map[string]interface{} {
+	return map[string]interface{}{"id": id, "method": method, "params": map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     map[string]int{"line": line, "character": char},
+		"context":      map[string]bool{"includeDeclaration": true},
+	}}
+}
+
+func TestLSP(t *testing.T) {
+	src := `type point struct {
+    x: int
+    y: int
+}
+
+func norm(p: point) int {
+    return p.x * p.x + p.y * p.y
+}
+
+func main() {
+    var p: point
+    var n: int
+    n = norm(p)
+    p.
+}`
+	fixed := strings.Replace(src, "    p.\n", "    print(str(p.y))\n", 1)
+	uri := "file:///tmp/a.hskl"
+
+	in := lspScript(
+		map[string]interface{}{"id": 1, "method": "initialize", "params": map[string]interface{}{}},
+		map[string]interface{}{"method": "initialized", "params": map[string]interface{}{}},
+		map[string]interface{}{"method": "textDocument/didOpen", "params": map[string]interface{}{
+			"textDocument": map[string]string{"uri": uri, "text": src}}},
+		lspRequest(2, "textDocument/completion", uri, 13, 6),
+		lspRequest(3, "textDocument/completion", uri, 12, 8),
+		map[string]interface{}{"method": "textDocument/didChange", "params": map[string]interface{}{
+			"textDocument":   map[string]string{"uri": uri},
+			"contentChanges": []map[string]string{{"text": fixed}}}},
+		lspRequest(4, "textDocument/definition", uri, 12, 9),
+		lspRequest(5, "textDocument/references", uri, 6, 11),
+		lspRequest(6, "textDocument/hover", uri, 12, 4),
+		lspRequest(7, "textDocument/hover", uri, 6, 11),
+		lspRequest(8, "textDocument/formatting", uri, 0, 0),
+		map[string]interface{}{"id": 9, "method": "shutdown"},
+		map[string]interface{}{"method": "exit"},
+	)
+
+	out := &bytes.Buffer{}
+	if err := ServeLSP(in, out); err != nil {
+		t.Fatalf("serve: %v", err)
+	}
+	replies, notes := lspReplies(t, out)
+
+	result := func(id float64) string {
+		body, _ := json.Marshal(replies[id]["result"])
+		return string(body)
+	}
+
+	if !strings.Contains(result(1), `"definitionProvider":true`) {
+		t.Errorf("initialize: %s", result(1))
+	}
+
+	//the broken text gets a syntax error at the '}' after the dot, the
+	//fixed text none
+	if len(notes) != 2 {
+		t.Fatalf("notifications: %v", notes)
+	}
+	diags, _ := json.Marshal(notes[0]["diagnostics"])
+	if !strings.Contains(string(diags), `"code":"P001"`) || !strings.Contains(string(diags), `"start":{"character":0,"line":14}`) {
+		t.Errorf("diagnostics of broken text: %s", diags)
+	}
+	if diags, _ := json.Marshal(notes[1]["diagnostics"]); string(diags) != "[]" {
+		t.Errorf("diagnostics of fixed text: %s", diags)
+	}
+
+	cases := []struct {
+		id   float64
+		want []string
+	}{
+		//fields after a dot
+		{2, []string{`{"detail":"int","kind":5,"label":"x"}`, `"label":"y"`}},
+		//vars, funcs and builtins
+		{3, []string{`{"detail":"var n: int","kind":6,"label":"n"}`,
+			`{"detail":"func norm(struct: point) int","kind":3,"label":"norm"}`,
+			`"label":"p"`, `{"detail":"builtin","kind":3,"label":"int"}`}},
+		//norm in main goes to its decl
+		{4, []string{`"range":{"end":{"character":9,"line":5},"start":{"character":5,"line":5}}`}},
+		//param p of norm, its decl and 4 uses
+		{5, []string{`"start":{"character":10,"line":5}`, `"start":{"character":11,"line":6}`,
+			`"start":{"character":29,"line":6}`}},
+		{6, []string{`"value":"var n: int"`}},
+		{7, []string{`"value":"var p: struct: point"`}},
+	}
+	for _, c := range cases {
+		got := result(c.id)
+		for _, want := range c.want {
+			if !strings.Contains(got, want) {
+				t.Errorf("reply %v: %s, want: %s", c.id, got, want)
+			}
+		}
+	}
+
+	var refs []interface{}
+	json.Unmarshal([]byte(result(5)), &refs)
+	if len(refs) != 5 {
+		t.Errorf("references: %s", result(5))
+	}
+
+	if replies[8]["error"] == nil {
+		t.Errorf("unknown method: %v", replies[8])
+	}
+	if result(9) != "null" {
+		t.Errorf("shutdown: %s", result(9))
+	}
+}
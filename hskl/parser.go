@@ -27,7 +27,8 @@ var_assign_decl : ID ":="
 
 type_spec : INT | STRING |  ID | LBRACKET RBRACKET type_spec
 
-func_decl: FUNC ID LPAREN formal_params RPAREN type_spec? code_block
+func_decl: FUNC ID LPAREN formal_params RPAREN ret_spec? code_block
+ret_spec : type_spec | LPAREN type_spec (COMMA type_spec)* RPAREN
 code_block : LBRACE variable_declaration statement_list RBRACE
 
 formal_params : ID (COMMA ID)* COLON type_spec (COMMA ID (COMMA ID)* COLON type_spec)*
@@ -37,7 +38,11 @@ statement : (misc_stat |
 			 func_call | condition_stat |
 			 while_stat | break_stat) ";" | Empty
 
-misc_stat: 	assign_statement | expr
+misc_stat: 	assign_statement | multi_assign | expr
+multi_assign : var_ref (COMMA var_ref)+ (ASSIGN | DEC_ASSIGN) expr
+return_stat : RETURN (expr (COMMA expr)*)?
+try_stat : TRY code_block CATCH ID? code_block
+throw_stat : THROW expr
 
 break_stat : BREAK
 condition_stat : IF expr
@@ -54,9 +59,10 @@ assign_statement: var_ref ASSIGN expr
 var_ref : ID (LBRACKET expr  RBRACKET | DOT ID)*
 expr   : expr_comp ((AND | OR) comp)*
 expr_comp   : expr ((GT | GTE | LT | LTE | EQ) expr)
-expr_add   : term ((PLUS | MINUS) term)*
-expr_mul   : factor ((MUL | DIV) factor)*
-factor : (PLUS|MINUS|NOT) factor
+expr_add   : term ((PLUS | MINUS | BIT_OR | BIT_XOR) term)*
+expr_mul   : expr_pow ((MUL | DIV | MOD | BIT_AND | SHL | SHR) expr_pow)*
+expr_pow   : factor (POW expr_pow)?
+factor : (PLUS|MINUS|NOT) expr_pow
 		 | INTEGER
 		 | STRING
 		 | var_ref
@@ -72,10 +78,16 @@ const (
 	symTypeVoid   = "void"
 	symTypeInt    = "int"
 	symTypeFloat  = "float"
+	symTypeBool   = "bool"
 	symTypeString = "string"
 	symTypeArray  = "array"
+	symTypeMap    = "map"
+	symTypeFunc   = "func"
 	symTypeAny    = "any"
 	symTypeStruct = "struct"
+	symTypeIface  = "interface"
+	symTypeTuple  = "tuple"
+	symTypeError  = "error"
 
 	entryFunc = "main"
 )
@@ -91,6 +103,31 @@ type hskParser struct {
 	markers   []int
 	lastError error
 	tpMap     map[string]AstType
+	file      string
+
+	//recovering is set when parsing a whole program, a syntax error is
+	//recorded in diags and parsing goes on after the broken part
+	recovering bool
+	diags      Diagnostics
+
+	//typeNames makes type_spec give every type as written, for the
+	//formatter. type names are not resolved and every use is a new node
+	typeNames bool
+
+	//furthest is the furthest token a failed speculation of the statement
+	//reached, with its error, a statement nothing fits is reported there
+	furthest    *Token
+	furthestMsg string
+}
+
+//syntaxError is a syntax error found at tok, not at the current token
+type syntaxError struct {
+	tok *Token
+	msg string
+}
+
+func (err *syntaxError) Error() string {
+	return err.msg
 }
 
 func (p *hskParser) getLastError() error {
@@ -166,6 +203,20 @@ func (p *hskParser) panic(format string, args ...interface{}) {
 	panic(desc)
 }
 
+//noteFailure keeps the token a failed speculation stopped at when it is
+//the furthest one so far
+func (p *hskParser) noteFailure(r interface{}) {
+	tok := p.curToken
+	if err, ok := r.(*syntaxError); ok {
+		tok = err.tok
+	}
+
+	if p.furthest == nil || tok.line > p.furthest.line ||
+		(tok.line == p.furthest.line && tok.column > p.furthest.column) {
+		p.furthest, p.furthestMsg = tok, panicMessage(r)
+	}
+}
+
 func (p *hskParser) eatSeperator() {
 	if p.curToken.type_ == SEMI {
 		p.eat(SEMI)
@@ -177,49 +228,210 @@ func (p *hskParser) eatSeperator() {
 	}
 }
 
-//same as program
+//same as program, the program is returned even when it has syntax
+//errors, check Diagnostics before using it
 func (p *hskParser) Program() AstNode {
+	p.recovering = true
 	return p.declarations()
 }
 
+//SetFile names the source file being parsed, runtime errors of the
+//program report it in their traceback
+func (p *hskParser) SetFile(name string) {
+	p.file = name
+}
+
+//Diagnostics gives the errors of lexer and parser in source order
+func (p *hskParser) Diagnostics() Diagnostics {
+	ds := Diagnostics{}
+	for _, d := range append(append(Diagnostics{}, p.lex.diags...), p.diags...) {
+		cp := *d
+		cp.File = p.file
+		ds = append(ds, &cp)
+	}
+	sortDiagnostics(ds)
+	return ds
+}
+
+//tokenSpan is the count of source chars of tok
+func tokenSpan(tok *Token) int {
+	switch tok.type_ {
+	case EOF:
+		return 1
+
+	case STRING_CONST:
+		return len([]rune(tok.value)) + 2
+	}
+	return len([]rune(tok.value))
+}
+
+//tryParse runs parse, a syntax error in it is recorded at the current
+//token and sync skips the broken tokens. speculation needs the panic, so
+//it is only caught when recovering
+func (p *hskParser) tryParse(parse func(), sync func()) {
+	if !p.recovering || p.is_speculating() {
+		parse()
+		return
+	}
+
+	start := p.curToken
+	defer func() {
+		if r := recover(); r != nil {
+			//an error at the same token is the echo of one already found
+			tok := p.curToken
+			if err, ok := r.(*syntaxError); ok {
+				tok = err.tok
+			}
+			if len(p.diags) == 0 || p.diags[len(p.diags)-1].Line != tok.line ||
+				p.diags[len(p.diags)-1].Column != tok.column {
+				p.diags = append(p.diags, newDiagnostic(diagSyntax, "", panicMessage(r), tok.line, tok.column, tokenSpan(tok)))
+			}
+			sync()
+
+			//always move on, or the same error is found again
+			if p.curToken == start && p.curToken.type_ != EOF {
+				p.eat(p.curToken.type_)
+			}
+		}
+	}()
+
+	parse()
+}
+
+//syncStatement skips to the end of the broken statement: a ';', a new line
+//or the '}' closing the block, braces opened on the way are skipped whole
+func (p *hskParser) syncStatement() {
+	depth := 0
+	first := true
+	for p.curToken.type_ != EOF {
+		switch p.curToken.type_ {
+		case LBRACE:
+			depth++
+			break
+
+		case RBRACE:
+			if depth == 0 {
+				return
+			}
+			depth--
+			break
+
+		case SEMI:
+			if depth == 0 {
+				p.eat(SEMI)
+				return
+			}
+			break
+
+		default:
+			if depth == 0 && !first && p.curToken.line != p.prevToken.line {
+				return
+			}
+		}
+
+		first = false
+		p.eat(p.curToken.type_)
+	}
+}
+
+//syncDeclaration skips to the next top level declaration, which is out of
+//any braces and starts its line with func, type, var or name :=
+func (p *hskParser) syncDeclaration() {
+	depth := 0
+	first := true
+	for p.curToken.type_ != EOF {
+		lineStart := p.prevToken == nil || p.curToken.line != p.prevToken.line
+		if !first && depth == 0 && lineStart {
+			switch p.curToken.type_ {
+			case FUNC, TYPE, VAR:
+				return
+
+			case ID:
+				if p.peekToken().type_ == DEC_ASSIGN {
+					return
+				}
+			}
+		}
+
+		if p.curToken.type_ == LBRACE {
+			depth++
+		} else if p.curToken.type_ == RBRACE && depth > 0 {
+			depth--
+		}
+
+		first = false
+		p.eat(p.curToken.type_)
+	}
+}
+
 //same as program
 func (p *hskParser) declarations() AstNode {
 	program := &AstProgram{}
 	program.decl_list = []AstNode{}
 	program.tpMap = p.tpMap
+	program.file = p.file
 	//declarations : (variable_declaration | func_decl)*
 
 	for p.curToken.type_ != EOF {
-		if p.curToken.type_ == FUNC {
-			p.eat(FUNC)
-			ast := p.func_decl()
-			if ast == nil {
-				p.lastError = errors.Errorf("parse func_decl error: %s", p.lex.getLastError())
-				break
-			} else {
-				program.decl_list = append(program.decl_list, ast)
-			}
-		} else if p.curToken.type_ == TYPE {
-			ast := p.type_def()
-			program.decl_list = append(program.decl_list, ast)
-		} else {
-			ast := p.variable_decl()
-			p.eatSeperator()
-			if ast == nil {
-				p.lastError = errors.Errorf("parse variable_declaration error: %s", p.lex.getLastError())
-				break
+		p.tryParse(func() {
+			if p.curToken.type_ == FUNC {
+				p.eat(FUNC)
+				program.decl_list = append(program.decl_list, p.func_decl())
+			} else if p.curToken.type_ == TYPE {
+				program.decl_list = append(program.decl_list, p.type_def())
 			} else {
+				ast := p.variable_decl()
+				p.eatSeperator()
 				for _, val := range ast {
 					program.decl_list = append(program.decl_list, val)
 				}
 			}
+		}, p.syncDeclaration)
+	}
+
+	return program
+}
+
+//replInput parses one interactive input, declarations and statements
+//can be mixed at top level
+func (p *hskParser) replInput() []AstNode {
+	//repl_input : (func_decl | type_def | variable_declaration | statement)*
+	items := []AstNode{}
+
+	for p.curToken.type_ != EOF {
+		if p.curToken.type_ == FUNC {
+			p.eat(FUNC)
+			items = append(items, p.func_decl())
+		} else if p.curToken.type_ == TYPE {
+			items = append(items, p.type_def())
+		} else if p.curToken.type_ == VAR ||
+			(p.curToken.type_ == ID && p.peekToken().type_ == DEC_ASSIGN) {
+			for _, decl := range p.variable_decl() {
+				items = append(items, decl)
+			}
+		} else {
+			stat := p.statement()
+			if stat.astType() != AST_Noop {
+				items = append(items, stat)
+			}
+		}
+
+		if p.curToken.type_ != EOF {
+			p.eatSeperator()
 		}
 	}
 
-	if p.lastError != nil {
-		return nil
+	return items
+}
+
+//replExpr parses a single expression which must span the whole input
+func (p *hskParser) replExpr() AstNode {
+	ast := p.expr()
+	if p.curToken.type_ != EOF {
+		p.panic("unexpected token after expression: '%s', line: %d", p.curToken.value, p.curToken.line)
 	}
-	return program
+
+	return ast
 }
 
 func (p *hskParser) type_def() *AstTypeDef {
@@ -230,16 +442,20 @@ func (p *hskParser) type_def() *AstTypeDef {
 		fields_def : (ID (COMMA ID)* COLON type_spec)*
 	*/
 
+	tok := p.curToken
 	p.eat(TYPE)
 	p.eat(ID)
 
 	name := p.prevToken.value
-	ast := &AstTypeDef{}
+	ast := &AstTypeDef{line: tok.line, col: tok.column}
 	ast.name = name
 	ast.impl = p.type_seek()
+	ast.endLine = p.prevToken.line
 
 	if strct, ok := ast.impl.(*AstStructType); ok {
 		strct.name = name
+	} else if iface, ok := ast.impl.(*AstIfaceType); ok {
+		iface.name = name
 	}
 
 	//fmt.Printf("type def name: %s, type: %s\n", ast.name, ast.impl.signature())
@@ -263,7 +479,7 @@ func (p *hskParser) type_def() *AstTypeDef {
 }
 
 func (p *hskParser) type_seek() AstType {
-	//type_ref:  (LBRACKET RBRACKET)* (INT | ID | STRING | struct_def)
+	//type_ref:  (LBRACKET RBRACKET)* (INT | FLOAT | BOOL | ID | STRING | map_type | func_type | struct_def | iface_def)
 
 	if p.curToken.type_ == LBRACKET {
 		p.eat(LBRACKET)
@@ -274,16 +490,28 @@ func (p *hskParser) type_seek() AstType {
 	} else if p.curToken.type_ == TYPE_INT {
 		p.eat(TYPE_INT)
 		return p.tpMap[symTypeInt]
+	} else if p.curToken.type_ == TYPE_FLOAT {
+		p.eat(TYPE_FLOAT)
+		return p.tpMap[symTypeFloat]
+	} else if p.curToken.type_ == TYPE_BOOL {
+		p.eat(TYPE_BOOL)
+		return p.tpMap[symTypeBool]
 	} else if p.curToken.type_ == TYPE_STRING {
 		p.eat(TYPE_STRING)
 		return p.tpMap[symTypeString]
+	} else if p.curToken.type_ == MAP {
+		return p.map_type(p.type_seek)
+	} else if p.curToken.type_ == FUNC {
+		return p.func_type(p.type_seek)
 	} else if p.curToken.type_ == STRUCT {
 		ast := p.struct_def()
 		return ast
+	} else if p.curToken.type_ == IFACE {
+		return p.iface_def()
 	} else {
 		p.eat(ID)
 		id := p.prevToken.value
-		return &AstUndefType{name: id}
+		return &AstUndefType{name: id, line: p.prevToken.line, col: p.prevToken.column}
 	}
 }
 
@@ -310,19 +538,47 @@ func (p *hskParser) struct_def() *AstStructType {
 	return ast
 }
 
+func (p *hskParser) iface_def() *AstIfaceType {
+	//iface_def : IFACE LBRACE (ID LPAREN formal_params RPAREN ret_spec?)* RBRACE
+	p.eat(IFACE)
+	p.eat(LBRACE)
+
+	ast := &AstIfaceType{}
+	for p.curToken.type_ != RBRACE {
+		method := &AstFuncDecl{name: p.curToken.value, line: p.curToken.line, col: p.curToken.column}
+		p.eat(ID)
+		p.eat(LPAREN)
+		method.params = p.formal_params()
+		p.eat(RPAREN)
+		if p.isTypeStart() {
+			method.retType = p.ret_spec(p.type_spec)
+		} else {
+			method.retType = newPrimType(symTypeVoid)
+		}
+
+		for _, old := range ast.methods {
+			if old.name == method.name {
+				p.panic("duplicate method name: %s in interface, line: %d", method.name, method.line)
+			}
+		}
+		ast.methods = append(ast.methods, method)
+	}
+
+	p.eat(RBRACE)
+	return ast
+}
+
 func (p *hskParser) field_decl() []*AstVarDecl {
 	decVars := []*AstVarDecl{}
 
-	names := []string{}
-	names = append(names, p.curToken.value)
-
-	line := p.curToken.line
+	names := []*Token{}
+	names = append(names, p.curToken)
 	p.eat(ID)
 
 	for p.curToken.type_ == COMMA {
 		p.eat(COMMA)
 		if p.curToken.type_ == ID {
-			names = append(names, p.curToken.value)
+			names = append(names, p.curToken)
 			p.eat(ID)
 		} else {
 			break
@@ -333,7 +589,7 @@ func (p *hskParser) field_decl() []*AstVarDecl {
 	varTp := p.type_spec()
 
 	for _, name := range names {
-		decVars = append(decVars, &AstVarDecl{name: name, type_: varTp, line: line})
+		decVars = append(decVars, &AstVarDecl{name: name.value, type_: varTp, line: name.line, col: name.column})
 	}
 
 	return decVars
@@ -356,11 +612,29 @@ func (p *hskParser) variable_decl() []*AstVarDecl {
 }
 
 func (p *hskParser) type_spec() AstType {
-	//type_spec : INT | STRING |  ID | LBRACKET RBRACKET type_spec
+	//type_spec : INT | FLOAT | BOOL | STRING |  ID | LBRACKET RBRACKET type_spec | map_type | func_type
+
+	if p.typeNames {
+		switch p.curToken.type_ {
+		case TYPE_INT, TYPE_FLOAT, TYPE_BOOL, TYPE_STRING, TYPE_ANY:
+			p.eat(p.curToken.type_)
+			return newPrimType(p.prevToken.value)
+
+		case ID:
+			p.eat(ID)
+			return &AstUndefType{name: p.prevToken.value, line: p.prevToken.line, col: p.prevToken.column}
+		}
+	}
 
 	if p.curToken.type_ == TYPE_INT {
 		p.eat(TYPE_INT)
 		return p.tpMap[symTypeInt]
+	} else if p.curToken.type_ == TYPE_FLOAT {
+		p.eat(TYPE_FLOAT)
+		return p.tpMap[symTypeFloat]
+	} else if p.curToken.type_ == TYPE_BOOL {
+		p.eat(TYPE_BOOL)
+		return p.tpMap[symTypeBool]
 	} else if p.curToken.type_ == TYPE_STRING {
 		p.eat(TYPE_STRING)
 		return p.tpMap[symTypeString]
@@ -373,7 +647,7 @@ func (p *hskParser) type_spec() AstType {
 		if tp != nil {
 			return tp
 		}
-		ast := &AstUndefType{}
+		ast := &AstUndefType{line: p.prevToken.line, col: p.prevToken.column}
 		ast.name = p.prevToken.value
 
 		p.tpMap[ast.name] = ast
@@ -384,12 +658,117 @@ func (p *hskParser) type_spec() AstType {
 		ast := &AstArrayType{}
 		ast.elemType = p.type_spec()
 		return ast
+	} else if p.curToken.type_ == MAP {
+		return p.map_type(p.type_spec)
+	} else if p.curToken.type_ == FUNC {
+		return p.func_type(p.type_spec)
 	} else {
 		p.panic("error type spec: %s", p.curToken.value)
 		return nil
 	}
 }
 
+//map_type parses key and value types with elem, which is type_spec
+//or type_seek depending on the caller
+func (p *hskParser) map_type(elem func() AstType) *AstMapType {
+	//map_type : MAP LBRACKET type_spec RBRACKET type_spec
+	line := p.curToken.line
+	p.eat(MAP)
+	p.eat(LBRACKET)
+	ast := &AstMapType{}
+	ast.keyType = elem()
+	p.eat(RBRACKET)
+	ast.valType = elem()
+
+	//keys are compared by value, only primitive types qualify
+	prim, ok := ast.keyType.(*AstPrimType)
+	if !ok || prim.name == symTypeAny || prim.name == symTypeVoid {
+		p.panic("map key should be int, float, bool or string, actual: %s, line: %d", ast.keyType.desc(), line)
+	}
+
+	return ast
+}
+
+//isTypeStart tells if a type follows on the same line, it decides whether
+//a func type has a return type
+func (p *hskParser) isTypeStart() bool {
+	if p.curToken.line != p.prevToken.line {
+		return false
+	}
+
+	switch p.curToken.type_ {
+	case TYPE_INT, TYPE_FLOAT, TYPE_BOOL, TYPE_STRING, TYPE_ANY, ID, LBRACKET, MAP, FUNC, LPAREN:
+		return true
+	}
+	return false
+}
+
+//ret_spec parses a return type, a type list in parens is multiple values
+func (p *hskParser) ret_spec(elem func() AstType) AstType {
+	//ret_spec : type_spec | LPAREN type_spec (COMMA type_spec)* RPAREN
+	if p.curToken.type_ != LPAREN {
+		return elem()
+	}
+
+	p.eat(LPAREN)
+	ast := &AstTupleType{}
+	ast.elems = append(ast.elems, elem())
+	for p.curToken.type_ == COMMA {
+		p.eat(COMMA)
+		ast.elems = append(ast.elems, elem())
+	}
+	p.eat(RPAREN)
+
+	if len(ast.elems) == 1 {
+		return ast.elems[0]
+	}
+	return ast
+}
+
+func (p *hskParser) func_type(elem func() AstType) *AstFuncType {
+	//func_type : FUNC LPAREN (type_spec (COMMA type_spec)*)? RPAREN ret_spec?
+	p.eat(FUNC)
+	p.eat(LPAREN)
+	ast := &AstFuncType{}
+	if p.curToken.type_ != RPAREN {
+		ast.params = append(ast.params, elem())
+		for p.curToken.type_ == COMMA {
+			p.eat(COMMA)
+			ast.params = append(ast.params, elem())
+		}
+	}
+	p.eat(RPAREN)
+
+	if p.isTypeStart() {
+		ast.retType = p.ret_spec(elem)
+	} else {
+		ast.retType = newPrimType(symTypeVoid)
+	}
+	return ast
+}
+
+//map_lit parses a map literal, the type is already known by the leading map_type
+func (p *hskParser) map_lit() *AstMapLit {
+	//map_lit : map_type LBRACE (expr COLON expr (COMMA expr COLON expr)* COMMA?)? RBRACE
+	ast := &AstMapLit{line: p.curToken.line, col: p.curToken.column}
+	ast.type_ = p.map_type(p.type_spec)
+	p.eat(LBRACE)
+
+	for p.curToken.type_ != RBRACE {
+		ast.keys = append(ast.keys, p.expr())
+		p.eat(COLON)
+		ast.vals = append(ast.vals, p.expr())
+
+		if p.curToken.type_ != COMMA {
+			break
+		}
+		p.eat(COMMA)
+	}
+
+	p.eat(RBRACE)
+	return ast
+}
+
 func (p *hskParser) var_type_decl() []*AstVarDecl {
 	decVars := []*AstVarDecl{}
 	if p.curToken.type_ != VAR {
@@ -397,16 +776,14 @@ func (p *hskParser) var_type_decl() []*AstVarDecl {
 	}
 	p.eat(VAR)
 
-	names := []string{}
-	names = append(names, p.curToken.value)
-
-	line := p.curToken.line
+	names := []*Token{}
+	names = append(names, p.curToken)
 	p.eat(ID)
 
 	for p.curToken.type_ == COMMA {
 		p.eat(COMMA)
 		if p.curToken.type_ == ID {
-			names = append(names, p.curToken.value)
+			names = append(names, p.curToken)
 			p.eat(ID)
 		} else {
 			break
@@ -417,7 +794,7 @@ func (p *hskParser) var_type_decl() []*AstVarDecl {
 	varTp := p.type_spec()
 
 	for _, name := range names {
-		decVars = append(decVars, &AstVarDecl{name: name, type_: varTp, line: line})
+		decVars = append(decVars, &AstVarDecl{name: name.value, type_: varTp, line: name.line, col: name.column})
 	}
 
 	return decVars
@@ -427,12 +804,17 @@ func (p *hskParser) var_assign_decl() *AstVarDecl {
 	/*
 		var_assign_decl : ID ":="
 			INT_CONST |
+			FLOAT_CONST |
+			BOOL_CONST |
 			STRING_CONST |
 			LBRACKET RBRACKET INT LBRACKET (INT_CONST (COMMA, INT_CONST)*) RBRACKET |
+			LBRACKET RBRACKET FLOAT LBRACKET (FLOAT_CONST (COMMA, FLOAT_CONST)*) RBRACKET |
+			map_lit |
+			func_lit |
 			LBRACKET RBRACKET STRING LBRACKET (STRING_CONST (COMMA, STRING_CONST)*) RBRACKET
 	*/
 	id := p.curToken
-	line := p.curToken.line
+	line, col := id.line, id.column
 	p.eat(ID)
 	p.eat(DEC_ASSIGN)
 
@@ -440,18 +822,34 @@ func (p *hskParser) var_assign_decl() *AstVarDecl {
 
 	if p.curToken.type_ == INT_CONST {
 		p.eat(INT_CONST)
-		astNode := &AstVarDecl{name: id.value, initVal: initVal.value, type_: &AstPrimType{name: symTypeInt}, line: line}
+		astNode := &AstVarDecl{name: id.value, initVal: initVal.value, type_: &AstPrimType{name: symTypeInt}, line: line, col: col, short: true}
+		return astNode
+	} else if p.curToken.type_ == FLOAT_CONST {
+		p.eat(FLOAT_CONST)
+		astNode := &AstVarDecl{name: id.value, initVal: initVal.value, type_: &AstPrimType{name: symTypeFloat}, line: line, col: col, short: true}
+		return astNode
+	} else if p.curToken.type_ == BOOL_CONST {
+		p.eat(BOOL_CONST)
+		astNode := &AstVarDecl{name: id.value, initVal: initVal.value, type_: &AstPrimType{name: symTypeBool}, line: line, col: col, short: true}
+		return astNode
+	} else if p.curToken.type_ == MAP {
+		lit := p.map_lit()
+		astNode := &AstVarDecl{name: id.value, initExpr: lit, type_: lit.type_, line: line, col: col, short: true}
+		return astNode
+	} else if p.curToken.type_ == FUNC {
+		lit := p.func_lit()
+		astNode := &AstVarDecl{name: id.value, initExpr: lit, type_: lit.type_, line: line, col: col, short: true}
 		return astNode
 	} else if p.curToken.type_ == STRING_CONST {
 		p.eat(STRING_CONST)
-		astNode := &AstVarDecl{name: id.value, initVal: initVal.value, type_: &AstPrimType{name: symTypeString}, line: line}
+		astNode := &AstVarDecl{name: id.value, initVal: initVal.value, type_: &AstPrimType{name: symTypeString}, line: line, col: col, short: true}
 		return astNode
 	} else {
 		//array
 		p.eat(LBRACKET)
 		p.eat(RBRACKET)
 
-		astNode := &AstVarDecl{name: id.value, line: line}
+		astNode := &AstVarDecl{name: id.value, line: line, col: col, short: true}
 		if p.curToken.type_ == TYPE_INT {
 			astNode.type_ = &AstArrayType{elemType: newPrimType(symTypeInt)}
 			p.eat(TYPE_INT)
@@ -467,6 +865,25 @@ func (p *hskParser) var_assign_decl() *AstVarDecl {
 				astNode.initArr = append(astNode.initArr, p.curToken)
 				p.eat(INT_CONST)
 			}
+		} else if p.curToken.type_ == TYPE_FLOAT {
+			astNode.type_ = &AstArrayType{elemType: newPrimType(symTypeFloat)}
+			p.eat(TYPE_FLOAT)
+			p.eat(LBRACE)
+
+			if p.curToken.type_ == FLOAT_CONST || p.curToken.type_ == INT_CONST {
+				astNode.initArr = append(astNode.initArr, p.curToken)
+				p.eat(p.curToken.type_)
+			}
+
+			for p.curToken.type_ == COMMA {
+				p.eat(COMMA)
+				astNode.initArr = append(astNode.initArr, p.curToken)
+				if p.curToken.type_ == INT_CONST {
+					p.eat(INT_CONST)
+				} else {
+					p.eat(FLOAT_CONST)
+				}
+			}
 		} else {
 			//string
 			astNode.type_ = &AstArrayType{elemType: newPrimType(symTypeString)}
@@ -491,20 +908,27 @@ func (p *hskParser) var_assign_decl() *AstVarDecl {
 
 func (p *hskParser) func_decl() *AstFuncDecl {
 	/*
-		func_decl: "func" ID "(" formal_params ")" type_spec? "{"
+		func_decl: "func" receiver? ID "(" formal_params ")" ret_spec? "{"
 			variable_declaration
 			statement_list
 		"}"
 	*/
 
-	ast := &AstFuncDecl{name: p.curToken.value}
+	var recv *AstVarDecl
+	recvPtr := false
+	if p.curToken.type_ == LPAREN {
+		recv, recvPtr = p.receiver()
+	}
+
+	ast := &AstFuncDecl{name: p.curToken.value, recv: recv, recvPtr: recvPtr}
 	ast.line = p.curToken.line
+	ast.col = p.curToken.column
 	p.eat(ID)
 	p.eat(LPAREN)
 	ast.params = p.formal_params()
 	p.eat(RPAREN)
 	if p.curToken.type_ != LBRACE {
-		ast.retType = p.type_spec()
+		ast.retType = p.ret_spec(p.type_spec)
 	} else {
 		ast.retType = newPrimType(symTypeVoid)
 	}
@@ -512,17 +936,84 @@ func (p *hskParser) func_decl() *AstFuncDecl {
 	return ast
 }
 
+//receiver parses the receiver of a method, the colon is optional
+func (p *hskParser) receiver() (*AstVarDecl, bool) {
+	//receiver : LPAREN ID COLON? MUL? ID RPAREN
+	p.eat(LPAREN)
+	recv := &AstVarDecl{name: p.curToken.value, line: p.curToken.line, col: p.curToken.column}
+	p.eat(ID)
+	if p.curToken.type_ == COLON {
+		p.eat(COLON)
+	}
+
+	ptr := false
+	if p.curToken.type_ == MUL {
+		p.eat(MUL)
+		ptr = true
+	}
+
+	if p.curToken.type_ != ID {
+		p.panic("receiver should be a struct type name, actual: '%s', line: %d", p.curToken.value, p.curToken.line)
+	}
+	recv.type_ = p.type_spec()
+	p.eat(RPAREN)
+	return recv, ptr
+}
+
+func (p *hskParser) func_lit() *AstFuncLit {
+	//func_lit : FUNC LPAREN formal_params RPAREN ret_spec? code_block
+	line, col := p.curToken.line, p.curToken.column
+	p.eat(FUNC)
+
+	decl := &AstFuncDecl{name: fmt.Sprintf("lambda@%d", line), line: line, col: col}
+	p.eat(LPAREN)
+	decl.params = p.formal_params()
+	p.eat(RPAREN)
+	if p.curToken.type_ != LBRACE {
+		decl.retType = p.ret_spec(p.type_spec)
+	} else {
+		decl.retType = newPrimType(symTypeVoid)
+	}
+	decl.block = p.code_block()
+
+	return &AstFuncLit{decl: decl, type_: funcTypeOf(decl), line: line, col: col}
+}
+
+//host_signature parses the signature of a go function registered by
+//the embedder, it has no name and no body
+func (p *hskParser) host_signature(name string) *AstFuncDecl {
+	//host_signature : LPAREN formal_params RPAREN ret_spec?
+	ast := &AstFuncDecl{name: name}
+	p.eat(LPAREN)
+	ast.params = p.formal_params()
+	p.eat(RPAREN)
+	if p.curToken.type_ != EOF {
+		ast.retType = p.ret_spec(p.type_spec)
+	} else {
+		ast.retType = newPrimType(symTypeVoid)
+	}
+
+	if p.curToken.type_ != EOF {
+		p.panic("unexpected token after signature: '%s'", p.curToken.value)
+	}
+
+	return ast
+}
+
 func (p *hskParser) code_block() *AstCodeBlock {
+	ast := &AstCodeBlock{line: p.curToken.line, col: p.curToken.column}
 	p.eat(LBRACE)
-	ast := &AstCodeBlock{}
 	ast.vars = []*AstVarDecl{}
 	for p.curToken.type_ == VAR ||
 		(p.curToken.type_ == ID && p.peekToken().type_ == DEC_ASSIGN) {
-		ast.vars = append(ast.vars, p.variable_decl()...)
+		p.tryParse(func() {
+			ast.vars = append(ast.vars, p.variable_decl()...)
+		}, p.syncStatement)
 	}
 
 	ast.stat_list = p.statement_list()
 	p.eat(RBRACE)
+	ast.endLine = p.prevToken.line
 	return ast
 }
 
@@ -533,16 +1024,14 @@ func (p *hskParser) formal_params() []*AstVarDecl {
 		return decVars
 	}
 
-	names := []string{}
-	names = append(names, p.curToken.value)
-
-	line := p.curToken.line
+	names := []*Token{}
+	names = append(names, p.curToken)
 	p.eat(ID)
 
 	for p.curToken.type_ == COMMA {
 		p.eat(COMMA)
 		if p.curToken.type_ == ID {
-			names = append(names, p.curToken.value)
+			names = append(names, p.curToken)
 			p.eat(ID)
 		} else {
 			break
@@ -553,7 +1042,7 @@ func (p *hskParser) formal_params() []*AstVarDecl {
 	tpVal := p.type_spec()
 
 	for _, name := range names {
-		decVars = append(decVars, &AstVarDecl{name: name, type_: tpVal, line: line})
+		decVars = append(decVars, &AstVarDecl{name: name.value, type_: tpVal, line: name.line, col: name.column})
 	}
 
 	for p.curToken.type_ == COMMA {
@@ -570,14 +1059,18 @@ func (p *hskParser) statement_list() []AstNode {
 		return list
 	}
 
-	list = append(list, p.statement())
+	p.tryParse(func() {
+		list = append(list, p.statement())
+	}, p.syncStatement)
 
 	for p.curToken.type_ != RBRACE && p.curToken.type_ != EOF {
-		stat := p.statement()
-		if stat.astType() != AST_Noop {
-			list = append(list, stat)
-		}
-		p.eatSeperator()
+		p.tryParse(func() {
+			stat := p.statement()
+			if stat.astType() != AST_Noop {
+				list = append(list, stat)
+			}
+			p.eatSeperator()
+		}, p.syncStatement)
 	}
 
 	return list
@@ -592,8 +1085,17 @@ func (p *hskParser) statement() AstNode {
 		ast = p.condition_stat()
 	} else if p.curToken.type_ == WHILE {
 		ast = p.while_stat()
+	} else if p.curToken.type_ == FOR {
+		ast = p.for_stat()
 	} else if p.curToken.type_ == BREAK {
 		ast = p.break_stat()
+	} else if p.curToken.type_ == CONTINUE {
+		ast = &AstContinue{line: p.curToken.line, col: p.curToken.column}
+		p.eat(CONTINUE)
+	} else if p.curToken.type_ == TRY {
+		ast = p.try_stat()
+	} else if p.curToken.type_ == THROW {
+		ast = p.throw_stat()
 	} else {
 		ast = p.misc_stat()
 	}
@@ -609,16 +1111,16 @@ func (p *hskParser) condition_stat() AstNode {
 				ELSE code_block
 	*/
 
+	topAst := &AstConditionBlock{line: p.curToken.line, col: p.curToken.column}
 	p.eat(IF)
-	topAst := &AstConditionBlock{}
 	topAst.cond = p.expr()
 	topAst.first = true
 	topAst.block = p.code_block()
 
 	curAst := topAst
 	for p.curToken.type_ == ELIF {
+		ast := &AstConditionBlock{line: p.curToken.line, col: p.curToken.column}
 		p.eat(ELIF)
-		ast := &AstConditionBlock{}
 		ast.cond = p.expr()
 		ast.block = p.code_block()
 
@@ -636,32 +1138,147 @@ func (p *hskParser) condition_stat() AstNode {
 
 func (p *hskParser) while_stat() AstNode {
 	//while_stat: while expr code_block
-	ast := &AstWhileBlock{}
+	ast := &AstWhileBlock{line: p.curToken.line, col: p.curToken.column}
 	p.eat(WHILE)
 	ast.cond = p.expr()
 	ast.block = p.code_block()
 	return ast
 }
 
+func (p *hskParser) for_stat() AstNode {
+	/*
+		for_stat : FOR (range_clause | for_clause) code_block
+		for_clause : (var_assign_decl | assign_statement)? SEMI expr? SEMI misc_stat?
+		range_clause : ID (COMMA ID)? DEC_ASSIGN RANGE expr
+	*/
+	tok := p.curToken
+	p.eat(FOR)
+
+	if p.spec_range() {
+		return p.range_clause(tok)
+	}
+
+	ast := &AstForBlock{line: tok.line, col: tok.column}
+	if p.curToken.type_ != SEMI {
+		if p.curToken.type_ == ID && p.peekToken().type_ == DEC_ASSIGN {
+			ast.init = p.var_assign_decl()
+		} else {
+			ast.init = p.assign_statement()
+		}
+	}
+	p.eat(SEMI)
+
+	if p.curToken.type_ != SEMI {
+		ast.cond = p.expr()
+	}
+	p.eat(SEMI)
+
+	if p.curToken.type_ != LBRACE {
+		ast.post = p.misc_stat()
+	}
+
+	ast.block = p.code_block()
+	return ast
+}
+
+//spec_range checks the for loop iterates with range
+func (p *hskParser) spec_range() (ok bool) {
+	p.mark_push()
+
+	defer func() {
+		p.mark_pop()
+
+		if r := recover(); r != nil {
+			ok = false
+		}
+	}()
+
+	p.eat(ID)
+	if p.curToken.type_ == COMMA {
+		p.eat(COMMA)
+		p.eat(ID)
+	}
+	p.eat(DEC_ASSIGN)
+	return p.curToken.type_ == RANGE
+}
+
+func (p *hskParser) range_clause(tok *Token) AstNode {
+	ast := &AstRangeBlock{line: tok.line, col: tok.column}
+	ast.key = p.range_var()
+	if p.curToken.type_ == COMMA {
+		p.eat(COMMA)
+		ast.val = p.range_var()
+	}
+
+	p.eat(DEC_ASSIGN)
+	p.eat(RANGE)
+	ast.expr = p.expr()
+	ast.block = p.code_block()
+	return ast
+}
+
+//range_var is nil for the blank name '_'
+func (p *hskParser) range_var() *AstVarDecl {
+	p.eat(ID)
+	if p.prevToken.value == "_" {
+		return nil
+	}
+	return &AstVarDecl{name: p.prevToken.value, line: p.prevToken.line, col: p.prevToken.column}
+}
+
+func (p *hskParser) try_stat() AstNode {
+	//try_stat : TRY code_block CATCH ID? code_block
+	ast := &AstTryBlock{line: p.curToken.line, col: p.curToken.column}
+	p.eat(TRY)
+	ast.block = p.code_block()
+	p.eat(CATCH)
+	if p.curToken.type_ == ID {
+		ast.name = p.range_var()
+	}
+	if ast.name != nil {
+		ast.name.type_ = p.tpMap[symTypeError]
+	}
+	ast.catch = p.code_block()
+	return ast
+}
+
+func (p *hskParser) throw_stat() AstNode {
+	//throw_stat : THROW expr
+	ast := &AstThrow{line: p.curToken.line, col: p.curToken.column}
+	p.eat(THROW)
+	ast.expr = p.expr()
+	return ast
+}
+
 func (p *hskParser) break_stat() AstNode {
 	//while_stat: while expr code_block
+	ast := &AstBreak{line: p.curToken.line, col: p.curToken.column}
 	p.eat(BREAK)
-	ast := &AstBreak{}
 	return ast
 }
 
 func (p *hskParser) return_stat() AstNode {
 	p.eat(RETURN)
 
-	ast := &AstReturn{}
+	ast := &AstReturn{line: p.prevToken.line, col: p.prevToken.column}
 
 	switch p.curToken.type_ {
-	case ID, INT_CONST, STRING_CONST:
-		ast.expr = p.expr()
+	case RBRACE, SEMI, EOF:
+		ast.expr = nil
 		break
 
 	default:
-		ast.expr = nil
+		ast.expr = p.expr()
+	}
+
+	//multiple values
+	if p.curToken.type_ == COMMA {
+		tuple := &AstTuple{exprs: []AstNode{ast.expr}, line: ast.line, col: ast.col}
+		for p.curToken.type_ == COMMA {
+			p.eat(COMMA)
+			tuple.exprs = append(tuple.exprs, p.expr())
+		}
+		ast.expr = tuple
 	}
 
 	return ast
@@ -677,6 +1294,7 @@ func (p *hskParser) func_call() AstNode {
 	ast := &AstFuncCall{}
 	ast.name = builtFuncMap(p.prevToken.value)
 	ast.line = p.prevToken.line
+	ast.col = p.prevToken.column
 
 	p.eat(LPAREN)
 	ast.args = p.call_args()
@@ -688,6 +1306,7 @@ func (p *hskParser) new_op() *AstNewOP {
 	//new_op : NEW LPAREN type_spec RPAREN
 	ast := &AstNewOP{}
 	ast.line = p.curToken.line
+	ast.col = p.curToken.column
 
 	p.eat(NEW)
 	p.eat(LPAREN)
@@ -720,16 +1339,11 @@ func (p *hskParser) call_args() []AstNode {
 func (p *hskParser) call_arg() AstNode {
 	/*
 		func_call : ID LPAREN (call_args) RPAREN
-		call_arg : expr | func_call
+		call_arg : expr
 	*/
 
-	if p.curToken.type_ == ID && p.peekToken().type_ == LPAREN {
-		//func call
-		return p.func_call()
-	} else {
-		//expr
-		return p.expr()
-	}
+	//a func call is a factor of the expr, so f(g(x) + 1) works
+	return p.expr()
 }
 
 func (p *hskParser) spec_assign_stat() (ok bool) {
@@ -737,12 +1351,12 @@ func (p *hskParser) spec_assign_stat() (ok bool) {
 	p.mark_push()
 
 	defer func() {
-		p.mark_pop()
-
 		if r := recover(); r != nil {
 			//fmt.Printf("spect assign stat failed: %s\n", r)
+			p.noteFailure(r)
 			ok = false
 		}
+		p.mark_pop()
 	}()
 
 	p.assign_statement()
@@ -754,12 +1368,12 @@ func (p *hskParser) spec_expr() (ok bool) {
 	p.mark_push()
 
 	defer func() {
-		p.mark_pop()
-
 		if r := recover(); r != nil {
 			//fmt.Printf("spect expr stat failed: %s\n", r)
+			p.noteFailure(r)
 			ok = false
 		}
+		p.mark_pop()
 	}()
 
 	p.expr()
@@ -767,8 +1381,16 @@ func (p *hskParser) spec_expr() (ok bool) {
 }
 
 func (p *hskParser) misc_stat() AstNode {
-	//misc_stat: 	assign_statement | expr
-	if p.spec_assign_stat() {
+	//misc_stat: 	assign_statement | multi_assign | expr
+	furthest, furthestMsg := p.furthest, p.furthestMsg
+	p.furthest = nil
+	defer func() {
+		p.furthest, p.furthestMsg = furthest, furthestMsg
+	}()
+
+	if p.spec_multi_assign() {
+		return p.multi_assign()
+	} else if p.spec_assign_stat() {
 		//fmt.Printf("get assign stat, cur token: %s, line: %d\n", p.curToken.value, p.curToken.line)
 		return p.assign_statement()
 	} else if p.spec_expr() {
@@ -776,19 +1398,91 @@ func (p *hskParser) misc_stat() AstNode {
 		return p.expr()
 	}
 
-	doPanic("unexpected token: %s:%d after token: %s:%d", p.curToken.value, p.curToken.line,
-		p.prevToken.value, p.prevToken.line)
+	//the speculation that got furthest tells what is wrong
+	if tok := p.furthest; tok != nil && tok != p.curToken {
+		err := &syntaxError{tok: tok, msg: p.furthestMsg}
+		p.lastError = err
+		panic(err)
+	}
+
+	//the first token of an input has none before it
+	if p.prevToken == nil {
+		doPanic("unexpected token: %s", p.curToken.value)
+	}
+	doPanic("unexpected token: %s after token: %s", p.curToken.value, p.prevToken.value)
 	return nil
 }
 
+//spec_multi_assign checks a list of targets is followed by '=' or ':='
+func (p *hskParser) spec_multi_assign() (ok bool) {
+	p.mark_push()
+
+	defer func() {
+		if r := recover(); r != nil {
+			p.noteFailure(r)
+			ok = false
+		}
+		p.mark_pop()
+	}()
+
+	p.var_ref()
+	if p.curToken.type_ != COMMA {
+		return false
+	}
+
+	for p.curToken.type_ == COMMA {
+		p.eat(COMMA)
+		p.var_ref()
+	}
+	return p.curToken.type_ == ASSIGN || p.curToken.type_ == DEC_ASSIGN
+}
+
+func (p *hskParser) multi_assign() AstNode {
+	//multi_assign : var_ref (COMMA var_ref)+ (ASSIGN | DEC_ASSIGN) expr
+	ast := &AstMultiAssign{line: p.curToken.line, col: p.curToken.column}
+	dsts := []AstNode{p.var_ref()}
+	for p.curToken.type_ == COMMA {
+		p.eat(COMMA)
+		dsts = append(dsts, p.var_ref())
+	}
+
+	if p.curToken.type_ == DEC_ASSIGN {
+		p.eat(DEC_ASSIGN)
+		ast.define = true
+		for _, dst := range dsts {
+			ref, ok := dst.(*AstVarNameRef)
+			if !ok {
+				p.panic("non-name %s on left side of :=, line: %d", dst.desc(), ast.line)
+			}
+
+			if ref.name == "_" {
+				ast.decls = append(ast.decls, nil)
+			} else {
+				ast.decls = append(ast.decls, &AstVarDecl{name: ref.name, line: ref.line, col: ref.col})
+			}
+		}
+	} else {
+		p.eat(ASSIGN)
+		for _, dst := range dsts {
+			if ref, ok := dst.(*AstVarNameRef); ok && ref.name == "_" {
+				dst = nil
+			}
+			ast.dsts = append(ast.dsts, dst)
+		}
+	}
+
+	ast.expr = p.expr()
+	return ast
+}
+
 func (p *hskParser) assign_statement() AstNode {
 	//assign_statement: var_ref ASSIGN expr
-	line := p.curToken.line
+	tok := p.curToken
 	lhs := p.var_ref()
 	p.eat(ASSIGN)
 	expr := p.expr()
 
-	ast := &AstAssgin{dst: lhs, expr: expr, line: line}
+	ast := &AstAssgin{dst: lhs, expr: expr, line: tok.line, col: tok.column}
 	return ast
 }
 
@@ -797,9 +1491,10 @@ expr   : expr_and (OR expr_and)*
 expr_and   : expr_equ (AND expr_equ)*
 expr_equ   : expr_comp ((EQU | NEQ) expr_comp)
 expr_comp   : expr_add ((GT | GTE | LT | LTE) expr_add)
-expr_add   : term ((PLUS | MINUS) term)*
-expr_mul   : factor ((MUL | DIV) factor)*
-factor : (PLUS | MINUS | NOT) factor | INT_CONST | LPAREN expr RPAREN
+expr_add   : term ((PLUS | MINUS | BIT_OR | BIT_XOR) term)*
+expr_mul   : expr_pow ((MUL | DIV | MOD | BIT_AND | SHL | SHR) expr_pow)*
+expr_pow   : factor (POW expr_pow)?
+factor : (PLUS | MINUS | NOT) expr_pow | INT_CONST | FLOAT_CONST | BOOL_CONST | LPAREN expr RPAREN
 */
 
 func (p *hskParser) expr() AstNode {
@@ -808,7 +1503,7 @@ func (p *hskParser) expr() AstNode {
 	for p.curToken.type_ == OR {
 		token := p.curToken
 		p.eat(p.curToken.type_)
-		node = &AstBinOP{op: token.type_, left: node, right: p.expr_and(), line: token.line}
+		node = &AstBinOP{op: token.type_, left: node, right: p.expr_and(), line: token.line, col: token.column}
 	}
 
 	return node
@@ -820,7 +1515,7 @@ func (p *hskParser) expr_and() AstNode {
 	for p.curToken.type_ == AND {
 		token := p.curToken
 		p.eat(p.curToken.type_)
-		node = &AstBinOP{op: token.type_, left: node, right: p.expr_equ(), line: token.line}
+		node = &AstBinOP{op: token.type_, left: node, right: p.expr_equ(), line: token.line, col: token.column}
 	}
 
 	return node
@@ -831,7 +1526,7 @@ func (p *hskParser) expr_equ() AstNode {
 	for p.curToken.type_ == EQU || p.curToken.type_ == NEQ {
 		token := p.curToken
 		p.eat(p.curToken.type_)
-		node = &AstBinOP{op: token.type_, left: node, right: p.expr_comp(), line: token.line}
+		node = &AstBinOP{op: token.type_, left: node, right: p.expr_comp(), line: token.line, col: token.column}
 	}
 	return node
 }
@@ -842,40 +1537,58 @@ func (p *hskParser) expr_comp() AstNode {
 		p.curToken.type_ == LT || p.curToken.type_ == LTE {
 		token := p.curToken
 		p.eat(p.curToken.type_)
-		node = &AstBinOP{op: token.type_, left: node, right: p.expr_add(), line: token.line}
+		node = &AstBinOP{op: token.type_, left: node, right: p.expr_add(), line: token.line, col: token.column}
 	}
 	return node
 }
 
 func (p *hskParser) expr_add() AstNode {
 	node := p.expr_mul()
-	for p.curToken.type_ == PLUS || p.curToken.type_ == MINUS {
+	for p.curToken.type_ == PLUS || p.curToken.type_ == MINUS ||
+		p.curToken.type_ == BIT_OR || p.curToken.type_ == BIT_XOR {
 		token := p.curToken
 		p.eat(p.curToken.type_)
-		node = &AstBinOP{op: token.type_, left: node, right: p.expr_mul(), line: token.line}
+		node = &AstBinOP{op: token.type_, left: node, right: p.expr_mul(), line: token.line, col: token.column}
 	}
 	return node
 }
 
 func (p *hskParser) expr_mul() AstNode {
-	node := p.factor()
-	for p.curToken.type_ == MUL || p.curToken.type_ == DIV {
+	node := p.expr_pow()
+	for p.curToken.type_ == MUL || p.curToken.type_ == DIV || p.curToken.type_ == MOD ||
+		p.curToken.type_ == BIT_AND || p.curToken.type_ == SHL || p.curToken.type_ == SHR {
 		token := p.curToken
 		p.eat(p.curToken.type_)
-		node = &AstBinOP{op: token.type_, left: node, right: p.factor(), line: token.line}
+		node = &AstBinOP{op: token.type_, left: node, right: p.expr_pow(), line: token.line, col: token.column}
+	}
+	return node
+}
+
+//expr_pow is right associative and binds tighter than a sign, -2 ** 2
+//is -(2 ** 2)
+func (p *hskParser) expr_pow() AstNode {
+	node := p.factor()
+	if p.curToken.type_ == POW {
+		token := p.curToken
+		p.eat(POW)
+		node = &AstBinOP{op: token.type_, left: node, right: p.expr_pow(), line: token.line, col: token.column}
 	}
 	return node
 }
 
 func (p *hskParser) factor() AstNode {
 	/*
-		factor : (PLUS|MINUS|NOT) factor
+		factor : (PLUS|MINUS|NOT) expr_pow
 				| INTEGER
+				| FLOAT
+				| BOOL
 				| STRING
 				| var_ref
 				| func_call
 				| new_op
-				| LPAREN expr RPAREN
+				| map_lit
+				| func_lit ref_tail
+				| LPAREN expr RPAREN ref_tail
 
 		new_op : NEW LPAREN type_spec RPAREN
 	*/
@@ -883,38 +1596,58 @@ func (p *hskParser) factor() AstNode {
 		ast := &AstUnaryOP{}
 		ast.op = p.curToken.type_
 		ast.line = p.curToken.line
+		ast.col = p.curToken.column
 		p.eat(p.curToken.type_)
 
-		ast.dst = p.factor()
+		ast.dst = p.expr_pow()
 		return ast
 	} else if p.curToken.type_ == INT_CONST {
 		p.eat(INT_CONST)
+		//the lexer reports a literal out of int range
 		val, _ := strconv.Atoi(p.prevToken.value)
-		ast := &AstIntConst{value: val}
+		ast := &AstIntConst{value: val, line: p.prevToken.line, col: p.prevToken.column}
+		return ast
+	} else if p.curToken.type_ == FLOAT_CONST {
+		p.eat(FLOAT_CONST)
+		val, err := strconv.ParseFloat(p.prevToken.value, 64)
+		if err != nil {
+			p.panic("bad float const: '%s', line: %d", p.prevToken.value, p.prevToken.line)
+		}
+		ast := &AstFloatConst{value: val, text: p.prevToken.value, line: p.prevToken.line, col: p.prevToken.column}
+		return ast
+	} else if p.curToken.type_ == BOOL_CONST {
+		p.eat(BOOL_CONST)
+		ast := &AstBoolConst{value: p.prevToken.value == "true", line: p.prevToken.line, col: p.prevToken.column}
 		return ast
 	} else if p.curToken.type_ == STRING_CONST {
 		p.eat(STRING_CONST)
-		ast := &AstStringConst{value: p.prevToken.value}
+		ast := &AstStringConst{value: p.prevToken.value, line: p.prevToken.line, col: p.prevToken.column}
 		return ast
 	} else if p.curToken.type_ == LPAREN {
 		p.eat(LPAREN)
 		ast := p.expr()
 		p.eat(RPAREN)
-		return ast
+		return p.ref_tail(ast)
 	} else if p.curToken.type_ == ID {
 		if p.peekToken().type_ == LPAREN {
 			ast := p.func_call()
-			return ast
+			return p.ref_tail(ast)
 		} else {
 			ast := p.var_ref()
 			return ast
 		}
-	} else if p.curToken.type_ == TYPE_INT && p.peekToken().type_ == LPAREN {
+	} else if (p.curToken.type_ == TYPE_INT || p.curToken.type_ == TYPE_FLOAT) && p.peekToken().type_ == LPAREN {
 		ast := p.func_call()
 		return ast
 	} else if p.curToken.type_ == NEW && p.peekToken().type_ == LPAREN {
 		ast := p.new_op()
 		return ast
+	} else if p.curToken.type_ == MAP {
+		ast := p.map_lit()
+		return ast
+	} else if p.curToken.type_ == FUNC {
+		ast := p.func_lit()
+		return p.ref_tail(ast)
 	} else {
 		msg := fmt.Sprintf("parse factor failed, cur token: '%s', line: %d", p.curToken.value, p.curToken.line)
 		//fmt.Println(msg)
@@ -924,20 +1657,42 @@ func (p *hskParser) factor() AstNode {
 }
 
 func (p *hskParser) var_ref() AstNode {
-	//var_ref : ID (LBRACKET expr  RBRACKET | DOT ID)*
-	var ast AstNode
-	ast = &AstVarNameRef{name: p.curToken.value, line: p.curToken.line}
+	//var_ref : ID ref_tail
+	ast := &AstVarNameRef{name: p.curToken.value, line: p.curToken.line, col: p.curToken.column}
 	p.eat(ID)
 
+	return p.ref_tail(ast)
+}
+
+func (p *hskParser) ref_tail(ast AstNode) AstNode {
+	//ref_tail : (LBRACKET (expr | expr? COLON expr?) RBRACKET | DOT ID | LPAREN call_args RPAREN)*
 loop:
 	for {
 		switch p.curToken.type_ {
 		case LBRACKET:
+			line, col := p.curToken.line, p.curToken.column
+			p.eat(LBRACKET)
+			var index AstNode
+			if p.curToken.type_ != COLON {
+				index = p.expr()
+			}
+
+			if p.curToken.type_ == COLON {
+				top := &AstSliceRef{host: ast, low: index, line: line, col: col}
+				p.eat(COLON)
+				if p.curToken.type_ != RBRACKET {
+					top.high = p.expr()
+				}
+				p.eat(RBRACKET)
+				ast = top
+				break
+			}
+
 			top := &AstIndexedRef{}
-			top.line = p.curToken.line
+			top.line = line
+			top.col = col
 			top.host = ast
-			p.eat(LBRACKET)
-			top.index = p.expr()
+			top.index = index
 			p.eat(RBRACKET)
 			ast = top
 			break
@@ -945,6 +1700,7 @@ loop:
 		case DOT:
 			top := &AstDotRef{}
 			top.line = p.curToken.line
+			top.col = p.curToken.column
 			top.host = ast
 			p.eat(DOT)
 			top.name = p.curToken.value
@@ -952,6 +1708,16 @@ loop:
 			ast = top
 			break
 
+		case LPAREN:
+			top := &AstFuncCall{callee: ast}
+			top.line = p.curToken.line
+			top.col = p.curToken.column
+			p.eat(LPAREN)
+			top.args = p.call_args()
+			p.eat(RPAREN)
+			ast = top
+			break
+
 		default:
 			break loop
 		}
@@ -960,9 +1726,39 @@ loop:
 	return ast
 }
 
+func newTypeMap() map[string]AstType {
+	tpMap := make(map[string]AstType)
+	arr := []string{symTypeAny, symTypeInt, symTypeFloat, symTypeBool, symTypeString, symTypeVoid}
+	for _, val := range arr {
+		tpMap[val] = &AstPrimType{name: val}
+	}
+	tpMap[symTypeError] = newErrorType()
+
+	return tpMap
+}
+
 func NewParser(text string) *hskParser {
+	return newIncrementalParser(text, newTypeMap())
+}
+
+//newIncrementalParser parses text with types defined by earlier inputs
+func newIncrementalParser(text string, tpMap map[string]AstType) *hskParser {
+	return newParserOf(newLexer(text), tpMap)
+}
+
+//newFormatParser keeps what the formatter prints: comments and type
+//names as written
+func newFormatParser(text string) *hskParser {
+	lex := newLexer(text)
+	lex.trivia = true
+	p := newParserOf(lex, newTypeMap())
+	p.typeNames = true
+	return p
+}
+
+func newParserOf(lex *hskLexer, tpMap map[string]AstType) *hskParser {
 	p := &hskParser{}
-	p.lex = newLexer(text)
+	p.lex = lex
 
 	p.curToken = p.lex.getNextToken()
 	p.lookAhead = append(p.lookAhead, p.curToken)
@@ -970,11 +1766,6 @@ func NewParser(text string) *hskParser {
 		p.eat(LF)
 	}
 
-	p.tpMap = make(map[string]AstType)
-	arr := []string{symTypeAny, symTypeInt, symTypeString, symTypeVoid}
-	for _, val := range arr {
-		p.tpMap[val] = &AstPrimType{name: val}
-	}
-
+	p.tpMap = tpMap
 	return p
 }
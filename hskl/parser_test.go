@@ -14,3 +14,82 @@ func TestParser(t *testing.T) {
 
 	fmt.Printf("parse result: %T, lastErr: %s\n", p.declarations(), p.lastError)
 }
+
+func TestParsePosition(t *testing.T) {
+	pro := NewParser(`func main() {
+    var s: string
+    s = "a" + str(12)
+}`).Program().(*AstProgram)
+
+	fn := pro.decl_list[0].(*AstFuncDecl)
+	assign := fn.block.stat_list[0].(*AstAssgin)
+	binop := assign.expr.(*AstBinOP)
+	call := binop.right.(*AstFuncCall)
+
+	cases := []struct {
+		node      AstNode
+		line, col int
+	}{
+		{fn.block, 1, 13},
+		{fn.block.vars[0], 2, 9},
+		{assign, 3, 5},
+		{binop.left, 3, 9},
+		{binop, 3, 13},
+		{call, 3, 15},
+		{call.args[0], 3, 19},
+	}
+
+	for _, c := range cases {
+		line, col := astPos(c.node)
+		if line != c.line || col != c.col {
+			t.Errorf("%s at %d:%d, want: %d:%d", c.node.desc(), line, col, c.line, c.col)
+		}
+	}
+}
+
+func TestParseRecovery(t *testing.T) {
+	p := NewParser(`func foo() {
+    var a: int
+    a = (1 + 2
+    a = 3
+}
+
+func bar(n int) {
+}
+
+func main() {
+    var s: string
+    s = "x" ]
+    foo()
+}`)
+	pro := p.Program().(*AstProgram)
+
+	diags := p.Diagnostics()
+	want := [][2]int{{3, 7}, {7, 12}, {12, 13}}
+	if len(diags) != len(want) {
+		t.Fatalf("diagnostics: %v, want %d", diags, len(want))
+	}
+	for idx, pos := range want {
+		if diags[idx].Code != diagSyntax || diags[idx].Line != pos[0] || diags[idx].Column != pos[1] {
+			t.Errorf("diagnostic %v, want at %d:%d", diags[idx], pos[0], pos[1])
+		}
+	}
+
+	//statements after the errors are still parsed
+	foo := pro.decl_list[0].(*AstFuncDecl)
+	last := foo.block.stat_list[len(foo.block.stat_list)-1]
+	if line, _ := astPos(last); line != 4 {
+		t.Errorf("foo last statement: %s at line %d, want line 4", last.desc(), line)
+	}
+	main := pro.decl_list[len(pro.decl_list)-1].(*AstFuncDecl)
+	if main.name != "main" || len(main.block.stat_list) != 2 {
+		t.Errorf("last decl %s, statements: %d", main.name, len(main.block.stat_list))
+	}
+
+	//a statement nothing fits is marked where the parse got furthest
+	p = NewParser("func main() {\n    printn(str(1 2))\n}")
+	p.Program()
+	if diags := p.Diagnostics(); len(diags) != 1 || diags[0].Line != 2 || diags[0].Column != 18 {
+		t.Errorf("diagnostics of bad call args: %v", diags)
+	}
+}
@@ -0,0 +1,261 @@
+package hskl
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	Builtin_sprintf = "sprintf"
+	Builtin_printf  = "printf"
+)
+
+//fmtVerb is a piece of a format string, a verb or the text before it.
+//flags, width and prec are kept as written and passed on to go's fmt
+type fmtVerb struct {
+	text  string
+	flags string
+	width string
+	prec  string
+	verb  byte
+}
+
+//verbs known by the format builtins and the types they take
+var fmtVerbTypes = map[byte][]string{
+	'd': {symTypeInt},
+	's': {symTypeString},
+	'q': {symTypeString},
+	'x': {symTypeInt, symTypeString},
+	'f': {symTypeFloat, symTypeInt},
+	'e': {symTypeFloat, symTypeInt},
+	'v': nil,
+}
+
+//parseFormat splits format into text and verbs: %[flags][width][.prec]verb,
+//flags are any of '-', '+', '0' and ' ', %% is a percent sign
+func parseFormat(format string) ([]fmtVerb, error) {
+	pieces := []fmtVerb{}
+	text := ""
+	for idx := 0; idx < len(format); idx++ {
+		if format[idx] != '%' {
+			text += string(format[idx])
+			continue
+		}
+
+		idx++
+		if idx < len(format) && format[idx] == '%' {
+			text += "%"
+			continue
+		}
+
+		piece := fmtVerb{text: text}
+		text = ""
+		start := idx
+		for idx < len(format) && strings.IndexByte("-+0 ", format[idx]) >= 0 {
+			idx++
+		}
+		piece.flags = format[start:idx]
+
+		start = idx
+		for idx < len(format) && format[idx] >= '0' && format[idx] <= '9' {
+			idx++
+		}
+		piece.width = format[start:idx]
+
+		if idx < len(format) && format[idx] == '.' {
+			idx++
+			start = idx
+			for idx < len(format) && format[idx] >= '0' && format[idx] <= '9' {
+				idx++
+			}
+			piece.prec = "." + format[start:idx]
+		}
+
+		if idx >= len(format) {
+			return nil, errors.Errorf("format %q ends in a verb", format)
+		}
+		piece.verb = format[idx]
+		if _, ok := fmtVerbTypes[piece.verb]; !ok {
+			return nil, errors.Errorf("unknown verb %%%c in format %q", piece.verb, format)
+		}
+		pieces = append(pieces, piece)
+	}
+
+	if text != "" {
+		pieces = append(pieces, fmtVerb{text: text})
+	}
+	return pieces, nil
+}
+
+//argCount is the count of args the format takes
+func argCount(pieces []fmtVerb) int {
+	count := 0
+	for _, piece := range pieces {
+		if piece.verb != 0 {
+			count++
+		}
+	}
+	return count
+}
+
+//verbTakes tells the verb takes a value of the type, name is of a
+//primitive type or empty for others
+func verbTakes(verb byte, name string) bool {
+	types := fmtVerbTypes[verb]
+	if types == nil {
+		return true
+	}
+
+	for _, tp := range types {
+		if tp == name {
+			return true
+		}
+	}
+	return false
+}
+
+//sprintf formats args the way a hskl script asks for, a wrong format is
+//a runtime error of the script
+func sprintf(name, format string, args []interface{}) string {
+	pieces, err := parseFormat(format)
+	if err != nil {
+		interpPanic("builtin %s(): %v", name, err)
+	}
+	if count := argCount(pieces); count != len(args) {
+		interpPanic("builtin %s(): format %q takes %d args, recv: %d", name, format, count, len(args))
+	}
+
+	var sb strings.Builder
+	idx := 0
+	for _, piece := range pieces {
+		sb.WriteString(piece.text)
+		if piece.verb == 0 {
+			continue
+		}
+
+		arg := args[idx]
+		idx++
+		if !verbTakes(piece.verb, valueTypeName(arg)) {
+			interpPanic("builtin %s(): %%%c can not format %s", name, piece.verb, valueTypeName(arg))
+		}
+
+		spec := "%" + piece.flags + piece.width + piece.prec
+		switch piece.verb {
+		case 'f', 'e':
+			if iVal, ok := arg.(int); ok {
+				arg = float64(iVal)
+			}
+			sb.WriteString(fmt.Sprintf(spec+string(piece.verb), arg))
+
+		case 'v':
+			sb.WriteString(fmt.Sprintf(spec+"s", nativeStr(nil, []interface{}{arg})))
+
+		default:
+			sb.WriteString(fmt.Sprintf(spec+string(piece.verb), arg))
+		}
+	}
+	return sb.String()
+}
+
+//valueTypeName is the primitive type of a runtime value, empty for others
+func valueTypeName(val interface{}) string {
+	switch val.(type) {
+	case int:
+		return symTypeInt
+	case float64:
+		return symTypeFloat
+	case bool:
+		return symTypeBool
+	case string:
+		return symTypeString
+	}
+	return ""
+}
+
+//checkFormat checks the args of a call of a format builtin when the
+//format is a literal, args are the types of all args of the call
+func checkFormat(fn *AstFuncCall, args []AstType) {
+	lit, ok := fn.args[0].(*AstStringConst)
+	if !ok {
+		return
+	}
+
+	name := builtinSourceName(fn.name)
+	pieces, err := parseFormat(lit.value)
+	if err != nil {
+		doPanic("builtin %s(): %v", name, err)
+	}
+	if count := argCount(pieces); count != len(args)-1 {
+		doPanic("builtin %s(): format %q takes %d args, recv: %d", name, lit.value, count, len(args)-1)
+	}
+
+	idx := 1
+	for _, piece := range pieces {
+		if piece.verb == 0 {
+			continue
+		}
+
+		tpName := ""
+		if prim, ok := realType(args[idx]).(*AstPrimType); ok {
+			tpName = prim.name
+		}
+		if !verbTakes(piece.verb, tpName) {
+			doPanic("builtin %s(): %%%c can not format arg %d of %s", name, piece.verb, idx, args[idx].desc())
+		}
+		idx++
+	}
+}
+
+//newFormatBuiltin declares a builtin taking a format string and any
+//count of args, a plain one takes the format as text when it is alone
+func newFormatBuiltin(name string, native nativeFunc, retType AstType, plain bool) *AstFuncDecl {
+	fc := &AstFuncDecl{}
+	fc.builtin = true
+	fc.native = native
+	fc.name = name
+	fc.retType = retType
+
+	args := "args"
+	fc.va_param = &args
+
+	fmtParam := &AstVarDecl{}
+	fmtParam.name = "format"
+	fmtParam.type_ = newPrimType(symTypeString)
+	fc.params = []*AstVarDecl{fmtParam}
+
+	fc.checkArgs = func(fn *AstFuncCall, args []AstType) AstType {
+		if !plain || len(args) > 1 {
+			checkFormat(fn, args)
+		}
+		return fc.retType
+	}
+	return fc
+}
+
+func builtinSprintf() *AstFuncDecl {
+	return newFormatBuiltin(Builtin_sprintf, nativeSprintf, newPrimType(symTypeString), false)
+}
+
+func builtinPrintf() *AstFuncDecl {
+	return newFormatBuiltin(Builtin_printf, nativePrintf, newPrimType(symTypeVoid), false)
+}
+
+func nativeSprintf(env *nativeEnv, args []interface{}) interface{} {
+	return sprintf(Builtin_sprintf, args[0].(string), args[1:])
+}
+
+func nativePrintf(env *nativeEnv, args []interface{}) interface{} {
+	fmt.Fprint(env.out(), sprintf(Builtin_printf, args[0].(string), args[1:]))
+	return nil
+}
+
+//printText is what print, printn and eprintn write: the first arg as it
+//is, or formatted by the others when there are any
+func printText(name string, args []interface{}) string {
+	if len(args) == 1 {
+		return fmt.Sprintf("%v", args[0])
+	}
+	return sprintf(name, args[0].(string), args[1:])
+}
@@ -0,0 +1,44 @@
+package hskl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSprintf(t *testing.T) {
+	cases := []struct {
+		format string
+		args   []interface{}
+		want   string
+	}{
+		{"%d|%5d|%-5d|%05d|%+d", []interface{}{1, 2, 3, 4, 5}, "1|    2|3    |00004|+5"},
+		{"%s|%6s|%-6s|%.2s", []interface{}{"ab", "ab", "ab", "abc"}, "ab|    ab|ab    |ab"},
+		{"%q %x %x", []interface{}{"a\"b\n", 255, "hi"}, `"a\"b\n" ff 6869`},
+		{"%.2f %8.3f %f %e", []interface{}{3.14159, 2, 0.5, 1500.0}, "3.14    2.000 0.500000 1.500000e+03"},
+		{"%v %v %v %v 100%%", []interface{}{1.0, []interface{}{1, 2}, true, "s"}, "1.0 [1 2] true s 100%"},
+	}
+	for _, c := range cases {
+		if got := sprintf("sprintf", c.format, c.args); got != c.want {
+			t.Errorf("sprintf(%q) = %q, want: %q", c.format, got, c.want)
+		}
+	}
+
+	bad := map[string][]interface{}{
+		"%d":    {"a"},
+		"%d %d": {1},
+		"%s":    {"a", "b"},
+		"%y":    {1},
+		"%5":    nil,
+	}
+	for format, args := range bad {
+		func() {
+			defer func() {
+				err, ok := recover().(*interpError)
+				if !ok || !strings.HasPrefix(err.msg, "builtin sprintf(): ") {
+					t.Errorf("sprintf(%q, %v) should be a runtime error, got: %v", format, args, err)
+				}
+			}()
+			sprintf("sprintf", format, args)
+		}()
+	}
+}
@@ -0,0 +1,323 @@
+package hskl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	replPrompt     = "hskl> "
+	replContPrompt = "....> "
+	replHelp       = `input declarations, statements or expressions, bare expressions are printed.
+an input with unclosed braces or ending with an operator goes on in the next line.
+commands:
+  :type <expr>   show the type of expression
+  :ast <input>   show the parsed ast of input
+  :reset         forget everything defined so far
+  :help          show this message
+  :quit          leave the repl
+`
+)
+
+//hsklRepl keeps parser types, analyzer symbols, resolver scopes and
+//interpreter globals alive between inputs
+type hsklRepl struct {
+	out      io.Writer
+	tpMap    map[string]AstType
+	analyzer *semanticAnalyzer
+	resolver *resolver
+	interp   *interpreter
+}
+
+func (r *hsklRepl) reset() {
+	r.tpMap = newTypeMap()
+	r.analyzer = NewSemanticAnalyzer()
+	r.analyzer.firstPass = false
+	r.resolver = newResolver()
+	r.interp = NewInterpreter()
+	r.interp.SetStdout(r.out)
+}
+
+func (r *hsklRepl) printf(format string, args ...interface{}) {
+	fmt.Fprintf(r.out, format, args...)
+}
+
+func panicMessage(r interface{}) string {
+	switch err := r.(type) {
+	case error:
+		return err.Error()
+
+	case string:
+		return err
+
+	default:
+		return fmt.Sprint(err)
+	}
+}
+
+func formatValue(val interface{}) string {
+	switch tVal := val.(type) {
+	case nil:
+		return "nil"
+
+	case string:
+		return strconv.Quote(tVal)
+
+	case float64:
+		return formatFloat(tVal)
+
+	default:
+		return fmt.Sprintf("%v", tVal)
+	}
+}
+
+//braceDepth counts unclosed braces, parens and brackets, ignoring strings
+//and comments
+func braceDepth(text string) int {
+	depth := 0
+	src := []rune(text)
+	for i := 0; i < len(src); i++ {
+		switch src[i] {
+		case '"':
+			for i++; i < len(src) && src[i] != '"'; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+			}
+			break
+
+		case '/':
+			if i+1 < len(src) && src[i+1] == '/' {
+				for i < len(src) && src[i] != '\n' {
+					i++
+				}
+			} else if i+1 < len(src) && src[i+1] == '*' {
+				for i += 2; i+1 < len(src) && !(src[i] == '*' && src[i+1] == '/'); i++ {
+				}
+				i++
+			}
+			break
+
+		case '{', '(', '[':
+			depth++
+			break
+
+		case '}', ')', ']':
+			depth--
+			break
+		}
+	}
+
+	return depth
+}
+
+//openTokens can not end an input, the line after them goes on with it
+var openTokens = map[string]bool{
+	PLUS: true, MINUS: true, MUL: true, DIV: true, MOD: true, POW: true,
+	BIT_AND: true, BIT_OR: true, BIT_XOR: true, SHL: true, SHR: true,
+	AND: true, OR: true, EQU: true, NEQ: true, LT: true, LTE: true, GT: true, GTE: true,
+	NOT: true, COMMA: true, DOT: true, COLON: true, ASSIGN: true, DEC_ASSIGN: true,
+}
+
+//needMore tells the input goes on in the next line, it has unclosed
+//braces or ends with an operator
+func needMore(text string) bool {
+	if braceDepth(text) > 0 {
+		return true
+	}
+
+	last := EOF
+	lex := newLexer(text)
+	for tok := lex.getNextToken(); tok.type_ != EOF; tok = lex.getNextToken() {
+		last = tok.type_
+	}
+	return openTokens[last]
+}
+
+//rollback forgets what a failed input left in the session
+func (r *hsklRepl) rollback(oldTypes map[string]bool, item AstNode) {
+	for name := range r.tpMap {
+		if !oldTypes[name] {
+			delete(r.tpMap, name)
+		}
+	}
+
+	if fn, ok := item.(*AstFuncDecl); ok && fn.recv != nil {
+		if strct, ok := realType(fn.recv.type_).(*AstStructType); ok && strct.methods[fn.name] == fn {
+			delete(strct.methods, fn.name)
+		}
+	} else if ok {
+		global := r.analyzer.symbolStack[0]
+		if sym, ok := global.table[fn.name].(*funcSymbol); ok && sym.ast == fn {
+			delete(global.table, fn.name)
+		}
+	}
+
+	r.analyzer.resetInput()
+	r.resolver.resetInput()
+	r.interp.resetInput()
+}
+
+//Eval runs one complete input
+func (r *hsklRepl) Eval(input string) {
+	oldTypes := make(map[string]bool)
+	for name := range r.tpMap {
+		oldTypes[name] = true
+	}
+
+	var cur AstNode
+	p := newIncrementalParser(input, r.tpMap)
+	defer func() {
+		if rec := recover(); rec != nil {
+			//a bad char or string found by the lexer is what the parser
+			//failed on, it is shown instead
+			if diags := p.Diagnostics(); len(diags) > 0 {
+				r.printf("%s", diags.Render(input))
+			} else {
+				r.printf("error: %s\n", panicMessage(rec))
+			}
+			r.rollback(oldTypes, cur)
+		}
+	}()
+
+	items := p.replInput()
+	if diags := p.Diagnostics(); len(diags) > 0 {
+		panic(diags)
+	}
+	for _, item := range items {
+		cur = item
+		tp := r.analyzer.analyzeInput(r.tpMap, item)
+		r.resolver.resolveInput(item)
+		val := r.interp.interpretInput(item)
+
+		if tp != nil && tp.signature() != "V" {
+			r.printf("%s\n", formatValue(val))
+		}
+	}
+}
+
+func (r *hsklRepl) showType(input string) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			r.printf("error: %s\n", panicMessage(rec))
+			r.analyzer.resetInput()
+		}
+	}()
+
+	p := newIncrementalParser(input, r.tpMap)
+	tp := r.analyzer.analyzeInput(r.tpMap, p.replExpr())
+	r.printf("%s\n", tp.desc())
+}
+
+func (r *hsklRepl) showAst(input string) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			r.printf("error: %s\n", panicMessage(rec))
+		}
+	}()
+
+	//parse with a copy, the input is not evaluated
+	tpMap := make(map[string]AstType)
+	for name, tp := range r.tpMap {
+		tpMap[name] = tp
+	}
+
+	p := newIncrementalParser(input, tpMap)
+	for _, item := range p.replInput() {
+		r.printf("%s\n", item.desc())
+	}
+}
+
+//splitCommand gives the name of a ':' command and its arg
+func splitCommand(line string) (string, string) {
+	if idx := strings.IndexAny(line, " \t\n"); idx > 0 {
+		return line[:idx], strings.TrimSpace(line[idx:])
+	}
+	return line, ""
+}
+
+//command handles ':' commands, false means the session should end
+func (r *hsklRepl) command(line string) bool {
+	name, arg := splitCommand(line)
+	switch name {
+	case ":type":
+		r.showType(arg)
+		break
+
+	case ":ast":
+		r.showAst(arg)
+		break
+
+	case ":reset":
+		r.reset()
+		break
+
+	case ":help":
+		r.printf("%s", replHelp)
+		break
+
+	case ":quit", ":q":
+		return false
+
+	default:
+		r.printf("unknown command: %s, try :help\n", name)
+	}
+
+	return true
+}
+
+//Run reads inputs line by line until EOF or :quit, an input or the arg
+//of a command with unclosed braces or a trailing operator continues on
+//the next line
+func (r *hsklRepl) Run(in io.Reader) error {
+	scanner := bufio.NewScanner(in)
+	pending := ""
+
+	r.printf("%s", replPrompt)
+	for scanner.Scan() {
+		pending += scanner.Text() + "\n"
+		text := strings.TrimSpace(pending)
+		src := pending
+		if strings.HasPrefix(text, ":") {
+			_, src = splitCommand(text)
+		}
+
+		if needMore(src) {
+			r.printf("%s", replContPrompt)
+			continue
+		}
+
+		if !r.input(pending) {
+			return nil
+		}
+		pending = ""
+		r.printf("%s", replPrompt)
+	}
+
+	if len(strings.TrimSpace(pending)) > 0 {
+		r.input(pending)
+	}
+
+	r.printf("\n")
+	return scanner.Err()
+}
+
+//input runs a command or evaluates the input, false means the session
+//should end
+func (r *hsklRepl) input(text string) bool {
+	if line := strings.TrimSpace(text); strings.HasPrefix(line, ":") {
+		return r.command(line)
+	}
+
+	r.Eval(text)
+	return true
+}
+
+func NewRepl(out io.Writer) *hsklRepl {
+	r := &hsklRepl{out: out}
+	r.reset()
+	return r
+}
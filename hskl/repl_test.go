@@ -0,0 +1,127 @@
+package hskl
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRepl(t *testing.T) {
+	script := `x := 3
+x + 4
+func sq(a: int) int {
+    return a * a
+}
+sq(x)
+:type sq(x)
+:ast 1 + x
+func bad() int {
+    return "str"
+}
+func bad() int {
+    return 1
+}
+bad()
+var arr: []int
+arr = append(arr, x)
+:type arr
+"v" + len(arr)
+:reset
+x
+`
+
+	var out bytes.Buffer
+	err := NewRepl(&out).Run(strings.NewReader(script))
+	if err != nil {
+		t.Fatalf("repl error: %v", err)
+	}
+
+	text := out.String()
+	for _, want := range []string{
+		"hskl> 7\n",
+		"hskl> 9\n",
+		"hskl> int\n",
+		"hskl> (int const: 1) PLUS (x)\n",
+		"error: return type not match, want: int, actual: string",
+		"hskl> 1\n",
+		"hskl> []int\n",
+		"hskl> \"v1\"\n",
+		"error: error in varRef, symbol not found: x",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("repl output missing %q, output:\n%s", want, text)
+		}
+	}
+}
+
+func TestBraceDepth(t *testing.T) {
+	cases := map[string]int{
+		"func f() {":              1,
+		"func f() { }":            0,
+		"s := \"{\"":              0,
+		"a := 1 // {":             0,
+		"/* { */ while a < 3 {\n": 1,
+		"f(a[0], (1":              2,
+		"}":                       -1,
+	}
+
+	for text, want := range cases {
+		if got := braceDepth(text); got != want {
+			t.Errorf("braceDepth(%q) = %d, want: %d", text, got, want)
+		}
+	}
+}
+
+func TestReplIncomplete(t *testing.T) {
+	script := `1 +
+2
+(1
+)
+}
+:ast 1 +
+3
+func f() int {
+    return 4
+}
+:type (1 +
+2.5)
+f()
+`
+
+	var out bytes.Buffer
+	err := NewRepl(&out).Run(strings.NewReader(script))
+	if err != nil {
+		t.Fatalf("repl error: %v", err)
+	}
+
+	want := "hskl> ....> 3\n" +
+		"hskl> ....> 1\n" +
+		"hskl> error: unexpected token: }\n" +
+		"hskl> ....> (int const: 1) PLUS (int const: 3)\n" +
+		"hskl> ....> ....> " +
+		"hskl> ....> float\n" +
+		"hskl> 4\n" +
+		"hskl> \n"
+	if got := out.String(); got != want {
+		t.Errorf("repl output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestReplDiagnostics(t *testing.T) {
+	var out bytes.Buffer
+	err := NewRepl(&out).Run(strings.NewReader("\"open\na$b\n1 + 2\n"))
+	if err != nil {
+		t.Fatalf("repl error: %v", err)
+	}
+
+	text := out.String()
+	for _, want := range []string{
+		"hskl> <script>:1:1: error[L002]: string is not closed\n",
+		"hskl> <script>:1:2: error[L001]: unknown char \"$\"",
+		"hskl> 3\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("repl output missing %q, output:\n%s", want, text)
+		}
+	}
+}
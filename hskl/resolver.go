@@ -0,0 +1,365 @@
+package hskl
+
+//scope kinds a variable reference can be bound to
+const (
+	refUnresolved = iota
+	refLocal
+	refEnclosing
+	refGlobal
+)
+
+//resolver binds every AstVarNameRef to the lexical scope declaring it.
+//scopes mirror the frames the interpreter pushes at runtime: one frame for
+//a function call (params and top level vars of its body), one frame for
+//every nested code block, and the global frame at index 0. a func literal
+//opens a frame chained to the scope it is defined in
+type resolver struct {
+	scopes   []map[string]*AstVarDecl
+	funcBase int
+	litBase  int
+}
+
+func (r *resolver) pushScope() {
+	r.scopes = append(r.scopes, make(map[string]*AstVarDecl))
+}
+
+func (r *resolver) popScope() {
+	r.scopes = r.scopes[:len(r.scopes)-1]
+}
+
+func (r *resolver) declare(decl *AstVarDecl) {
+	r.scopes[len(r.scopes)-1][decl.name] = decl
+}
+
+func (r *resolver) resolveProgram(program *AstProgram) {
+	for _, decl := range program.decl_list {
+		if node, ok := decl.(*AstVarDecl); ok {
+			r.declare(node)
+		}
+	}
+
+	for _, decl := range program.decl_list {
+		if node, ok := decl.(*AstVarDecl); ok && node.initExpr != nil {
+			r.visitAst(node.initExpr)
+		}
+	}
+
+	for _, decl := range program.decl_list {
+		if node, ok := decl.(*AstFuncDecl); ok {
+			r.visitFuncDecl(node)
+		}
+	}
+}
+
+//resolveInput binds one item of an incremental session at top level
+func (r *resolver) resolveInput(item AstNode) {
+	switch node := item.(type) {
+	case *AstVarDecl:
+		r.visitVarDecl(node)
+		break
+
+	case *AstFuncDecl:
+		r.visitFuncDecl(node)
+		break
+
+	case *AstTypeDef:
+		break
+
+	default:
+		r.visitAst(item)
+	}
+}
+
+//resetInput drops the scopes left by a failed incremental input
+func (r *resolver) resetInput() {
+	r.scopes = r.scopes[:1]
+	r.funcBase = 0
+	r.litBase = 0
+}
+
+func (r *resolver) visitFuncDecl(node *AstFuncDecl) {
+	if node.builtin {
+		return
+	}
+
+	oldBase, oldLit := r.funcBase, r.litBase
+	r.pushScope()
+	r.funcBase = len(r.scopes) - 1
+	r.litBase = r.funcBase
+
+	for _, param := range funcParams(node) {
+		r.declare(param)
+	}
+
+	//function body shares the frame with params
+	r.visitCodeBlock(node.block)
+
+	r.popScope()
+	r.funcBase, r.litBase = oldBase, oldLit
+}
+
+//visitFuncLit keeps the enclosing scopes visible, variables found below
+//the literal are captured
+func (r *resolver) visitFuncLit(node *AstFuncLit) {
+	oldLit := r.litBase
+	r.pushScope()
+	r.litBase = len(r.scopes) - 1
+
+	for _, param := range node.decl.params {
+		r.declare(param)
+	}
+	r.visitCodeBlock(node.decl.block)
+
+	r.popScope()
+	r.litBase = oldLit
+}
+
+//visitVarDecl resolves the init expression before the name is visible
+func (r *resolver) visitVarDecl(node *AstVarDecl) {
+	if node.initExpr != nil {
+		r.visitAst(node.initExpr)
+	}
+	r.declare(node)
+}
+
+func (r *resolver) visitCodeBlock(node *AstCodeBlock) {
+	for _, varDecl := range node.vars {
+		r.visitVarDecl(varDecl)
+	}
+
+	for _, ast := range node.stat_list {
+		r.visitAst(ast)
+	}
+}
+
+func (r *resolver) visitNestedBlock(node *AstCodeBlock) {
+	r.pushScope()
+	r.visitCodeBlock(node)
+	r.popScope()
+}
+
+func (r *resolver) visitConditionBlock(node *AstConditionBlock) {
+	r.visitAst(node.cond)
+	r.visitNestedBlock(node.block)
+
+	if node.altCondBlock != nil {
+		r.visitConditionBlock(node.altCondBlock)
+	}
+
+	if node.altBlock != nil {
+		r.visitNestedBlock(node.altBlock)
+	}
+}
+
+//visitForBlock opens a frame for the init var, the body gets a frame of
+//its own in every iteration, post runs in the init frame
+func (r *resolver) visitForBlock(node *AstForBlock) {
+	r.pushScope()
+	if decl, ok := node.init.(*AstVarDecl); ok {
+		r.visitVarDecl(decl)
+	} else if node.init != nil {
+		r.visitAst(node.init)
+	}
+
+	if node.cond != nil {
+		r.visitAst(node.cond)
+	}
+	r.visitNestedBlock(node.block)
+	if node.post != nil {
+		r.visitAst(node.post)
+	}
+	r.popScope()
+}
+
+//visitRangeBlock declares key and value in the frame of the body
+func (r *resolver) visitRangeBlock(node *AstRangeBlock) {
+	r.visitAst(node.expr)
+
+	r.pushScope()
+	if node.key != nil {
+		r.declare(node.key)
+	}
+	if node.val != nil {
+		r.declare(node.val)
+	}
+	r.visitCodeBlock(node.block)
+	r.popScope()
+}
+
+//visitMultiAssign resolves the call before new vars of ':=' are visible
+func (r *resolver) visitMultiAssign(node *AstMultiAssign) {
+	r.visitAst(node.expr)
+	for _, decl := range node.decls {
+		if decl != nil {
+			r.declare(decl)
+		}
+	}
+
+	for _, dst := range node.dsts {
+		if dst != nil {
+			r.visitAst(dst)
+		}
+	}
+}
+
+func (r *resolver) visitVarRef(node *AstVarNameRef) {
+	if node.fn != nil {
+		//top level func used as value
+		return
+	}
+
+	top := len(r.scopes) - 1
+	for idx := top; idx >= r.funcBase && idx > 0; idx-- {
+		if decl := r.scopes[idx][node.name]; decl != nil {
+			if idx < r.litBase {
+				decl.captured = true
+			}
+			node.depth = top - idx
+			if node.depth == 0 {
+				node.scope = refLocal
+			} else {
+				node.scope = refEnclosing
+			}
+			return
+		}
+	}
+
+	if r.scopes[0][node.name] != nil {
+		node.scope = refGlobal
+		node.depth = 0
+		return
+	}
+
+	doPanic("resolve error, variable not declared in lexical scope: %s, line: %d", node.name, node.line)
+}
+
+func (r *resolver) visitAst(ast AstNode) {
+	switch node := ast.(type) {
+	case *AstAssgin:
+		r.visitAst(node.expr)
+		r.visitAst(node.dst)
+		break
+
+	case *AstBinOP:
+		r.visitAst(node.left)
+		r.visitAst(node.right)
+		break
+
+	case *AstUnaryOP:
+		r.visitAst(node.dst)
+		break
+
+	case *AstFuncCall:
+		if node.callee != nil {
+			r.visitAst(node.callee)
+		}
+		if node.recv != nil {
+			r.visitAst(node.recv)
+		}
+		for _, arg := range node.args {
+			r.visitAst(arg)
+		}
+		break
+
+	case *AstFuncLit:
+		r.visitFuncLit(node)
+		break
+
+	case *AstIfaceBox:
+		r.visitAst(node.expr)
+		break
+
+	case *AstReturn:
+		if node.expr != nil {
+			r.visitAst(node.expr)
+		}
+		break
+
+	case *AstTuple:
+		for _, expr := range node.exprs {
+			r.visitAst(expr)
+		}
+		break
+
+	case *AstMultiAssign:
+		r.visitMultiAssign(node)
+		break
+
+	case *AstTryBlock:
+		r.visitNestedBlock(node.block)
+		r.pushScope()
+		if node.name != nil {
+			r.declare(node.name)
+		}
+		r.visitCodeBlock(node.catch)
+		r.popScope()
+		break
+
+	case *AstThrow:
+		r.visitAst(node.expr)
+		break
+
+	case *AstVarNameRef:
+		r.visitVarRef(node)
+		break
+
+	case *AstIndexedRef:
+		r.visitAst(node.host)
+		r.visitAst(node.index)
+		break
+
+	case *AstSliceRef:
+		r.visitAst(node.host)
+		if node.low != nil {
+			r.visitAst(node.low)
+		}
+		if node.high != nil {
+			r.visitAst(node.high)
+		}
+		break
+
+	case *AstDotRef:
+		r.visitAst(node.host)
+		break
+
+	case *AstCodeBlock:
+		r.visitNestedBlock(node)
+		break
+
+	case *AstConditionBlock:
+		r.visitConditionBlock(node)
+		break
+
+	case *AstWhileBlock:
+		r.visitAst(node.cond)
+		r.visitNestedBlock(node.block)
+		break
+
+	case *AstForBlock:
+		r.visitForBlock(node)
+		break
+
+	case *AstRangeBlock:
+		r.visitRangeBlock(node)
+		break
+
+	case *AstMapLit:
+		for idx := range node.keys {
+			r.visitAst(node.keys[idx])
+			r.visitAst(node.vals[idx])
+		}
+		break
+
+	case *AstIntConst, *AstFloatConst, *AstBoolConst, *AstStringConst, *AstNewOP, *AstBreak, *AstContinue, *AstNoopStat:
+		break
+
+	default:
+		doPanic("resolver: unknown ast type: %T", ast)
+	}
+}
+
+func newResolver() *resolver {
+	r := &resolver{}
+	r.pushScope()
+	return r
+}
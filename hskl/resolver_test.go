@@ -0,0 +1,112 @@
+package hskl
+
+import (
+	"testing"
+)
+
+const lexicalScopeProgram = `
+x := 1
+var seen : int
+
+func readX() int {
+    return x
+}
+
+func writeX() {
+    x = 5
+}
+
+func main() {
+    x := 2
+    var y : int
+    while y < 1 {
+        y = y + x
+    }
+
+    seen = readX()
+    writeX()
+}
+`
+
+func TestResolveScope(t *testing.T) {
+	p := NewParser(lexicalScopeProgram)
+	pro := p.Program()
+	err := NewSemanticAnalyzer().DoAnalyze(pro)
+	if err != nil {
+		t.Fatalf("analyze error: %v\n", err)
+	}
+
+	refs := map[string][]*AstVarNameRef{}
+	var collect func(ast AstNode)
+	collect = func(ast AstNode) {
+		switch node := ast.(type) {
+		case *AstFuncDecl:
+			collect(node.block)
+		case *AstCodeBlock:
+			for _, stat := range node.stat_list {
+				collect(stat)
+			}
+		case *AstWhileBlock:
+			collect(node.cond)
+			collect(node.block)
+		case *AstAssgin:
+			collect(node.dst)
+			collect(node.expr)
+		case *AstBinOP:
+			collect(node.left)
+			collect(node.right)
+		case *AstReturn:
+			collect(node.expr)
+		case *AstVarNameRef:
+			refs[node.name] = append(refs[node.name], node)
+		}
+	}
+
+	for _, decl := range pro.(*AstProgram).decl_list {
+		collect(decl)
+	}
+
+	for _, ref := range refs["seen"] {
+		if ref.scope != refGlobal {
+			t.Errorf("seen should be global, actual: %d", ref.scope)
+		}
+	}
+
+	//x in readX/writeX is global, x in main's loop is main's local
+	kinds := map[int]int{}
+	for _, ref := range refs["x"] {
+		kinds[ref.scope]++
+	}
+	if kinds[refGlobal] != 2 || kinds[refEnclosing] != 1 {
+		t.Errorf("unexpected x bindings: %v", kinds)
+	}
+
+	for _, ref := range refs["y"] {
+		if ref.scope == refEnclosing && ref.depth != 1 {
+			t.Errorf("y in loop should be one block up, actual depth: %d", ref.depth)
+		}
+	}
+}
+
+func TestInterpLexicalScope(t *testing.T) {
+	p := NewParser(lexicalScopeProgram)
+	pro := p.Program()
+	err := NewSemanticAnalyzer().DoAnalyze(pro)
+	if err != nil {
+		t.Fatalf("analyze error: %v\n", err)
+	}
+
+	interp := NewInterpreter()
+	err = interp.DoInterpret(pro)
+	if err != nil {
+		t.Fatalf("interpret error: %v\n", err)
+	}
+
+	if seen := interp.globalFrame.lookup("seen", false).val; seen != 1 {
+		t.Errorf("callee should read global x, want: 1, actual: %v", seen)
+	}
+
+	if x := interp.globalFrame.lookup("x", false).val; x != 5 {
+		t.Errorf("callee should write global x, want: 5, actual: %v", x)
+	}
+}
@@ -2,7 +2,6 @@ package hskl
 
 import (
 	"fmt"
-	"runtime/debug"
 
 	"github.com/pkg/errors"
 )
@@ -19,6 +18,8 @@ func newSymTable(level int, upLevel *symbolTable) *symbolTable {
 	tb := &symbolTable{level: level, upLevel: upLevel}
 	tb.table = make(map[string]symbolClass)
 	tb.table[symTypeInt] = newBuiltinSymbol(symTypeInt)
+	tb.table[symTypeFloat] = newBuiltinSymbol(symTypeFloat)
+	tb.table[symTypeBool] = newBuiltinSymbol(symTypeBool)
 	tb.table[symTypeString] = newBuiltinSymbol(symTypeString)
 	return tb
 }
@@ -135,6 +136,72 @@ type semanticAnalyzer struct {
 	firstPass      bool
 	debug          bool
 	brkStack       []bool
+	//retType of the func being checked, returned structs may need boxing
+	retType AstType
+
+	//collect is set when checking a whole program, an error in a decl or
+	//statement is recorded in diags and checking goes on with the next one
+	collect bool
+	diags   Diagnostics
+	file    string
+	//index records decls and uses of symbols when set, for editors
+	index *symbolIndex
+	//universe holds the builtins below the global table, so a top level
+	//decl of the script shadows a builtin of the same name
+	universe *symbolTable
+}
+
+//semanticError is an error found at node, code tells its kind
+type semanticError struct {
+	code string
+	msg  string
+	node AstNode
+}
+
+func (err *semanticError) Error() string {
+	return err.msg
+}
+
+//semPanic fails the check, the diagnostic of it marks node
+func semPanic(code string, node AstNode, format string, args ...interface{}) {
+	panic(&semanticError{code: code, msg: fmt.Sprintf(format, args...), node: node})
+}
+
+//tryCheck runs check on node, its error becomes a diagnostic at the node
+//it is found at, or at node when that has no position. the analyzer state
+//is rolled back to where check started
+func (se *semanticAnalyzer) tryCheck(node AstNode, check func()) (ok bool) {
+	if !se.collect {
+		check()
+		return true
+	}
+
+	depth := len(se.symbolStack)
+	brk := len(se.brkStack)
+	retType := se.retType
+	defer func() {
+		if r := recover(); r != nil {
+			code, at := diagSemantic, node
+			if err, ok := r.(*semanticError); ok {
+				code = err.code
+				if line, _ := astPos(err.node); line > 0 {
+					at = err.node
+				}
+			}
+			line, col := astPos(at)
+			se.diags = append(se.diags, newDiagnostic(code, se.file, panicMessage(r), line, col, astSpan(at)))
+
+			for len(se.symbolStack) > depth {
+				se.popSymbolTable()
+			}
+			se.brkStack = se.brkStack[:brk]
+			se.retType = retType
+			ok = false
+		}
+	}()
+
+	check()
+	return true
 }
 
 func (p *semanticAnalyzer) pushBrk() {
@@ -165,74 +232,108 @@ func (se *semanticAnalyzer) popSymbolTable() *symbolTable {
 }
 
 func (se *semanticAnalyzer) visitProgram(program *AstProgram) {
-	se.resolveTypes(program)
-
 	for _, decl := range program.decl_list {
-		switch node := decl.(type) {
-		case *AstVarDecl:
-			se.visitVarDecl(node)
-			break
+		se.tryCheck(decl, func() {
+			switch node := decl.(type) {
+			case *AstVarDecl:
+				se.visitVarDecl(node)
+				break
 
-		case *AstFuncDecl:
-			se.visitFuncDecl(node)
-			break
+			case *AstFuncDecl:
+				se.visitFuncDecl(node)
+				break
 
-		case *AstTypeDef:
-			break
+			case *AstTypeDef:
+				break
 
-		default:
-			doPanic("unsupported ast type in program: %T", node)
-		}
+			default:
+				doPanic("unsupported ast type in program: %T", node)
+			}
+		})
 	}
 
 	se.firstPass = false
 	for _, decl := range program.decl_list {
-		switch node := decl.(type) {
-		case *AstFuncDecl:
-			se.visitFuncDecl(node)
-			break
-		}
+		se.tryCheck(decl, func() {
+			switch node := decl.(type) {
+			case *AstFuncDecl:
+				se.visitFuncDecl(node)
+				break
+
+			case *AstVarDecl:
+				if _, ok := node.initExpr.(*AstFuncLit); ok {
+					se.visitAst(node.initExpr)
+				}
+				break
+			}
+		})
 	}
 }
 
 func (se *semanticAnalyzer) visitVarDecl(node *AstVarDecl) {
 	if sym := se.curSymbolTable.lookup(node.name, false); sym != nil {
-		doPanic("error var symbol defined in level: %d, name: %s, type: %s, already exist: %s",
+		semPanic(diagRedefined, node, "error var symbol defined in level: %d, name: %s, type: %s, already exist: %s",
 			se.curSymbolTable.level, node.name, node.type_, sym.symName())
 		return
 	}
 
 	if primTp, ok := node.type_.(*AstPrimType); ok {
 		if tp := se.curSymbolTable.lookup(primTp.name, true); tp == nil {
-			doPanic("variable type not defined in level: %d, name: %s", se.curSymbolTable.level, node.type_)
+			semPanic(diagUndefined, node, "variable type not defined in level: %d, name: %s", se.curSymbolTable.level, node.type_)
 			return
 		}
 	}
 
+	//body of a global func literal may call funcs not collected yet,
+	//it is checked in the second pass, its type is known by parser
+	if _, ok := node.initExpr.(*AstFuncLit); ok && se.firstPass {
+		sym := newVarSymbol(node.name, node.type_, se.curSymbolTable.level, node)
+		se.curSymbolTable.insertSymbol(sym, se.debug)
+		se.index.define(node)
+		return
+	}
+
+	if node.initExpr != nil {
+		initTp := se.visitAst(node.initExpr).(AstType)
+		if initTp.signature() != node.type_.signature() {
+			semPanic(diagTypeMismatch, node.initExpr, "init var with diffirent type, var: %s, want: %s, actual: %s, line: %d",
+				node.name, node.type_.desc(), initTp.desc(), node.line)
+		}
+	}
+
 	//ok
 	sym := newVarSymbol(node.name, node.type_, se.curSymbolTable.level, node)
 	se.curSymbolTable.insertSymbol(sym, se.debug)
+	se.index.define(node)
 }
 
 func (se *semanticAnalyzer) visitFuncDecl(node *AstFuncDecl) {
 	//fmt.Printf("visit func decl: %s, line: %d\n", node.name, node.line)
 
+	//methods live in the method set of their struct, not in symbol table
+	if node.recv != nil && se.firstPass {
+		se.attachMethod(node)
+		return
+	}
+
 	if sym := se.curSymbolTable.lookup(node.name, false); se.firstPass && sym != nil {
-		doPanic("error func symbol defined in level: %d, name: %s, already exist: %s",
+		semPanic(diagRedefined, node, "error func symbol defined in level: %d, name: %s, already exist: %s",
 			se.curSymbolTable.level, node.name, sym.symName())
 		return
 	}
 
 	se.pushSymbolTable()
 	if !se.firstPass {
-		for _, varDecl := range node.params {
+		for _, varDecl := range funcParams(node) {
 			se.visitVarDecl(varDecl)
 		}
 
+		se.retType = node.retType
+
 		ret := se.visitCodeBlock(node.block).(AstType)
 		if ret.signature() != node.retType.signature() {
-			doPanic("return type not match in func: %s, want: %s, actual: %s",
-				node.name, node.retType, ret)
+			semPanic(diagTypeMismatch, node, "return type not match in func: %s, want: %s, actual: %s",
+				funcFullName(node), node.retType, ret)
 		}
 	}
 
@@ -240,63 +341,243 @@ func (se *semanticAnalyzer) visitFuncDecl(node *AstFuncDecl) {
 	if se.firstPass {
 		funcSym := newFuncSymbol(node.name, se.curSymbolTable.level, node)
 		se.curSymbolTable.insertSymbol(funcSym, se.debug)
+		se.index.define(node)
 	}
 }
 
+//attachMethod adds the method to the struct named by its receiver, all
+//aliases of the struct resolve to the same method set
+func (se *semanticAnalyzer) attachMethod(node *AstFuncDecl) {
+	strct, ok := realType(node.recv.type_).(*AstStructType)
+	if !ok {
+		doPanic("receiver of method %s should be struct, actual: %s, line: %d",
+			node.name, node.recv.type_.desc(), node.line)
+		return
+	}
+
+	for _, field := range strct.fields {
+		if field.name == node.name {
+			doPanic("struct %s has both field and method named %s, line: %d", strct.name, node.name, node.line)
+			return
+		}
+	}
+
+	if old, ok := strct.methods[node.name]; ok {
+		semPanic(diagRedefined, node, "duplicate method %s of struct %s, line: %d, prev line: %d", node.name, strct.name, node.line, old.line)
+		return
+	}
+
+	if strct.methods == nil {
+		strct.methods = make(map[string]*AstFuncDecl)
+	}
+	strct.methods[node.name] = node
+	se.index.define(node)
+}
+
+//lookupMethod finds the method a dot ref names, nil for a field.
+//methods of an interface are dispatched at runtime
+func (se *semanticAnalyzer) lookupMethod(node *AstDotRef) (*AstFuncDecl, bool) {
+	hostTp := se.visitAst(node.host).(AstType)
+	_, dynamic := realType(hostTp).(*AstIfaceType)
+	return methodSet(hostTp)[node.name], dynamic
+}
+
+//toIface boxes a struct value going where an interface is wanted, it
+//gives the expr to use and its type. the struct must have every method
+//of the interface with the same signature, an interface value must have
+//a superset of the wanted methods
+func (se *semanticAnalyzer) toIface(want AstType, expr AstNode, get AstType, line int) (AstNode, AstType) {
+	iface, ok := realType(want).(*AstIfaceType)
+	if !ok {
+		return expr, get
+	}
+
+	has := realType(get)
+	if has == iface {
+		return expr, get
+	}
+
+	var name string
+	switch tp := has.(type) {
+	case *AstStructType:
+		name = "struct " + tp.name
+		break
+
+	case *AstIfaceType:
+		name = "interface " + tp.name
+		break
+
+	default:
+		return expr, get
+	}
+
+	methods := methodSet(has)
+	for _, need := range iface.methods {
+		method, ok := methods[need.name]
+		if !ok {
+			semPanic(diagTypeMismatch, expr, "%s does not implement %s, missing method: %s, line: %d", name, iface.name, need.name, line)
+		}
+
+		wantTp, hasTp := funcTypeOf(need), funcTypeOf(method)
+		if wantTp.signature() != hasTp.signature() {
+			semPanic(diagTypeMismatch, expr, "%s does not implement %s, method %s should be %s, actual: %s, line: %d",
+				name, iface.name, need.name, wantTp.desc(), hasTp.desc(), line)
+		}
+	}
+
+	if strct, ok := has.(*AstStructType); ok {
+		return &AstIfaceBox{expr: expr, strct: strct, iface: iface, line: line}, iface
+	}
+	return expr, iface
+}
+
 func (se *semanticAnalyzer) visitCodeBlock(node *AstCodeBlock) interface{} {
 	for _, varDecl := range node.vars {
-		se.visitVarDecl(varDecl)
+		if !se.tryCheck(varDecl, func() { se.visitVarDecl(varDecl) }) {
+			//declare the broken var anyway, its uses should not fail too
+			if varDecl.type_ != nil && se.curSymbolTable.lookup(varDecl.name, false) == nil {
+				sym := newVarSymbol(varDecl.name, varDecl.type_, se.curSymbolTable.level, varDecl)
+				se.curSymbolTable.insertSymbol(sym, se.debug)
+			}
+		}
 	}
 
 	var ret AstType
 	ret = &AstPrimType{name: symTypeVoid}
 
-eval_loop:
 	for _, ast := range node.stat_list {
-		switch stat := ast.(type) {
-		case *AstAssgin, *AstBinOP, *AstUnaryOP, *AstIntConst, *AstVarNameRef:
-			se.visitAst(ast)
-			break
+		done := false
+		if !se.tryCheck(ast, func() { ret, done = se.visitStatement(ast, ret) }) {
+			//a broken statement is taken as returning the right type
+			if se.retType != nil {
+				ret = se.retType
+			}
+			_, done = ast.(*AstReturn)
+		}
 
-		case *AstFuncCall:
-			se.visitFuncCall(stat)
+		if done {
 			break
+		}
+	}
 
-		case *AstBreak:
-			if !se.allowBrk() {
-				doPanic("break has not exit point")
-			}
-			break
+	return ret
+}
 
-		case *AstReturn:
-			ret = se.visitReturn(stat).(AstType)
-			break eval_loop
+//visitStatement checks one statement of a code block, ret is the type the
+//block returns so far. done is set when nothing after stat can run
+func (se *semanticAnalyzer) visitStatement(ast AstNode, ret AstType) (AstType, bool) {
+	switch stat := ast.(type) {
+	case *AstAssgin, *AstBinOP, *AstUnaryOP, *AstIntConst, *AstFloatConst, *AstVarNameRef:
+		se.visitAst(ast)
+		break
 
-		case *AstCodeBlock:
-			se.pushSymbolTable()
-			ret = se.visitCodeBlock(stat).(AstType)
-			se.popSymbolTable()
-			break
+	case *AstFuncCall:
+		se.visitFuncCall(stat)
+		break
 
-		case *AstConditionBlock:
-			ret = se.visitConditionBlock(stat).(AstType)
-			break
+	case *AstMultiAssign:
+		se.visitMultiAssign(stat)
+		break
 
-		case *AstWhileBlock:
-			se.pushBrk()
-			ret = se.visitWhileBlock(stat).(AstType)
-			se.popBrk()
-			break
+	case *AstBreak:
+		if !se.allowBrk() {
+			semPanic(diagMisplaced, stat, "break has not exit point")
+		}
+		break
 
-		case *AstNoopStat:
-			break
+	case *AstContinue:
+		if !se.allowBrk() {
+			semPanic(diagMisplaced, stat, "continue is not in a loop")
+		}
+		break
 
-		default:
-			doPanic("error ast in func block: %T", ast)
+	case *AstReturn:
+		return se.visitReturn(stat).(AstType), true
+
+	case *AstThrow:
+		//like return, nothing after throw runs
+		se.visitThrow(stat)
+		if se.retType != nil {
+			ret = se.retType
 		}
+		return ret, true
+
+	case *AstTryBlock:
+		ret = se.visitTryBlock(stat).(AstType)
+		break
+
+	case *AstCodeBlock:
+		se.pushSymbolTable()
+		ret = se.visitCodeBlock(stat).(AstType)
+		se.popSymbolTable()
+		break
+
+	case *AstConditionBlock:
+		ret = se.visitConditionBlock(stat).(AstType)
+		break
+
+	case *AstWhileBlock:
+		se.pushBrk()
+		ret = se.visitWhileBlock(stat).(AstType)
+		se.popBrk()
+		break
+
+	case *AstForBlock:
+		ret = se.visitForBlock(stat).(AstType)
+		break
+
+	case *AstRangeBlock:
+		ret = se.visitRangeBlock(stat).(AstType)
+		break
+
+	case *AstNoopStat:
+		break
+
+	default:
+		doPanic("error ast in func block: %T", ast)
 	}
 
-	return ret
+	return ret, false
+}
+
+//visitTryBlock checks both blocks, the caught error is visible in catch only
+func (se *semanticAnalyzer) visitTryBlock(node *AstTryBlock) interface{} {
+	se.pushSymbolTable()
+	realRet := se.visitCodeBlock(node.block).(AstType)
+	se.popSymbolTable()
+
+	se.pushSymbolTable()
+	if node.name != nil {
+		se.visitVarDecl(node.name)
+	}
+	ret := se.visitCodeBlock(node.catch).(AstType)
+	se.popSymbolTable()
+
+	if realRet.signature() == "V" {
+		return ret
+	}
+
+	if ret.signature() != "V" && ret.signature() != realRet.signature() {
+		semPanic(diagTypeMismatch, node.catch, "return diffrent type, ret1: %v, ret2: %v", realRet, ret)
+		return nil
+	}
+	return realRet
+}
+
+//visitThrow accepts a message or an error caught before
+func (se *semanticAnalyzer) visitThrow(node *AstThrow) {
+	tp := se.visitAst(node.expr).(AstType)
+	if tp.signature() != "S" && tp.signature() != newErrorType().signature() {
+		semPanic(diagTypeMismatch, node.expr, "throw needs string or error, actual: %s, line: %d", tp.desc(), node.line)
+	}
+}
+
+//visitCond requires a bool condition, int and string are not truthy
+func (se *semanticAnalyzer) visitCond(cond AstNode, line int) {
+	tp := se.visitAst(cond).(AstType)
+	if tp.signature() != "B" {
+		semPanic(diagTypeMismatch, cond, "condition should be bool, actual: %s, line: %d", tp.desc(), line)
+	}
 }
 
 func (se *semanticAnalyzer) visitConditionBlock(node *AstConditionBlock) interface{} {
@@ -304,7 +585,7 @@ func (se *semanticAnalyzer) visitConditionBlock(node *AstConditionBlock) interfa
 	normRet = &AstPrimType{name: symTypeVoid}
 	realRet := normRet
 
-	se.visitAst(node.cond)
+	se.visitCond(node.cond, node.line)
 	se.pushSymbolTable()
 	realRet = se.visitCodeBlock(node.block).(AstType)
 	se.popSymbolTable()
@@ -312,7 +593,7 @@ func (se *semanticAnalyzer) visitConditionBlock(node *AstConditionBlock) interfa
 	if node.altCondBlock != nil {
 		ret := se.visitConditionBlock(node.altCondBlock).(AstType)
 		if ret.signature() != "V" && ret.signature() != realRet.signature() {
-			doPanic("return diffrent type, ret1: %v, ret2: %v", realRet, ret)
+			semPanic(diagTypeMismatch, node.altCondBlock, "return diffrent type, ret1: %v, ret2: %v", realRet, ret)
 			return nil
 		}
 	}
@@ -323,7 +604,7 @@ func (se *semanticAnalyzer) visitConditionBlock(node *AstConditionBlock) interfa
 		se.popSymbolTable()
 
 		if ret.signature() != "V" && ret.signature() != realRet.signature() {
-			doPanic("return diffrent type, ret1: %v, ret2: %v", realRet, ret)
+			semPanic(diagTypeMismatch, node.altBlock, "return diffrent type, ret1: %v, ret2: %v", realRet, ret)
 			return nil
 		}
 	}
@@ -332,11 +613,87 @@ func (se *semanticAnalyzer) visitConditionBlock(node *AstConditionBlock) interfa
 }
 
 func (se *semanticAnalyzer) visitWhileBlock(node *AstWhileBlock) interface{} {
-	se.visitAst(node.cond)
+	se.visitCond(node.cond, node.line)
+	se.pushSymbolTable()
+	ret := se.visitCodeBlock(node.block)
+	se.popSymbolTable()
+
+	return ret
+}
+
+//visitForBlock checks a counted loop, the init var is visible in cond,
+//post and body. break and continue are checked in body only
+func (se *semanticAnalyzer) visitForBlock(node *AstForBlock) interface{} {
+	se.pushSymbolTable()
+	switch init := node.init.(type) {
+	case *AstVarDecl:
+		se.visitVarDecl(init)
+		break
+
+	case *AstAssgin:
+		se.visitAssign(init)
+		break
+	}
+
+	if node.cond != nil {
+		se.visitCond(node.cond, node.line)
+	}
+
+	if node.post != nil {
+		se.visitAst(node.post)
+	}
+
+	se.pushBrk()
 	se.pushSymbolTable()
 	ret := se.visitCodeBlock(node.block)
 	se.popSymbolTable()
+	se.popBrk()
 
+	se.popSymbolTable()
+	return ret
+}
+
+//visitRangeBlock types the key and value by what is iterated: index and
+//element of array, key and value of map, byte offset and char of string
+func (se *semanticAnalyzer) visitRangeBlock(node *AstRangeBlock) interface{} {
+	var keyTp, valTp AstType
+	exprTp := se.visitAst(node.expr).(AstType)
+	switch tp := realType(exprTp).(type) {
+	case *AstArrayType:
+		keyTp, valTp = newPrimType(symTypeInt), tp.elemType
+		break
+
+	case *AstMapType:
+		keyTp, valTp = tp.keyType, tp.valType
+		break
+
+	case *AstPrimType:
+		if tp.name == symTypeString {
+			keyTp, valTp = newPrimType(symTypeInt), newPrimType(symTypeString)
+		}
+		break
+	}
+
+	if keyTp == nil {
+		semPanic(diagTypeMismatch, node.expr, "can not range over %s, line: %d", exprTp.desc(), node.line)
+		return nil
+	}
+
+	se.pushBrk()
+	se.pushSymbolTable()
+	if node.key != nil {
+		node.key.type_ = keyTp
+		se.visitVarDecl(node.key)
+	}
+
+	if node.val != nil {
+		node.val.type_ = valTp
+		se.visitVarDecl(node.val)
+	}
+
+	ret := se.visitCodeBlock(node.block)
+	se.popSymbolTable()
+	se.popBrk()
 	return ret
 }
 
@@ -379,8 +736,8 @@ func isTypeCompatiable(want string, has interface{}) bool {
 			return true
 		}
 
-		if s1 == nil {
-			return false
+		if s1 == nil || s2 == nil {
+			return s1 != nil && s1.tp == symTypeAny
 		}
 
 		if s1.tp == symTypeAny {
@@ -397,50 +754,191 @@ func isTypeCompatiable(want string, has interface{}) bool {
 			return false
 		}
 	}
+}
 
-	return false
+//promoteInt wraps an int expr in a float() call. int is widened to float
+//implicitly in arithmetic, assignment and call args, float is never
+//narrowed to int without an explicit int()
+func promoteInt(expr AstNode, line int) AstNode {
+	fc := &AstFuncCall{name: Builtin_float, line: line}
+	fc.args = []AstNode{expr}
+	return fc
+}
+
+//toElem converts an elem going into an array of want elems, an int is
+//widened to float and a struct is boxed for an interface array
+func (se *semanticAnalyzer) toElem(want AstType, expr AstNode, get AstType, line int) (AstNode, AstType) {
+	expr, get = se.toIface(want, expr, get, line)
+	if want.signature() == "F" && get.signature() == "I" {
+		expr = promoteInt(expr, line)
+		return expr, se.visitAst(expr).(AstType)
+	}
+	return expr, get
 }
 
 func (se *semanticAnalyzer) visitFuncCall(node *AstFuncCall) interface{} {
-	sym := se.curSymbolTable.lookup(node.name, true)
-	if sym == nil {
-		doPanic("undefined func: %s, line: %d", node.name, node.line)
-		return nil
+	//a method call becomes a direct call with the receiver as first arg
+	if dot, ok := node.callee.(*AstDotRef); ok {
+		if method, dynamic := se.lookupMethod(dot); method != nil {
+			se.index.refer(dot.line, dot.col+1, method)
+			node.recv = dot.host
+			node.name = method.name
+			node.ast = method
+			node.dynamic = dynamic
+			node.callee = nil
+		}
 	}
 
-	fdef, ok := sym.(*funcSymbol)
-	if !ok {
-		doPanic("error func call, target is not func decl: %s, line: %d, dst: %T", node.name, node.line, sym)
-		return nil
+	if node.callee != nil {
+		return se.visitValueCall(node)
 	}
 
-	node.ast = fdef.ast
+	//a call inserted by the analyzer is bound to its builtin already
+	if node.recv == nil && (node.ast == nil || !node.ast.builtin) {
+		sym := se.curSymbolTable.lookup(node.name, true)
+		if sym == nil {
+			semPanic(diagUndefined, node, "undefined func: %s, line: %d", node.name, node.line)
+			return nil
+		}
+
+		//a variable holding a func value
+		if _, ok := sym.(*varSymbol); ok {
+			node.callee = &AstVarNameRef{name: node.name, line: node.line, col: node.col}
+			return se.visitValueCall(node)
+		}
+
+		fdef, ok := sym.(*funcSymbol)
+		if !ok {
+			doPanic("error func call, target is not func decl: %s, line: %d, dst: %T", node.name, node.line, sym)
+			return nil
+		}
+
+		node.ast = fdef.ast
+		se.index.refer(node.line, node.col, fdef.ast)
+	}
 
 	argLen := len(node.args)
 	paramLen := len(node.ast.params)
 	if node.ast.va_param == nil && argLen != paramLen {
-		doPanic("error func call, param count not match, need: %d, actual: %d, func: %s, line: %d",
+		semPanic(diagArgCount, node, "error func call, param count not match, need: %d, actual: %d, func: %s, line: %d",
 			paramLen, argLen, node.name, node.line)
 		return nil
 	}
 
+	argTps := []AstType{}
 	for idx, ast := range node.args {
-		get := se.visitAst(ast)
+		get := se.visitAst(ast).(AstType)
+		argTps = append(argTps, get)
+		if idx >= paramLen {
+			//variadic args are not typed
+			continue
+		}
+
 		want := node.ast.params[idx].type_
-		if isTypeCompatiable(want.signature(), get) {
-			doPanic("error func call, arg type not match, idx: %d, need: %s, actual: %s, func: %s, line: %d",
+		if want.signature() == "F" && get.signature() == "I" {
+			node.args[idx] = promoteInt(ast, node.line)
+			get = se.visitAst(node.args[idx]).(AstType)
+		}
+		node.args[idx], get = se.toIface(want, node.args[idx], get, node.line)
+
+		if !isTypeCompatiable(want.signature(), get.signature()) {
+			semPanic(diagTypeMismatch, node.args[idx], "error func call, arg type not match, idx: %d, need: %s, actual: %s, func: %s, line: %d",
 				idx, want, get, node.name, node.line)
 			return nil
 		}
 	}
 
-	if fdef.ast.fixRetType == nil {
-		return fdef.ast.retType
+	if elem := node.ast.elemArg; elem > 0 && elem < argLen {
+		if arrTp, ok := realType(argTps[0]).(*AstArrayType); ok {
+			node.args[elem], argTps[elem] = se.toElem(arrTp.elemType, node.args[elem], argTps[elem], node.line)
+		}
+	}
+
+	if node.ast.checkArgs != nil {
+		return se.checkBuiltinArgs(node, argTps)
 	}
-	retTp := se.visitAst(fdef.ast.fixRetType(node))
+
+	if node.ast.fixRetType == nil {
+		return node.ast.retType
+	}
+	retTp := se.visitAst(node.ast.fixRetType(node))
 	return retTp
 }
 
+//checkBuiltinArgs runs checkArgs of a builtin, its errors mark the call
+func (se *semanticAnalyzer) checkBuiltinArgs(node *AstFuncCall, args []AstType) AstType {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(*semanticError); ok {
+				panic(r)
+			}
+			semPanic(diagTypeMismatch, node, "%s", panicMessage(r))
+		}
+	}()
+
+	return node.ast.checkArgs(node, args)
+}
+
+//visitValueCall checks a call through a func value
+func (se *semanticAnalyzer) visitValueCall(node *AstFuncCall) interface{} {
+	fnTp, ok := realType(se.visitAst(node.callee).(AstType)).(*AstFuncType)
+	if !ok {
+		semPanic(diagTypeMismatch, node.callee, "error func call, %s is not a func, line: %d", node.callee.desc(), node.line)
+		return nil
+	}
+
+	if len(node.args) != len(fnTp.params) {
+		semPanic(diagArgCount, node, "error func call, param count not match, need: %d, actual: %d, func: %s, line: %d",
+			len(fnTp.params), len(node.args), node.callee.desc(), node.line)
+		return nil
+	}
+
+	for idx, ast := range node.args {
+		get := se.visitAst(ast).(AstType)
+		want := fnTp.params[idx]
+		if want.signature() == "F" && get.signature() == "I" {
+			node.args[idx] = promoteInt(ast, node.line)
+			get = se.visitAst(node.args[idx]).(AstType)
+		}
+		node.args[idx], get = se.toIface(want, node.args[idx], get, node.line)
+
+		if want.signature() != get.signature() {
+			semPanic(diagTypeMismatch, node.args[idx], "error func call, arg type not match, idx: %d, need: %s, actual: %s, func: %s, line: %d",
+				idx, want.desc(), get.desc(), node.callee.desc(), node.line)
+			return nil
+		}
+	}
+
+	return realType(fnTp.retType)
+}
+
+//visitFuncLit checks the body like a func decl, its symbol table is
+//chained to the enclosing one so captured variables are visible
+func (se *semanticAnalyzer) visitFuncLit(node *AstFuncLit) interface{} {
+	//break can not leave a func literal
+	oldBrk := se.brkStack
+	se.brkStack = nil
+
+	oldRet := se.retType
+	se.retType = node.decl.retType
+
+	se.pushSymbolTable()
+	for _, varDecl := range node.decl.params {
+		se.visitVarDecl(varDecl)
+	}
+
+	ret := se.visitCodeBlock(node.decl.block).(AstType)
+	if ret.signature() != node.decl.retType.signature() {
+		semPanic(diagTypeMismatch, node, "return type not match in func literal, want: %s, actual: %s, line: %d",
+			node.decl.retType.desc(), ret.desc(), node.line)
+	}
+
+	se.popSymbolTable()
+	se.brkStack = oldBrk
+	se.retType = oldRet
+	return node.type_
+}
+
 func (se *semanticAnalyzer) visitAssign(node *AstAssgin) interface{} {
 	// name := ""
 	// sym := se.curSymbolTable.lookup(name, true)
@@ -461,9 +959,9 @@ func (se *semanticAnalyzer) visitAssign(node *AstAssgin) interface{} {
 
 	var ret AstType
 	switch node.expr.(type) {
-	case *AstBinOP, *AstUnaryOP, *AstNewOP,
-		*AstIntConst, *AstStringConst,
-		*AstIndexedRef, *AstDotRef, *AstVarNameRef,
+	case *AstBinOP, *AstUnaryOP, *AstNewOP, *AstMapLit, *AstFuncLit,
+		*AstIntConst, *AstFloatConst, *AstBoolConst, *AstStringConst,
+		*AstIndexedRef, *AstSliceRef, *AstDotRef, *AstVarNameRef,
 		*AstFuncCall:
 		ret = se.visitAst(node.expr).(AstType)
 		break
@@ -472,12 +970,84 @@ func (se *semanticAnalyzer) visitAssign(node *AstAssgin) interface{} {
 		doPanic("error ast in func block: %T", node.expr)
 	}
 
+	node.expr, ret = se.toIface(dstType, node.expr, ret, node.line)
 	lhs := dstType.signature()
 	rhs := ret.signature()
+	if lhs == "F" && rhs == "I" {
+		node.expr = promoteInt(node.expr, node.line)
+		rhs = se.visitAst(node.expr).(AstType).signature()
+	}
 
 	//fmt.Printf("match assign left: %s, right: %s\n", lhs, rhs)
 	if lhs != rhs {
-		doPanic("assign with diffirent type, lhs: %s, rhs: %s, line: %d", lhs, rhs, node.line)
+		semPanic(diagTypeMismatch, node.expr, "assign with diffirent type, lhs: %s, rhs: %s, line: %d", lhs, rhs, node.line)
+	}
+
+	return nil
+}
+
+//visitMultiAssign checks the call gives one value for each target, new
+//vars of ':=' take the types of the values
+func (se *semanticAnalyzer) visitMultiAssign(node *AstMultiAssign) interface{} {
+	call, ok := node.expr.(*AstFuncCall)
+	if !ok {
+		doPanic("multiple assignment requires a func call, actual: %s, line: %d", node.expr.desc(), node.line)
+		return nil
+	}
+
+	count := len(node.dsts)
+	if node.define {
+		count = len(node.decls)
+	}
+
+	tuple, ok := se.visitFuncCall(call).(AstType).(*AstTupleType)
+	if !ok || len(tuple.elems) != count {
+		values := 1
+		if ok {
+			values = len(tuple.elems)
+		}
+		semPanic(diagArgCount, node, "assignment mismatch: %d variables but %s() returns %d values, line: %d",
+			count, call.name, values, node.line)
+		return nil
+	}
+
+	if node.define {
+		fresh := false
+		for idx, decl := range node.decls {
+			if decl == nil {
+				continue
+			}
+
+			decl.type_ = tuple.elems[idx]
+			se.visitVarDecl(decl)
+			fresh = true
+		}
+
+		if !fresh {
+			semPanic(diagRedefined, node, "no new variables on left side of :=, line: %d", node.line)
+		}
+		return nil
+	}
+
+	for idx, dst := range node.dsts {
+		if dst == nil {
+			continue
+		}
+
+		var dstType AstType
+		switch dst.(type) {
+		case *AstIndexedRef, *AstVarNameRef, *AstDotRef:
+			dstType = se.visitAst(dst).(AstType)
+			break
+
+		default:
+			doPanic("can not assign to %s, line: %d", dst.desc(), node.line)
+		}
+
+		if dstType.signature() != tuple.elems[idx].signature() {
+			semPanic(diagTypeMismatch, dst, "assign with diffirent type at %d, lhs: %s, rhs: %s, line: %d",
+				idx, dstType.desc(), tuple.elems[idx].desc(), node.line)
+		}
 	}
 
 	return nil
@@ -486,8 +1056,66 @@ func (se *semanticAnalyzer) visitAssign(node *AstAssgin) interface{} {
 func (se *semanticAnalyzer) visitReturn(node *AstReturn) interface{} {
 	var ret AstType
 	ret = &AstPrimType{name: symTypeVoid}
-	if node.expr != nil {
-		ret = se.visitAst(node.expr).(AstType)
+	if tuple, ok := node.expr.(*AstTuple); ok {
+		return se.visitReturnTuple(node, tuple)
+	}
+
+	//the values of a call returning multiple values are passed through
+	if call, ok := node.expr.(*AstFuncCall); ok {
+		if tp, ok := se.visitFuncCall(call).(AstType).(*AstTupleType); ok {
+			se.checkReturn(call, tp)
+			return tp
+		}
+	}
+
+	if node.expr == nil {
+		se.checkReturn(node, ret)
+		return ret
+	}
+
+	ret = se.visitAst(node.expr).(AstType)
+	if se.retType != nil {
+		node.expr, ret = se.toIface(se.retType, node.expr, ret, node.line)
+	}
+	se.checkReturn(node.expr, ret)
+	return ret
+}
+
+//checkReturn marks a returned type other than the one of the func at node
+func (se *semanticAnalyzer) checkReturn(node AstNode, ret AstType) {
+	if se.retType != nil && ret.signature() != se.retType.signature() {
+		semPanic(diagTypeMismatch, node, "return type not match, want: %s, actual: %s",
+			se.retType.desc(), ret.desc())
+	}
+}
+
+//visitReturnTuple checks every value against its position in the return
+//type, int is widened and structs are boxed the same as single values
+func (se *semanticAnalyzer) visitReturnTuple(node *AstReturn, tuple *AstTuple) interface{} {
+	want, ok := se.retType.(*AstTupleType)
+	if !ok || len(want.elems) != len(tuple.exprs) {
+		wantDesc := "void"
+		if se.retType != nil {
+			wantDesc = se.retType.desc()
+		}
+		semPanic(diagArgCount, node, "return count not match, want: %s, actual: %d values, line: %d", wantDesc, len(tuple.exprs), node.line)
+		return nil
+	}
+
+	ret := &AstTupleType{}
+	for idx, expr := range tuple.exprs {
+		get := se.visitAst(expr).(AstType)
+		if want.elems[idx].signature() == "F" && get.signature() == "I" {
+			tuple.exprs[idx] = promoteInt(expr, node.line)
+			get = se.visitAst(tuple.exprs[idx]).(AstType)
+		}
+		tuple.exprs[idx], get = se.toIface(want.elems[idx], tuple.exprs[idx], get, node.line)
+
+		if get.signature() != want.elems[idx].signature() {
+			semPanic(diagTypeMismatch, tuple.exprs[idx], "return value %d type not match, want: %s, actual: %s, line: %d",
+				idx, want.elems[idx].desc(), get.desc(), node.line)
+		}
+		ret.elems = append(ret.elems, get)
 	}
 
 	return ret
@@ -497,8 +1125,8 @@ func (se *semanticAnalyzer) visitBinOP(node *AstBinOP) interface{} {
 	var lhs AstType
 	var rhs AstType
 	switch node.left.(type) {
-	case *AstBinOP, *AstUnaryOP, *AstDotRef, *AstIndexedRef,
-		*AstStringConst, *AstIntConst, *AstVarNameRef, *AstFuncCall:
+	case *AstBinOP, *AstUnaryOP, *AstDotRef, *AstIndexedRef, *AstSliceRef,
+		*AstStringConst, *AstIntConst, *AstFloatConst, *AstBoolConst, *AstVarNameRef, *AstFuncCall:
 		lhs = se.visitAst(node.left).(AstType)
 		break
 
@@ -507,8 +1135,8 @@ func (se *semanticAnalyzer) visitBinOP(node *AstBinOP) interface{} {
 	}
 
 	switch node.right.(type) {
-	case *AstBinOP, *AstUnaryOP, *AstDotRef, *AstIndexedRef,
-		*AstStringConst, *AstIntConst, *AstVarNameRef, *AstFuncCall:
+	case *AstBinOP, *AstUnaryOP, *AstDotRef, *AstIndexedRef, *AstSliceRef,
+		*AstStringConst, *AstIntConst, *AstFloatConst, *AstBoolConst, *AstVarNameRef, *AstFuncCall:
 		rhs = se.visitAst(node.right).(AstType)
 		break
 
@@ -522,13 +1150,26 @@ func (se *semanticAnalyzer) visitBinOP(node *AstBinOP) interface{} {
 			strAst := &AstFuncCall{}
 			strAst.args = append(strAst.args, node.right)
 			strAst.name = Builtin_str
+			strAst.ast = se.universe.lookup(Builtin_str, false).(*funcSymbol).ast
 			strAst.line = node.line
+			strAst.col = node.col
 
 			node.right = strAst
 			return se.visitBinOP(node)
 		}
 
-		doPanic("assign with incompatiable type, lhs: %s, rhs: %s, line: %d", lhs, rhs, node.line)
+		//mixed int and float, the int side is widened
+		if lhs.signature() == "F" && rhs.signature() == "I" {
+			node.right = promoteInt(node.right, node.line)
+			return se.visitBinOP(node)
+		}
+
+		if lhs.signature() == "I" && rhs.signature() == "F" {
+			node.left = promoteInt(node.left, node.line)
+			return se.visitBinOP(node)
+		}
+
+		semPanic(diagTypeMismatch, node, "assign with incompatiable type, lhs: %s, rhs: %s, line: %d", lhs, rhs, node.line)
 		return nil
 	}
 
@@ -540,21 +1181,51 @@ func (se *semanticAnalyzer) visitBinOP(node *AstBinOP) interface{} {
 	}
 
 	switch first.tp {
-	case symTypeInt:
+	case symTypeInt, symTypeFloat:
+		switch node.op {
+		case AND, OR:
+			semPanic(diagTypeMismatch, node, "logic operator requires bool, lhs: %s, rhs: %s, line: %d", lhs, rhs, node.line)
+			break
+
+		case EQU, NEQ, LT, LTE, GT, GTE:
+			return &AstPrimType{name: symTypeBool}
+
+		case MOD, BIT_AND, BIT_OR, BIT_XOR, SHL, SHR:
+			if first.tp == symTypeFloat {
+				semPanic(diagTypeMismatch, node, "operator %s requires int, lhs: %s, rhs: %s, line: %d", opText[node.op], lhs, rhs, node.line)
+			}
+		}
+		break
+
+	case symTypeBool:
+		switch node.op {
+		case AND, OR, EQU, NEQ:
+			return &AstPrimType{name: symTypeBool}
+
+		default:
+			semPanic(diagTypeMismatch, node, "bool type only allow && || == !=, lhs: %s, rhs: %s, line: %d", lhs, rhs, node.line)
+		}
 		break
 
-	case symTypeVoid, symTypeAny, symTypeArray, symTypeStruct:
-		doPanic("error binop on type: %s, lhs: %s, rhs: %s, line: %d", first.tp, lhs, rhs, node.line)
+	case symTypeVoid, symTypeAny, symTypeArray, symTypeStruct, symTypeIface:
+		semPanic(diagTypeMismatch, node, "error binop on type: %s, lhs: %s, rhs: %s, line: %d", first.tp, lhs, rhs, node.line)
 		break
 
 	case symTypeString:
-		if node.op != PLUS {
-			doPanic("string type only allow add, lhs: %s, rhs: %s, line: %d", lhs, rhs, node.line)
+		switch node.op {
+		case EQU, NEQ, LT, LTE, GT, GTE:
+			return &AstPrimType{name: symTypeBool}
+
+		case PLUS:
+			break
+
+		default:
+			semPanic(diagTypeMismatch, node, "string type only allow add and compare, lhs: %s, rhs: %s, line: %d", lhs, rhs, node.line)
 		}
 		break
 
 	default:
-		doPanic("error binop on type: %s, lhs: %s, rhs: %s, line: %d", first.tp, lhs, rhs, node.line)
+		semPanic(diagTypeMismatch, node, "error binop on type: %s, lhs: %s, rhs: %s, line: %d", first.tp, lhs, rhs, node.line)
 	}
 
 	return lhs
@@ -563,7 +1234,8 @@ func (se *semanticAnalyzer) visitBinOP(node *AstBinOP) interface{} {
 func (se *semanticAnalyzer) visitUnaryOP(node *AstUnaryOP) interface{} {
 	var rhs AstType
 	switch node.dst.(type) {
-	case *AstBinOP, *AstUnaryOP, *AstIntConst, *AstVarNameRef:
+	case *AstBinOP, *AstUnaryOP, *AstIntConst, *AstFloatConst, *AstBoolConst,
+		*AstVarNameRef, *AstIndexedRef, *AstSliceRef, *AstDotRef, *AstFuncCall:
 		rhs = se.visitAst(node.dst).(AstType)
 		break
 
@@ -571,6 +1243,15 @@ func (se *semanticAnalyzer) visitUnaryOP(node *AstUnaryOP) interface{} {
 		doPanic("error in unaryop dst, unknown ast type: %s, line: %d", node.dst, node.line)
 	}
 
+	sig := rhs.signature()
+	if node.op == NOT && sig != "B" {
+		semPanic(diagTypeMismatch, node, "logic operator requires bool, dst: %s, line: %d", rhs, node.line)
+	}
+
+	if node.op != NOT && sig != "I" && sig != "F" {
+		semPanic(diagTypeMismatch, node, "sign operator requires number, dst: %s, line: %d", rhs, node.line)
+	}
+
 	return rhs
 }
 
@@ -589,24 +1270,67 @@ func (se *semanticAnalyzer) visitIntConst(node *AstIntConst) interface{} {
 	return &AstPrimType{name: symTypeInt}
 }
 
+func (se *semanticAnalyzer) visitFloatConst(node *AstFloatConst) interface{} {
+	return &AstPrimType{name: symTypeFloat}
+}
+
+func (se *semanticAnalyzer) visitBoolConst(node *AstBoolConst) interface{} {
+	return &AstPrimType{name: symTypeBool}
+}
+
 func (se *semanticAnalyzer) visitStringConst(node *AstStringConst) interface{} {
 	return &AstPrimType{name: symTypeString}
 }
 
+func (se *semanticAnalyzer) visitMapLit(node *AstMapLit) interface{} {
+	for idx := range node.keys {
+		keyTp := se.visitAst(node.keys[idx]).(AstType)
+		if !isTypeCompatiable(node.type_.keyType.signature(), keyTp.signature()) {
+			semPanic(diagTypeMismatch, node.keys[idx], "map literal key type not match, need: %s, actual: %s, line: %d",
+				node.type_.keyType.desc(), keyTp.desc(), node.line)
+		}
+
+		valTp := se.visitAst(node.vals[idx]).(AstType)
+		if node.type_.valType.signature() == "F" && valTp.signature() == "I" {
+			node.vals[idx] = promoteInt(node.vals[idx], node.line)
+			valTp = se.visitAst(node.vals[idx]).(AstType)
+		}
+
+		if !isTypeCompatiable(node.type_.valType.signature(), valTp.signature()) {
+			semPanic(diagTypeMismatch, node.vals[idx], "map literal value type not match, need: %s, actual: %s, line: %d",
+				node.type_.valType.desc(), valTp.desc(), node.line)
+		}
+	}
+
+	return node.type_
+}
+
 func (se *semanticAnalyzer) visitIndexedRef(node *AstIndexedRef) interface{} {
+	if mapTp, ok := realType(se.visitAst(node.host).(AstType)).(*AstMapType); ok {
+		keyTp := se.visitAst(node.index).(AstType)
+		if keyTp.signature() != mapTp.keyType.signature() {
+			semPanic(diagTypeMismatch, node.index, "error in indexedRef: %s, key should be %s, actual: %s, line: %d",
+				node.host.desc(), mapTp.keyType.desc(), keyTp.desc(), node.line)
+		}
+		return realType(mapTp.valType)
+	}
+
 	idxTp := se.visitAst(node.index)
 	primTp, ok := idxTp.(*AstPrimType)
 	if !ok || primTp.name != symTypeInt {
-		doPanic("error in indexedRef: %s, index should be int, actual: %s",
+		semPanic(diagTypeMismatch, node.index, "error in indexedRef: %s, index should be int, actual: %s",
 			node.host, primTp)
 		return nil
 	}
 
-	//check host
+	//check host, a char of a string is a string
 	hostTp := se.visitAst(node.host)
+	if hostTp.(AstType).signature() == "S" {
+		return hostTp
+	}
 	arrTp, ok := hostTp.(*AstArrayType)
 	if !ok {
-		doPanic("error in indexedRef: %s, host should be array, actual: %s",
+		semPanic(diagTypeMismatch, node.host, "error in indexedRef: %s, host should be array, actual: %s",
 			node.host, arrTp)
 		return nil
 	}
@@ -614,11 +1338,34 @@ func (se *semanticAnalyzer) visitIndexedRef(node *AstIndexedRef) interface{} {
 	return arrTp.elemType
 }
 
+//visitSliceRef checks host[low:high], a slice has the type of its host
+func (se *semanticAnalyzer) visitSliceRef(node *AstSliceRef) interface{} {
+	hostTp := se.visitAst(node.host).(AstType)
+	for _, bound := range []AstNode{node.low, node.high} {
+		if bound == nil {
+			continue
+		}
+		if tp := se.visitAst(bound).(AstType); tp.signature() != "I" {
+			semPanic(diagTypeMismatch, bound, "error in sliceRef: %s, bound should be int, actual: %s, line: %d", node.host.desc(), tp.desc(), node.line)
+		}
+	}
+
+	if _, ok := realType(hostTp).(*AstArrayType); !ok && hostTp.signature() != "S" {
+		semPanic(diagTypeMismatch, node.host, "error in sliceRef: %s, host should be string or array, actual: %s, line: %d", node.host.desc(), hostTp.desc(), node.line)
+	}
+	return hostTp
+}
+
 func (se *semanticAnalyzer) visitDotRef(node *AstDotRef) interface{} {
 	hType := se.visitAst(node.host)
+	if iface, ok := hType.(*AstIfaceType); ok && methodSet(iface)[node.name] != nil {
+		doPanic("method %s of interface %s can only be called, line: %d", node.name, iface.name, node.line)
+		return nil
+	}
+
 	strctTp, ok := hType.(*AstStructType)
 	if !ok {
-		doPanic("error in dotRef: %s, host should be struct, actual: %s",
+		semPanic(diagTypeMismatch, node.host, "error in dotRef: %s, host should be struct, actual: %s",
 			node.host, hType)
 		return nil
 	}
@@ -626,23 +1373,37 @@ func (se *semanticAnalyzer) visitDotRef(node *AstDotRef) interface{} {
 	//check host
 	for _, field := range strctTp.fields {
 		if field.name == node.name {
+			se.index.refer(node.line, node.col+1, field)
 			return realType(field.type_)
 		}
 	}
 
-	doPanic("visit dotRef error, struct %s has no field: %s", strctTp.name, node.name)
+	if _, ok := strctTp.methods[node.name]; ok {
+		doPanic("method %s of struct %s can only be called, line: %d", node.name, strctTp.name, node.line)
+		return nil
+	}
+
+	semPanic(diagUndefined, node, "visit dotRef error, struct %s has no field: %s", strctTp.name, node.name)
 	return nil
 }
 
 func (se *semanticAnalyzer) visitVarRef(node *AstVarNameRef) interface{} {
 	sym := se.curSymbolTable.lookup(node.name, true)
 	if sym == nil {
-		doPanic("error in varRef, symbol not found: %s", node.name)
+		semPanic(diagUndefined, node, "error in varRef, symbol not found: %s", node.name)
 		return nil
 	}
 
 	if varSym, ok := sym.(*varSymbol); ok {
+		se.index.refer(node.line, node.col, varSym.ast)
 		return realType(varSym.type_)
+	} else if fnSym, ok := sym.(*funcSymbol); ok {
+		if fnSym.ast.builtin || fnSym.ast.va_param != nil {
+			doPanic("builtin func %s can not be used as value, line: %d", node.name, node.line)
+		}
+		se.index.refer(node.line, node.col, fnSym.ast)
+		node.fn = fnSym.ast
+		return funcTypeOf(fnSym.ast)
 	} else {
 		doPanic("error in varRef, name: %s, line: %d, %T", node.name, node.line, sym)
 		return nil
@@ -656,7 +1417,7 @@ func (se *semanticAnalyzer) resolveTypes(pro *AstProgram) {
 		fixArr := []*AstUndefType{}
 		for k, v := range pro.tpMap {
 			switch node := v.(type) {
-			case *AstPrimType, *AstArrayType, *AstStructType:
+			case *AstPrimType, *AstArrayType, *AstMapType, *AstFuncType, *AstStructType, *AstIfaceType:
 				//fmt.Printf("skip resolve type: %s\n", node)
 				break
 
@@ -677,8 +1438,6 @@ func (se *semanticAnalyzer) resolveTypes(pro *AstProgram) {
 							fixArr = append(fixArr, node)
 						}
 					}
-				} else {
-					doPanic("undefined type, name: %s", node.name)
 				}
 				break
 
@@ -692,56 +1451,137 @@ func (se *semanticAnalyzer) resolveTypes(pro *AstProgram) {
 		}
 	}
 
-	for k, v := range pro.tpMap {
+	//the first use in the source is reported, not one picked by map order
+	var first *AstUndefType
+	for _, v := range pro.tpMap {
 		switch node := v.(type) {
 		case *AstUndefType:
-			if node.resolved == nil {
-				doPanic("unresolved type, name: %s, ref by: %s", node.name, k)
+			if node.resolved == nil && (first == nil || node.line < first.line ||
+				(node.line == first.line && node.col < first.col)) {
+				first = node
 			}
-
-			//fmt.Printf("indirect collected type: %s -> %s\n", k, node.resolved)
 			break
 
 		default:
 			//fmt.Printf("collected type: [%s] %s\n", k, node)
 		}
 	}
+
+	if first != nil {
+		if tp, ok := pro.tpMap[first.name]; ok && tp != first {
+			semPanic(diagUndefined, first, "unresolved type, name: %s, it is defined by itself", first.name)
+		}
+		semPanic(diagUndefined, first, "undefined type, name: %s", first.name)
+	}
 }
 
 func (se *semanticAnalyzer) DoAnalyze(root AstNode) (result error) {
-	defer func() {
-		if r := recover(); r != nil {
-			stack := string(debug.Stack())
-			desc := r.(error).Error() + "\n" + stack
-			result = errors.New(desc)
-		}
-	}()
+	return se.analyze(root, true)
+}
 
-	switch node := root.(type) {
-	case *AstProgram:
-		se.visitProgram(node)
-		break
+//addHostFunc makes a go function registered by the embedder visible
+//to scripts, it is checked like any builtin
+func (se *semanticAnalyzer) addHostFunc(decl *AstFuncDecl) {
+	symFunc := newFuncSymbol(decl.name, 0, decl)
+	se.symbolStack[0].insertSymbol(symFunc, se.debug)
+}
 
-	default:
+//analyze checks the program, a library loaded by embedder needs no main.
+//all errors found are returned as Diagnostics
+func (se *semanticAnalyzer) analyze(root AstNode, needMain bool) (result error) {
+	program, ok := root.(*AstProgram)
+	if !ok {
 		return errors.Errorf("root ast type should be program, actual recv: %T", root)
 	}
 
-	main := se.curSymbolTable.lookup(entryFunc, false)
-	if main == nil {
-		return fmt.Errorf("main func is not defined")
+	se.collect = true
+	se.file = program.file
+	se.diags = nil
+	defer func() {
+		se.collect = false
+	}()
+
+	//checking with unresolved types only gives bogus errors, a missing
+	//main among them as funcs are not collected
+	if !se.tryCheck(program, func() { se.resolveTypes(program) }) {
+		return se.diags
 	}
 
-	if funcDecl, ok := main.(*funcSymbol); ok {
+	se.visitProgram(program)
+	if len(se.diags) == 0 {
+		se.tryCheck(program, func() { newResolver().resolveProgram(program) })
+	}
+
+	if main := se.curSymbolTable.lookup(entryFunc, false); main == nil {
+		if needMain {
+			se.diags = append(se.diags, newDiagnostic(diagNoMain, se.file, "main func is not defined", 0, 0, 0))
+		}
+	} else if funcDecl, ok := main.(*funcSymbol); ok {
 		if len(funcDecl.ast.params) > 0 {
-			return errors.Errorf("func 'main' has params count > 0")
+			se.diags = append(se.diags, newDiagnostic(diagNoMain, se.file, "func 'main' has params count > 0",
+				funcDecl.ast.line, funcDecl.ast.col, 0))
 		}
 	} else {
-		return errors.Errorf("'main' is not func symbol, actual type: %T", main)
+		line, col := 0, 0
+		if varSym, ok := main.(*varSymbol); ok && varSym.ast != nil {
+			line, col = varSym.ast.line, varSym.ast.col
+		}
+		se.diags = append(se.diags, newDiagnostic(diagNoMain, se.file,
+			fmt.Sprintf("'main' is not func symbol, actual type: %T", main), line, col, 0))
+	}
+
+	if len(se.diags) == 0 {
+		return nil
+	}
+	sortDiagnostics(se.diags)
+	return se.diags
+}
+
+//analyzeInput checks one item of an incremental session. symbols stay in
+//the global table between calls and no main func is required. it returns
+//the type of a bare expression, or nil for other items
+func (se *semanticAnalyzer) analyzeInput(tpMap map[string]AstType, item AstNode) AstType {
+	se.resolveTypes(&AstProgram{tpMap: tpMap})
+
+	switch node := item.(type) {
+	case *AstVarDecl:
+		se.visitVarDecl(node)
+		break
+
+	case *AstFuncDecl:
+		se.firstPass = true
+		se.visitFuncDecl(node)
+		se.firstPass = false
+		se.visitFuncDecl(node)
+		break
+
+	case *AstTypeDef:
+		break
+
+	case *AstReturn, *AstBreak, *AstContinue:
+		doPanic("return, break and continue are not allowed at top level")
+
+	case *AstAssgin, *AstMultiAssign, *AstConditionBlock, *AstWhileBlock, *AstForBlock, *AstRangeBlock, *AstCodeBlock,
+		*AstTryBlock, *AstThrow:
+		se.visitCodeBlock(&AstCodeBlock{stat_list: []AstNode{node}})
+		break
+
+	default:
+		return se.visitAst(node).(AstType)
 	}
 
 	return nil
 }
 
+//resetInput drops the scopes left by a failed incremental input
+func (se *semanticAnalyzer) resetInput() {
+	se.symbolStack = se.symbolStack[:1]
+	se.stackSize = 1
+	se.curSymbolTable = se.symbolStack[0]
+	se.brkStack = []bool{}
+	se.firstPass = false
+}
+
 func (se *semanticAnalyzer) visitAst(ast AstNode) interface{} {
 	switch statement := ast.(type) {
 	case *AstVarDecl:
@@ -766,21 +1606,44 @@ func (se *semanticAnalyzer) visitAst(ast AstNode) interface{} {
 	case *AstIntConst:
 		return se.visitIntConst(statement)
 
+	case *AstFloatConst:
+		return se.visitFloatConst(statement)
+
+	case *AstBoolConst:
+		return se.visitBoolConst(statement)
+
 	case *AstVarNameRef:
 		return se.visitVarRef(statement)
 
 	case *AstFuncCall:
-		return se.visitFuncCall(statement)
+		tp := se.visitFuncCall(statement).(AstType)
+		if tuple, ok := tp.(*AstTupleType); ok {
+			doPanic("multiple-value %s() %s in single-value context, line: %d",
+				statement.name, tuple.desc(), statement.line)
+		}
+		return tp
 
 	case *AstIndexedRef:
 		return se.visitIndexedRef(statement)
 
+	case *AstSliceRef:
+		return se.visitSliceRef(statement)
+
 	case *AstDotRef:
 		return se.visitDotRef(statement)
 
 	case *AstNewOP:
 		return se.visitNewOP(statement)
 
+	case *AstMapLit:
+		return se.visitMapLit(statement)
+
+	case *AstFuncLit:
+		return se.visitFuncLit(statement)
+
+	case *AstIfaceBox:
+		return statement.iface
+
 	default:
 		doPanic("unknown ast type: %T", ast)
 		break
@@ -792,17 +1655,18 @@ func (se *semanticAnalyzer) visitAst(ast AstNode) interface{} {
 func NewSemanticAnalyzer() *semanticAnalyzer {
 	se := &semanticAnalyzer{}
 
-	symTb := newSymTable(0, nil)
+	se.universe = newSymTable(-1, nil)
+	for _, bfc := range getBuiltinFunc() {
+		symFunc := newFuncSymbol(bfc.name, -1, bfc)
+		se.universe.insertSymbol(symFunc, se.debug)
+	}
+
+	symTb := newSymTable(0, se.universe)
 	se.symbolStack = []*symbolTable{symTb}
 	se.stackSize = 1
 	se.curSymbolTable = se.symbolStack[0]
 	se.brkStack = []bool{}
 
-	for _, bfc := range getBuiltinFunc() {
-		symFunc := newFuncSymbol(bfc.name, 0, bfc)
-		se.curSymbolTable.insertSymbol(symFunc, se.debug)
-	}
-
 	se.firstPass = true
 	return se
 }
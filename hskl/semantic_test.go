@@ -21,3 +21,515 @@ func TestSemantic(t *testing.T) {
 		t.Errorf("analyze error: %v\n", err)
 	}
 }
+
+func TestFloatSemantic(t *testing.T) {
+	cases := map[string]bool{
+		"var f: float\n f = 1":              true,
+		"var f: float\n f = 2 * 1.5":        true,
+		"var i: int\n i = 1.5":              false,
+		"var i: int\n i = int(1.5)":         true,
+		"var i: int\n i = int(true)":        false,
+		"var f: float\n f = float(\"2.5\")": true,
+		"var f: float\n f = float(f < 1)":   false,
+		"var i: int\n i = 1.5 && 2.0":       false,
+		"var b: bool\n b = 1.5 < 2":         true,
+		"var s: string\n s = \"v\" + 1.5":   true,
+	}
+
+	for body, ok := range cases {
+		src := "func main() {\n" + body + "\n}"
+		err := NewSemanticAnalyzer().DoAnalyze(NewParser(src).Program())
+		if (err == nil) != ok {
+			t.Errorf("analyze %q, want ok: %v, error: %v", body, ok, err)
+		}
+	}
+}
+
+func TestBoolSemantic(t *testing.T) {
+	cases := map[string]bool{
+		"var b: bool\n b = true && 1 < 2": true,
+		"var b: bool\n b = !(1 == 2)":     true,
+		"var b: bool\n b = 1 && 2":        false,
+		"var b: bool\n b = !1":            false,
+		"var i: int\n i = 1 < 2":          false,
+		"var i: int\n i = true + 1":       false,
+		"if true {\n}":                    true,
+		"if 1 {\n}":                       false,
+		"if 1 > 0 {\n} elif \"s\" {\n}":   false,
+		"while 0 {\n}":                    false,
+	}
+
+	for body, ok := range cases {
+		src := "func main() {\n" + body + "\n}"
+		err := NewSemanticAnalyzer().DoAnalyze(NewParser(src).Program())
+		if (err == nil) != ok {
+			t.Errorf("analyze %q, want ok: %v, error: %v", body, ok, err)
+		}
+	}
+}
+
+func TestMapSemantic(t *testing.T) {
+	cases := map[string]bool{
+		"m := map[string]int{\"a\": 1}\n m[\"b\"] = 2":          true,
+		"m := map[string]float{\"a\": 1, \"b\": 2.5}":           true,
+		"m := map[string]int{\"a\": 1.5}":                       false,
+		"m := map[string]int{1: 1}":                             false,
+		"var m: map[int]bool\n m[\"x\"] = true":                 false,
+		"var m: map[int]bool\n var b: bool\n b = m[1]":          true,
+		"var m: map[int]bool\n var i: int\n i = m[1]":           false,
+		"var m: map[int]bool\n var b: bool\n b = has(m, 1)":     true,
+		"var m: map[int]bool\n var b: bool\n b = has(m, \"k\")": false,
+		"var m: map[int]bool\n var k: []int\n k = keys(m)":      true,
+		"var m: map[int]bool\n var k: []bool\n k = keys(m)":     false,
+		"var m: map[int]bool\n var v: []bool\n v = values(m)":   true,
+		"var a: []int\n var b: bool\n b = contains(a, 1.5)":     false,
+		"var m: map[int]bool\n var n: int\n n = len(m)":         true,
+		"var n: int\n n = len(3)":                               false,
+		"var m: map[int]bool\n delete(m, 1)":                    true,
+	}
+
+	for body, ok := range cases {
+		src := "func main() {\n" + body + "\n}"
+		err := NewSemanticAnalyzer().DoAnalyze(NewParser(src).Program())
+		if (err == nil) != ok {
+			t.Errorf("analyze %q, want ok: %v, error: %v", body, ok, err)
+		}
+	}
+}
+
+func TestFuncValueSemantic(t *testing.T) {
+	cases := map[string]bool{
+		"var f: func(int) int\n f = double":                             true,
+		"var f: func(int) int\n f = func(n: int) int {\n return n\n}":   true,
+		"var f: func(int) int\n f = func(n: float) int {\n return 1\n}": false,
+		"var f: func(int) string\n f = double":                          false,
+		"var f: func(int) int\n var i: int\n i = f(1)":                  true,
+		"var f: func(int) int\n var i: int\n i = f(\"x\")":              false,
+		"var f: func(int) int\n var i: int\n i = f(1, 2)":               false,
+		"var f: func(float) float\n var x: float\n x = f(1)":            true,
+		"var i: int\n i = i(1)":                                         false,
+		"var f: func(string)\n f = printn":                              false,
+		"f := func() int {\n return 1\n}\n var i: int\n i = f()":        true,
+		"f := func() int {\n return \"s\"\n}":                           false,
+		"while true {\n f := func() {\n break\n}\n}":                    false,
+	}
+
+	for body, ok := range cases {
+		src := "func double(n: int) int {\n return n * 2\n}\nfunc main() {\n" + body + "\n}"
+		err := NewSemanticAnalyzer().DoAnalyze(NewParser(src).Program())
+		if (err == nil) != ok {
+			t.Errorf("analyze %q, want ok: %v, error: %v", body, ok, err)
+		}
+	}
+}
+
+func TestMethodSemantic(t *testing.T) {
+	cases := []struct {
+		decl string
+		body string
+		ok   bool
+	}{
+		{"func (p pt) get() int {\n return p.x\n}", "var i: int\n i = p.get()", true},
+		{"func (p *pt) set(v: int) {\n p.x = v\n}", "p.set(1)", true},
+		{"func (p alias) get() int {\n return p.x\n}", "var i: int\n i = q.get() + p.get()", true},
+		{"func (p pt) get() int {\n return p.x\n}", "var s: string\n s = p.get()", false},
+		{"func (p pt) get() int {\n return p.x\n}", "var i: int\n i = p.get(1)", false},
+		{"func (p pt) get() int {\n return p.x\n}", "var i: int\n i = get()", false},
+		{"func (p pt) get() int {\n return p.x\n}", "var f: func() int\n f = p.get", false},
+		{"func (p pt) x() int {\n return 1\n}", "", false},
+		{"func (p pt) get() int {\n return 1\n}\nfunc (p alias) get() int {\n return 2\n}", "", false},
+		{"func (p pt) get() int {\n return 1\n}\nfunc get() int {\n return 2\n}", "var i: int\n i = get() + p.get()", true},
+		{"func (n num) get() int {\n return 1\n}", "", false},
+		{"func (p pt) main() {\n}", "p.main()", true},
+	}
+
+	for _, c := range cases {
+		src := "type alias pt\ntype num int\ntype pt struct {\n x: int\n}\n" + c.decl +
+			"\nfunc main() {\n var p: pt\n var q: alias\n" + c.body + "\n}"
+		err := NewSemanticAnalyzer().DoAnalyze(NewParser(src).Program())
+		if (err == nil) != c.ok {
+			t.Errorf("analyze %q %q, want ok: %v, error: %v", c.decl, c.body, c.ok, err)
+		}
+	}
+}
+
+func TestIfaceSemantic(t *testing.T) {
+	cases := map[string]bool{
+		"var s: Shape\n s = p":                                  true,
+		"var s: Shape\n s = o":                                  false,
+		"var s: Shape\n var n: Named\n s = p\n n = s":           true,
+		"var s: Shape\n var n: Named\n n = p\n s = n":           false,
+		"var i: int\n i = area(p)":                              true,
+		"var i: int\n i = area(o)":                              false,
+		"var s: Shape\n var i: int\n s = p\n i = s.area()":      true,
+		"var s: Shape\n var i: string\n s = p\n i = s.area()":   false,
+		"var s: Shape\n var i: int\n s = p\n i = s.perimeter()": false,
+		"var s: Shape\n var f: func() int\n f = s.area":         false,
+		"var s: Shape\n var i: int\n s = p\n i = s.x":           false,
+		"var s: Shape\n var b: bool\n b = s == s":               false,
+		"var s: Shape\n s = make()":                             true,
+	}
+
+	decls := "type Shape interface {\n area() int\n name() string\n}\n" +
+		"type Named interface {\n name() string\n}\n" +
+		"type pt struct {\n x: int\n}\ntype other struct {\n x: int\n}\n" +
+		"func (p pt) area() int {\n return p.x\n}\nfunc (p *pt) name() string {\n return \"pt\"\n}\n" +
+		"func (o other) area() string {\n return \"\"\n}\nfunc (o other) name() string {\n return \"o\"\n}\n" +
+		"func area(s: Shape) int {\n return s.area()\n}\n" +
+		"func make() Shape {\n var p: pt\n return p\n}\n"
+	for body, ok := range cases {
+		src := decls + "func main() {\n var p: pt\n var o: other\n" + body + "\n}"
+		err := NewSemanticAnalyzer().DoAnalyze(NewParser(src).Program())
+		if (err == nil) != ok {
+			t.Errorf("analyze %q, want ok: %v, error: %v", body, ok, err)
+		}
+	}
+}
+
+func TestLoopSemantic(t *testing.T) {
+	cases := map[string]bool{
+		"for i := 0; i < n; i = i + 1 {\n n = n + i\n}":        true,
+		"for i := 0; i; i = i + 1 {\n}":                        false,
+		"for ; n < 3; {\n n = n + 1\n}":                        true,
+		"continue":                                             false,
+		"while n < 3 {\n continue\n}":                          true,
+		"for i, v := range arr {\n n = i + v\n}":               true,
+		"for k, v := range m {\n s = k\n n = v\n}":             true,
+		"for k, v := range m {\n n = k\n}":                     false,
+		"for i, c := range s {\n n = i\n s = c\n}":             true,
+		"for i := range n {\n}":                                false,
+		"for _, v := range arr {\n s = v\n}":                   false,
+		"for i := 0; i < 3; i = i + 1 {\n}\n n = i":            false,
+		"for _, v := range arr {\n f = func() {\n break\n}\n}": false,
+	}
+
+	for body, ok := range cases {
+		src := "func main() {\n var n: int\n var s: string\n var arr: []int\n var m: map[string]int\n var f: func()\n" +
+			body + "\n}"
+		err := NewSemanticAnalyzer().DoAnalyze(NewParser(src).Program())
+		if (err == nil) != ok {
+			t.Errorf("analyze %q, want ok: %v, error: %v", body, ok, err)
+		}
+	}
+}
+
+func TestMultiReturnSemantic(t *testing.T) {
+	cases := map[string]bool{
+		"a, b := two()\n n = a\n s = b": true,
+		"a, b, c := two()":              false,
+		"n, s = two()":                  true,
+		"s, n = two()":                  false,
+		"n, _ = two()":                  true,
+		"_, _ := two()":                 false,
+		"n, b := two()":                 false,
+		"n = two()":                     false,
+		"printn(two())":                 false,
+		"two()":                         true,
+		"n, s = one()":                  false,
+		"a, b := f(1)\n n = a\n s = b":  true,
+		"arr[0], s = two()":             true,
+		"n, s = pass()":                 true,
+		"a, b := two()\n c, a := two()": false,
+	}
+
+	decls := "func one() int {\n return 1\n}\n" +
+		"func two() (int, string) {\n return 1, \"a\"\n}\n" +
+		"func pass() (int, string) {\n return two()\n}\n"
+	for body, ok := range cases {
+		src := decls + "func main() {\n var n: int\n var s: string\n var arr: []int\n" +
+			" var f: func(int) (int, string)\n" + body + "\n}"
+		err := NewSemanticAnalyzer().DoAnalyze(NewParser(src).Program())
+		if (err == nil) != ok {
+			t.Errorf("analyze %q, want ok: %v, error: %v", body, ok, err)
+		}
+	}
+
+	returns := map[string]bool{
+		"return 1, \"a\"":     true,
+		"return 1":            false,
+		"return 1, \"a\", 2":  false,
+		"return \"a\", 1":     false,
+		"return one(), \"a\"": true,
+		"return two()":        true,
+		"return":              false,
+	}
+
+	for ret, ok := range returns {
+		retType := "(float, string)"
+		if ret == "return two()" {
+			retType = "(int, string)"
+		}
+		src := decls + "func three() " + retType + " {\n" + ret + "\n}\nfunc main() {\n}"
+		err := NewSemanticAnalyzer().DoAnalyze(NewParser(src).Program())
+		if (err == nil) != ok {
+			t.Errorf("analyze %q, want ok: %v, error: %v", ret, ok, err)
+		}
+	}
+}
+
+func TestTrySemantic(t *testing.T) {
+	cases := map[string]bool{
+		"throw \"bad\"": true,
+		"throw 1":       false,
+		"throw err":     true,
+		"try {\n n = fail()\n} catch e {\n s = e.msg\n}": true,
+		"try {\n} catch e {\n n = e.line\n err = e\n}":   true,
+		"try {\n} catch e {\n n = e.msg\n}":              false,
+		"try {\n} catch {\n s = e.msg\n}":                false,
+		"try {\n} catch e {\n}\n s = e.msg":              false,
+		"try {\n var e: int\n} catch e {\n}":             true,
+	}
+
+	decls := "func fail() int {\n throw \"no\"\n}\n"
+	for body, ok := range cases {
+		src := decls + "func main() {\n var n: int\n var s: string\n var err: error\n" + body + "\n}"
+		err := NewSemanticAnalyzer().DoAnalyze(NewParser(src).Program())
+		if (err == nil) != ok {
+			t.Errorf("analyze %q, want ok: %v, error: %v", body, ok, err)
+		}
+	}
+}
+
+func TestSemanticDiagnostics(t *testing.T) {
+	src := `func foo(a: int) int {
+    var b: int
+    b = a + "x"
+    c = 3
+    return b
+}
+
+func main() {
+    var s: string
+    foo(1, 2)
+    s = 5
+    print(s)
+}`
+	err := NewSemanticAnalyzer().DoAnalyze(NewParser(src).Program())
+	diags, ok := err.(Diagnostics)
+	if !ok {
+		t.Fatalf("analyze error: %v, want diagnostics", err)
+	}
+
+	//each error marks the node it is found at
+	want := []struct {
+		code            string
+		line, col, span int
+	}{
+		{diagTypeMismatch, 3, 11, 1},
+		{diagUndefined, 4, 5, 1},
+		{diagArgCount, 10, 5, 3},
+		{diagTypeMismatch, 11, 9, 1},
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("diagnostics:\n%v\nwant %d", diags, len(want))
+	}
+	for idx, w := range want {
+		d := diags[idx]
+		if d.Code != w.code || d.Line != w.line || d.Column != w.col || d.Span != w.span {
+			t.Errorf("diagnostic %v span %d, want %s at %d:%d span %d", d, d.Span, w.code, w.line, w.col, w.span)
+		}
+	}
+
+	err = NewSemanticAnalyzer().DoAnalyze(NewParser("func foo() {\n}").Program())
+	if diags, ok := err.(Diagnostics); !ok || diags[0].Code != diagNoMain {
+		t.Errorf("analyze without main: %v", err)
+	}
+
+	//a wrong return value and a struct missing a method are marked where
+	//they are used
+	err = NewSemanticAnalyzer().DoAnalyze(NewParser(`type Shape interface {
+    area() int
+}
+
+type dot struct {
+    x: int
+}
+
+func size() int {
+    return "s"
+}
+
+func main() {
+    var s: Shape
+    var d: dot
+    s = d
+}`).Program())
+	diags, ok = err.(Diagnostics)
+	if !ok || len(diags) != 2 || diags[0].Code != diagTypeMismatch || diags[0].Line != 10 || diags[0].Column != 12 ||
+		diags[1].Code != diagTypeMismatch || diags[1].Line != 16 || diags[1].Column != 9 {
+		t.Errorf("analyze with bad return and iface: %v", err)
+	}
+
+	//an undefined type is marked at its use, main is not looked for
+	err = NewSemanticAnalyzer().DoAnalyze(NewParser("var b: nosuch\n\nfunc main() {\n}").Program())
+	if diags, ok := err.(Diagnostics); !ok || len(diags) != 1 || diags[0].Code != diagUndefined ||
+		diags[0].Line != 1 || diags[0].Column != 8 || diags[0].Span != 6 {
+		t.Errorf("analyze with undefined type: %v", err)
+	}
+}
+
+func TestPrintfSemantic(t *testing.T) {
+	cases := map[string]bool{
+		`printf("%d %s\n", 1, "a")`:         true,
+		`printf("%d %s\n", "a", 1)`:         false,
+		`printf("%d\n")`:                    false,
+		`printf("%d\n", 1, 2)`:              false,
+		`printf("%5.2f %e", 1.5, 2)`:        true,
+		`printf("%v %v", new([]int), true)`: true,
+		`printf("%y", 1)`:                   false,
+		`printn("100%")`:                    true,
+		`printn("%q", 1)`:                   false,
+		`eprintn("%-4d|", 1)`:               true,
+		`var s: string
+ s = sprintf("%x%x", 255, "ab")`: true,
+		`var i: int
+ i = sprintf("%d", 1)`: false,
+	}
+
+	for body, ok := range cases {
+		src := "func main() {\n" + body + "\n}"
+		err := NewSemanticAnalyzer().DoAnalyze(NewParser(src).Program())
+		if (err == nil) != ok {
+			t.Errorf("analyze %q, want ok: %v, error: %v", body, ok, err)
+		}
+	}
+}
+
+func TestStringSemantic(t *testing.T) {
+	cases := map[string]bool{
+		`var b: bool
+ b = "a" < "b"`: true,
+		`var b: bool
+ b = "a" == 1`: false,
+		`var s: string
+ s = s[1] + s[1:] + s[:2] + s[:]`: true,
+		`var s: string
+ s = s["a"]`: false,
+		`var s: string
+ s = s[1:"b"]`: false,
+		`var arr: []int
+ var s: string
+ s = arr[1:2]`: false,
+		`var s: string
+ s = "a" - "b"`: false,
+		`var n: int
+ n = len("abc") + indexOf("abc", "b") + ord("a")`: true,
+		`var b: bool
+ b = contains("abc", 1)`: false,
+		`var b: bool
+ m := map[string]int{"a": 1}
+ b = contains(m, "a")`: true,
+		`var s: string
+ s = join(split("a,b", ","), "-")`: true,
+	}
+
+	for body, ok := range cases {
+		src := "func main() {\n" + body + "\n}"
+		err := NewSemanticAnalyzer().DoAnalyze(NewParser(src).Program())
+		if (err == nil) != ok {
+			t.Errorf("analyze %q, want ok: %v, error: %v", body, ok, err)
+		}
+	}
+}
+
+func TestArraySemantic(t *testing.T) {
+	cases := map[string]bool{
+		`var a: []int
+ a = a[1:] + a[:2]`: false,
+		`var a: []int
+ a = a[1:len(a)]`: true,
+		`var a: []int
+ var s: []string
+ s = a[:]`: false,
+		`var a: []int
+ a = insert(a, 0, 1)
+ a = remove(a, 0)`: true,
+		`var a: []int
+ a = insert(a, 0, "x")`: false,
+		`var a: []float
+ a = append(a, 1)
+ a = insert(a, 0, 2)`: true,
+		`var a: []int
+ a = append(a, 1.5)`: false,
+		`var a: []float
+ var b: bool
+ b = contains(a, 1)`: true,
+		`var a: []int
+ var n: int
+ a, n = pop(a)`: true,
+		`var a: []int
+ var s: string
+ a, s = pop(a)`: false,
+		`var a: []string
+ a = copy(a)
+ reverse(a)
+ sort(a)`: true,
+		`var b: []bool
+ sort(b)`: false,
+		`var b: []bool
+ sort(b, func(x: bool, y: bool) bool {
+ return !x && y
+ })`: true,
+		`var a: []int
+ sort(a, func(x: string, y: string) bool {
+ return x < y
+ })`: false,
+		`var m: map[int]int
+ sort(m)`: false,
+		`var a: []float
+ a = fill(3, 0.5)`: true,
+		`var a: []int
+ a = fill(3, "x")`: false,
+		`var a: []string
+ var n: int
+ var b: bool
+ n = indexOf(a, "x")
+ b = contains(a, "x")`: true,
+		`var a: [][]int
+ var b: bool
+ b = contains(a, a[0])`: false,
+	}
+
+	for body, ok := range cases {
+		src := "func main() {\n" + body + "\n}"
+		err := NewSemanticAnalyzer().DoAnalyze(NewParser(src).Program())
+		if (err == nil) != ok {
+			t.Errorf("analyze %q, want ok: %v, error: %v", body, ok, err)
+		}
+	}
+}
+
+func TestMathSemantic(t *testing.T) {
+	cases := map[string]bool{
+		`var n: int
+ n = 7 % 3 + 2 ** 10 + (1 << 4 | 3 & 1 ^ 2) >> 1`: true,
+		`var f: float
+ f = 2 ** 0.5`: true,
+		`var n: int
+ n = 2 ** 0.5`: false,
+		`var f: float
+ f = 7.5 % 2`: false,
+		`var f: float
+ f = 1.5 & 1`: false,
+		`var b: bool
+ b = true | false`: false,
+		`var n: int
+ var f: float
+ n = abs(-1) + min(1, 2, 3) + max(1, 2) + clamp(5, 0, 3) + gcd(4, 6) + floor(1.5) + ceil(1.5)
+ f = abs(-1.5) + min(1, 2.5) + clamp(0.5, 0, 1) + pow(2, 3) + sqrt(2)`: true,
+		`var n: int
+ n = min(1, 2.5)`: false,
+		`var n: int
+ n = max(1, "2")`: false,
+		`var n: int
+ n = min(1)`: false,
+	}
+
+	for body, ok := range cases {
+		src := "func main() {\n" + body + "\n}"
+		err := NewSemanticAnalyzer().DoAnalyze(NewParser(src).Program())
+		if (err == nil) != ok {
+			t.Errorf("analyze %q, want ok: %v, error: %v", body, ok, err)
+		}
+	}
+}
@@ -1,9 +1,11 @@
 package hskl
 
 var sigCharMap = map[rune]string{'*': symTypeAny,
-	'I': symTypeInt, 'S': symTypeString,
-	'[': symTypeArray, 'V': symTypeArray,
-	's': symTypeStruct,
+	'I': symTypeInt, 'F': symTypeFloat, 'B': symTypeBool, 'S': symTypeString,
+	'[': symTypeArray, '{': symTypeMap, 'V': symTypeVoid,
+	'(': symTypeFunc, ')': symTypeFunc,
+	's': symTypeStruct, 'i': symTypeIface,
+	'<': symTypeTuple, '>': symTypeTuple,
 }
 
 type sigParser struct {
@@ -18,7 +20,9 @@ type sigElem struct {
 	tp    string
 }
 
+//getStructSig parses a named type, struct or interface, ended by ';'
 func (sp *sigParser) getStructSig() *sigElem {
+	tp := sigCharMap[sp.curChar]
 	sp.advance()
 
 	arr := []rune{}
@@ -29,7 +33,7 @@ func (sp *sigParser) getStructSig() *sigElem {
 
 	sp.advance()
 
-	elem := &sigElem{tp: symTypeStruct, value: string(arr)}
+	elem := &sigElem{tp: tp, value: string(arr)}
 	return elem
 }
 
@@ -49,14 +53,16 @@ func (s *sigParser) getNextElem() *sigElem {
 	}
 
 	switch s.curChar {
-	case '*', 'I', 'S', '[', 'V':
-		return &sigElem{tp: sigCharMap[s.curChar], value: string(s.curChar)}
+	case '*', 'I', 'F', 'B', 'S', '[', '{', '(', ')', '<', '>', 'V':
+		elem := &sigElem{tp: sigCharMap[s.curChar], value: string(s.curChar)}
+		s.advance()
+		return elem
 
-	case 's':
+	case 's', 'i':
 		return s.getStructSig()
 
 	default:
-		doPanic("")
+		doPanic("unknown signature char: %s", string(s.curChar))
 		return nil
 	}
 }
@@ -0,0 +1,67 @@
+package hskl
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const lineSuffix = ", line: "
+
+//StackFrame is a hskl func being called when a runtime error is raised,
+//Line is the line the func is running at
+type StackFrame struct {
+	Func string
+	File string
+	Line int
+}
+
+//RuntimeError is an uncaught error of a running script, Stack has the
+//hskl funcs being called, the outermost first
+type RuntimeError struct {
+	Msg   string
+	Line  int
+	Stack []StackFrame
+}
+
+func (err *RuntimeError) Error() string {
+	if err.Line > 0 {
+		return fmt.Sprintf("%s%s%d", err.Msg, lineSuffix, err.Line)
+	}
+	return err.Msg
+}
+
+//Traceback formats the error with its call stack, the most recent call last
+func (err *RuntimeError) Traceback() string {
+	var sb strings.Builder
+	sb.WriteString("Traceback (most recent call last):\n")
+	for _, frame := range err.Stack {
+		file := frame.File
+		if file == "" {
+			file = "<script>"
+		}
+		fmt.Fprintf(&sb, "  File %q, line %d, in %s\n", file, frame.Line, frame.Func)
+	}
+	sb.WriteString(err.Error())
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+//newRuntimeError makes the error of a script, the innermost frame runs at
+//the line of the error when it is known
+func newRuntimeError(msg string, line int, stack []StackFrame) *RuntimeError {
+	if line > 0 && len(stack) > 0 {
+		stack[len(stack)-1].Line = line
+	}
+	return &RuntimeError{Msg: msg, Line: line, Stack: stack}
+}
+
+//splitLine takes the ", line: N" off the end of a message
+func splitLine(msg string) (string, int) {
+	if idx := strings.LastIndex(msg, lineSuffix); idx >= 0 {
+		if n, err := strconv.Atoi(msg[idx+len(lineSuffix):]); err == nil {
+			return msg[:idx], n
+		}
+	}
+	return msg, 0
+}
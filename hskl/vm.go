@@ -0,0 +1,908 @@
+package hskl
+
+import (
+	"context"
+	"io"
+	"math"
+)
+
+type vmFrame struct {
+	fn    *bcFunc
+	ip    int
+	base  int
+	cells []*bcCell
+}
+
+//bcCell boxes a local captured by closures, so all of them share it
+type bcCell struct {
+	val interface{}
+}
+
+//bcClosure is a func value of the vm, native is set for host funcs
+type bcClosure struct {
+	fn     *bcFunc
+	native *AstFuncDecl
+	cells  []*bcCell
+}
+
+func (c *bcClosure) String() string {
+	if c.native != nil {
+		return funcValueDesc(c.native)
+	}
+	return funcValueDesc(c.fn.decl)
+}
+
+//bcHandler is a try block being run, frames and stack are the sizes to
+//unwind to when an error is caught
+type bcHandler struct {
+	frames int
+	stack  int
+	target int
+}
+
+//bytecodeVM runs a compiled program, locals live on the value stack
+//starting at the frame base
+type bytecodeVM struct {
+	prog     *bcProgram
+	stack    []interface{}
+	frames   []*vmFrame
+	globals  []interface{}
+	handlers []bcHandler
+	debug    bool
+	env      *nativeEnv
+	//limits are counted by steps and allocated, ctx is set while a
+	//program runs with a context
+	limits    Limits
+	ctx       context.Context
+	steps     int
+	allocated int
+}
+
+func (vm *bytecodeVM) push(val interface{}) {
+	vm.stack = append(vm.stack, val)
+}
+
+func (vm *bytecodeVM) pop() interface{} {
+	top := len(vm.stack) - 1
+	val := vm.stack[top]
+	vm.stack = vm.stack[:top]
+	return val
+}
+
+func (vm *bytecodeVM) pushFrame(fn *bcFunc, argc int) {
+	if max := vm.limits.MaxCallDepth; max > 0 && len(vm.frames) >= max {
+		panic(&CallDepthError{Max: max, Line: vm.curLine()})
+	}
+
+	frame := &vmFrame{fn: fn, base: len(vm.stack) - argc}
+	for i := argc; i < fn.nslots; i++ {
+		vm.push(nil)
+	}
+	vm.frames = append(vm.frames, frame)
+}
+
+func (vm *bytecodeVM) curLine() int {
+	if len(vm.frames) == 0 {
+		return 0
+	}
+	return vm.frames[len(vm.frames)-1].line()
+}
+
+//line is the line of the last op run by the frame
+func (frame *vmFrame) line() int {
+	if frame.ip > 0 && frame.ip <= len(frame.fn.lines) {
+		return frame.fn.lines[frame.ip-1]
+	}
+	return 0
+}
+
+//varInitValue builds the initial value of a declared variable,
+//same as the tree walker does in visitVarDecl
+func varInitValue(node *AstVarDecl) interface{} {
+	decl := node
+	if undef, ok := node.type_.(*AstUndefType); ok {
+		decl = &AstVarDecl{name: node.name, type_: undef.resolved,
+			initVal: node.initVal, initArr: node.initArr, line: node.line}
+	}
+
+	switch tp := decl.type_.(type) {
+	case *AstPrimType:
+		switch tp.name {
+		case symTypeInt:
+			return newIntVari(0, decl).val
+
+		case symTypeFloat:
+			return newFloatVari(0, decl).val
+
+		case symTypeBool:
+			return newBoolVari(0, decl).val
+
+		case symTypeString:
+			return newStringVari(0, decl).val
+
+		default:
+			doPanic("unknown AstPrimType when init var: %s", decl.type_)
+		}
+		break
+
+	case *AstStructType:
+		return newStructVari(0, decl).val
+
+	case *AstArrayType:
+		return newArrayVari(0, decl).val
+
+	case *AstMapType:
+		return make(map[interface{}]interface{})
+
+	case *AstFuncType, *AstIfaceType:
+		return nil
+
+	default:
+		doPanic("unknown type when init var: %s", decl.type_)
+	}
+
+	return nil
+}
+
+func (vm *bytecodeVM) truthy(val interface{}) bool {
+	switch tp := val.(type) {
+	case bool:
+		return tp
+
+	case nil:
+		doPanic("condition recv nil value")
+		return false
+
+	default:
+		doPanic("condition recv unknown type: %T:%v", val, val)
+		return false
+	}
+}
+
+func (vm *bytecodeVM) binaryOp(op byte, lhs, rhs interface{}) interface{} {
+	if s1, ok := lhs.(string); ok {
+		s2 := rhs.(string)
+		switch op {
+		case OP_ADD:
+			vm.alloc(len(s1) + len(s2))
+			return s1 + s2
+
+		case OP_EQ:
+			return s1 == s2
+
+		case OP_NEQ:
+			return s1 != s2
+
+		case OP_LT:
+			return s1 < s2
+
+		case OP_LTE:
+			return s1 <= s2
+
+		case OP_GT:
+			return s1 > s2
+
+		case OP_GTE:
+			return s1 >= s2
+		}
+
+		doPanic("unsupported string op code: %d", op)
+	}
+
+	if f1, ok := lhs.(float64); ok {
+		return vm.floatOp(op, f1, rhs.(float64))
+	}
+
+	if b1, ok := lhs.(bool); ok {
+		switch op {
+		case OP_EQ:
+			return b1 == rhs.(bool)
+
+		case OP_NEQ:
+			return b1 != rhs.(bool)
+		}
+
+		doPanic("unsupported bool op code: %d", op)
+	}
+
+	lhv := lhs.(int)
+	rhv := rhs.(int)
+	switch op {
+	case OP_ADD:
+		return lhv + rhv
+
+	case OP_SUB:
+		return lhv - rhv
+
+	case OP_MUL:
+		return lhv * rhv
+
+	case OP_DIV:
+		if rhv == 0 {
+			interpPanic("div by zero")
+		}
+		return lhv / rhv
+
+	case OP_MOD:
+		if rhv == 0 {
+			interpPanic("mod by zero")
+		}
+		return lhv % rhv
+
+	case OP_POW:
+		if rhv < 0 {
+			interpPanic("negative exponent: %d", rhv)
+		}
+		return intPow(lhv, rhv)
+
+	case OP_BIT_AND:
+		return lhv & rhv
+
+	case OP_BIT_OR:
+		return lhv | rhv
+
+	case OP_BIT_XOR:
+		return lhv ^ rhv
+
+	case OP_SHL, OP_SHR:
+		if rhv < 0 {
+			interpPanic("negative shift count: %d", rhv)
+		}
+		if op == OP_SHL {
+			return lhv << uint(rhv)
+		}
+		return lhv >> uint(rhv)
+
+	case OP_EQ:
+		return lhv == rhv
+
+	case OP_NEQ:
+		return lhv != rhv
+
+	case OP_LT:
+		return lhv < rhv
+
+	case OP_LTE:
+		return lhv <= rhv
+
+	case OP_GT:
+		return lhv > rhv
+
+	case OP_GTE:
+		return lhv >= rhv
+	}
+
+	doPanic("unknown binary op code: %d", op)
+	return nil
+}
+
+func (vm *bytecodeVM) floatOp(op byte, lhv, rhv float64) interface{} {
+	switch op {
+	case OP_ADD:
+		return lhv + rhv
+
+	case OP_SUB:
+		return lhv - rhv
+
+	case OP_MUL:
+		return lhv * rhv
+
+	case OP_DIV:
+		if rhv == 0 {
+			interpPanic("div by zero")
+		}
+		return lhv / rhv
+
+	case OP_POW:
+		return math.Pow(lhv, rhv)
+
+	case OP_EQ:
+		return lhv == rhv
+
+	case OP_NEQ:
+		return lhv != rhv
+
+	case OP_LT:
+		return lhv < rhv
+
+	case OP_LTE:
+		return lhv <= rhv
+
+	case OP_GT:
+		return lhv > rhv
+
+	case OP_GTE:
+		return lhv >= rhv
+	}
+
+	doPanic("unsupported float op code: %d", op)
+	return nil
+}
+
+func (vm *bytecodeVM) newValue(tp AstType) interface{} {
+	switch rtp := realType(tp).(type) {
+	case *AstStructType:
+		return make(map[string]interface{})
+
+	case *AstArrayType:
+		return []interface{}{}
+
+	case *AstMapType:
+		return make(map[interface{}]interface{})
+
+	default:
+		doPanic("error type when interpret new op: %s", rtp.desc())
+		return nil
+	}
+}
+
+func (vm *bytecodeVM) indexValue(host, index interface{}) interface{} {
+	if host == nil {
+		interpPanic("hskl runtime error, nil array reference")
+	}
+
+	if mv, ok := host.(map[interface{}]interface{}); ok {
+		val, found := mv[index]
+		if !found {
+			interpPanic("hskl runtime error, map key not found: %v", index)
+		}
+		return val
+	}
+
+	if str, ok := host.(string); ok {
+		return runeIndex(str, index.(int))
+	}
+
+	arr, ok := host.([]interface{})
+	if !ok {
+		interpPanic("hskl runtime error, can not index %T", host)
+	}
+	idx := index.(int)
+	if idx < 0 || idx >= len(arr) {
+		interpPanic("hskl runtime error, index out of range: %d, len: %d", idx, len(arr))
+	}
+	return arr[idx]
+}
+
+//run executes until the frame count drops back to stopDepth, a caught
+//runtime error resumes at its handler
+func (vm *bytecodeVM) run(stopDepth int) interface{} {
+	for {
+		if ret, done := vm.runCatch(stopDepth); done {
+			return ret
+		}
+	}
+}
+
+func (vm *bytecodeVM) runCatch(stopDepth int) (ret interface{}, done bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			if !vm.catch(r, stopDepth) {
+				panic(r)
+			}
+		}
+	}()
+
+	return vm.exec(stopDepth), true
+}
+
+//catch unwinds to the innermost handler run by this call of run and
+//pushes the error value for the catch block
+func (vm *bytecodeVM) catch(r interface{}, stopDepth int) bool {
+	err, ok := r.(*interpError)
+	if !ok || len(vm.handlers) == 0 {
+		return false
+	}
+
+	top := len(vm.handlers) - 1
+	handler := vm.handlers[top]
+	if handler.frames <= stopDepth {
+		return false
+	}
+
+	val := errorValue(err, vm.curLine())
+	vm.handlers = vm.handlers[:top]
+	vm.frames = vm.frames[:handler.frames]
+	vm.stack = vm.stack[:handler.stack]
+	vm.frames[handler.frames-1].ip = handler.target
+	vm.push(val)
+	return true
+}
+
+func (vm *bytecodeVM) exec(stopDepth int) interface{} {
+	frame := vm.frames[len(vm.frames)-1]
+	code := frame.fn.code
+
+	for {
+		op := code[frame.ip]
+		frame.ip++
+		if frame.fn.steps[frame.ip-1] {
+			vm.step()
+		}
+
+		switch op {
+		case OP_CONST:
+			idx := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			vm.push(frame.fn.consts[idx])
+			break
+
+		case OP_NIL:
+			vm.push(nil)
+			break
+
+		case OP_POP:
+			vm.stack = vm.stack[:len(vm.stack)-1]
+			break
+
+		case OP_LOAD_LOCAL:
+			slot := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			vm.push(vm.stack[frame.base+slot])
+			break
+
+		case OP_STORE_LOCAL:
+			slot := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			vm.stack[frame.base+slot] = vm.pop()
+			break
+
+		case OP_LOAD_GLOBAL:
+			slot := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			vm.push(vm.globals[slot])
+			break
+
+		case OP_STORE_GLOBAL:
+			slot := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			vm.globals[slot] = vm.pop()
+			break
+
+		case OP_VAR_INIT:
+			idx := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			decl := frame.fn.consts[idx].(*AstVarDecl)
+			vm.alloc(len(decl.initArr))
+			vm.push(varInitValue(decl))
+			break
+
+		case OP_COPY_RECV:
+			idx := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			decl := frame.fn.consts[idx].(*AstFuncDecl)
+			recv, ok := recvValue(decl, vm.pop())
+			if !ok {
+				interpPanic("hskl runtime error, nil receiver of method %s", funcFullName(decl))
+			}
+			vm.push(recv)
+			break
+
+		case OP_BOX:
+			idx := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			vm.push(&ifaceVal{tp: frame.fn.consts[idx].(*AstStructType), val: vm.pop()})
+			break
+
+		case OP_METHOD:
+			idx := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			name := frame.fn.consts[idx].(string)
+			box, ok := vm.pop().(*ifaceVal)
+			if !ok {
+				interpPanic("hskl runtime error, call of method %s on nil interface", name)
+			}
+
+			decl := box.tp.methods[name]
+			recv, ok := recvValue(decl, box.val)
+			if !ok {
+				interpPanic("hskl runtime error, nil receiver of method %s", funcFullName(decl))
+			}
+			vm.push(vm.prog.methods[decl])
+			vm.push(recv)
+			break
+
+		case OP_MAKE_CELL:
+			slot := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			vm.stack[frame.base+slot] = &bcCell{val: vm.stack[frame.base+slot]}
+			break
+
+		case OP_LOAD_CELL:
+			slot := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			vm.push(vm.stack[frame.base+slot].(*bcCell).val)
+			break
+
+		case OP_STORE_CELL:
+			slot := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			vm.stack[frame.base+slot].(*bcCell).val = vm.pop()
+			break
+
+		case OP_LOAD_UPVAL:
+			idx := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			vm.push(frame.cells[idx].val)
+			break
+
+		case OP_STORE_UPVAL:
+			idx := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			frame.cells[idx].val = vm.pop()
+			break
+
+		case OP_CLOSURE:
+			idx := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			fn := vm.prog.funcs[idx]
+			cells := make([]*bcCell, len(fn.upvals))
+			for i, uv := range fn.upvals {
+				if uv.local {
+					cells[i] = vm.stack[frame.base+uv.index].(*bcCell)
+				} else {
+					cells[i] = frame.cells[uv.index]
+				}
+			}
+			vm.push(&bcClosure{fn: fn, cells: cells})
+			break
+
+		case OP_NEW:
+			idx := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			vm.push(vm.newValue(frame.fn.consts[idx].(AstType)))
+			break
+
+		case OP_MAKE_MAP:
+			count := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			mv := make(map[interface{}]interface{}, count)
+			base := len(vm.stack) - 2*count
+			for i := base; i < len(vm.stack); i += 2 {
+				mv[vm.stack[i]] = vm.stack[i+1]
+			}
+			vm.stack = vm.stack[:base]
+			vm.push(mv)
+			break
+
+		case OP_TUPLE:
+			count := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			base := len(vm.stack) - count
+			vals := make([]interface{}, count)
+			copy(vals, vm.stack[base:])
+			vm.stack = vm.stack[:base]
+			vm.push(vals)
+			break
+
+		case OP_UNPACK:
+			//first value on top, targets are stored from left to right
+			count := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			vals := vm.pop().([]interface{})
+			for i := count - 1; i >= 0; i-- {
+				vm.push(vals[i])
+			}
+			break
+
+		case OP_INDEX:
+			host := vm.pop()
+			index := vm.pop()
+			vm.push(vm.indexValue(host, index))
+			break
+
+		case OP_SLICE:
+			high := vm.pop()
+			low := vm.pop()
+			val := sliceValue(vm.pop(), low, high)
+			vm.alloc(allocOf(val, nil))
+			vm.push(val)
+			break
+
+		case OP_SET_INDEX:
+			index := vm.pop()
+			host := vm.pop()
+			val := vm.pop()
+			if host == nil {
+				interpPanic("hskl runtime error, nil reference in index assign")
+			}
+			if mv, ok := host.(map[interface{}]interface{}); ok {
+				mv[index] = val
+				break
+			}
+			arr, ok := host.([]interface{})
+			if !ok {
+				interpPanic("hskl runtime error, can not assign to index of %T", host)
+			}
+			idx := index.(int)
+			if idx < 0 || idx >= len(arr) {
+				interpPanic("hskl runtime error, index out of range: %d, len: %d", idx, len(arr))
+			}
+			arr[idx] = val
+			break
+
+		case OP_FIELD:
+			idx := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			host := vm.pop()
+			if host == nil {
+				interpPanic("hskl runtime error, nil reference in field access")
+			}
+			vm.push(host.(map[string]interface{})[frame.fn.consts[idx].(string)])
+			break
+
+		case OP_SET_FIELD:
+			idx := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			host := vm.pop()
+			val := vm.pop()
+			if host == nil {
+				interpPanic("hskl runtime error, nil reference in field assign")
+			}
+			host.(map[string]interface{})[frame.fn.consts[idx].(string)] = val
+			break
+
+		case OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_POW,
+			OP_BIT_AND, OP_BIT_OR, OP_BIT_XOR, OP_SHL, OP_SHR,
+			OP_EQ, OP_NEQ, OP_LT, OP_LTE, OP_GT, OP_GTE:
+			rhs := vm.pop()
+			lhs := vm.pop()
+			vm.push(vm.binaryOp(op, lhs, rhs))
+			break
+
+		case OP_NEG:
+			if fVal, ok := vm.stack[len(vm.stack)-1].(float64); ok {
+				vm.stack[len(vm.stack)-1] = -fVal
+			} else {
+				vm.push(-vm.pop().(int))
+			}
+			break
+
+		case OP_NOT:
+			vm.push(!vm.pop().(bool))
+			break
+
+		case OP_JUMP:
+			//a jump back is a round of a loop, unless it goes to a
+			//statement start which is counted itself
+			target := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			if target < frame.ip && !frame.fn.steps[target] {
+				vm.step()
+			}
+			frame.ip = target
+			break
+
+		case OP_JUMP_FALSE:
+			target := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			if !vm.truthy(vm.pop()) {
+				frame.ip = target
+			}
+			break
+
+		case OP_ITER:
+			vm.push(newRangeIter(vm.pop()))
+			break
+
+		case OP_ITER_NEXT:
+			target := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			key, val, ok := vm.pop().(*rangeIter).next()
+			if !ok {
+				frame.ip = target
+				break
+			}
+			vm.push(key)
+			vm.push(val)
+			break
+
+		case OP_CALL:
+			idx := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			argc := int(code[frame.ip+2])
+			frame.ip += 3
+			vm.pushFrame(vm.prog.funcs[idx], argc)
+			frame = vm.frames[len(vm.frames)-1]
+			code = frame.fn.code
+			break
+
+		case OP_NATIVE:
+			idx := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			argc := int(code[frame.ip+2])
+			frame.ip += 3
+			args := vm.stack[len(vm.stack)-argc:]
+			ret := vm.callNative(vm.prog.natives[idx], args)
+			vm.stack = vm.stack[:len(vm.stack)-argc]
+			vm.push(ret)
+			break
+
+		case OP_CALL_VALUE:
+			argc := int(code[frame.ip])
+			frame.ip++
+			pos := len(vm.stack) - argc - 1
+			callee, ok := vm.stack[pos].(*bcClosure)
+			if !ok {
+				interpPanic("hskl runtime error, call of nil func")
+			}
+
+			//drop the func value below args
+			copy(vm.stack[pos:], vm.stack[pos+1:])
+			vm.stack = vm.stack[:len(vm.stack)-1]
+
+			if callee.native != nil {
+				args := vm.stack[len(vm.stack)-argc:]
+				ret := vm.callNative(callee.native, args)
+				vm.stack = vm.stack[:len(vm.stack)-argc]
+				vm.push(ret)
+				break
+			}
+
+			vm.pushFrame(callee.fn, argc)
+			frame = vm.frames[len(vm.frames)-1]
+			frame.cells = callee.cells
+			code = frame.fn.code
+			break
+
+		case OP_TRY:
+			target := int(code[frame.ip])<<8 | int(code[frame.ip+1])
+			frame.ip += 2
+			vm.handlers = append(vm.handlers, bcHandler{frames: len(vm.frames), stack: len(vm.stack), target: target})
+			break
+
+		case OP_END_TRY:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+			break
+
+		case OP_THROW:
+			panic(thrownError(vm.pop(), vm.curLine()))
+
+		case OP_RETURN:
+			//try blocks of the returning func are left
+			for len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].frames >= len(vm.frames) {
+				vm.handlers = vm.handlers[:len(vm.handlers)-1]
+			}
+
+			ret := vm.pop()
+			vm.stack = vm.stack[:frame.base]
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			if len(vm.frames) <= stopDepth {
+				return ret
+			}
+
+			vm.push(ret)
+			frame = vm.frames[len(vm.frames)-1]
+			code = frame.fn.code
+			break
+
+		default:
+			doPanic("unknown op code: %d in func: %s", op, frame.fn.name)
+		}
+	}
+}
+
+func (vm *bytecodeVM) DoRun(prog *bcProgram) (result error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := limitError(r); ok {
+				result = err
+				return
+			}
+			result = vm.runError(r)
+		}
+	}()
+
+	vm.load(prog)
+	if vm.ctx != nil {
+		vm.checkDeadline()
+	}
+	vm.callFunc(prog.mainFunc, nil)
+	return nil
+}
+
+//callNative runs a builtin or host func, what it makes counts to the
+//allocated size
+func (vm *bytecodeVM) callNative(decl *AstFuncDecl, args []interface{}) interface{} {
+	ret := decl.native(vm.env, args)
+	if size := allocOf(ret, args); size > 0 {
+		vm.alloc(size)
+	}
+	return ret
+}
+
+//runError describes a panic of run with the line it is raised at and the
+//funcs being called, the global initializer is not a frame of the script
+func (vm *bytecodeVM) runError(r interface{}) error {
+	msg, line := panicMessage(r), vm.curLine()
+	if err, ok := r.(*interpError); ok {
+		msg = err.msg
+		if err.line > 0 {
+			line = err.line
+		}
+	}
+
+	file := ""
+	if vm.prog != nil {
+		file = vm.prog.file
+	}
+
+	stack := []StackFrame{}
+	for _, frame := range vm.frames {
+		if frame.fn.decl == nil {
+			continue
+		}
+		stack = append(stack, StackFrame{Func: frame.fn.name, File: file, Line: frame.line()})
+	}
+	return newRuntimeError(msg, line, stack)
+}
+
+//load runs the global initializers of prog
+func (vm *bytecodeVM) load(prog *bcProgram) {
+	vm.prog = prog
+	vm.globals = make([]interface{}, len(prog.globals))
+
+	vm.pushFrame(prog.init, 0)
+	vm.run(0)
+}
+
+func (vm *bytecodeVM) callFunc(idx int, args []interface{}) interface{} {
+	depth := len(vm.frames)
+	for _, arg := range args {
+		vm.push(arg)
+	}
+
+	vm.pushFrame(vm.prog.funcs[idx], len(args))
+	return vm.run(depth)
+}
+
+//callValue calls a func value for a builtin, it runs until the callee
+//returns
+func (vm *bytecodeVM) callValue(fn interface{}, args []interface{}) interface{} {
+	callee, ok := fn.(*bcClosure)
+	if !ok {
+		interpPanic("hskl runtime error, call of nil func")
+	}
+	if callee.native != nil {
+		return vm.callNative(callee.native, args)
+	}
+
+	depth := len(vm.frames)
+	for _, arg := range args {
+		vm.push(arg)
+	}
+	vm.pushFrame(callee.fn, len(args))
+	vm.frames[len(vm.frames)-1].cells = callee.cells
+	return vm.run(depth)
+}
+
+//resetInput unwinds the frames left by a failed call
+func (vm *bytecodeVM) resetInput() {
+	vm.frames = vm.frames[:0]
+	vm.stack = vm.stack[:0]
+	vm.handlers = vm.handlers[:0]
+}
+
+func NewBytecodeVM() *bytecodeVM {
+	vm := &bytecodeVM{}
+	vm.env = &nativeEnv{call: vm.callValue, canAlloc: vm.canAlloc}
+	vm.stack = make([]interface{}, 0, 1024)
+	return vm
+}
+
+//SetStdout sets where print and printn write, nil is the process stdout
+func (vm *bytecodeVM) SetStdout(out io.Writer) {
+	vm.env.stdout = out
+}
+
+//SetStderr sets where eprintn writes, nil is the process stderr
+func (vm *bytecodeVM) SetStderr(out io.Writer) {
+	vm.env.stderr = out
+}
+
+//SetStdin sets what readLine and readAll read, nil is the process stdin
+func (vm *bytecodeVM) SetStdin(in io.Reader) {
+	vm.env.setStdin(in)
+}
+
+//SetSeed fixes the seed of the rng behind rand_int, rand_float and shuffle
+func (vm *bytecodeVM) SetSeed(seed int64) {
+	vm.env.setSeed(seed)
+}
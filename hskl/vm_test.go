@@ -0,0 +1,144 @@
+package hskl
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//go test -run TestVMDifferential -update rewrites the golden outputs
+var update = flag.Bool("update", false, "rewrite the golden .out files under data")
+
+//interpretOut runs pro on the tree walker, giving what it prints. the
+//seed is fixed so runs can be compared
+func interpretOut(pro AstNode) (string, error) {
+	stdout := &bytes.Buffer{}
+	interp := NewInterpreter()
+	interp.SetSeed(1)
+	interp.SetStdout(stdout)
+	err := interp.DoInterpret(pro)
+	return stdout.String(), err
+}
+
+//runOut runs prog on the vm, giving what it prints
+func runOut(prog *bcProgram) (string, error) {
+	stdout := &bytes.Buffer{}
+	bcVM := NewBytecodeVM()
+	bcVM.SetSeed(1)
+	bcVM.SetStdout(stdout)
+	err := bcVM.DoRun(prog)
+	return stdout.String(), err
+}
+
+func analyzeFile(t testing.TB, path string) AstNode {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s error: %v", path, err)
+	}
+
+	pro := NewParser(string(body)).Program()
+	if err := NewSemanticAnalyzer().DoAnalyze(pro); err != nil {
+		t.Fatalf("analyze %s error: %v", path, err)
+	}
+	return pro
+}
+
+func TestVMDifferential(t *testing.T) {
+	files, _ := filepath.Glob("../data/*.hskl")
+	if len(files) == 0 {
+		t.Fatal("no source file found under data")
+	}
+
+	for _, path := range files {
+		pro := analyzeFile(t, path)
+		//both engines draw the same random numbers
+		treeOut, treeErr := interpretOut(pro)
+
+		prog, err := NewCompiler().DoCompile(pro)
+		if err != nil {
+			t.Errorf("%s: compile error: %v", path, err)
+			continue
+		}
+
+		vmOut, vmErr := runOut(prog)
+
+		if (treeErr == nil) != (vmErr == nil) {
+			t.Errorf("%s: engines disagree on error, tree: %v, vm: %v", path, treeErr, vmErr)
+		}
+
+		if treeOut != vmOut {
+			t.Errorf("%s: output differs\ntree:\n%s\nvm:\n%s", path, treeOut, vmOut)
+		}
+
+		treeRt, _ := treeErr.(*RuntimeError)
+		vmRt, _ := vmErr.(*RuntimeError)
+		if treeRt != nil && vmRt != nil && !reflect.DeepEqual(treeRt.Stack, vmRt.Stack) {
+			t.Errorf("%s: call stacks differ\ntree: %v\nvm: %v", path, treeRt.Stack, vmRt.Stack)
+		}
+
+		checkGolden(t, path, treeOut, treeErr)
+	}
+}
+
+//checkGolden compares the output of path and the error it stopped with
+//to the .out file next to it
+func checkGolden(t *testing.T, path string, out string, runErr error) {
+	if runErr != nil {
+		out += "error: " + runErr.Error() + "\n"
+	}
+
+	golden := strings.TrimSuffix(path, ".hskl") + ".out"
+	if *update {
+		if err := ioutil.WriteFile(golden, []byte(out), 0644); err != nil {
+			t.Fatalf("write %s error: %v", golden, err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Errorf("%s: read golden output error: %v", path, err)
+		return
+	}
+	if out != string(want) {
+		t.Errorf("%s: output differs from %s\n%s", path, golden, UnifiedDiff(golden, string(want), out))
+	}
+}
+
+const benchFibProgram = `
+func fibonacci(num:int) int {
+    if num < 2 {
+        return num
+    }
+    return fibonacci(num - 1) + fibonacci(num - 2)
+}
+
+var result : int
+
+func main() {
+    result = fibonacci(20)
+}
+`
+
+func BenchmarkTreeFib(b *testing.B) {
+	pro := NewParser(benchFibProgram).Program()
+	NewSemanticAnalyzer().DoAnalyze(pro)
+
+	for i := 0; i < b.N; i++ {
+		NewInterpreter().DoInterpret(pro)
+	}
+}
+
+func BenchmarkVMFib(b *testing.B) {
+	pro := NewParser(benchFibProgram).Program()
+	NewSemanticAnalyzer().DoAnalyze(pro)
+	prog, _ := NewCompiler().DoCompile(pro)
+
+	for i := 0; i < b.N; i++ {
+		NewBytecodeVM().DoRun(prog)
+	}
+}